@@ -1,6 +1,9 @@
 package main
 
 import (
+	"log"
+	"os"
+	"personal-site/internal/config"
 	"personal-site/internal/db"
 	"personal-site/internal/server"
 )
@@ -8,6 +11,19 @@ import (
 // TODO: set up delve for better debugging
 
 func main() {
-	defer db.DB.Close()
-	server.Start()
+	store, err := db.Open(config.DatabaseURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrate(store, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := store.Setup(); err != nil {
+		log.Fatal(err)
+	}
+	server.New(store).Start()
 }
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"personal-site/internal/db"
+	"strconv"
+)
+
+const migrateUsage = "usage: app migrate [up | down [steps] | status]"
+
+// handles the migrate subcommand, e.g. `app migrate down 1`
+func migrate(store *db.SQLStore, args []string) error {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	switch command {
+	case "up":
+		return store.Migrate()
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q\n%s", args[1], migrateUsage)
+			}
+		}
+		return store.MigrateDown(steps)
+	case "status":
+		statuses, err := store.GetMigrationStatus()
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.Applied {
+				appliedAt = "applied " + status.AppliedAt.Time.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q\n%s", command, migrateUsage)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/go-chi/jwtauth/v5"
 	"github.com/joho/godotenv"
@@ -12,10 +14,21 @@ import (
 var IsDev bool
 var Addr string
 var Port string
+var SiteURL string
+var PageSize int
+var DatabaseURL string
+var MediaDir string
 var SignKey []byte
 var TokenAuth *jwtauth.JWTAuth
 var AdminUser string
 var AdminPass string
+var SMTPAddr string
+var SMTPUser string
+var SMTPPass string
+var SMTPFrom string
+var HTMLAllow string
+var CodeStyle string
+var CodeLineNumbers bool
 
 func init() {
 	if err := godotenv.Load(); err != nil {
@@ -24,8 +37,48 @@ func init() {
 	IsDev = os.Getenv("GO_ENV") == "development"
 	Addr = os.Getenv("SERVER_ADDR")
 	Port = fmt.Sprintf(":%s", os.Getenv("SERVER_PORT"))
+	// absolute urls (e.g. in feeds) are built from SITE_URL, falling back to the listen address
+	SiteURL = strings.TrimSuffix(os.Getenv("SITE_URL"), "/")
+	if SiteURL == "" {
+		host := Addr
+		if host == "" {
+			host = "localhost"
+		}
+		SiteURL = fmt.Sprintf("http://%s%s", host, Port)
+	}
+	PageSize = 10
+	if pageSize, err := strconv.Atoi(os.Getenv("PAGE_SIZE")); err == nil && pageSize > 0 {
+		PageSize = pageSize
+	}
+	// postgres:// urls select the postgres store, anything else is a sqlite file
+	DatabaseURL = os.Getenv("DATABASE_URL")
+	if DatabaseURL == "" {
+		DatabaseURL = "./db.sqlite"
+	}
+	// uploads from the micropub media endpoint, served under /media
+	MediaDir = os.Getenv("MEDIA_DIR")
+	if MediaDir == "" {
+		MediaDir = "./media"
+	}
 	SignKey = []byte(os.Getenv("SIGN_KEY"))
 	TokenAuth = jwtauth.New("HS256", SignKey, nil)
 	AdminUser = os.Getenv("ADMIN_USER")
 	AdminPass = os.Getenv("ADMIN_PASS")
+	// notifications are emailed through SMTP_ADDR (host:port) when it's set, and only logged otherwise
+	SMTPAddr = os.Getenv("SMTP_ADDR")
+	SMTPUser = os.Getenv("SMTP_USER")
+	SMTPPass = os.Getenv("SMTP_PASS")
+	SMTPFrom = os.Getenv("SMTP_FROM")
+	if SMTPFrom == "" {
+		SMTPFrom = SMTPUser
+	}
+	// elements posts may use on top of the defaults, e.g. "iframe[src,width,height] video[src,controls]"
+	HTMLAllow = os.Getenv("HTML_ALLOW")
+	// the chroma style code blocks are colored with, e.g. github or monokai
+	CodeStyle = os.Getenv("CODE_STYLE")
+	if CodeStyle == "" {
+		CodeStyle = "github"
+	}
+	// numbers every code block, single blocks can opt in with {linenos=true}
+	CodeLineNumbers = os.Getenv("CODE_LINE_NUMBERS") == "true"
 }
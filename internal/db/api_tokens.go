@@ -0,0 +1,75 @@
+package db
+
+import (
+	"time"
+)
+
+type APITokensData struct {
+	Tokens []*APIToken
+	// the plain token, only set right after it was created
+	NewToken string
+}
+
+func (s *SQLStore) CreateAPIToken(token *APIToken) (int64, error) {
+	var tokenID int64
+	err := s.db.QueryRow(
+		"INSERT INTO api_token (user_id, name, token_hash, created_at) VALUES (?, ?, ?, ?) RETURNING id;",
+		token.UserId, token.Name, token.TokenHash, token.CreatedAt.UTC()).
+		Scan(&tokenID)
+	if err != nil {
+		return -1, err
+	}
+	return tokenID, nil
+}
+
+func (s *SQLStore) GetAPITokenByHash(tokenHash string) (*APIToken, error) {
+	var token APIToken
+	row := s.db.QueryRow(
+		"SELECT id, user_id, name, token_hash, created_at, last_used_at FROM api_token WHERE token_hash = ?", tokenHash)
+	err := row.Scan(&token.Id, &token.UserId, &token.Name, &token.TokenHash, &token.CreatedAt, &token.LastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// returns a user's tokens, newest first
+func (s *SQLStore) GetAPITokens(userID int) ([]*APIToken, error) {
+	rows, err := s.db.Query(
+		"SELECT id, user_id, name, token_hash, created_at, last_used_at FROM api_token WHERE user_id = ? ORDER BY created_at DESC, id DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tokens := make([]*APIToken, 0)
+	for rows.Next() {
+		var token APIToken
+		err := rows.Scan(&token.Id, &token.UserId, &token.Name, &token.TokenHash, &token.CreatedAt, &token.LastUsedAt)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, &token)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *SQLStore) TouchAPIToken(tokenID int, lastUsedAt time.Time) error {
+	_, err := s.db.Exec("UPDATE api_token SET last_used_at = ? WHERE id = ?;", lastUsedAt.UTC(), tokenID)
+	return err
+}
+
+// deletes one of a user's tokens, returns ErrNotFound if the user has no such token
+func (s *SQLStore) DeleteAPIToken(userID int, tokenID int) error {
+	res, err := s.db.Exec("DELETE FROM api_token WHERE id = ? AND user_id = ?;", tokenID, userID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package db
+
+import "time"
+
+const commentColumns = "id, post_id, parent_id, author_name, author_email, author_url, body, content, status, ip, created_at"
+
+type CommentsData struct {
+	Comments []*ModeratedComment
+	// the status being listed and the ones that can be picked instead
+	Status   CommentStatus
+	Statuses []CommentStatus
+}
+
+// a comment in the moderation queue along with the post it's on
+type ModeratedComment struct {
+	*Comment
+	Post *Post
+}
+
+func scanComment(row scanner) (*Comment, error) {
+	var comment Comment
+	err := row.Scan(&comment.Id, &comment.PostId, &comment.ParentId, &comment.AuthorName, &comment.AuthorEmail,
+		&comment.AuthorURL, &comment.Body, &comment.Content, &comment.Status, &comment.IP, &comment.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &comment, nil
+}
+
+// nests comments under the ones they reply to, replies whose parent isn't in the
+// list are left out so a hidden comment hides its thread
+func CommentTree(comments []*Comment) []*Comment {
+	byID := make(map[int64]*Comment, len(comments))
+	for _, comment := range comments {
+		comment.Replies = nil
+		byID[int64(comment.Id)] = comment
+	}
+	roots := make([]*Comment, 0)
+	for _, comment := range comments {
+		if !comment.ParentId.Valid {
+			roots = append(roots, comment)
+		} else if parent, ok := byID[comment.ParentId.Int64]; ok {
+			parent.Replies = append(parent.Replies, comment)
+		}
+	}
+	return roots
+}
+
+func (s *SQLStore) CreateComment(comment *Comment) (int64, error) {
+	var commentID int64
+	err := s.db.QueryRow(
+		`INSERT INTO comment (post_id, parent_id, author_name, author_email, author_url, body, content, status, ip, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id;`,
+		comment.PostId, comment.ParentId, comment.AuthorName, comment.AuthorEmail, comment.AuthorURL,
+		comment.Body, comment.Content, comment.Status, comment.IP, comment.CreatedAt.UTC()).
+		Scan(&commentID)
+	if err != nil {
+		return -1, err
+	}
+	return commentID, nil
+}
+
+func (s *SQLStore) GetComment(commentID int) (*Comment, error) {
+	return scanComment(s.db.QueryRow("SELECT "+commentColumns+" FROM comment WHERE id = ?", commentID))
+}
+
+// returns a post's comments with the given status, oldest first. a post id of 0
+// returns them for every post
+func (s *SQLStore) GetComments(postID int, status CommentStatus) ([]*Comment, error) {
+	query := "SELECT " + commentColumns + " FROM comment WHERE status = ?"
+	args := []interface{}{status}
+	if postID != 0 {
+		query += " AND post_id = ?"
+		args = append(args, postID)
+	}
+	rows, err := s.db.Query(query+" ORDER BY created_at, id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	comments := make([]*Comment, 0)
+	for rows.Next() {
+		comment, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+	return comments, rows.Err()
+}
+
+// returns the comments sent from an ip since the given time, oldest first, whatever
+// their status
+func (s *SQLStore) GetCommentsByIP(ip string, since time.Time) ([]*Comment, error) {
+	rows, err := s.db.Query("SELECT "+commentColumns+" FROM comment WHERE ip = ? AND created_at >= ? ORDER BY created_at, id", ip, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	comments := make([]*Comment, 0)
+	for rows.Next() {
+		comment, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+	return comments, rows.Err()
+}
+
+func (s *SQLStore) SetCommentStatus(commentID int, status CommentStatus) error {
+	res, err := s.db.Exec("UPDATE comment SET status = ? WHERE id = ?;", status, commentID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// deletes a comment along with every reply under it
+func (s *SQLStore) DeleteComment(commentID int) error {
+	res, err := s.db.Exec(
+		`WITH RECURSIVE thread(id) AS (
+			SELECT id FROM comment WHERE id = ?
+			UNION ALL SELECT comment.id FROM comment JOIN thread ON comment.parent_id = thread.id
+		) DELETE FROM comment WHERE id IN (SELECT id FROM thread);`, commentID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package db
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// the sql flavours a SQLStore can talk to, queries are written for sqlite with
+// ? placeholders and rewritten where postgres needs something different
+type dialect int
+
+const (
+	sqliteDialect dialect = iota
+	postgresDialect
+)
+
+func (d dialect) String() string {
+	if d == postgresDialect {
+		return "postgres"
+	}
+	return "sqlite"
+}
+
+// rewrites ? placeholders into the numbered $1, $2, ... form postgres expects.
+// question marks in string literals, quoted identifiers and comments aren't
+// placeholders, and ?? is written out as a single ?, for postgres operators such as
+// jsonb's ? and ?|
+func (d dialect) rebind(query string) string {
+	if d != postgresDialect || !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for i := 0; i < len(query); {
+		if end := skipQuoted(query, i); end > i {
+			b.WriteString(query[i:end])
+			i = end
+			continue
+		}
+		switch {
+		case strings.HasPrefix(query[i:], "??"):
+			b.WriteByte('?')
+			i += 2
+		case query[i] == '?':
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			i++
+		default:
+			b.WriteByte(query[i])
+			i++
+		}
+	}
+	return b.String()
+}
+
+// returns where the literal, quoted identifier or comment starting at i ends, or i
+// when there isn't one there. unterminated ones run to the end of the query
+func skipQuoted(query string, i int) int {
+	switch {
+	case query[i] == '\'' || query[i] == '"':
+		quote := query[i]
+		for j := i + 1; j < len(query); j++ {
+			if query[j] != quote {
+				continue
+			}
+			// a doubled quote is an escaped one
+			if j+1 < len(query) && query[j+1] == quote {
+				j++
+				continue
+			}
+			return j + 1
+		}
+		return len(query)
+	case strings.HasPrefix(query[i:], "--"):
+		if end := strings.IndexByte(query[i:], '\n'); end != -1 {
+			return i + end + 1
+		}
+		return len(query)
+	case strings.HasPrefix(query[i:], "/*"):
+		if end := strings.Index(query[i+2:], "*/"); end != -1 {
+			return i + 2 + end + 2
+		}
+		return len(query)
+	}
+	return i
+}
+
+// wraps a *sql.DB so every query is rebound for its dialect
+type database struct {
+	*sql.DB
+	dialect dialect
+}
+
+func (db *database) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.Exec(db.dialect.rebind(query), args...)
+}
+
+func (db *database) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.Query(db.dialect.rebind(query), args...)
+}
+
+func (db *database) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRow(db.dialect.rebind(query), args...)
+}
+
+func (db *database) Begin() (*transaction, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &transaction{Tx: tx, dialect: db.dialect}, nil
+}
+
+// wraps a *sql.Tx so every query is rebound for its dialect
+type transaction struct {
+	*sql.Tx
+	dialect dialect
+}
+
+func (tx *transaction) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.Exec(tx.dialect.rebind(query), args...)
+}
+
+func (tx *transaction) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.dialect.rebind(query), args...)
+}
+
+func (tx *transaction) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRow(tx.dialect.rebind(query), args...)
+}
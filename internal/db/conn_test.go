@@ -0,0 +1,34 @@
+package db
+
+import "testing"
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"no placeholders", "SELECT 1", "SELECT 1"},
+		{"placeholders", "SELECT * FROM post WHERE id = ? AND slug = ?", "SELECT * FROM post WHERE id = $1 AND slug = $2"},
+		{"string literal", "SELECT * FROM post WHERE title = 'why?' AND id = ?", "SELECT * FROM post WHERE title = 'why?' AND id = $1"},
+		{"escaped quote", "SELECT 'it''s ?', ?", "SELECT 'it''s ?', $1"},
+		{"quoted identifier", `SELECT "what?" FROM "user" WHERE id = ?`, `SELECT "what?" FROM "user" WHERE id = $1`},
+		{"line comment", "SELECT ? -- why?\nFROM post WHERE id = ?", "SELECT $1 -- why?\nFROM post WHERE id = $2"},
+		{"block comment", "SELECT /* ? */ ?", "SELECT /* ? */ $1"},
+		{"jsonb operators", "SELECT * FROM t WHERE data ?? 'key' AND data ??| ? AND id = ?", "SELECT * FROM t WHERE data ? 'key' AND data ?| $1 AND id = $2"},
+		{"unterminated literal", "SELECT ? WHERE 'why?", "SELECT $1 WHERE 'why?"},
+		{"multibyte text", "SELECT '…' || ?", "SELECT '…' || $1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := postgresDialect.rebind(tt.query); got != tt.want {
+				t.Errorf("rebind(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+	// sqlite takes ? as it is
+	query := "SELECT * FROM post WHERE id = ? AND title = 'why?'"
+	if got := sqliteDialect.rebind(query); got != query {
+		t.Errorf("sqlite rebind(%q) = %q", query, got)
+	}
+}
@@ -3,18 +3,85 @@ package db
 import (
 	"database/sql"
 	"errors"
-	"fmt"
-	"log"
-	"os"
-	"personal-site/internal/config"
-	"reflect"
-	"strings"
+	"personal-site/pkg/utils/markdown"
 	"time"
-
-	_ "github.com/mattn/go-sqlite3"
 )
 
-var DB *sql.DB
+// returned by stores when a post, revision or user doesn't exist
+var ErrNotFound = sql.ErrNoRows
+
+// returned when saving a post whose slug belongs to another post
+var ErrSlugTaken = errors.New("slug is already used by another post")
+
+// Store is implemented by every storage backend the site can run on
+type Store interface {
+	GetAllPosts(options ...Option) ([]*Post, error)
+	GetFilteredPosts(filters []string, options ...Option) ([]*Post, error)
+	GetPost(postID int) (*Post, error)
+	GetPostBySlug(slug string, options ...Option) (*Post, error)
+	CreatePost(post *Post) (int64, error)
+	EditPost(postID int, post *Post) error
+	DeletePost(postID int) error
+	PublishScheduledPosts(now time.Time) (int64, error)
+	SearchPosts(query string, options ...Option) ([]*SearchResult, error)
+
+	GetRevisions(postID int) ([]*Revision, error)
+	GetRevision(postID int, revisionID int) (*Revision, error)
+	RestoreRevision(postID int, revision *Revision) error
+	SanitizeContent(sanitize func(string) string) (int64, error)
+
+	GetTags(postID int) ([]*Tag, error)
+	CreateTags(postID int64, tags []string) error
+	SetTags(postID int, tags []string) error
+	GetAllTags() ([]*TagCount, error)
+	DeleteTag(name string) error
+
+	GetUserByCreds(username string, password string) (*User, error)
+	GetUser(userID int) (*User, error)
+	GetUserByUsername(username string) (*User, error)
+	GetUsers() ([]*User, error)
+	CreateUser(username string, password string, role Role) (int64, error)
+	SetUserRole(userID int, role Role) error
+	SetUserEmail(userID int, email string) error
+	DeleteUser(userID int) error
+	SetTOTPSecret(userID int, secret string) error
+	EnableTOTP(userID int, recoveryCodeHashes []string) error
+	DisableTOTP(userID int) error
+	UseTOTPCounter(userID int, counter int64) (bool, error)
+	UseRecoveryCode(userID int, codeHash string) (bool, error)
+	CountRecoveryCodes(userID int) (int, error)
+	RecordLoginAttempt(attempt *LoginAttempt) error
+	GetLoginAttempts(username string, ip string, since time.Time) ([]*LoginAttempt, error)
+	DeleteLoginAttempts(before time.Time) (int64, error)
+
+	CreateSession(session *Session) error
+	GetSession(sessionID string) (*Session, error)
+	GetSessions(userID int, now time.Time) ([]*Session, error)
+	TouchSession(sessionID string, ip string, lastSeenAt time.Time, expiresAt time.Time) error
+	DeleteSession(sessionID string) error
+	DeleteExpiredSessions(now time.Time) (int64, error)
+
+	CreateAPIToken(token *APIToken) (int64, error)
+	GetAPITokenByHash(tokenHash string) (*APIToken, error)
+	GetAPITokens(userID int) ([]*APIToken, error)
+	TouchAPIToken(tokenID int, lastUsedAt time.Time) error
+	DeleteAPIToken(userID int, tokenID int) error
+
+	SaveWebmention(mention *Webmention) (int64, error)
+	GetWebmention(mentionID int) (*Webmention, error)
+	GetWebmentions(postID int, status WebmentionStatus) ([]*Webmention, error)
+	SetWebmentionStatus(mentionID int, status WebmentionStatus, title string, updatedAt time.Time) error
+	DeleteWebmention(mentionID int) error
+
+	CreateComment(comment *Comment) (int64, error)
+	GetComment(commentID int) (*Comment, error)
+	GetComments(postID int, status CommentStatus) ([]*Comment, error)
+	GetCommentsByIP(ip string, since time.Time) ([]*Comment, error)
+	SetCommentStatus(commentID int, status CommentStatus) error
+	DeleteComment(commentID int) error
+
+	Close() error
+}
 
 type OrderDirection string
 
@@ -31,6 +98,11 @@ type QueryOptions struct {
 	OrderByColumn    string
 	OrderByDirection OrderDirection
 	Limit            int
+	Offset           int
+	Statuses         []PostStatus
+	IncludeContent   bool
+	// only posts by this user, zero means any author
+	UserId int
 }
 
 type Option func(*QueryOptions)
@@ -38,342 +110,92 @@ type Option func(*QueryOptions)
 type PostData struct {
 	Post *Post
 	Tags []*Tag
+	// nil if the author no longer exists
+	Author *User
+	// verified mentions from other sites, oldest first
+	Webmentions []*Webmention
+	// approved comments, nested by CommentTree
+	Comments []*Comment
+	// signs when the comment form was rendered, see the comment spam checks
+	CommentToken string
+	// nil unless the post shows a table of contents
+	TOC []*markdown.Heading
 }
 
 type BlogData struct {
 	Posts   []*Post
 	Filters []string
+	Page    int
+	PrevURL string
+	NextURL string
+	// set when listing a single author's posts
+	Author *User
 }
 
-func init() {
-	err := connect()
-	if err != nil {
-		log.Fatal(err)
-	}
-	pingErr := DB.Ping()
-	if pingErr != nil {
-		log.Fatal(pingErr)
-	}
-	fmt.Println("Connected!")
-}
-
-func connect() error {
-	// if db exists, just connect, otherwise initialize
-	if _, err := os.Stat("./db.sqlite"); errors.Is(err, os.ErrNotExist) {
-		DB, err = sql.Open("sqlite3", "./db.sqlite")
-		if err != nil {
-			return err
-		}
-		err = initialize(DB)
-		if err != nil {
-			return err
-		}
-	} else {
-		DB, err = sql.Open("sqlite3", "./db.sqlite")
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+type AdminData struct {
+	User      *User
+	Drafts    []*Post
+	Scheduled []*Post
+	Published []*Post
+	// comments on the user's posts waiting for a moderator
+	PendingComments int
 }
 
-// TODO: think about security (don't store passwords as plaintext)
-func initialize(*sql.DB) error {
-	user, pass := config.AdminUser, config.AdminPass
-	stmt := `
-	CREATE TABLE IF NOT EXISTS user(
-		id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, 
-		username VARCHAR(255), 
-		password VARCHAR(255), 
-		is_admin BOOLEAN
-	);
-	CREATE TABLE IF NOT EXISTS post(
-		id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, 
-		user_id INTEGER,
-		title TEXT,
-		slug TEXT,
-		content TEXT,
-		published TEXT,
-		created_at TIMESTAMP,
-		updated_at TIMESTAMP,
-		FOREIGN KEY(user_id) REFERENCES user(id)
-	);
-	CREATE TABLE IF NOT EXISTS tag(
-		id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
-		name VARCHAR(255)
-	);
-	CREATE TABLE IF NOT EXISTS post_tags(
-		id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
-		post_id INTEGER,
-		tag_id INTEGER,
-		FOREIGN KEY(post_id) REFERENCES post(id),
-		FOREIGN KEY(tag_id) REFERENCES tag(id)
-	);
-	`
-	// create user and post tables
-	_, err := DB.Exec(stmt)
-	if err != nil {
-		return err
-	}
-
-	insertStmt := `
-		INSERT INTO user (username, password, is_admin) VALUES (?, ?, ?);
-	`
-	// create admin user
-	_, err = DB.Exec(insertStmt, user, pass, true)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-// should make this more flexible in the future
-func addQueryOptions(query *string, queryOptions *QueryOptions) {
-	v := reflect.ValueOf(*queryOptions)
-
-	orderByColumn := v.Field(0).String()
-	orderByDirection := OrderDirection(v.Field(1).String())
-	limit := v.Field(2).Int()
-
-	if orderByColumn != "" && orderByDirection != "" && isValidOrderDirection(orderByDirection) {
-		*query += fmt.Sprintf(" ORDER BY %s %s ", orderByColumn, orderByDirection)
-	}
-	if limit != 0 {
-		*query += fmt.Sprintf("LIMIT %d", limit)
-	}
-	*query += ";"
-}
-
-func WithLimit(limit int) Option {
-	return func(q *QueryOptions) {
-		q.Limit = limit
-	}
-}
-
-func GetAllPosts(options ...Option) ([]*Post, error) {
-	query := "SELECT id, title, slug, published, content, created_at FROM post"
+func newQueryOptions(options []Option) *QueryOptions {
 	queryOptions := &QueryOptions{
 		OrderByColumn:    "created_at",
 		OrderByDirection: DESC,
+		Statuses:         []PostStatus{Published},
 	}
 	for _, opt := range options {
 		opt(queryOptions)
 	}
-	addQueryOptions(&query, queryOptions)
-	result, err := DB.Query(query)
-	if err != nil {
-		return nil, err
-	}
-	defer result.Close()
-	posts := make([]*Post, 0)
-	for result.Next() {
-		post, err := createPost(result)
-		if err != nil {
-			return nil, err
-		}
-		posts = append(posts, post)
-	}
-	return posts, nil
-}
-
-func createPost(rows *sql.Rows) (*Post, error) {
-	post := new(Post)
-	err := rows.Scan(
-		&post.Id,
-		&post.Title,
-		&post.Slug,
-		&post.Published,
-		&post.Content,
-		&post.CreatedAt,
-	)
-	if err != nil {
-		return nil, err
-	}
-	return post, nil
+	return queryOptions
 }
 
-func GetFilteredPosts(filters []string) ([]*Post, error) {
-	placeholders := strings.Repeat("?,", len(filters))
-	placeholders = placeholders[:len(placeholders)-1]
-	query := fmt.Sprintf(`
-		SELECT DISTINCT post.id, post.title, post.slug, post.published, post.content, post.created_at 
-		FROM post
-		INNER JOIN post_tags ON post.id = post_tags.post_id
-		INNER JOIN tag ON tag.id = post_tags.tag_id
-		WHERE tag.name IN (%s)
-	`, placeholders)
-	args := make([]interface{}, len(filters))
-	for i, filter := range filters {
-		args[i] = filter
-	}
-	rows, err := DB.Query(query, args...)
-	if err != nil {
-		return nil, err
-	}
-	posts := make([]*Post, 0)
-	for rows.Next() {
-		post, err := createPost(rows)
-		if err != nil {
-			return nil, err
-		}
-		posts = append(posts, post)
-	}
-	return posts, nil
-}
-
-func GetPost(postID int) (*Post, error) {
-	var post Post
-	row := DB.QueryRow("SELECT * FROM post WHERE id = ?", postID)
-	err := row.Scan(&post.Id, &post.UserId, &post.Title, &post.Slug, &post.Content, &post.Published, &post.CreatedAt, &post.UpdatedAt)
-	if err != nil {
-		return nil, err
-	}
-	return &post, nil
-}
-
-func GetTags(postID int) ([]*Tag, error) {
-	var tags []*Tag
-
-	res, err := DB.Query(
-		`SELECT tag.id, tag.name 
-		FROM tag 
-		INNER JOIN post_tags ON tag.id = post_tags.tag_id
-		WHERE post_tags.post_id = ?`, postID)
-	if err != nil {
-		return nil, err
-	}
-	for res.Next() {
-		var tag Tag
-		res.Scan(&tag.Id, &tag.Name)
-		tags = append(tags, &tag)
+func WithOrderBy(column string, direction OrderDirection) Option {
+	return func(q *QueryOptions) {
+		q.OrderByColumn = column
+		q.OrderByDirection = direction
 	}
-	res.Close()
-	return tags, nil
 }
 
-func GetPostBySlug(slug string) (*Post, error) {
-	var post Post
-	row := DB.QueryRow("SELECT * FROM post WHERE slug = ?", slug)
-	err := row.Scan(&post.Id, &post.UserId, &post.Title, &post.Slug, &post.Content, &post.Published, &post.CreatedAt, &post.UpdatedAt)
-	if err != nil {
-		return nil, err
+func WithLimit(limit int) Option {
+	return func(q *QueryOptions) {
+		q.Limit = limit
 	}
-	return &post, nil
 }
 
-func GetUserByCreds(username string, password string) (*User, error) {
-	var user User
-	row := DB.QueryRow("SELECT * FROM user WHERE username = ? AND password = ?", username, password)
-	err := row.Scan(&user.Id, &user.Username, &user.Password, &user.IsAdmin)
-	if err != nil {
-		return nil, err
+func WithOffset(offset int) Option {
+	return func(q *QueryOptions) {
+		q.Offset = offset
 	}
-	return &user, nil
 }
 
-func CreatePost(post *Post) (int64, error) {
-	res, err := DB.Exec(
-		"INSERT INTO post (user_id, title, slug, content, published, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?);",
-		post.UserId, post.Title, post.Slug, post.Content, post.Published, time.Now(), time.Now())
-	if err != nil {
-		return -1, err
-	}
-	postID, err := res.LastInsertId()
-	if err != nil {
-		return -1, err
+// also select post content when listing posts
+func WithContent() Option {
+	return func(q *QueryOptions) {
+		q.IncludeContent = true
 	}
-	return postID, nil
 }
 
-func DeletePost(postID int) error {
-	tx, err := DB.Begin()
-	if err != nil {
-		return err
-	}
-	defer func() {
-		if err != nil {
-			tx.Rollback()
-		}
-	}()
-
-	// check to see if post has any tags
-	rows, err := tx.Query("SELECT tag_id FROM post_tags WHERE post_id = ?", postID)
-	if err != nil {
-		return err
-	}
-	var tagIDs []int
-	for rows.Next() {
-		var tagID int
-		if err := rows.Scan(&tagID); err != nil {
-			return err
-
-		}
-		tagIDs = append(tagIDs, tagID)
-	}
-	rows.Close()
-
-	_, err = tx.Exec("DELETE FROM post_tags WHERE post_id = ?", postID)
-	if err != nil {
-		return err
-	}
-	// delete orphaned tags
-	for _, tagID := range tagIDs {
-		var count int
-		row := tx.QueryRow("SELECT COUNT(*) FROM post_tags WHERE tag_id = ?", tagID)
-		err := row.Scan(&count)
-		if err != nil {
-			return err
-		}
-		if count == 0 {
-			_, err := tx.Exec("DELETE FROM tag WHERE id = ?", tagID)
-			if err != nil {
-				return err
-			}
-		}
-	}
-	_, err = tx.Exec("DELETE FROM post WHERE id = ?;", postID)
-	if err != nil {
-		return err
+// only return posts with the given statuses (defaults to published only)
+func WithStatus(statuses ...PostStatus) Option {
+	return func(q *QueryOptions) {
+		q.Statuses = statuses
 	}
-	return tx.Commit()
 }
 
-func EditPost(postID int, post *Post) error {
-	_, err := DB.Exec(
-		"UPDATE post SET title = ?, slug = ?, content = ?, updated_at = ? WHERE id = ?;", post.Title, post.Slug, post.Content, post.UpdatedAt, postID)
-	if err != nil {
-		return err
+// return posts regardless of status, should only be used for admins
+func WithAnyStatus() Option {
+	return func(q *QueryOptions) {
+		q.Statuses = nil
 	}
-	return nil
 }
 
-func CreateTags(postID int64, tags []string) error {
-	// for each tag, lookup in tags table and then insert if not already present
-	for _, tag := range tags {
-		var tagID int
-		row := DB.QueryRow("SELECT id FROM tag WHERE name = ?", tag)
-		err := row.Scan(&tagID)
-		if err != nil {
-			if err == sql.ErrNoRows {
-				res, err := DB.Exec(
-					"INSERT INTO tag (name) VALUES (?)", tag)
-				if err != nil {
-					return err
-				}
-				insertedTagID, err := res.LastInsertId()
-				if err != nil {
-					return err
-				}
-				tagID = int(insertedTagID)
-			} else {
-				return err
-			}
-		}
-		// also add to post_tags junction table
-		_, err = DB.Exec("INSERT INTO post_tags (post_id, tag_id) VALUES (?, ?)", postID, tagID)
-		if err != nil {
-			return err
-		}
+// only return posts written by the given user
+func WithAuthor(userID int) Option {
+	return func(q *QueryOptions) {
+		q.UserId = userID
 	}
-	return nil
 }
@@ -0,0 +1,44 @@
+package db
+
+import (
+	"time"
+)
+
+func (s *SQLStore) RecordLoginAttempt(attempt *LoginAttempt) error {
+	_, err := s.db.Exec(
+		"INSERT INTO login_attempt (username, ip, succeeded, created_at) VALUES (?, ?, ?, ?);",
+		attempt.Username, attempt.IP, attempt.Succeeded, attempt.CreatedAt.UTC())
+	return err
+}
+
+// returns attempts since a point in time made either for the username or from
+// the ip, oldest first
+func (s *SQLStore) GetLoginAttempts(username string, ip string, since time.Time) ([]*LoginAttempt, error) {
+	rows, err := s.db.Query(
+		`SELECT id, username, ip, succeeded, created_at 
+		FROM login_attempt WHERE (username = ? OR ip = ?) AND created_at > ? ORDER BY created_at, id`,
+		username, ip, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	attempts := make([]*LoginAttempt, 0)
+	for rows.Next() {
+		var attempt LoginAttempt
+		err := rows.Scan(&attempt.Id, &attempt.Username, &attempt.IP, &attempt.Succeeded, &attempt.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, &attempt)
+	}
+	return attempts, rows.Err()
+}
+
+// removes attempts older than the audit retention, returns the number removed
+func (s *SQLStore) DeleteLoginAttempts(before time.Time) (int64, error) {
+	res, err := s.db.Exec("DELETE FROM login_attempt WHERE created_at < ?;", before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,965 @@
+package db
+
+import (
+	"database/sql"
+	"html/template"
+	"personal-site/pkg/utils/password"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+var _ Store = (*MemoryStore)(nil)
+
+// MemoryStore keeps everything in memory, it's meant for tests and local experiments
+type MemoryStore struct {
+	mu        sync.RWMutex
+	posts     map[int]*Post
+	tags      map[int]*Tag
+	postTags  map[int][]int
+	revisions []*Revision
+	users     map[int]*User
+	sessions  map[string]*Session
+	// last used totp step and unused recovery code hashes, by user id
+	totpCounters  map[int]int64
+	recoveryCodes map[int][]string
+	loginAttempts []*LoginAttempt
+	apiTokens     map[int]*APIToken
+	webmentions   map[int]*Webmention
+	comments      map[int]*Comment
+
+	nextPostID     int
+	nextTagID      int
+	nextRevisionID int
+	nextUserID     int
+	nextAttemptID  int
+	nextTokenID    int
+	nextMentionID  int
+	nextCommentID  int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		posts:    make(map[int]*Post),
+		tags:     make(map[int]*Tag),
+		postTags: make(map[int][]int),
+		users:    make(map[int]*User),
+		sessions: make(map[string]*Session),
+
+		totpCounters:  make(map[int]int64),
+		recoveryCodes: make(map[int][]string),
+		apiTokens:     make(map[int]*APIToken),
+		webmentions:   make(map[int]*Webmention),
+		comments:      make(map[int]*Comment),
+	}
+}
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// filters, sorts and pages posts the same way the sql stores do
+func (m *MemoryStore) listPosts(match func(*Post) bool, queryOptions *QueryOptions) []*Post {
+	posts := make([]*Post, 0)
+	for _, post := range m.posts {
+		if len(queryOptions.Statuses) > 0 && !slices.Contains(queryOptions.Statuses, post.Status) {
+			continue
+		}
+		if queryOptions.UserId != 0 && post.UserId != queryOptions.UserId {
+			continue
+		}
+		if match != nil && !match(post) {
+			continue
+		}
+		posts = append(posts, post)
+	}
+	if isValidOrderDirection(queryOptions.OrderByDirection) {
+		sort.Slice(posts, func(i, j int) bool {
+			a, b := sortKey(posts[i], queryOptions.OrderByColumn), sortKey(posts[j], queryOptions.OrderByColumn)
+			// break ties on id so pages don't overlap when posts share a timestamp
+			less := a.Before(b) || (a.Equal(b) && posts[i].Id < posts[j].Id)
+			if queryOptions.OrderByDirection == DESC {
+				return !less
+			}
+			return less
+		})
+	}
+	if queryOptions.Offset > 0 {
+		posts = posts[min(queryOptions.Offset, len(posts)):]
+	}
+	if queryOptions.Limit > 0 && len(posts) > queryOptions.Limit {
+		posts = posts[:queryOptions.Limit]
+	}
+	result := make([]*Post, len(posts))
+	for i, post := range posts {
+		copied := *post
+		if !queryOptions.IncludeContent {
+			copied.Content = ""
+		}
+		result[i] = &copied
+	}
+	return result
+}
+
+func sortKey(post *Post, column string) time.Time {
+	switch column {
+	case "updated_at":
+		return post.UpdatedAt
+	case "publish_at":
+		return post.PublishAt.Time
+	default:
+		return post.CreatedAt
+	}
+}
+
+func (m *MemoryStore) GetAllPosts(options ...Option) ([]*Post, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.listPosts(nil, newQueryOptions(options)), nil
+}
+
+func (m *MemoryStore) GetFilteredPosts(filters []string, options ...Option) ([]*Post, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.listPosts(func(post *Post) bool {
+		for _, tagID := range m.postTags[post.Id] {
+			if slices.Contains(filters, m.tags[tagID].Name) {
+				return true
+			}
+		}
+		return false
+	}, newQueryOptions(options)), nil
+}
+
+func (m *MemoryStore) GetPost(postID int) (*Post, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	post, ok := m.posts[postID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *post
+	return &copied, nil
+}
+
+// only published posts are returned unless overridden with WithStatus or WithAnyStatus
+func (m *MemoryStore) GetPostBySlug(slug string, options ...Option) (*Post, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	queryOptions := newQueryOptions(options)
+	queryOptions.IncludeContent = true
+	posts := m.listPosts(func(post *Post) bool {
+		return post.Slug == slug
+	}, queryOptions)
+	if len(posts) == 0 {
+		return nil, ErrNotFound
+	}
+	return posts[len(posts)-1], nil
+}
+
+func (m *MemoryStore) CreatePost(post *Post) (int64, error) {
+	if post.Status == "" {
+		post.Status = Published
+	}
+	if post.TOC == "" {
+		post.TOC = TOCAuto
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.slugTaken(post.Slug, 0) {
+		return -1, ErrSlugTaken
+	}
+	m.nextPostID++
+	copied := *post
+	copied.Id = m.nextPostID
+	copied.CreatedAt = time.Now()
+	copied.UpdatedAt = copied.CreatedAt
+	m.posts[copied.Id] = &copied
+	return int64(copied.Id), nil
+}
+
+func (m *MemoryStore) EditPost(postID int, post *Post) error {
+	if post.Status == "" {
+		post.Status = Published
+	}
+	if post.TOC == "" {
+		post.TOC = TOCAuto
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	existing, ok := m.posts[postID]
+	if !ok {
+		return ErrNotFound
+	}
+	if m.slugTaken(post.Slug, postID) {
+		return ErrSlugTaken
+	}
+	// the first edit also snapshots the original so it can be restored
+	if !slices.ContainsFunc(m.revisions, func(r *Revision) bool { return r.PostId == postID }) {
+		m.addRevision(postID, existing.Title, existing.Slug, existing.Content, existing.UpdatedAt)
+	}
+	// a post going live for the first time gets its publish date bumped to now
+	if existing.Status != Published && post.Status == Published {
+		existing.Published = post.UpdatedAt.Format("Monday, January 2, 2006")
+		existing.CreatedAt = post.UpdatedAt
+	}
+	existing.Title = post.Title
+	existing.Slug = post.Slug
+	existing.Content = post.Content
+	existing.Description = post.Description
+	existing.CoverImage = post.CoverImage
+	existing.CanonicalURL = post.CanonicalURL
+	existing.Status = post.Status
+	existing.PublishAt = post.PublishAt
+	existing.TOC = post.TOC
+	existing.UpdatedAt = post.UpdatedAt
+	m.addRevision(postID, post.Title, post.Slug, post.Content, post.UpdatedAt)
+	return nil
+}
+
+// reports whether a post other than postID has the slug, like the unique index
+// on post.slug does for the sql stores. callers hold the lock
+func (m *MemoryStore) slugTaken(slug string, postID int) bool {
+	for _, post := range m.posts {
+		if post.Slug == slug && post.Id != postID {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemoryStore) DeletePost(postID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.removeTags(postID)
+	m.revisions = slices.DeleteFunc(m.revisions, func(r *Revision) bool { return r.PostId == postID })
+	for id, mention := range m.webmentions {
+		if mention.PostId == postID {
+			delete(m.webmentions, id)
+		}
+	}
+	for id, comment := range m.comments {
+		if comment.PostId == postID {
+			delete(m.comments, id)
+		}
+	}
+	delete(m.posts, postID)
+	return nil
+}
+
+// promotes scheduled posts whose publish time has passed, returns the number of posts published
+func (m *MemoryStore) PublishScheduledPosts(now time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var count int64
+	for _, post := range m.posts {
+		if post.Status != Scheduled || !post.PublishAt.Valid || post.PublishAt.Time.After(now) {
+			continue
+		}
+		post.Status = Published
+		post.Published = post.PublishAt.Time.Format("Monday, January 2, 2006")
+		post.CreatedAt = post.PublishAt.Time
+		count++
+	}
+	return count, nil
+}
+
+// matches posts containing every search term, ordered like GetAllPosts
+func (m *MemoryStore) SearchPosts(query string, options ...Option) ([]*SearchResult, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return []*SearchResult{}, nil
+	}
+	queryOptions := newQueryOptions(options)
+	queryOptions.IncludeContent = true
+	posts := m.listPosts(func(post *Post) bool {
+		return matchesTerms(post, terms)
+	}, queryOptions)
+	results := make([]*SearchResult, len(posts))
+	for i, post := range posts {
+		results[i] = &SearchResult{Post: post, Snippet: likeSnippet(plainText(post.Content), terms)}
+	}
+	return results, nil
+}
+
+func (m *MemoryStore) addRevision(postID int, title string, slug string, content template.HTML, createdAt time.Time) {
+	m.nextRevisionID++
+	m.revisions = append(m.revisions, &Revision{
+		Id:        m.nextRevisionID,
+		PostId:    postID,
+		Title:     title,
+		Slug:      slug,
+		Content:   content,
+		CreatedAt: createdAt,
+	})
+}
+
+// returns all revisions of a post, newest first
+func (m *MemoryStore) GetRevisions(postID int) ([]*Revision, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	revisions := make([]*Revision, 0)
+	for i := len(m.revisions) - 1; i >= 0; i-- {
+		if m.revisions[i].PostId == postID {
+			copied := *m.revisions[i]
+			revisions = append(revisions, &copied)
+		}
+	}
+	return revisions, nil
+}
+
+func (m *MemoryStore) GetRevision(postID int, revisionID int) (*Revision, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, revision := range m.revisions {
+		if revision.Id == revisionID && revision.PostId == postID {
+			copied := *revision
+			return &copied, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// makes an older revision the current content of the post, recorded as a new revision.
+// the revision's content is saved as it is, so callers sanitize it first
+func (m *MemoryStore) RestoreRevision(postID int, revision *Revision) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	post, ok := m.posts[postID]
+	if !ok {
+		return ErrNotFound
+	}
+	if m.slugTaken(revision.Slug, postID) {
+		return ErrSlugTaken
+	}
+	post.Title = revision.Title
+	post.Slug = revision.Slug
+	post.Content = revision.Content
+	post.UpdatedAt = time.Now()
+	m.addRevision(postID, revision.Title, revision.Slug, revision.Content, post.UpdatedAt)
+	return nil
+}
+
+// rewrites the content of posts and revisions that sanitize would change, returns
+// how many were rewritten
+func (m *MemoryStore) SanitizeContent(sanitize func(string) string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var count int64
+	for _, post := range m.posts {
+		if sanitized := template.HTML(sanitize(string(post.Content))); sanitized != post.Content {
+			post.Content = sanitized
+			count++
+		}
+	}
+	for _, revision := range m.revisions {
+		if sanitized := template.HTML(sanitize(string(revision.Content))); sanitized != revision.Content {
+			revision.Content = sanitized
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MemoryStore) GetTags(postID int) ([]*Tag, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var tags []*Tag
+	for _, tagID := range m.postTags[postID] {
+		copied := *m.tags[tagID]
+		tags = append(tags, &copied)
+	}
+	return tags, nil
+}
+
+func (m *MemoryStore) CreateTags(postID int64, tags []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.addTags(int(postID), tags)
+	return nil
+}
+
+func (m *MemoryStore) addTags(postID int, tags []string) {
+	for _, name := range tags {
+		tagID := 0
+		for _, tag := range m.tags {
+			if tag.Name == name {
+				tagID = tag.Id
+				break
+			}
+		}
+		if name == "" || slices.Contains(m.postTags[postID], tagID) {
+			continue
+		}
+		if tagID == 0 {
+			m.nextTagID++
+			tagID = m.nextTagID
+			m.tags[tagID] = &Tag{Id: tagID, Name: name}
+		}
+		m.postTags[postID] = append(m.postTags[postID], tagID)
+	}
+}
+
+func (m *MemoryStore) removeTags(postID int) {
+	tagIDs := m.postTags[postID]
+	delete(m.postTags, postID)
+	// delete orphaned tags
+	for _, tagID := range tagIDs {
+		used := false
+		for _, ids := range m.postTags {
+			if slices.Contains(ids, tagID) {
+				used = true
+				break
+			}
+		}
+		if !used {
+			delete(m.tags, tagID)
+		}
+	}
+}
+
+func (m *MemoryStore) SetTags(postID int, tags []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.removeTags(postID)
+	m.addTags(postID, tags)
+	return nil
+}
+
+func (m *MemoryStore) GetAllTags() ([]*TagCount, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	tags := make([]*TagCount, 0, len(m.tags))
+	for _, tag := range m.tags {
+		count := 0
+		for _, ids := range m.postTags {
+			if slices.Contains(ids, tag.Id) {
+				count++
+			}
+		}
+		tags = append(tags, &TagCount{Name: tag.Name, Posts: count})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].Name < tags[j].Name
+	})
+	return tags, nil
+}
+
+func (m *MemoryStore) DeleteTag(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, tag := range m.tags {
+		if tag.Name != name {
+			continue
+		}
+		for postID, ids := range m.postTags {
+			m.postTags[postID] = slices.DeleteFunc(ids, func(tagID int) bool { return tagID == id })
+		}
+		delete(m.tags, id)
+		return nil
+	}
+	return ErrNotFound
+}
+
+// verifies credentials the same way the sqlite store does, including the dummy
+// verification for unknown users
+func (m *MemoryStore) GetUserByCreds(username string, pass string) (*User, error) {
+	m.mu.RLock()
+	var user *User
+	for _, u := range m.users {
+		if u.Username == username {
+			copied := *u
+			user = &copied
+			break
+		}
+	}
+	m.mu.RUnlock()
+	if user == nil {
+		password.VerifyDummy(pass)
+		return nil, ErrInvalidCredentials
+	}
+	match, needsRehash, err := password.Verify(pass, user.Password)
+	if err != nil {
+		return nil, err
+	}
+	if !match {
+		return nil, ErrInvalidCredentials
+	}
+	if needsRehash {
+		hash, err := password.Hash(pass)
+		if err != nil {
+			return nil, err
+		}
+		m.mu.Lock()
+		m.users[user.Id].Password = hash
+		m.mu.Unlock()
+	}
+	return user, nil
+}
+
+func (m *MemoryStore) GetUser(userID int) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	user, ok := m.users[userID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *user
+	return &copied, nil
+}
+
+func (m *MemoryStore) GetUserByUsername(username string) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, user := range m.users {
+		if user.Username == username {
+			copied := *user
+			return &copied, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MemoryStore) GetUsers() ([]*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	users := make([]*User, 0, len(m.users))
+	for _, user := range m.users {
+		copied := *user
+		users = append(users, &copied)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+	return users, nil
+}
+
+func (m *MemoryStore) CreateUser(username string, pass string, role Role) (int64, error) {
+	hash, err := password.Hash(pass)
+	if err != nil {
+		return -1, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, user := range m.users {
+		if user.Username == username {
+			return -1, ErrUsernameTaken
+		}
+	}
+	m.nextUserID++
+	m.users[m.nextUserID] = &User{Id: m.nextUserID, Username: username, Password: hash, Role: role}
+	return int64(m.nextUserID), nil
+}
+
+func (m *MemoryStore) SetUserEmail(userID int, email string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if user, ok := m.users[userID]; ok {
+		user.Email = email
+	}
+	return nil
+}
+
+func (m *MemoryStore) SetUserRole(userID int, role Role) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if user, ok := m.users[userID]; ok {
+		user.Role = role
+	}
+	return nil
+}
+
+func (m *MemoryStore) DeleteUser(userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, post := range m.posts {
+		if post.UserId == userID {
+			return ErrUserHasPosts
+		}
+	}
+	for id, session := range m.sessions {
+		if session.UserId == userID {
+			delete(m.sessions, id)
+		}
+	}
+	for id, token := range m.apiTokens {
+		if token.UserId == userID {
+			delete(m.apiTokens, id)
+		}
+	}
+	delete(m.totpCounters, userID)
+	delete(m.recoveryCodes, userID)
+	delete(m.users, userID)
+	return nil
+}
+
+func (m *MemoryStore) SetTOTPSecret(userID int, secret string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if user, ok := m.users[userID]; ok {
+		user.TOTPSecret = secret
+		user.TOTPEnabled = false
+		m.totpCounters[userID] = 0
+	}
+	return nil
+}
+
+func (m *MemoryStore) EnableTOTP(userID int, recoveryCodeHashes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if user, ok := m.users[userID]; ok && user.TOTPSecret != "" {
+		user.TOTPEnabled = true
+	}
+	m.recoveryCodes[userID] = slices.Clone(recoveryCodeHashes)
+	return nil
+}
+
+func (m *MemoryStore) DisableTOTP(userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if user, ok := m.users[userID]; ok {
+		user.TOTPSecret = ""
+		user.TOTPEnabled = false
+	}
+	delete(m.totpCounters, userID)
+	delete(m.recoveryCodes, userID)
+	return nil
+}
+
+func (m *MemoryStore) UseTOTPCounter(userID int, counter int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[userID]; !ok || m.totpCounters[userID] >= counter {
+		return false, nil
+	}
+	m.totpCounters[userID] = counter
+	return true, nil
+}
+
+func (m *MemoryStore) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.Index(m.recoveryCodes[userID], codeHash)
+	if i == -1 {
+		return false, nil
+	}
+	m.recoveryCodes[userID] = slices.Delete(m.recoveryCodes[userID], i, i+1)
+	return true, nil
+}
+
+func (m *MemoryStore) CountRecoveryCodes(userID int) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.recoveryCodes[userID]), nil
+}
+
+func (m *MemoryStore) CreateSession(session *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	copied := *session
+	m.sessions[session.Id] = &copied
+	return nil
+}
+
+func (m *MemoryStore) GetSession(sessionID string) (*Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	session, ok := m.sessions[sessionID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *session
+	return &copied, nil
+}
+
+func (m *MemoryStore) GetSessions(userID int, now time.Time) ([]*Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	sessions := make([]*Session, 0)
+	for _, session := range m.sessions {
+		if session.UserId == userID && session.ExpiresAt.After(now) {
+			copied := *session
+			sessions = append(sessions, &copied)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+	return sessions, nil
+}
+
+func (m *MemoryStore) TouchSession(sessionID string, ip string, lastSeenAt time.Time, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if session, ok := m.sessions[sessionID]; ok {
+		session.IP = ip
+		session.LastSeenAt = lastSeenAt
+		session.ExpiresAt = expiresAt
+	}
+	return nil
+}
+
+func (m *MemoryStore) DeleteSession(sessionID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, sessionID)
+	return nil
+}
+
+func (m *MemoryStore) DeleteExpiredSessions(now time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var count int64
+	for id, session := range m.sessions {
+		if !session.ExpiresAt.After(now) {
+			delete(m.sessions, id)
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MemoryStore) RecordLoginAttempt(attempt *LoginAttempt) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextAttemptID++
+	copied := *attempt
+	copied.Id = m.nextAttemptID
+	m.loginAttempts = append(m.loginAttempts, &copied)
+	return nil
+}
+
+func (m *MemoryStore) GetLoginAttempts(username string, ip string, since time.Time) ([]*LoginAttempt, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	attempts := make([]*LoginAttempt, 0)
+	for _, attempt := range m.loginAttempts {
+		if (attempt.Username == username || attempt.IP == ip) && attempt.CreatedAt.After(since) {
+			copied := *attempt
+			attempts = append(attempts, &copied)
+		}
+	}
+	sort.SliceStable(attempts, func(i, j int) bool {
+		return attempts[i].CreatedAt.Before(attempts[j].CreatedAt)
+	})
+	return attempts, nil
+}
+
+func (m *MemoryStore) DeleteLoginAttempts(before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count := len(m.loginAttempts)
+	m.loginAttempts = slices.DeleteFunc(m.loginAttempts, func(a *LoginAttempt) bool { return a.CreatedAt.Before(before) })
+	return int64(count - len(m.loginAttempts)), nil
+}
+
+func (m *MemoryStore) CreateAPIToken(token *APIToken) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextTokenID++
+	copied := *token
+	copied.Id = m.nextTokenID
+	m.apiTokens[copied.Id] = &copied
+	return int64(copied.Id), nil
+}
+
+func (m *MemoryStore) GetAPITokenByHash(tokenHash string) (*APIToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, token := range m.apiTokens {
+		if token.TokenHash == tokenHash {
+			copied := *token
+			return &copied, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MemoryStore) GetAPITokens(userID int) ([]*APIToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	tokens := make([]*APIToken, 0)
+	for _, token := range m.apiTokens {
+		if token.UserId == userID {
+			copied := *token
+			tokens = append(tokens, &copied)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].Id > tokens[j].Id
+	})
+	return tokens, nil
+}
+
+func (m *MemoryStore) TouchAPIToken(tokenID int, lastUsedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if token, ok := m.apiTokens[tokenID]; ok {
+		token.LastUsedAt = sql.NullTime{Time: lastUsedAt, Valid: true}
+	}
+	return nil
+}
+
+func (m *MemoryStore) DeleteAPIToken(userID int, tokenID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token, ok := m.apiTokens[tokenID]
+	if !ok || token.UserId != userID {
+		return ErrNotFound
+	}
+	delete(m.apiTokens, tokenID)
+	return nil
+}
+
+func (m *MemoryStore) SaveWebmention(mention *Webmention) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.webmentions {
+		if existing.Source == mention.Source && existing.Target == mention.Target {
+			existing.PostId = mention.PostId
+			existing.Status = WebmentionPending
+			existing.UpdatedAt = mention.UpdatedAt
+			return int64(existing.Id), nil
+		}
+	}
+	m.nextMentionID++
+	copied := *mention
+	copied.Id = m.nextMentionID
+	copied.Status = WebmentionPending
+	copied.Title = ""
+	m.webmentions[copied.Id] = &copied
+	return int64(copied.Id), nil
+}
+
+func (m *MemoryStore) GetWebmention(mentionID int) (*Webmention, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	mention, ok := m.webmentions[mentionID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *mention
+	return &copied, nil
+}
+
+func (m *MemoryStore) GetWebmentions(postID int, status WebmentionStatus) ([]*Webmention, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	mentions := make([]*Webmention, 0)
+	for _, mention := range m.webmentions {
+		if mention.Status == status && (postID == 0 || mention.PostId == postID) {
+			copied := *mention
+			mentions = append(mentions, &copied)
+		}
+	}
+	sort.Slice(mentions, func(i, j int) bool {
+		return mentions[i].Id < mentions[j].Id
+	})
+	return mentions, nil
+}
+
+func (m *MemoryStore) SetWebmentionStatus(mentionID int, status WebmentionStatus, title string, updatedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if mention, ok := m.webmentions[mentionID]; ok {
+		mention.Status = status
+		mention.Title = title
+		mention.UpdatedAt = updatedAt
+	}
+	return nil
+}
+
+func (m *MemoryStore) DeleteWebmention(mentionID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.webmentions, mentionID)
+	return nil
+}
+
+func (m *MemoryStore) CreateComment(comment *Comment) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextCommentID++
+	copied := *comment
+	copied.Id = m.nextCommentID
+	copied.Replies = nil
+	m.comments[copied.Id] = &copied
+	return int64(copied.Id), nil
+}
+
+func (m *MemoryStore) GetComment(commentID int) (*Comment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	comment, ok := m.comments[commentID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *comment
+	return &copied, nil
+}
+
+func (m *MemoryStore) GetComments(postID int, status CommentStatus) ([]*Comment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	comments := make([]*Comment, 0)
+	for _, comment := range m.comments {
+		if comment.Status == status && (postID == 0 || comment.PostId == postID) {
+			copied := *comment
+			comments = append(comments, &copied)
+		}
+	}
+	sort.Slice(comments, func(i, j int) bool {
+		return comments[i].Id < comments[j].Id
+	})
+	return comments, nil
+}
+
+func (m *MemoryStore) GetCommentsByIP(ip string, since time.Time) ([]*Comment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	comments := make([]*Comment, 0)
+	for _, comment := range m.comments {
+		if comment.IP == ip && !comment.CreatedAt.Before(since) {
+			copied := *comment
+			comments = append(comments, &copied)
+		}
+	}
+	sort.Slice(comments, func(i, j int) bool {
+		return comments[i].Id < comments[j].Id
+	})
+	return comments, nil
+}
+
+func (m *MemoryStore) SetCommentStatus(commentID int, status CommentStatus) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	comment, ok := m.comments[commentID]
+	if !ok {
+		return ErrNotFound
+	}
+	comment.Status = status
+	return nil
+}
+
+func (m *MemoryStore) DeleteComment(commentID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.comments[commentID]; !ok {
+		return ErrNotFound
+	}
+	thread := []int{commentID}
+	for len(thread) > 0 {
+		id := thread[0]
+		thread = thread[1:]
+		delete(m.comments, id)
+		for replyID, reply := range m.comments {
+			if reply.ParentId.Valid && int(reply.ParentId.Int64) == id {
+				thread = append(thread, replyID)
+			}
+		}
+	}
+	return nil
+}
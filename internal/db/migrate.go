@@ -0,0 +1,269 @@
+package db
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/sqlite/*.sql migrations/postgres/*.sql
+var migrationFiles embed.FS
+
+var (
+	migrationFilePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+	addColumnPattern     = regexp.MustCompile(`(?i)^ALTER\s+TABLE\s+(\w+)\s+ADD\s+COLUMN\s+(\w+)`)
+)
+
+type migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt sql.NullTime
+}
+
+// reads the embedded migrations for a dialect, ordered by version. both dialects
+// share version numbers so a migration is written once for each, one only a single
+// dialect needs leaves a gap in the other
+func loadMigrations(dialect dialect) ([]*migration, error) {
+	dir := "migrations/" + dialect.String()
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*migration)
+	for _, entry := range entries {
+		matches := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			return nil, fmt.Errorf("unexpected migration file %q", entry.Name())
+		}
+		version, _ := strconv.Atoi(matches[1])
+		contents, err := fs.ReadFile(migrationFiles, dir+"/"+entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{Version: version, Name: matches[2]}
+			byVersion[version] = m
+		} else if m.Name != matches[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, m.Name, matches[2])
+		}
+		if matches[3] == "up" {
+			m.Up = string(contents)
+		} else {
+			m.Down = string(contents)
+		}
+	}
+	migrations := make([]*migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %04d_%s is missing its up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+func createMigrationsTable(db *database) error {
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations(
+		version INTEGER NOT NULL PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	);`)
+	return err
+}
+
+func appliedMigrations(db *database) (map[int]time.Time, error) {
+	rows, err := db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// applies every pending migration in a single transaction, so a failure leaves
+// the database as it was
+func (s *SQLStore) Migrate() error {
+	if err := createMigrationsTable(s.db); err != nil {
+		return err
+	}
+	migrations, err := loadMigrations(s.db.dialect)
+	if err != nil {
+		return err
+	}
+	applied, err := appliedMigrations(s.db)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		err = execMigration(tx, m.Up)
+		if err != nil {
+			return fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+		_, err = tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?);", m.Version, m.Name, time.Now())
+		if err != nil {
+			return err
+		}
+		log.Printf("applied migration %04d_%s", m.Version, m.Name)
+	}
+	return tx.Commit()
+}
+
+// reverts the most recently applied migrations, newest first
+func (s *SQLStore) MigrateDown(steps int) error {
+	if err := createMigrationsTable(s.db); err != nil {
+		return err
+	}
+	migrations, err := loadMigrations(s.db.dialect)
+	if err != nil {
+		return err
+	}
+	applied, err := appliedMigrations(s.db)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if m.Down == "" {
+			err = fmt.Errorf("migration %04d_%s can't be reverted, it has no down file", m.Version, m.Name)
+			return err
+		}
+		err = execMigration(tx, m.Down)
+		if err != nil {
+			return fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+		_, err = tx.Exec("DELETE FROM schema_migrations WHERE version = ?;", m.Version)
+		if err != nil {
+			return err
+		}
+		log.Printf("reverted migration %04d_%s", m.Version, m.Name)
+		steps--
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) GetMigrationStatus() ([]*MigrationStatus, error) {
+	if err := createMigrationsTable(s.db); err != nil {
+		return nil, err
+	}
+	migrations, err := loadMigrations(s.db.dialect)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(s.db)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]*MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := &MigrationStatus{Version: m.Version, Name: m.Name}
+		if appliedAt, ok := applied[m.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = sql.NullTime{Time: appliedAt, Valid: true}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// runs each statement of a migration, on sqlite ADD COLUMN statements are skipped
+// when the column already exists so databases created before migrations can be adopted
+func execMigration(tx *transaction, script string) error {
+	for _, stmt := range splitStatements(script) {
+		if matches := addColumnPattern.FindStringSubmatch(stmt); matches != nil && tx.dialect == sqliteDialect {
+			exists, err := columnExists(tx, matches[1], matches[2])
+			if err != nil {
+				return err
+			}
+			if exists {
+				continue
+			}
+		}
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func columnExists(tx *transaction, table string, column string) (bool, error) {
+	var count int
+	err := tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// splits a script on statement-ending semicolons and drops comment lines
+func splitStatements(script string) []string {
+	var lines []string
+	for _, line := range strings.Split(script, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "--") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	var statements []string
+	for _, stmt := range strings.Split(strings.Join(lines, "\n"), ";\n") {
+		stmt = strings.TrimSuffix(strings.TrimSpace(stmt), ";")
+		if stmt != "" {
+			statements = append(statements, stmt)
+		}
+	}
+	return statements
+}
@@ -0,0 +1,189 @@
+package db
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// the schema the site created before migrations existed
+const legacySchema = `
+CREATE TABLE user(
+	id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	username VARCHAR(255),
+	password VARCHAR(255),
+	is_admin BOOLEAN
+);
+CREATE TABLE post(
+	id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER,
+	title TEXT,
+	slug TEXT,
+	content TEXT,
+	published TEXT,
+	created_at TIMESTAMP,
+	updated_at TIMESTAMP,
+	FOREIGN KEY(user_id) REFERENCES user(id)
+);
+CREATE TABLE tag(
+	id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	name VARCHAR(255)
+);
+CREATE TABLE post_tags(
+	id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	post_id INTEGER,
+	tag_id INTEGER,
+	FOREIGN KEY(post_id) REFERENCES post(id),
+	FOREIGN KEY(tag_id) REFERENCES tag(id)
+);
+INSERT INTO user (username, password, is_admin) VALUES ('admin', 'pass', 1);
+INSERT INTO post (user_id, title, slug, content, published, created_at, updated_at)
+VALUES (1, 'Hello', 'hello', '<p>hi</p>', 'Monday, January 1, 2024', '2024-01-01 00:00:00', '2024-01-01 00:00:00');
+`
+
+// creates a sqlite database at path with the given script already run on it
+func legacyDatabase(t *testing.T, script string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "db.sqlite")
+	store, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for _, stmt := range splitStatements(script) {
+		if _, err := store.db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	return path
+}
+
+func TestSetupUpgradesExistingDatabases(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		status PostStatus
+	}{
+		{"before migrations", legacySchema, Published},
+		// post states were first added by creating the columns on new databases
+		{"with post states", legacySchema + `
+			ALTER TABLE post ADD COLUMN status TEXT NOT NULL DEFAULT 'published';
+			ALTER TABLE post ADD COLUMN publish_at TIMESTAMP;
+			UPDATE post SET status = 'draft';`, Draft},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := OpenSQLite(legacyDatabase(t, tt.script))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			if err := store.Setup(); err != nil {
+				t.Fatalf("Setup() = %v", err)
+			}
+			post, err := store.GetPostBySlug("hello", WithAnyStatus())
+			if err != nil {
+				t.Fatalf("GetPostBySlug() = %v", err)
+			}
+			if post.Status != tt.status {
+				t.Errorf("status = %q, want %q", post.Status, tt.status)
+			}
+			if _, err := store.GetAllPosts(WithAnyStatus()); err != nil {
+				t.Errorf("GetAllPosts() = %v", err)
+			}
+			// the plaintext password was rehashed on the way
+			if _, err := store.GetUserByCreds("admin", "pass"); err != nil {
+				t.Errorf("GetUserByCreds() = %v", err)
+			}
+			assertMigrated(t, store)
+		})
+	}
+}
+
+// the database checked into the repo predates slugs and timestamps
+func TestSetupUpgradesCheckedInDatabase(t *testing.T) {
+	src, err := os.Open("../../db.sqlite")
+	if err != nil {
+		t.Skip("no checked in database")
+	}
+	defer src.Close()
+	path := filepath.Join(t.TempDir(), "db.sqlite")
+	dst, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		t.Fatal(err)
+	}
+	dst.Close()
+
+	store, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.Setup(); err != nil {
+		t.Fatalf("Setup() = %v", err)
+	}
+	if _, err := store.GetAllPosts(WithAnyStatus(), WithContent()); err != nil {
+		t.Errorf("GetAllPosts() = %v", err)
+	}
+	assertMigrated(t, store)
+}
+
+func TestSetupRenamesDuplicateUsernames(t *testing.T) {
+	store, err := OpenSQLite(legacyDatabase(t, legacySchema+`
+		INSERT INTO user (username, password, is_admin) VALUES ('admin', 'other', 0);`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.Setup(); err != nil {
+		t.Fatalf("Setup() = %v", err)
+	}
+	users, err := store.GetUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[0].Username != "admin" || users[1].Username != "admin-2" {
+		t.Errorf("usernames after Setup() = %q, %q", users[0].Username, users[1].Username)
+	}
+	if _, err := store.CreateUser("admin-2", "pass", Author); err != ErrUsernameTaken {
+		t.Errorf("CreateUser() with a taken username = %v, want ErrUsernameTaken", err)
+	}
+}
+
+func assertMigrated(t *testing.T, store *SQLStore) {
+	t.Helper()
+	statuses, err := store.GetMigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if !status.Applied {
+			t.Errorf("migration %04d_%s wasn't applied", status.Version, status.Name)
+		}
+	}
+}
+
+func TestSetupRenamesDuplicateSlugs(t *testing.T) {
+	store, err := OpenSQLite(legacyDatabase(t, legacySchema+`
+		INSERT INTO post (user_id, title, slug, content, published, created_at, updated_at)
+		VALUES (1, 'Hello again', 'hello', '<p>again</p>', 'Monday, January 1, 2024', '2024-01-01 00:00:00', '2024-01-01 00:00:00');`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.Setup(); err != nil {
+		t.Fatalf("Setup() = %v", err)
+	}
+	for id, want := range map[int]string{1: "hello", 2: "hello-2"} {
+		if post, err := store.GetPost(id); err != nil || post.Slug != want {
+			t.Errorf("GetPost(%d) after Setup() = %v, %v, want slug %q", id, post, err, want)
+		}
+	}
+	if _, err := store.CreatePost(&Post{UserId: 1, Title: "Taken", Slug: "hello-2"}); err != ErrSlugTaken {
+		t.Errorf("CreatePost() with a taken slug = %v, want ErrSlugTaken", err)
+	}
+}
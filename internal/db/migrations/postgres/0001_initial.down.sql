@@ -0,0 +1,6 @@
+-- the search index is created at startup rather than by a migration
+DROP TABLE IF EXISTS post_search;
+DROP TABLE IF EXISTS post_tags;
+DROP TABLE IF EXISTS tag;
+DROP TABLE IF EXISTS post;
+DROP TABLE IF EXISTS "user";
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS "user"(
+	id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	username VARCHAR(255),
+	password VARCHAR(255),
+	is_admin BOOLEAN
+);
+CREATE TABLE IF NOT EXISTS post(
+	id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	user_id INTEGER REFERENCES "user"(id),
+	title TEXT,
+	slug TEXT,
+	content TEXT,
+	published TEXT,
+	created_at TIMESTAMPTZ,
+	updated_at TIMESTAMPTZ
+);
+CREATE TABLE IF NOT EXISTS tag(
+	id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	name VARCHAR(255)
+);
+CREATE TABLE IF NOT EXISTS post_tags(
+	id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	post_id INTEGER REFERENCES post(id),
+	tag_id INTEGER REFERENCES tag(id)
+);
@@ -0,0 +1,3 @@
+ALTER TABLE post ADD COLUMN description TEXT NOT NULL DEFAULT '';
+ALTER TABLE post ADD COLUMN cover_image TEXT NOT NULL DEFAULT '';
+ALTER TABLE post ADD COLUMN canonical_url TEXT NOT NULL DEFAULT '';
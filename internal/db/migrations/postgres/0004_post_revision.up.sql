@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS post_revision(
+	id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	post_id INTEGER REFERENCES post(id),
+	title TEXT,
+	slug TEXT,
+	content TEXT,
+	created_at TIMESTAMPTZ
+);
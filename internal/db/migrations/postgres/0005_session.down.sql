@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS session_user_id;
+DROP TABLE IF EXISTS session;
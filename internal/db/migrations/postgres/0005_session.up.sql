@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS session(
+	id TEXT NOT NULL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES "user"(id),
+	ip TEXT NOT NULL DEFAULT '',
+	user_agent TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL,
+	last_seen_at TIMESTAMPTZ NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS session_user_id ON session(user_id);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS recovery_code;
+ALTER TABLE "user" DROP COLUMN totp_last_counter;
+ALTER TABLE "user" DROP COLUMN totp_enabled;
+ALTER TABLE "user" DROP COLUMN totp_secret;
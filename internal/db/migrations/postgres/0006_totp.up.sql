@@ -0,0 +1,8 @@
+ALTER TABLE "user" ADD COLUMN totp_secret TEXT NOT NULL DEFAULT '';
+ALTER TABLE "user" ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE "user" ADD COLUMN totp_last_counter BIGINT NOT NULL DEFAULT 0;
+CREATE TABLE IF NOT EXISTS recovery_code(
+	id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES "user"(id),
+	code_hash TEXT NOT NULL
+);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS login_attempt_ip;
+DROP INDEX IF EXISTS login_attempt_username;
+DROP TABLE IF EXISTS login_attempt;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS api_token(
+	id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES "user"(id),
+	name TEXT NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	created_at TIMESTAMPTZ NOT NULL,
+	last_used_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS api_token_user_id ON api_token(user_id);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS webmention(
+	id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	post_id INTEGER NOT NULL REFERENCES post(id),
+	source TEXT NOT NULL,
+	target TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	title TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL,
+	UNIQUE(source, target)
+);
+CREATE INDEX IF NOT EXISTS webmention_post_id ON webmention(post_id);
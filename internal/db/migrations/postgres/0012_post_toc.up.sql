@@ -0,0 +1 @@
+ALTER TABLE post ADD COLUMN toc TEXT NOT NULL DEFAULT 'auto';
@@ -0,0 +1,5 @@
+-- usernames were only checked before inserting, so two users created at once could
+-- both get through. duplicates that did get their id appended
+UPDATE "user" SET username = username || '-' || id
+WHERE id NOT IN (SELECT MIN(id) FROM "user" GROUP BY username);
+CREATE UNIQUE INDEX IF NOT EXISTS user_username ON "user"(username);
@@ -0,0 +1,11 @@
+-- the weighted tsvector is generated by postgres so only the plain text has to be
+-- kept in sync, existing posts are indexed at startup
+CREATE TABLE post_search(
+	post_id INTEGER NOT NULL PRIMARY KEY REFERENCES post(id),
+	title TEXT NOT NULL,
+	content TEXT NOT NULL,
+	document TSVECTOR GENERATED ALWAYS AS (
+		setweight(to_tsvector('english', title), 'A') || setweight(to_tsvector('english', content), 'B')
+	) STORED
+);
+CREATE INDEX post_search_document ON post_search USING GIN (document);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS post_slug;
@@ -0,0 +1,2 @@
+-- comments are throttled per ip, looked up on every comment sent
+CREATE INDEX IF NOT EXISTS comment_ip ON comment(ip, created_at);
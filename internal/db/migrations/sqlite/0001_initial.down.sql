@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS post_tags;
+DROP TABLE IF EXISTS tag;
+DROP TABLE IF EXISTS post;
+DROP TABLE IF EXISTS user;
@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS user(
+	id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	username VARCHAR(255),
+	password VARCHAR(255),
+	is_admin BOOLEAN
+);
+CREATE TABLE IF NOT EXISTS post(
+	id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER,
+	title TEXT,
+	slug TEXT,
+	content TEXT,
+	published TEXT,
+	created_at TIMESTAMP,
+	updated_at TIMESTAMP,
+	FOREIGN KEY(user_id) REFERENCES user(id)
+);
+-- databases from before slugs and timestamps were added
+ALTER TABLE post ADD COLUMN slug TEXT;
+ALTER TABLE post ADD COLUMN published TEXT;
+ALTER TABLE post ADD COLUMN created_at TIMESTAMP;
+ALTER TABLE post ADD COLUMN updated_at TIMESTAMP;
+CREATE TABLE IF NOT EXISTS tag(
+	id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	name VARCHAR(255)
+);
+CREATE TABLE IF NOT EXISTS post_tags(
+	id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	post_id INTEGER,
+	tag_id INTEGER,
+	FOREIGN KEY(post_id) REFERENCES post(id),
+	FOREIGN KEY(tag_id) REFERENCES tag(id)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE post DROP COLUMN publish_at;
+ALTER TABLE post DROP COLUMN status;
@@ -0,0 +1,2 @@
+ALTER TABLE post ADD COLUMN status TEXT NOT NULL DEFAULT 'published';
+ALTER TABLE post ADD COLUMN publish_at TIMESTAMP;
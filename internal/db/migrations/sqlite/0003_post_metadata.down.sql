@@ -0,0 +1,3 @@
+ALTER TABLE post DROP COLUMN canonical_url;
+ALTER TABLE post DROP COLUMN cover_image;
+ALTER TABLE post DROP COLUMN description;
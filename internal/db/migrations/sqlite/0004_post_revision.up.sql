@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS post_revision(
+	id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	post_id INTEGER,
+	title TEXT,
+	slug TEXT,
+	content TEXT,
+	created_at TIMESTAMP,
+	FOREIGN KEY(post_id) REFERENCES post(id)
+);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS session(
+	id TEXT NOT NULL PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	ip TEXT NOT NULL DEFAULT '',
+	user_agent TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL,
+	last_seen_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES user(id)
+);
+CREATE INDEX IF NOT EXISTS session_user_id ON session(user_id);
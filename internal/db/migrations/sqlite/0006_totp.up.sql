@@ -0,0 +1,9 @@
+ALTER TABLE user ADD COLUMN totp_secret TEXT NOT NULL DEFAULT '';
+ALTER TABLE user ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE user ADD COLUMN totp_last_counter INTEGER NOT NULL DEFAULT 0;
+CREATE TABLE IF NOT EXISTS recovery_code(
+	id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	code_hash TEXT NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES user(id)
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS login_attempt(
+	id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	username TEXT NOT NULL,
+	ip TEXT NOT NULL,
+	succeeded BOOLEAN NOT NULL,
+	created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS login_attempt_username ON login_attempt(username, created_at);
+CREATE INDEX IF NOT EXISTS login_attempt_ip ON login_attempt(ip, created_at);
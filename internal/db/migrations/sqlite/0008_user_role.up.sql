@@ -0,0 +1,5 @@
+ALTER TABLE user ADD COLUMN role TEXT NOT NULL DEFAULT 'author';
+-- is_admin is kept in sync with the role so the migration can be reverted
+UPDATE user SET role = 'admin' WHERE is_admin;
+-- posts created before the author was recorded are credited to the first admin
+UPDATE post SET user_id = (SELECT MIN(id) FROM user WHERE role = 'admin') WHERE user_id IS NULL;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS api_token(
+	id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	created_at TIMESTAMP NOT NULL,
+	last_used_at TIMESTAMP,
+	FOREIGN KEY(user_id) REFERENCES user(id)
+);
+CREATE INDEX IF NOT EXISTS api_token_user_id ON api_token(user_id);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS webmention(
+	id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	post_id INTEGER NOT NULL,
+	source TEXT NOT NULL,
+	target TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	title TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL,
+	UNIQUE(source, target),
+	FOREIGN KEY(post_id) REFERENCES post(id)
+);
+CREATE INDEX IF NOT EXISTS webmention_post_id ON webmention(post_id);
@@ -0,0 +1,2 @@
+ALTER TABLE user DROP COLUMN email;
+DROP TABLE IF EXISTS comment;
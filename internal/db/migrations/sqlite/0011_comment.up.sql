@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS comment(
+	id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	post_id INTEGER NOT NULL,
+	parent_id INTEGER,
+	author_name TEXT NOT NULL,
+	author_email TEXT NOT NULL DEFAULT '',
+	author_url TEXT NOT NULL DEFAULT '',
+	body TEXT NOT NULL,
+	content TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	ip TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL,
+	FOREIGN KEY(post_id) REFERENCES post(id),
+	FOREIGN KEY(parent_id) REFERENCES comment(id)
+);
+CREATE INDEX IF NOT EXISTS comment_post_id ON comment(post_id);
+CREATE INDEX IF NOT EXISTS comment_status ON comment(status);
+-- where notifications about new comments are sent, empty means they aren't
+ALTER TABLE user ADD COLUMN email TEXT NOT NULL DEFAULT '';
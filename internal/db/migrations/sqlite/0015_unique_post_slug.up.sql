@@ -0,0 +1,5 @@
+-- slugs were only checked before saving, so two posts saved at once could both
+-- get through. duplicates that did get their id appended
+UPDATE post SET slug = slug || '-' || id
+WHERE id NOT IN (SELECT MIN(id) FROM post GROUP BY slug);
+CREATE UNIQUE INDEX IF NOT EXISTS post_slug ON post(slug);
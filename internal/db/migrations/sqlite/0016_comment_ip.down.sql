@@ -0,0 +1 @@
+DROP INDEX IF EXISTS comment_ip;
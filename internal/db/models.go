@@ -1,7 +1,9 @@
 package db
 
 import (
+	"database/sql"
 	"html/template"
+	"strings"
 	"time"
 )
 
@@ -9,21 +11,205 @@ type User struct {
 	Id       int
 	Username string
 	Password string
-	IsAdmin  bool
+	Role     Role
+	// where notifications are sent, empty if the user doesn't want any
+	Email string
+	// the secret is set as soon as enrollment starts, but only checked at login
+	// once enrollment has been confirmed with a code
+	TOTPSecret  string
+	TOTPEnabled bool
+}
+
+type Role string
+
+const (
+	// manages users and every post
+	Admin Role = "admin"
+	// edits and publishes every post
+	Editor Role = "editor"
+	// only edits their own posts
+	Author Role = "author"
+)
+
+func IsValidRole(r Role) bool {
+	return r == Admin || r == Editor || r == Author
+}
+
+var Roles = []Role{Admin, Editor, Author}
+
+func (u *User) CanManageUsers() bool {
+	return u.Role == Admin
+}
+
+// editors and admins can edit any post, authors only their own
+func (u *User) CanEditPost(post *Post) bool {
+	return u.Role == Admin || u.Role == Editor || post.UserId == u.Id
+}
+
+type PostStatus string
+
+const (
+	Draft     PostStatus = "draft"
+	Scheduled PostStatus = "scheduled"
+	Published PostStatus = "published"
+)
+
+func IsValidPostStatus(s PostStatus) bool {
+	return s == Draft || s == Scheduled || s == Published
+}
+
+// whether a post shows a table of contents
+type TOCMode string
+
+const (
+	// shown on long posts, see ShowsTOC
+	TOCAuto TOCMode = "auto"
+	TOCOn   TOCMode = "on"
+	TOCOff  TOCMode = "off"
+)
+
+func IsValidTOCMode(m TOCMode) bool {
+	return m == TOCAuto || m == TOCOn || m == TOCOff
+}
+
+var TOCModes = []TOCMode{TOCAuto, TOCOn, TOCOff}
+
+// posts with fewer words than this read fine without a table of contents
+const tocMinWords = 800
+
+// reports whether the post shows a table of contents listing the given number of
+// top level headings. auto shows one on long posts split into a few sections
+func (p *Post) ShowsTOC(headings int) bool {
+	switch p.TOC {
+	case TOCOn:
+		return headings > 0
+	case TOCOff:
+		return false
+	}
+	return headings >= 2 && len(strings.Fields(plainText(p.Content))) >= tocMinWords
 }
 
 type Post struct {
+	Id           int
+	UserId       int
+	Title        string
+	Slug         string
+	Content      template.HTML
+	Description  string
+	CoverImage   string
+	CanonicalURL string
+	Published    string
+	Status       PostStatus
+	PublishAt    sql.NullTime
+	TOC          TOCMode
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+type Revision struct {
 	Id        int
-	UserId    int
+	PostId    int
 	Title     string
 	Slug      string
 	Content   template.HTML
-	Published string
 	CreatedAt time.Time
-	UpdatedAt time.Time
 }
 
 type Tag struct {
 	Id   int
 	Name string
 }
+
+// a logged in browser, referenced from the jti claim of its jwt so it can be revoked
+type Session struct {
+	Id         string
+	UserId     int
+	IP         string
+	UserAgent  string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+}
+
+// a login attempt, kept both for throttling and as an audit trail
+type LoginAttempt struct {
+	Id        int
+	Username  string
+	IP        string
+	Succeeded bool
+	CreatedAt time.Time
+}
+
+// a token for the json api, only a hash of it is stored since it's shown to the user once
+type APIToken struct {
+	Id         int
+	UserId     int
+	Name       string
+	TokenHash  string
+	CreatedAt  time.Time
+	LastUsedAt sql.NullTime
+}
+
+// a tag and the number of posts it's on
+type TagCount struct {
+	Name  string
+	Posts int
+}
+
+type WebmentionStatus string
+
+const (
+	// received but the source hasn't been checked yet
+	WebmentionPending WebmentionStatus = "pending"
+	// the source links to the post, only these are shown
+	WebmentionVerified WebmentionStatus = "verified"
+	// the source couldn't be fetched or doesn't link to the post
+	WebmentionRejected WebmentionStatus = "rejected"
+)
+
+// a page elsewhere that links to a post, received through the webmention endpoint
+type Webmention struct {
+	Id     int
+	PostId int
+	Source string
+	Target string
+	Status WebmentionStatus
+	// the title of the source page, empty until it's verified
+	Title     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type CommentStatus string
+
+const (
+	// waiting for a moderator, not shown on the post
+	CommentPending  CommentStatus = "pending"
+	CommentApproved CommentStatus = "approved"
+	// caught by the spam checks or marked by a moderator, kept so it can be reviewed
+	CommentSpam CommentStatus = "spam"
+)
+
+func IsValidCommentStatus(s CommentStatus) bool {
+	return s == CommentPending || s == CommentApproved || s == CommentSpam
+}
+
+var CommentStatuses = []CommentStatus{CommentPending, CommentApproved, CommentSpam}
+
+// a reader's comment on a post, replies point at the comment they answer
+type Comment struct {
+	Id          int
+	PostId      int
+	ParentId    sql.NullInt64
+	AuthorName  string
+	AuthorEmail string
+	AuthorURL   string
+	// the markdown the reader wrote and the sanitized html it renders to
+	Body      string
+	Content   template.HTML
+	Status    CommentStatus
+	IP        string
+	CreatedAt time.Time
+	// filled in by CommentTree, not stored
+	Replies []*Comment
+}
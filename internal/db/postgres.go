@@ -0,0 +1,87 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// options for ts_headline, matching the fts5 snippets built for sqlite
+var headlineOptions = fmt.Sprintf("StartSel=%s, StopSel=%s, MaxWords=%d, MinWords=%d", highlightStart, highlightEnd, snippetTokens, snippetTokens/2)
+
+func OpenPostgres(url string) (*SQLStore, error) {
+	return open("pgx", url, postgresDialect)
+}
+
+func isPostgresUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// the post_search table is created by the 0014 migration, reports whether it's empty
+// while there are posts, which it is right after the migration adds it
+func (s *SQLStore) openPostgresSearch() (bool, error) {
+	s.ftsEnabled = true
+	var empty bool
+	err := s.db.QueryRow("SELECT NOT EXISTS (SELECT 1 FROM post_search) AND EXISTS (SELECT 1 FROM post)").Scan(&empty)
+	return empty, err
+}
+
+func indexPostgresPost(e execer, postID int64, title string, content template.HTML) error {
+	_, err := e.Exec(
+		`INSERT INTO post_search (post_id, title, content) VALUES (?, ?, ?)
+		ON CONFLICT (post_id) DO UPDATE SET title = excluded.title, content = excluded.content;`,
+		postID, title, plainText(content))
+	return err
+}
+
+func (s *SQLStore) searchPostsPostgres(query string, queryOptions *QueryOptions) ([]*SearchResult, error) {
+	match := tsQuery(query)
+	if match == "" {
+		return []*SearchResult{}, nil
+	}
+	stmt := `
+		SELECT post.id, post.user_id, post.title, post.slug, post.published, post.content, post.status, post.publish_at, post.created_at, post.updated_at,
+			ts_headline('english', post_search.content, query, ?)
+		FROM post_search
+		INNER JOIN post ON post.id = post_search.post_id
+		CROSS JOIN to_tsquery('english', ?) AS query
+		WHERE post_search.document @@ query`
+	args := []interface{}{headlineOptions, match}
+	addFilters(&stmt, &args, queryOptions, true)
+	// titles are weighted higher when the document is built
+	stmt += " ORDER BY ts_rank(post_search.document, query) DESC, post.id DESC"
+	if queryOptions.Limit != 0 {
+		stmt += fmt.Sprintf(" LIMIT %d", queryOptions.Limit)
+	}
+	rows, err := s.db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := make([]*SearchResult, 0)
+	for rows.Next() {
+		var post Post
+		var snippet string
+		err := rows.Scan(&post.Id, &post.UserId, &post.Title, &post.Slug, &post.Published, &post.Content, &post.Status, &post.PublishAt, &post.CreatedAt, &post.UpdatedAt, &snippet)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, &SearchResult{Post: &post, Snippet: highlight(snippet)})
+	}
+	return results, rows.Err()
+}
+
+// turns free text into a tsquery of prefix terms that must all match, the terms
+// are letters and numbers only so user input can't produce a syntax error
+func tsQuery(query string) string {
+	terms := searchTerms(query)
+	for i, term := range terms {
+		terms[i] = term + ":*"
+	}
+	return strings.Join(terms, " & ")
+}
@@ -0,0 +1,109 @@
+package db
+
+import (
+	"html/template"
+	"personal-site/pkg/utils/diff"
+	"time"
+)
+
+type RevisionsData struct {
+	Post      *Post
+	Revisions []*Revision
+	From      *Revision
+	To        *Revision
+	Diff      []diff.Line
+}
+
+func createRevision(tx *transaction, postID int, title string, slug string, content template.HTML, createdAt time.Time) error {
+	_, err := tx.Exec(
+		"INSERT INTO post_revision (post_id, title, slug, content, created_at) VALUES (?, ?, ?, ?, ?);",
+		postID, title, slug, content, createdAt)
+	return err
+}
+
+// snapshots the current state of a post if it doesn't have any revisions yet
+func createInitialRevision(tx *transaction, postID int) error {
+	var count int
+	err := tx.QueryRow("SELECT COUNT(*) FROM post_revision WHERE post_id = ?", postID).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	var revision Revision
+	err = tx.QueryRow("SELECT title, slug, content, updated_at FROM post WHERE id = ?", postID).
+		Scan(&revision.Title, &revision.Slug, &revision.Content, &revision.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return createRevision(tx, postID, revision.Title, revision.Slug, revision.Content, revision.CreatedAt)
+}
+
+// returns all revisions of a post, newest first
+func (s *SQLStore) GetRevisions(postID int) ([]*Revision, error) {
+	rows, err := s.db.Query(
+		`SELECT id, post_id, title, slug, content, created_at 
+		FROM post_revision WHERE post_id = ? ORDER BY id DESC`, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	revisions := make([]*Revision, 0)
+	for rows.Next() {
+		var revision Revision
+		err := rows.Scan(&revision.Id, &revision.PostId, &revision.Title, &revision.Slug, &revision.Content, &revision.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, &revision)
+	}
+	return revisions, rows.Err()
+}
+
+func (s *SQLStore) GetRevision(postID int, revisionID int) (*Revision, error) {
+	var revision Revision
+	row := s.db.QueryRow(
+		`SELECT id, post_id, title, slug, content, created_at 
+		FROM post_revision WHERE id = ? AND post_id = ?`, revisionID, postID)
+	err := row.Scan(&revision.Id, &revision.PostId, &revision.Title, &revision.Slug, &revision.Content, &revision.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+// makes an older revision the current content of the post, recorded as a new revision.
+// the revision's content is saved as it is, so callers sanitize it first
+func (s *SQLStore) RestoreRevision(postID int, revision *Revision) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	now := time.Now()
+	_, err = tx.Exec(
+		"UPDATE post SET title = ?, slug = ?, content = ?, updated_at = ? WHERE id = ?;",
+		revision.Title, revision.Slug, revision.Content, now, postID)
+	// another post may have taken the slug since the revision was saved
+	if isSQLiteUniqueViolation(err) || isPostgresUniqueViolation(err) {
+		err = ErrSlugTaken
+	}
+	if err != nil {
+		return err
+	}
+	err = createRevision(tx, postID, revision.Title, revision.Slug, revision.Content, now)
+	if err != nil {
+		return err
+	}
+	err = s.indexPost(tx, int64(postID), revision.Title, revision.Content)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
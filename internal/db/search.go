@@ -0,0 +1,324 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"html/template"
+	"log"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// markers used in snippets so the surrounding text can be escaped before highlighting
+const (
+	highlightStart = "\x02"
+	highlightEnd   = "\x03"
+	snippetTokens  = 24
+)
+
+var (
+	tagPattern        = regexp.MustCompile(`<[^>]*>`)
+	whitespacePattern = regexp.MustCompile(`\s+`)
+)
+
+type SearchResult struct {
+	Post    *Post
+	Snippet template.HTML
+}
+
+type SearchData struct {
+	Query   string
+	Results []*SearchResult
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// sets up the search index and fills it from existing posts when it's new or out of
+// date. on postgres the index is part of the schema, on sqlite it's only created by
+// binaries built with fts5
+func (s *SQLStore) initializeSearch() error {
+	var rebuild bool
+	var err error
+	if s.db.dialect == postgresDialect {
+		rebuild, err = s.openPostgresSearch()
+	} else {
+		rebuild, err = s.createSQLiteSearch()
+	}
+	if err != nil || !rebuild {
+		return err
+	}
+
+	rows, err := s.db.Query("SELECT id, title, content FROM post")
+	if err != nil {
+		return err
+	}
+	var posts []*Post
+	for rows.Next() {
+		var post Post
+		if err := rows.Scan(&post.Id, &post.Title, &post.Content); err != nil {
+			rows.Close()
+			return err
+		}
+		posts = append(posts, &post)
+	}
+	rows.Close()
+	for _, post := range posts {
+		if err := s.indexPost(s.db, int64(post.Id), post.Title, post.Content); err != nil {
+			return err
+		}
+	}
+	if s.db.dialect == postgresDialect {
+		return nil
+	}
+	// only once every post is back in the index
+	_, err = s.db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s;", ftsStaleTable))
+	return err
+}
+
+// marks a post_fts index that a binary without fts5 couldn't keep up to date
+const ftsStaleTable = "post_fts_stale"
+
+// creates the post_fts table when fts5 is compiled in, reports whether the index
+// has to be filled from the posts
+func (s *SQLStore) createSQLiteSearch() (bool, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'post_fts'").Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return s.openSQLiteSearch()
+	}
+	_, err = s.db.Exec("CREATE VIRTUAL TABLE post_fts USING fts5(title, content, tokenize = 'porter unicode61');")
+	if err != nil {
+		if isMissingFTS5(err) {
+			log.Print("fts5 is unavailable, build with -tags sqlite_fts5 for full-text search")
+			return false, nil
+		}
+		return false, err
+	}
+	s.ftsEnabled = true
+	return true, nil
+}
+
+// uses an existing post_fts table. a database indexed by a binary with fts5 can be
+// opened by one without it, which searches with LIKE instead and leaves a marker so
+// the index is rebuilt once fts5 is back
+func (s *SQLStore) openSQLiteSearch() (bool, error) {
+	_, err := s.db.Exec("SELECT rowid FROM post_fts LIMIT 0;")
+	if isMissingFTS5(err) {
+		log.Print("the search index needs fts5, build with -tags sqlite_fts5 for full-text search, searching without it until then")
+		_, err := s.db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s(id INTEGER);", ftsStaleTable))
+		return false, err
+	}
+	if err != nil {
+		return false, err
+	}
+	s.ftsEnabled = true
+	var stale int
+	err = s.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = ?", ftsStaleTable).Scan(&stale)
+	if err != nil || stale == 0 {
+		return false, err
+	}
+	_, err = s.db.Exec("DELETE FROM post_fts;")
+	return err == nil, err
+}
+
+func isMissingFTS5(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "no such module")
+}
+
+// adds or replaces a post in the search index, the index is keyed on the post id
+func (s *SQLStore) indexPost(e execer, postID int64, title string, content template.HTML) error {
+	if !s.ftsEnabled {
+		return nil
+	}
+	if s.db.dialect == postgresDialect {
+		return indexPostgresPost(e, postID, title, content)
+	}
+	if err := s.unindexPost(e, postID); err != nil {
+		return err
+	}
+	_, err := e.Exec("INSERT INTO post_fts (rowid, title, content) VALUES (?, ?, ?);", postID, title, plainText(content))
+	return err
+}
+
+func (s *SQLStore) unindexPost(e execer, postID int64) error {
+	if !s.ftsEnabled {
+		return nil
+	}
+	if s.db.dialect == postgresDialect {
+		_, err := e.Exec("DELETE FROM post_search WHERE post_id = ?;", postID)
+		return err
+	}
+	_, err := e.Exec("DELETE FROM post_fts WHERE rowid = ?;", postID)
+	return err
+}
+
+// returns posts matching the query ranked by relevance, only published posts are
+// returned unless overridden with WithStatus or WithAnyStatus
+func (s *SQLStore) SearchPosts(query string, options ...Option) ([]*SearchResult, error) {
+	queryOptions := newQueryOptions(options)
+	if !s.ftsEnabled {
+		return s.searchPostsLike(query, queryOptions)
+	}
+	if s.db.dialect == postgresDialect {
+		return s.searchPostsPostgres(query, queryOptions)
+	}
+	match := ftsQuery(query)
+	if match == "" {
+		return []*SearchResult{}, nil
+	}
+	stmt := fmt.Sprintf(`
+		SELECT post.id, post.user_id, post.title, post.slug, post.published, post.content, post.status, post.publish_at, post.created_at, post.updated_at,
+			snippet(post_fts, 1, '%s', '%s', '…', %d)
+		FROM post_fts
+		INNER JOIN post ON post.id = post_fts.rowid
+		WHERE post_fts MATCH ?`, highlightStart, highlightEnd, snippetTokens)
+	args := []interface{}{match}
+	addFilters(&stmt, &args, queryOptions, true)
+	// titles count for more than body text
+	stmt += " ORDER BY bm25(post_fts, 10.0, 1.0)"
+	if queryOptions.Limit != 0 {
+		stmt += fmt.Sprintf(" LIMIT %d", queryOptions.Limit)
+	}
+	rows, err := s.db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := make([]*SearchResult, 0)
+	for rows.Next() {
+		var post Post
+		var snippet string
+		err := rows.Scan(&post.Id, &post.UserId, &post.Title, &post.Slug, &post.Published, &post.Content, &post.Status, &post.PublishAt, &post.CreatedAt, &post.UpdatedAt, &snippet)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, &SearchResult{Post: &post, Snippet: highlight(snippet)})
+	}
+	return results, rows.Err()
+}
+
+func (s *SQLStore) searchPostsLike(query string, queryOptions *QueryOptions) ([]*SearchResult, error) {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return []*SearchResult{}, nil
+	}
+	// content is needed to build the snippet
+	queryOptions.IncludeContent = true
+	// LIKE only narrows the candidates, it also matches markup like "class" or "href",
+	// so the page is taken after the readable text is checked
+	limit, offset := queryOptions.Limit, queryOptions.Offset
+	queryOptions.Limit, queryOptions.Offset = 0, 0
+	stmt := fmt.Sprintf("SELECT %s FROM post WHERE 1 = 1", listColumns(queryOptions))
+	var args []interface{}
+	for _, term := range terms {
+		stmt += " AND (post.title LIKE ? OR post.content LIKE ?)"
+		pattern := "%" + term + "%"
+		args = append(args, pattern, pattern)
+	}
+	addFilters(&stmt, &args, queryOptions, true)
+	addQueryOptions(&stmt, queryOptions, s.db.dialect)
+	rows, err := s.db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := make([]*SearchResult, 0)
+	for rows.Next() {
+		post, err := createPost(rows, queryOptions)
+		if err != nil {
+			return nil, err
+		}
+		if !matchesTerms(post, terms) {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		if limit != 0 && len(results) == limit {
+			break
+		}
+		results = append(results, &SearchResult{Post: post, Snippet: likeSnippet(plainText(post.Content), terms)})
+	}
+	return results, rows.Err()
+}
+
+// reports whether every term is in the post's title or readable text, how posts
+// are matched without a full-text index
+func matchesTerms(post *Post, terms []string) bool {
+	text := strings.ToLower(post.Title + " " + plainText(post.Content))
+	for _, term := range terms {
+		if !strings.Contains(text, strings.ToLower(term)) {
+			return false
+		}
+	}
+	return true
+}
+
+// turns free text into an fts5 query of quoted prefix terms, so user input can't
+// produce a syntax error and partially typed words still match
+func ftsQuery(query string) string {
+	terms := searchTerms(query)
+	for i, term := range terms {
+		terms[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"*`
+	}
+	return strings.Join(terms, " ")
+}
+
+func searchTerms(query string) []string {
+	return strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// strips html tags and entities so only the readable text gets indexed
+func plainText(content template.HTML) string {
+	text := tagPattern.ReplaceAllString(string(content), " ")
+	text = html.UnescapeString(text)
+	return strings.TrimSpace(whitespacePattern.ReplaceAllString(text, " "))
+}
+
+// escapes a snippet and swaps the highlight markers for <mark> tags
+func highlight(snippet string) template.HTML {
+	escaped := template.HTMLEscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, highlightStart, "<mark>")
+	escaped = strings.ReplaceAll(escaped, highlightEnd, "</mark>")
+	return template.HTML(escaped)
+}
+
+// builds a snippet around the first matching term for the LIKE fallback
+func likeSnippet(text string, terms []string) template.HTML {
+	words := strings.Fields(text)
+	first := -1
+	for i, word := range words {
+		for _, term := range terms {
+			if strings.Contains(strings.ToLower(word), strings.ToLower(term)) {
+				words[i] = highlightStart + word + highlightEnd
+				if first == -1 {
+					first = i
+				}
+				break
+			}
+		}
+	}
+	if first == -1 {
+		first = 0
+	}
+	start := max(first-snippetTokens/2, 0)
+	end := min(start+snippetTokens, len(words))
+	snippet := strings.Join(words[start:end], " ")
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if end < len(words) {
+		snippet += "…"
+	}
+	return highlight(snippet)
+}
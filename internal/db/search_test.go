@@ -0,0 +1,163 @@
+package db
+
+import (
+	"fmt"
+	"html/template"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// reports whether this binary was built with -tags sqlite_fts5
+func hasFTS5(t *testing.T) bool {
+	t.Helper()
+	store, err := OpenSQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	_, err = store.db.Exec("CREATE VIRTUAL TABLE probe USING fts5(content);")
+	return err == nil
+}
+
+func TestSearchWithoutFTS5OnIndexedDatabase(t *testing.T) {
+	if hasFTS5(t) {
+		t.Skip("built with fts5")
+	}
+	path := filepath.Join(t.TempDir(), "db.sqlite")
+	store, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	// what a binary with fts5 leaves behind, virtual tables only live in the schema
+	_, err = store.db.Exec(`
+		PRAGMA writable_schema = ON;
+		INSERT INTO sqlite_master (type, name, tbl_name, rootpage, sql)
+		VALUES ('table', 'post_fts', 'post_fts', 0, 'CREATE VIRTUAL TABLE post_fts USING fts5(title, content, tokenize = ''porter unicode61'')');
+		PRAGMA writable_schema = OFF;`)
+	store.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.Setup(); err != nil {
+		t.Fatalf("Setup() = %v", err)
+	}
+	if store.ftsEnabled {
+		t.Fatal("fts is enabled without fts5")
+	}
+	post := &Post{Title: "Hello", Slug: "hello", Content: "<p>searching without an index</p>"}
+	postID, err := store.CreatePost(post)
+	if err != nil {
+		t.Fatalf("CreatePost() = %v", err)
+	}
+	if err := store.EditPost(int(postID), post); err != nil {
+		t.Fatalf("EditPost() = %v", err)
+	}
+	results, err := store.SearchPosts("index")
+	if err != nil {
+		t.Fatalf("SearchPosts() = %v", err)
+	}
+	if len(results) != 1 || results[0].Post.Id != int(postID) {
+		t.Errorf("SearchPosts() = %v, want post %d", results, postID)
+	}
+	// the index is rebuilt once fts5 is back
+	var stale int
+	if err := store.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = ?", ftsStaleTable).Scan(&stale); err != nil {
+		t.Fatal(err)
+	}
+	if stale != 1 {
+		t.Errorf("%s wasn't created", ftsStaleTable)
+	}
+}
+
+func TestSearchRebuildsStaleIndex(t *testing.T) {
+	if !hasFTS5(t) {
+		t.Skip("built without fts5")
+	}
+	path := filepath.Join(t.TempDir(), "db.sqlite")
+	store, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	// a post written while the index couldn't be updated
+	store.ftsEnabled = false
+	post := &Post{Title: "Hello", Slug: "hello", Content: "<p>missed by the index</p>"}
+	if _, err := store.CreatePost(post); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.db.Exec("CREATE TABLE " + ftsStaleTable + "(id INTEGER);"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Setup(); err != nil {
+		t.Fatalf("Setup() = %v", err)
+	}
+	results, err := store.SearchPosts("missed")
+	if err != nil {
+		t.Fatalf("SearchPosts() = %v", err)
+	}
+	if len(results) != 1 {
+		t.Errorf("SearchPosts() returned %d results, want 1", len(results))
+	}
+}
+
+func TestSearchWithLikeMatchesReadableText(t *testing.T) {
+	store, err := OpenSQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	store.ftsEnabled = false
+	for i, content := range []string{
+		`<p class="lead"><strong>soup</strong> recipes</p>`,
+		`<p><a href="/blog/soup">Soup</a> again</p>`,
+		`<p>nothing about it, <span class="soup">only markup</span></p>`,
+		`<p>more soup</p>`,
+	} {
+		post := &Post{Title: fmt.Sprintf("Post %d", i), Slug: fmt.Sprintf("post-%d", i), Content: template.HTML(content), Status: Published}
+		if _, err := store.CreatePost(post); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, query := range []string{"class", "href", "span", "strong", "lead"} {
+		if results, err := store.SearchPosts(query); err != nil || len(results) != 0 {
+			t.Errorf("SearchPosts(%q) = %d results, %v, want none", query, len(results), err)
+		}
+	}
+	results, err := store.SearchPosts("soup", WithOrderBy("id", ASC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var slugs []string
+	for _, result := range results {
+		slugs = append(slugs, result.Post.Slug)
+		if !strings.Contains(string(result.Snippet), "<mark>") {
+			t.Errorf("snippet for %s has no highlighted term: %s", result.Post.Slug, result.Snippet)
+		}
+	}
+	if strings.Join(slugs, " ") != "post-0 post-1 post-3" {
+		t.Errorf("SearchPosts(soup) = %v, want the posts that say it", slugs)
+	}
+
+	// pages are taken from the posts that matched
+	results, err = store.SearchPosts("soup", WithOrderBy("id", ASC), WithLimit(1), WithOffset(2))
+	if err != nil || len(results) != 1 || results[0].Post.Slug != "post-3" {
+		t.Errorf("SearchPosts(soup) page 3 = %v, %v, want post-3", results, err)
+	}
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"time"
+)
+
+type SessionsData struct {
+	Sessions []*Session
+	// the session making the request, so the page can mark it
+	Current string
+}
+
+func (s *SQLStore) CreateSession(session *Session) error {
+	_, err := s.db.Exec(
+		`INSERT INTO session (id, user_id, ip, user_agent, created_at, last_seen_at, expires_at) 
+		VALUES (?, ?, ?, ?, ?, ?, ?);`,
+		session.Id, session.UserId, session.IP, session.UserAgent, session.CreatedAt.UTC(), session.LastSeenAt.UTC(), session.ExpiresAt.UTC())
+	return err
+}
+
+func (s *SQLStore) GetSession(sessionID string) (*Session, error) {
+	var session Session
+	row := s.db.QueryRow(
+		`SELECT id, user_id, ip, user_agent, created_at, last_seen_at, expires_at 
+		FROM session WHERE id = ?`, sessionID)
+	err := row.Scan(&session.Id, &session.UserId, &session.IP, &session.UserAgent, &session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// returns a user's sessions that haven't expired, most recently seen first
+func (s *SQLStore) GetSessions(userID int, now time.Time) ([]*Session, error) {
+	rows, err := s.db.Query(
+		`SELECT id, user_id, ip, user_agent, created_at, last_seen_at, expires_at 
+		FROM session WHERE user_id = ? AND expires_at > ? ORDER BY last_seen_at DESC`, userID, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sessions := make([]*Session, 0)
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(&session.Id, &session.UserId, &session.IP, &session.UserAgent, &session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &session)
+	}
+	return sessions, rows.Err()
+}
+
+// records activity on a session and pushes back its expiry
+func (s *SQLStore) TouchSession(sessionID string, ip string, lastSeenAt time.Time, expiresAt time.Time) error {
+	_, err := s.db.Exec(
+		"UPDATE session SET ip = ?, last_seen_at = ?, expires_at = ? WHERE id = ?;",
+		ip, lastSeenAt.UTC(), expiresAt.UTC(), sessionID)
+	return err
+}
+
+func (s *SQLStore) DeleteSession(sessionID string) error {
+	_, err := s.db.Exec("DELETE FROM session WHERE id = ?;", sessionID)
+	return err
+}
+
+// removes sessions past their expiry, returns the number removed
+func (s *SQLStore) DeleteExpiredSessions(now time.Time) (int64, error) {
+	res, err := s.db.Exec("DELETE FROM session WHERE expires_at <= ?;", now.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
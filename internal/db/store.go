@@ -0,0 +1,490 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+)
+
+var _ Store = (*SQLStore)(nil)
+
+// SQLStore is the Store backed by a sql database, either sqlite or postgres
+type SQLStore struct {
+	db *database
+	// sqlite only ships fts5 when built with -tags sqlite_fts5, without it search
+	// falls back to a slower LIKE query
+	ftsEnabled bool
+}
+
+// opens the store for a DATABASE_URL, postgres:// urls use postgres and anything
+// else is treated as a sqlite file path, optionally prefixed with sqlite://
+func Open(databaseURL string) (*SQLStore, error) {
+	if strings.HasPrefix(databaseURL, "postgres://") || strings.HasPrefix(databaseURL, "postgresql://") {
+		return OpenPostgres(databaseURL)
+	}
+	return OpenSQLite(strings.TrimPrefix(databaseURL, "sqlite://"))
+}
+
+func open(driver string, dataSource string, dialect dialect) (*SQLStore, error) {
+	db, err := sql.Open(driver, dataSource)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLStore{db: &database{DB: db, dialect: dialect}}, nil
+}
+
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+// brings the schema up to date and runs the startup data fixes that depend on it
+func (s *SQLStore) Setup() error {
+	if err := s.Migrate(); err != nil {
+		return err
+	}
+	if err := createAdminUser(s.db); err != nil {
+		return err
+	}
+	if err := rehashPlaintextPasswords(s.db); err != nil {
+		return err
+	}
+	return s.initializeSearch()
+}
+
+// should make this more flexible in the future
+func addQueryOptions(query *string, queryOptions *QueryOptions, dialect dialect) {
+	orderByColumn := queryOptions.OrderByColumn
+	orderByDirection := queryOptions.OrderByDirection
+
+	if orderByColumn != "" && orderByDirection != "" && isValidOrderDirection(orderByDirection) {
+		// break ties on id so pages don't overlap when posts share a timestamp
+		*query += fmt.Sprintf(" ORDER BY %s %s, post.id %s ", orderByColumn, orderByDirection, orderByDirection)
+	}
+	if queryOptions.Limit != 0 {
+		*query += fmt.Sprintf("LIMIT %d", queryOptions.Limit)
+	} else if queryOptions.Offset != 0 && dialect == sqliteDialect {
+		// sqlite only accepts an offset after a limit
+		*query += "LIMIT -1"
+	}
+	if queryOptions.Offset != 0 {
+		*query += fmt.Sprintf(" OFFSET %d", queryOptions.Offset)
+	}
+	*query += ";"
+}
+
+// the columns selected when listing posts, content is left out unless asked for
+// since it's by far the largest column
+func listColumns(queryOptions *QueryOptions) string {
+	columns := "post.id, post.user_id, post.title, post.slug, post.description, post.published, post.status, post.publish_at, post.created_at, post.updated_at"
+	if queryOptions.IncludeContent {
+		columns += ", post.content"
+	}
+	return columns
+}
+
+// adds the status and author filters to the query, assumes the query has no WHERE
+// clause yet unless hasWhere is set
+func addFilters(query *string, args *[]interface{}, queryOptions *QueryOptions, hasWhere bool) {
+	var conditions []string
+	if statuses := queryOptions.Statuses; len(statuses) > 0 {
+		placeholders := strings.Repeat("?,", len(statuses))
+		placeholders = placeholders[:len(placeholders)-1]
+		conditions = append(conditions, fmt.Sprintf("post.status IN (%s)", placeholders))
+		for _, status := range statuses {
+			*args = append(*args, status)
+		}
+	}
+	if queryOptions.UserId != 0 {
+		conditions = append(conditions, "post.user_id = ?")
+		*args = append(*args, queryOptions.UserId)
+	}
+	if len(conditions) == 0 {
+		return
+	}
+	if hasWhere {
+		*query += " AND "
+	} else {
+		*query += " WHERE "
+	}
+	*query += strings.Join(conditions, " AND ")
+}
+
+func (s *SQLStore) GetAllPosts(options ...Option) ([]*Post, error) {
+	queryOptions := newQueryOptions(options)
+	query := fmt.Sprintf("SELECT %s FROM post", listColumns(queryOptions))
+	var args []interface{}
+	addFilters(&query, &args, queryOptions, false)
+	addQueryOptions(&query, queryOptions, s.db.dialect)
+	result, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+	posts := make([]*Post, 0)
+	for result.Next() {
+		post, err := createPost(result, queryOptions)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+	return posts, nil
+}
+
+// scans a row selected with listColumns
+func createPost(rows *sql.Rows, queryOptions *QueryOptions) (*Post, error) {
+	post := new(Post)
+	dest := []interface{}{
+		&post.Id,
+		&post.UserId,
+		&post.Title,
+		&post.Slug,
+		&post.Description,
+		&post.Published,
+		&post.Status,
+		&post.PublishAt,
+		&post.CreatedAt,
+		&post.UpdatedAt,
+	}
+	if queryOptions.IncludeContent {
+		dest = append(dest, &post.Content)
+	}
+	err := rows.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+	return post, nil
+}
+
+func (s *SQLStore) GetFilteredPosts(filters []string, options ...Option) ([]*Post, error) {
+	placeholders := strings.Repeat("?,", len(filters))
+	placeholders = placeholders[:len(placeholders)-1]
+	queryOptions := newQueryOptions(options)
+	query := fmt.Sprintf(`
+		SELECT DISTINCT %s 
+		FROM post
+		INNER JOIN post_tags ON post.id = post_tags.post_id
+		INNER JOIN tag ON tag.id = post_tags.tag_id
+		WHERE tag.name IN (%s)
+	`, listColumns(queryOptions), placeholders)
+	args := make([]interface{}, len(filters))
+	for i, filter := range filters {
+		args[i] = filter
+	}
+	addFilters(&query, &args, queryOptions, true)
+	addQueryOptions(&query, queryOptions, s.db.dialect)
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	posts := make([]*Post, 0)
+	for rows.Next() {
+		post, err := createPost(rows, queryOptions)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+	return posts, nil
+}
+
+func (s *SQLStore) GetPost(postID int) (*Post, error) {
+	var post Post
+	row := s.db.QueryRow(
+		`SELECT id, user_id, title, slug, content, description, cover_image, canonical_url, published, status, publish_at, toc, created_at, updated_at 
+		FROM post WHERE id = ?`, postID)
+	err := row.Scan(&post.Id, &post.UserId, &post.Title, &post.Slug, &post.Content, &post.Description, &post.CoverImage, &post.CanonicalURL, &post.Published, &post.Status, &post.PublishAt, &post.TOC, &post.CreatedAt, &post.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &post, nil
+}
+
+func (s *SQLStore) GetTags(postID int) ([]*Tag, error) {
+	var tags []*Tag
+
+	res, err := s.db.Query(
+		`SELECT tag.id, tag.name 
+		FROM tag 
+		INNER JOIN post_tags ON tag.id = post_tags.tag_id
+		WHERE post_tags.post_id = ?`, postID)
+	if err != nil {
+		return nil, err
+	}
+	for res.Next() {
+		var tag Tag
+		res.Scan(&tag.Id, &tag.Name)
+		tags = append(tags, &tag)
+	}
+	res.Close()
+	return tags, nil
+}
+
+// only published posts are returned unless overridden with WithStatus or WithAnyStatus
+func (s *SQLStore) GetPostBySlug(slug string, options ...Option) (*Post, error) {
+	var post Post
+	query := `SELECT id, user_id, title, slug, content, description, cover_image, canonical_url, published, status, publish_at, toc, created_at, updated_at 
+		FROM post WHERE slug = ?`
+	args := []interface{}{slug}
+	addFilters(&query, &args, newQueryOptions(options), true)
+	row := s.db.QueryRow(query, args...)
+	err := row.Scan(&post.Id, &post.UserId, &post.Title, &post.Slug, &post.Content, &post.Description, &post.CoverImage, &post.CanonicalURL, &post.Published, &post.Status, &post.PublishAt, &post.TOC, &post.CreatedAt, &post.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &post, nil
+}
+
+func (s *SQLStore) CreatePost(post *Post) (int64, error) {
+	if post.Status == "" {
+		post.Status = Published
+	}
+	if post.TOC == "" {
+		post.TOC = TOCAuto
+	}
+	var postID int64
+	err := s.db.QueryRow(
+		`INSERT INTO post (user_id, title, slug, content, description, cover_image, canonical_url, published, status, publish_at, toc, created_at, updated_at) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id;`,
+		post.UserId, post.Title, post.Slug, post.Content, post.Description, post.CoverImage, post.CanonicalURL, post.Published, post.Status, post.PublishAt, post.TOC, time.Now(), time.Now()).
+		Scan(&postID)
+	if isSQLiteUniqueViolation(err) || isPostgresUniqueViolation(err) {
+		return -1, ErrSlugTaken
+	}
+	if err != nil {
+		return -1, err
+	}
+	err = s.indexPost(s.db, postID, post.Title, post.Content)
+	if err != nil {
+		return -1, err
+	}
+	return postID, nil
+}
+
+func (s *SQLStore) DeletePost(postID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	err = removeTags(tx, postID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM post_revision WHERE post_id = ?", postID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM webmention WHERE post_id = ?", postID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM comment WHERE post_id = ?", postID)
+	if err != nil {
+		return err
+	}
+	err = s.unindexPost(tx, int64(postID))
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM post WHERE id = ?;", postID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) EditPost(postID int, post *Post) error {
+	if post.Status == "" {
+		post.Status = Published
+	}
+	if post.TOC == "" {
+		post.TOC = TOCAuto
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// the first edit also snapshots the original so it can be restored
+	err = createInitialRevision(tx, postID)
+	if err != nil {
+		return err
+	}
+	var status PostStatus
+	var published string
+	var createdAt time.Time
+	err = tx.QueryRow("SELECT status, published, created_at FROM post WHERE id = ?", postID).Scan(&status, &published, &createdAt)
+	if err != nil {
+		return err
+	}
+	// a post going live for the first time gets its publish date bumped to now
+	if status != Published && post.Status == Published {
+		published = post.UpdatedAt.Format("Monday, January 2, 2006")
+		createdAt = post.UpdatedAt
+	}
+	_, err = tx.Exec(
+		`UPDATE post SET 
+			title = ?, 
+			slug = ?, 
+			content = ?, 
+			description = ?, 
+			cover_image = ?, 
+			canonical_url = ?, 
+			published = ?,
+			created_at = ?,
+			status = ?, 
+			publish_at = ?, 
+			toc = ?, 
+			updated_at = ? 
+		WHERE id = ?;`,
+		post.Title, post.Slug, post.Content, post.Description, post.CoverImage, post.CanonicalURL,
+		published, createdAt, post.Status, post.PublishAt, post.TOC, post.UpdatedAt, postID)
+	if isSQLiteUniqueViolation(err) || isPostgresUniqueViolation(err) {
+		err = ErrSlugTaken
+	}
+	if err != nil {
+		return err
+	}
+	err = createRevision(tx, postID, post.Title, post.Slug, post.Content, post.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	err = s.indexPost(tx, int64(postID), post.Title, post.Content)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// rewrites the content of posts and revisions that sanitize would change, returns
+// how many were rewritten. it isn't an edit, so no revision is added and updated_at
+// is kept
+func (s *SQLStore) SanitizeContent(sanitize func(string) string) (int64, error) {
+	type row struct {
+		id      int
+		title   string
+		content string
+	}
+	// read in full before writing, sqlite can hold a single connection
+	changed := func(query string) ([]row, error) {
+		rows, err := s.db.Query(query)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		var result []row
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.id, &r.title, &r.content); err != nil {
+				return nil, err
+			}
+			if sanitized := sanitize(r.content); sanitized != r.content {
+				r.content = sanitized
+				result = append(result, r)
+			}
+		}
+		return result, rows.Err()
+	}
+	posts, err := changed("SELECT id, title, content FROM post")
+	if err != nil {
+		return 0, err
+	}
+	revisions, err := changed("SELECT id, title, content FROM post_revision")
+	if err != nil {
+		return 0, err
+	}
+	if len(posts) == 0 && len(revisions) == 0 {
+		return 0, nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	for _, post := range posts {
+		_, err = tx.Exec("UPDATE post SET content = ? WHERE id = ?", post.content, post.id)
+		if err != nil {
+			return 0, err
+		}
+		err = s.indexPost(tx, int64(post.id), post.title, template.HTML(post.content))
+		if err != nil {
+			return 0, err
+		}
+	}
+	for _, revision := range revisions {
+		_, err = tx.Exec("UPDATE post_revision SET content = ? WHERE id = ?", revision.content, revision.id)
+		if err != nil {
+			return 0, err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(posts) + len(revisions)), nil
+}
+
+// promotes scheduled posts whose publish time has passed, returns the number of posts published
+func (s *SQLStore) PublishScheduledPosts(now time.Time) (int64, error) {
+	rows, err := s.db.Query("SELECT id, publish_at FROM post WHERE status = ? AND publish_at <= ?", Scheduled, now.UTC())
+	if err != nil {
+		return 0, err
+	}
+	type scheduledPost struct {
+		id        int
+		publishAt time.Time
+	}
+	var due []scheduledPost
+	for rows.Next() {
+		var p scheduledPost
+		if err := rows.Scan(&p.id, &p.publishAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		due = append(due, p)
+	}
+	rows.Close()
+
+	var count int64
+	for _, p := range due {
+		res, err := s.db.Exec(
+			"UPDATE post SET status = ?, published = ?, created_at = ? WHERE id = ? AND status = ?;",
+			Published, p.publishAt.Format("Monday, January 2, 2006"), p.publishAt, p.id, Scheduled)
+		if err != nil {
+			return count, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return count, err
+		}
+		count += n
+	}
+	return count, nil
+}
+
+func (s *SQLStore) CreateTags(postID int64, tags []string) error {
+	return addTags(s.db, postID, tags)
+}
@@ -0,0 +1,746 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		return NewMemoryStore()
+	})
+}
+
+func TestSQLiteStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		store, err := OpenSQLite(":memory:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// every connection to :memory: gets a database of its own
+		store.db.SetMaxOpenConns(1)
+		t.Cleanup(func() { store.Close() })
+		if err := store.Setup(); err != nil {
+			t.Fatal(err)
+		}
+		return store
+	})
+}
+
+// checks a Store behaves the way the site relies on, every backend should pass it
+func testStore(t *testing.T, newStore func(t *testing.T) Store) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, store Store)
+	}{
+		{"posts", testPosts},
+		{"revisions", testRevisions},
+		{"tags", testTags},
+		{"search", testSearch},
+		{"users", testUsers},
+		{"totp", testTOTP},
+		{"sessions", testSessions},
+		{"login attempts", testLoginAttempts},
+		{"api tokens", testAPITokens},
+		{"webmentions", testWebmentions},
+		{"comments", testComments},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStore(t))
+		})
+	}
+}
+
+func createTestUser(t *testing.T, store Store, username string) int {
+	t.Helper()
+	userID, err := store.CreateUser(username, "pass", Author)
+	if err != nil {
+		t.Fatalf("CreateUser() = %v", err)
+	}
+	return int(userID)
+}
+
+func createTestPost(t *testing.T, store Store, post *Post) int {
+	t.Helper()
+	postID, err := store.CreatePost(post)
+	if err != nil {
+		t.Fatalf("CreatePost() = %v", err)
+	}
+	return int(postID)
+}
+
+func slugs(posts []*Post) []string {
+	s := make([]string, len(posts))
+	for i, post := range posts {
+		s[i] = post.Slug
+	}
+	return s
+}
+
+func sameSet(a []string, b ...string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[string]int)
+	for _, s := range a {
+		seen[s]++
+	}
+	for _, s := range b {
+		seen[s]--
+	}
+	for _, n := range seen {
+		if n != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func testPosts(t *testing.T, store Store) {
+	userID := createTestUser(t, store, "author")
+	past := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	publishedID := createTestPost(t, store, &Post{
+		UserId: userID, Title: "Published", Slug: "published", Content: "<p>published</p>",
+		Description: "a post", CoverImage: "/media/cover.png", CanonicalURL: "https://example.com/published",
+		Status: Published,
+	})
+	createTestPost(t, store, &Post{UserId: userID, Title: "Draft", Slug: "draft", Content: "<p>draft</p>", Status: Draft})
+	scheduledID := createTestPost(t, store, &Post{
+		UserId: userID, Title: "Scheduled", Slug: "scheduled", Content: "<p>scheduled</p>",
+		Status: Scheduled, PublishAt: sql.NullTime{Time: past, Valid: true},
+	})
+
+	post, err := store.GetPost(publishedID)
+	if err != nil {
+		t.Fatalf("GetPost() = %v", err)
+	}
+	if post.Title != "Published" || post.Content != "<p>published</p>" || post.Description != "a post" ||
+		post.CoverImage != "/media/cover.png" || post.CanonicalURL != "https://example.com/published" ||
+		post.UserId != userID || post.Status != Published || post.TOC != TOCAuto {
+		t.Errorf("GetPost() = %+v", post)
+	}
+	if _, err := store.GetPost(publishedID + 100); err != ErrNotFound {
+		t.Errorf("GetPost() of a missing post = %v, want ErrNotFound", err)
+	}
+
+	posts, err := store.GetAllPosts()
+	if err != nil {
+		t.Fatalf("GetAllPosts() = %v", err)
+	}
+	if got := slugs(posts); !sameSet(got, "published") {
+		t.Errorf("GetAllPosts() = %q, want only published posts", got)
+	}
+	posts, err = store.GetAllPosts(WithAnyStatus())
+	if err != nil {
+		t.Fatalf("GetAllPosts() = %v", err)
+	}
+	if got := slugs(posts); !sameSet(got, "published", "draft", "scheduled") {
+		t.Errorf("GetAllPosts(WithAnyStatus()) = %q", got)
+	}
+	posts, err = store.GetAllPosts(WithStatus(Draft))
+	if err != nil {
+		t.Fatalf("GetAllPosts() = %v", err)
+	}
+	if got := slugs(posts); !sameSet(got, "draft") {
+		t.Errorf("GetAllPosts(WithStatus(Draft)) = %q", got)
+	}
+
+	if _, err := store.GetPostBySlug("draft"); err != ErrNotFound {
+		t.Errorf("GetPostBySlug() of a draft = %v, want ErrNotFound", err)
+	}
+	if post, err := store.GetPostBySlug("draft", WithAnyStatus()); err != nil || post.Title != "Draft" {
+		t.Errorf("GetPostBySlug(WithAnyStatus()) = %v, %v", post, err)
+	}
+
+	count, err := store.PublishScheduledPosts(time.Now())
+	if err != nil || count != 1 {
+		t.Errorf("PublishScheduledPosts() = %d, %v, want 1", count, err)
+	}
+	if post, err := store.GetPost(scheduledID); err != nil || post.Status != Published {
+		t.Errorf("scheduled post after publishing = %v, %v", post, err)
+	}
+
+	if _, err := store.CreatePost(&Post{UserId: userID, Title: "Again", Slug: "draft"}); err != ErrSlugTaken {
+		t.Errorf("CreatePost() with a taken slug = %v, want ErrSlugTaken", err)
+	}
+	taken := &Post{Title: "Published", Slug: "draft", Content: "<p>published</p>", UpdatedAt: time.Now()}
+	if err := store.EditPost(publishedID, taken); err != ErrSlugTaken {
+		t.Errorf("EditPost() to a taken slug = %v, want ErrSlugTaken", err)
+	}
+	if post, err := store.GetPost(publishedID); err != nil || post.Slug != "published" {
+		t.Errorf("GetPost() after a rejected EditPost() = %v, %v", post, err)
+	}
+	// only one of several posts created at once gets the slug
+	errs := make(chan error, 5)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := store.CreatePost(&Post{UserId: userID, Title: "Racing", Slug: "racing"})
+			errs <- err
+		}()
+	}
+	created := 0
+	for i := 0; i < cap(errs); i++ {
+		switch err := <-errs; err {
+		case nil:
+			created++
+		case ErrSlugTaken:
+		default:
+			t.Errorf("CreatePost() = %v", err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d posts created with the same slug, want 1", created)
+	}
+
+	edit := &Post{Title: "Edited", Slug: "edited", Content: "<p>edited</p>", Status: Draft, TOC: TOCOff, UpdatedAt: time.Now()}
+	if err := store.EditPost(publishedID, edit); err != nil {
+		t.Fatalf("EditPost() = %v", err)
+	}
+	post, err = store.GetPost(publishedID)
+	if err != nil {
+		t.Fatalf("GetPost() = %v", err)
+	}
+	if post.Title != "Edited" || post.Slug != "edited" || post.Content != "<p>edited</p>" ||
+		post.Status != Draft || post.TOC != TOCOff || post.Description != "" {
+		t.Errorf("GetPost() after EditPost() = %+v", post)
+	}
+
+	if err := store.DeletePost(publishedID); err != nil {
+		t.Fatalf("DeletePost() = %v", err)
+	}
+	if _, err := store.GetPost(publishedID); err != ErrNotFound {
+		t.Errorf("GetPost() of a deleted post = %v, want ErrNotFound", err)
+	}
+}
+
+func testRevisions(t *testing.T, store Store) {
+	userID := createTestUser(t, store, "author")
+	postID := createTestPost(t, store, &Post{UserId: userID, Title: "First", Slug: "post", Content: "<p>first</p>"})
+	edit := &Post{Title: "Second", Slug: "post", Content: "<p>second</p>", UpdatedAt: time.Now()}
+	if err := store.EditPost(postID, edit); err != nil {
+		t.Fatalf("EditPost() = %v", err)
+	}
+
+	// the original is kept along with the edit, newest first
+	revisions, err := store.GetRevisions(postID)
+	if err != nil {
+		t.Fatalf("GetRevisions() = %v", err)
+	}
+	if len(revisions) != 2 || revisions[0].Title != "Second" || revisions[1].Title != "First" {
+		t.Fatalf("GetRevisions() = %+v", revisions)
+	}
+	if _, err := store.GetRevision(postID+1, revisions[1].Id); err != ErrNotFound {
+		t.Errorf("GetRevision() of another post = %v, want ErrNotFound", err)
+	}
+
+	if err := store.RestoreRevision(postID, revisions[1]); err != nil {
+		t.Fatalf("RestoreRevision() = %v", err)
+	}
+	post, err := store.GetPost(postID)
+	if err != nil {
+		t.Fatalf("GetPost() = %v", err)
+	}
+	if post.Title != "First" || post.Content != "<p>first</p>" {
+		t.Errorf("GetPost() after RestoreRevision() = %+v", post)
+	}
+	if revisions, err := store.GetRevisions(postID); err != nil || len(revisions) != 3 {
+		t.Errorf("GetRevisions() after RestoreRevision() = %d revisions, %v, want 3", len(revisions), err)
+	}
+
+	// content saved before it was sanitized is rewritten in place, without a new revision
+	edit = &Post{Title: "Unsafe", Slug: "post", Content: "<p>unsafe</p><script>x</script>", UpdatedAt: time.Now()}
+	if err := store.EditPost(postID, edit); err != nil {
+		t.Fatalf("EditPost() = %v", err)
+	}
+	stripScripts := func(content string) string {
+		return strings.ReplaceAll(content, "<script>x</script>", "")
+	}
+	if count, err := store.SanitizeContent(stripScripts); err != nil || count != 2 {
+		t.Errorf("SanitizeContent() = %d, %v, want the post and its newest revision", count, err)
+	}
+	if post, err := store.GetPost(postID); err != nil || post.Content != "<p>unsafe</p>" {
+		t.Errorf("GetPost() after SanitizeContent() = %v, %v", post, err)
+	}
+	revisions, err = store.GetRevisions(postID)
+	if err != nil || len(revisions) != 4 || revisions[0].Content != "<p>unsafe</p>" {
+		t.Errorf("GetRevisions() after SanitizeContent() = %+v, %v", revisions, err)
+	}
+	if count, err := store.SanitizeContent(stripScripts); err != nil || count != 0 {
+		t.Errorf("SanitizeContent() again = %d, %v, want 0", count, err)
+	}
+
+	// the slug the post had then now belongs to another post
+	edit = &Post{Title: "Renamed", Slug: "renamed", Content: "<p>renamed</p>", UpdatedAt: time.Now()}
+	if err := store.EditPost(postID, edit); err != nil {
+		t.Fatalf("EditPost() = %v", err)
+	}
+	createTestPost(t, store, &Post{UserId: userID, Title: "Other", Slug: "post", Content: "<p>other</p>"})
+	if err := store.RestoreRevision(postID, revisions[len(revisions)-1]); err != ErrSlugTaken {
+		t.Errorf("RestoreRevision() to a taken slug = %v, want ErrSlugTaken", err)
+	}
+	if post, err := store.GetPost(postID); err != nil || post.Slug != "renamed" {
+		t.Errorf("GetPost() after a rejected RestoreRevision() = %v, %v", post, err)
+	}
+}
+
+func testTags(t *testing.T, store Store) {
+	userID := createTestUser(t, store, "author")
+	first := createTestPost(t, store, &Post{UserId: userID, Title: "First", Slug: "first", Content: "<p>first</p>"})
+	second := createTestPost(t, store, &Post{UserId: userID, Title: "Second", Slug: "second", Content: "<p>second</p>"})
+	if err := store.CreateTags(int64(first), []string{"go", "web"}); err != nil {
+		t.Fatalf("CreateTags() = %v", err)
+	}
+	if err := store.CreateTags(int64(second), []string{"web"}); err != nil {
+		t.Fatalf("CreateTags() = %v", err)
+	}
+
+	tags, err := store.GetTags(first)
+	if err != nil {
+		t.Fatalf("GetTags() = %v", err)
+	}
+	names := make([]string, len(tags))
+	for i, tag := range tags {
+		names[i] = tag.Name
+	}
+	if !sameSet(names, "go", "web") {
+		t.Errorf("GetTags() = %q", names)
+	}
+	posts, err := store.GetFilteredPosts([]string{"go"})
+	if err != nil {
+		t.Fatalf("GetFilteredPosts() = %v", err)
+	}
+	if got := slugs(posts); !sameSet(got, "first") {
+		t.Errorf("GetFilteredPosts() = %q", got)
+	}
+
+	if err := store.SetTags(first, []string{"web"}); err != nil {
+		t.Fatalf("SetTags() = %v", err)
+	}
+	counts, err := store.GetAllTags()
+	if err != nil {
+		t.Fatalf("GetAllTags() = %v", err)
+	}
+	if len(counts) != 1 || counts[0].Name != "web" || counts[0].Posts != 2 {
+		t.Errorf("GetAllTags() = %+v, want web on 2 posts", counts)
+	}
+
+	if err := store.DeleteTag("web"); err != nil {
+		t.Fatalf("DeleteTag() = %v", err)
+	}
+	if tags, err := store.GetTags(second); err != nil || len(tags) != 0 {
+		t.Errorf("GetTags() after DeleteTag() = %v, %v", tags, err)
+	}
+	if err := store.DeleteTag("web"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteTag() of a missing tag = %v, want ErrNotFound", err)
+	}
+}
+
+func testSearch(t *testing.T, store Store) {
+	userID := createTestUser(t, store, "author")
+	createTestPost(t, store, &Post{UserId: userID, Title: "Gardening", Slug: "gardening", Content: "<p>growing tomatoes</p>"})
+	createTestPost(t, store, &Post{UserId: userID, Title: "Cooking", Slug: "cooking", Content: "<p>tomato soup</p>", Status: Draft})
+	createTestPost(t, store, &Post{UserId: userID, Title: "Other", Slug: "other", Content: "<p>nothing</p>"})
+
+	results, err := store.SearchPosts("tomato")
+	if err != nil {
+		t.Fatalf("SearchPosts() = %v", err)
+	}
+	if len(results) != 1 || results[0].Post.Slug != "gardening" || results[0].Snippet == "" {
+		t.Errorf("SearchPosts() = %+v, want the published post", results)
+	}
+	results, err = store.SearchPosts("tomato", WithAnyStatus())
+	if err != nil || len(results) != 2 {
+		t.Errorf("SearchPosts(WithAnyStatus()) = %d results, %v, want 2", len(results), err)
+	}
+	if results, err := store.SearchPosts("  "); err != nil || len(results) != 0 {
+		t.Errorf("SearchPosts() of nothing = %v, %v", results, err)
+	}
+}
+
+func testUsers(t *testing.T, store Store) {
+	userID := createTestUser(t, store, "bob")
+	createTestUser(t, store, "alice")
+	if _, err := store.CreateUser("bob", "other", Author); err != ErrUsernameTaken {
+		t.Errorf("CreateUser() with a taken username = %v, want ErrUsernameTaken", err)
+	}
+	// only one of several users created at once gets the name
+	errs := make(chan error, 5)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := store.CreateUser("carol", "pass", Author)
+			errs <- err
+		}()
+	}
+	created := 0
+	for i := 0; i < cap(errs); i++ {
+		switch err := <-errs; err {
+		case nil:
+			created++
+		case ErrUsernameTaken:
+		default:
+			t.Errorf("CreateUser() = %v", err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d users named carol were created, want 1", created)
+	}
+
+	if user, err := store.GetUserByCreds("bob", "pass"); err != nil || user.Id != userID {
+		t.Errorf("GetUserByCreds() = %v, %v", user, err)
+	}
+	if _, err := store.GetUserByCreds("bob", "wrong"); err != ErrInvalidCredentials {
+		t.Errorf("GetUserByCreds() with the wrong password = %v, want ErrInvalidCredentials", err)
+	}
+	if _, err := store.GetUserByCreds("dave", "pass"); err != ErrInvalidCredentials {
+		t.Errorf("GetUserByCreds() of a missing user = %v, want ErrInvalidCredentials", err)
+	}
+	if _, err := store.GetUserByUsername("dave"); err != ErrNotFound {
+		t.Errorf("GetUserByUsername() of a missing user = %v, want ErrNotFound", err)
+	}
+
+	users, err := store.GetUsers()
+	if err != nil {
+		t.Fatalf("GetUsers() = %v", err)
+	}
+	if len(users) != 3 || users[0].Username != "alice" || users[1].Username != "bob" {
+		t.Errorf("GetUsers() isn't sorted by username: %+v", users)
+	}
+
+	if err := store.SetUserRole(userID, Editor); err != nil {
+		t.Fatalf("SetUserRole() = %v", err)
+	}
+	if err := store.SetUserEmail(userID, "bob@example.com"); err != nil {
+		t.Fatalf("SetUserEmail() = %v", err)
+	}
+	user, err := store.GetUserByUsername("bob")
+	if err != nil {
+		t.Fatalf("GetUserByUsername() = %v", err)
+	}
+	if user.Role != Editor || user.Email != "bob@example.com" {
+		t.Errorf("GetUserByUsername() = %+v", user)
+	}
+
+	postID := createTestPost(t, store, &Post{UserId: userID, Title: "Post", Slug: "post", Content: "<p>post</p>"})
+	if err := store.DeleteUser(userID); err != ErrUserHasPosts {
+		t.Errorf("DeleteUser() of a user with posts = %v, want ErrUserHasPosts", err)
+	}
+	if err := store.DeletePost(postID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteUser(userID); err != nil {
+		t.Fatalf("DeleteUser() = %v", err)
+	}
+	if _, err := store.GetUser(userID); err != ErrNotFound {
+		t.Errorf("GetUser() of a deleted user = %v, want ErrNotFound", err)
+	}
+}
+
+func testTOTP(t *testing.T, store Store) {
+	userID := createTestUser(t, store, "bob")
+	if err := store.SetTOTPSecret(userID, "SECRET"); err != nil {
+		t.Fatalf("SetTOTPSecret() = %v", err)
+	}
+	if err := store.EnableTOTP(userID, []string{"first", "second"}); err != nil {
+		t.Fatalf("EnableTOTP() = %v", err)
+	}
+	user, err := store.GetUser(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.TOTPSecret != "SECRET" || !user.TOTPEnabled {
+		t.Errorf("GetUser() after EnableTOTP() = %+v", user)
+	}
+
+	// a counter can only be used once, and never one older than the last
+	for _, step := range []struct {
+		counter int64
+		want    bool
+	}{{5, true}, {5, false}, {4, false}, {6, true}} {
+		if ok, err := store.UseTOTPCounter(userID, step.counter); ok != step.want || err != nil {
+			t.Errorf("UseTOTPCounter(%d) = %v, %v, want %v", step.counter, ok, err, step.want)
+		}
+	}
+
+	if ok, err := store.UseRecoveryCode(userID, "first"); !ok || err != nil {
+		t.Errorf("UseRecoveryCode() = %v, %v, want true", ok, err)
+	}
+	if ok, err := store.UseRecoveryCode(userID, "first"); ok || err != nil {
+		t.Errorf("UseRecoveryCode() of a used code = %v, %v, want false", ok, err)
+	}
+	if count, err := store.CountRecoveryCodes(userID); count != 1 || err != nil {
+		t.Errorf("CountRecoveryCodes() = %d, %v, want 1", count, err)
+	}
+
+	if err := store.DisableTOTP(userID); err != nil {
+		t.Fatalf("DisableTOTP() = %v", err)
+	}
+	if user, err := store.GetUser(userID); err != nil || user.TOTPEnabled || user.TOTPSecret != "" {
+		t.Errorf("GetUser() after DisableTOTP() = %+v, %v", user, err)
+	}
+	if count, err := store.CountRecoveryCodes(userID); count != 0 || err != nil {
+		t.Errorf("CountRecoveryCodes() after DisableTOTP() = %d, %v, want 0", count, err)
+	}
+}
+
+func testSessions(t *testing.T, store Store) {
+	userID := createTestUser(t, store, "bob")
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, session := range []*Session{
+		{Id: "current", UserId: userID, IP: "127.0.0.1", UserAgent: "test", CreatedAt: now, LastSeenAt: now, ExpiresAt: now.Add(time.Hour)},
+		{Id: "expired", UserId: userID, IP: "127.0.0.1", UserAgent: "test", CreatedAt: now, LastSeenAt: now, ExpiresAt: now.Add(-time.Hour)},
+	} {
+		if err := store.CreateSession(session); err != nil {
+			t.Fatalf("CreateSession() = %v", err)
+		}
+	}
+
+	sessions, err := store.GetSessions(userID, now)
+	if err != nil {
+		t.Fatalf("GetSessions() = %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].Id != "current" {
+		t.Errorf("GetSessions() = %+v, want only the current session", sessions)
+	}
+
+	later := now.Add(time.Minute)
+	if err := store.TouchSession("current", "10.0.0.1", later, later.Add(time.Hour)); err != nil {
+		t.Fatalf("TouchSession() = %v", err)
+	}
+	session, err := store.GetSession("current")
+	if err != nil {
+		t.Fatalf("GetSession() = %v", err)
+	}
+	if session.IP != "10.0.0.1" || !session.LastSeenAt.Equal(later) || !session.ExpiresAt.Equal(later.Add(time.Hour)) {
+		t.Errorf("GetSession() after TouchSession() = %+v", session)
+	}
+
+	if count, err := store.DeleteExpiredSessions(now); count != 1 || err != nil {
+		t.Errorf("DeleteExpiredSessions() = %d, %v, want 1", count, err)
+	}
+	if err := store.DeleteSession("current"); err != nil {
+		t.Fatalf("DeleteSession() = %v", err)
+	}
+	if _, err := store.GetSession("current"); err != ErrNotFound {
+		t.Errorf("GetSession() of a deleted session = %v, want ErrNotFound", err)
+	}
+}
+
+func testLoginAttempts(t *testing.T, store Store) {
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, attempt := range []*LoginAttempt{
+		{Username: "bob", IP: "10.0.0.1", CreatedAt: now.Add(-2 * time.Hour)},
+		{Username: "bob", IP: "10.0.0.2", CreatedAt: now.Add(-time.Minute)},
+		{Username: "alice", IP: "10.0.0.1", Succeeded: true, CreatedAt: now},
+		{Username: "carol", IP: "10.0.0.3", CreatedAt: now},
+	} {
+		if err := store.RecordLoginAttempt(attempt); err != nil {
+			t.Fatalf("RecordLoginAttempt() = %v", err)
+		}
+	}
+
+	// attempts on the username or from the ip, oldest first
+	attempts, err := store.GetLoginAttempts("bob", "10.0.0.1", now.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("GetLoginAttempts() = %v", err)
+	}
+	if len(attempts) != 2 || attempts[0].Username != "bob" || attempts[1].Username != "alice" || !attempts[1].Succeeded {
+		t.Errorf("GetLoginAttempts() = %+v", attempts)
+	}
+	if count, err := store.DeleteLoginAttempts(now.Add(-time.Hour)); count != 1 || err != nil {
+		t.Errorf("DeleteLoginAttempts() = %d, %v, want 1", count, err)
+	}
+}
+
+func testAPITokens(t *testing.T, store Store) {
+	userID := createTestUser(t, store, "bob")
+	otherID := createTestUser(t, store, "alice")
+	now := time.Now().UTC().Truncate(time.Second)
+	firstID, err := store.CreateAPIToken(&APIToken{UserId: userID, Name: "first", TokenHash: "hash1", CreatedAt: now})
+	if err != nil {
+		t.Fatalf("CreateAPIToken() = %v", err)
+	}
+	if _, err := store.CreateAPIToken(&APIToken{UserId: userID, Name: "second", TokenHash: "hash2", CreatedAt: now}); err != nil {
+		t.Fatalf("CreateAPIToken() = %v", err)
+	}
+
+	tokens, err := store.GetAPITokens(userID)
+	if err != nil {
+		t.Fatalf("GetAPITokens() = %v", err)
+	}
+	if len(tokens) != 2 || tokens[0].Name != "second" || tokens[1].Name != "first" {
+		t.Errorf("GetAPITokens() = %+v, want newest first", tokens)
+	}
+	if err := store.TouchAPIToken(int(firstID), now); err != nil {
+		t.Fatalf("TouchAPIToken() = %v", err)
+	}
+	token, err := store.GetAPITokenByHash("hash1")
+	if err != nil {
+		t.Fatalf("GetAPITokenByHash() = %v", err)
+	}
+	if token.Id != int(firstID) || !token.LastUsedAt.Valid || !token.LastUsedAt.Time.Equal(now) {
+		t.Errorf("GetAPITokenByHash() = %+v", token)
+	}
+
+	if err := store.DeleteAPIToken(otherID, int(firstID)); err != ErrNotFound {
+		t.Errorf("DeleteAPIToken() of another user's token = %v, want ErrNotFound", err)
+	}
+	if err := store.DeleteAPIToken(userID, int(firstID)); err != nil {
+		t.Fatalf("DeleteAPIToken() = %v", err)
+	}
+	if _, err := store.GetAPITokenByHash("hash1"); err != ErrNotFound {
+		t.Errorf("GetAPITokenByHash() of a deleted token = %v, want ErrNotFound", err)
+	}
+}
+
+func testWebmentions(t *testing.T, store Store) {
+	userID := createTestUser(t, store, "bob")
+	postID := createTestPost(t, store, &Post{UserId: userID, Title: "Post", Slug: "post", Content: "<p>post</p>"})
+	now := time.Now().UTC().Truncate(time.Second)
+	mention := &Webmention{PostId: postID, Source: "https://example.com/reply", Target: "https://site.test/blog/post", CreatedAt: now, UpdatedAt: now}
+	mentionID, err := store.SaveWebmention(mention)
+	if err != nil {
+		t.Fatalf("SaveWebmention() = %v", err)
+	}
+	if err := store.SetWebmentionStatus(int(mentionID), WebmentionVerified, "A reply", now); err != nil {
+		t.Fatalf("SetWebmentionStatus() = %v", err)
+	}
+	mentions, err := store.GetWebmentions(postID, WebmentionVerified)
+	if err != nil {
+		t.Fatalf("GetWebmentions() = %v", err)
+	}
+	if len(mentions) != 1 || mentions[0].Title != "A reply" || mentions[0].Source != mention.Source {
+		t.Errorf("GetWebmentions() = %+v", mentions)
+	}
+
+	// sending the same mention again queues it to be verified again
+	againID, err := store.SaveWebmention(mention)
+	if err != nil {
+		t.Fatalf("SaveWebmention() = %v", err)
+	}
+	if againID != mentionID {
+		t.Errorf("SaveWebmention() of the same mention = %d, want %d", againID, mentionID)
+	}
+	if saved, err := store.GetWebmention(int(mentionID)); err != nil || saved.Status != WebmentionPending {
+		t.Errorf("GetWebmention() = %+v, %v, want it pending", saved, err)
+	}
+	if pending, err := store.GetWebmentions(0, WebmentionPending); err != nil || len(pending) != 1 {
+		t.Errorf("GetWebmentions() of any post = %d, %v, want 1", len(pending), err)
+	}
+
+	if err := store.DeleteWebmention(int(mentionID)); err != nil {
+		t.Fatalf("DeleteWebmention() = %v", err)
+	}
+	if _, err := store.GetWebmention(int(mentionID)); err != ErrNotFound {
+		t.Errorf("GetWebmention() of a deleted mention = %v, want ErrNotFound", err)
+	}
+}
+
+func testComments(t *testing.T, store Store) {
+	userID := createTestUser(t, store, "bob")
+	postID := createTestPost(t, store, &Post{UserId: userID, Title: "Post", Slug: "post", Content: "<p>post</p>"})
+	now := time.Now().UTC().Truncate(time.Second)
+	rootID, err := store.CreateComment(&Comment{PostId: postID, AuthorName: "alice", Body: "hi", Content: "<p>hi</p>", Status: CommentPending, IP: "192.0.2.1", CreatedAt: now})
+	if err != nil {
+		t.Fatalf("CreateComment() = %v", err)
+	}
+	replyID, err := store.CreateComment(&Comment{
+		PostId: postID, ParentId: sql.NullInt64{Int64: rootID, Valid: true},
+		AuthorName: "carol", Body: "hello", Content: "<p>hello</p>", Status: CommentPending, IP: "192.0.2.2", CreatedAt: now,
+	})
+	if err != nil {
+		t.Fatalf("CreateComment() = %v", err)
+	}
+	_, err = store.CreateComment(&Comment{PostId: postID, AuthorName: "alice", Body: "old", Content: "<p>old</p>", Status: CommentSpam, IP: "192.0.2.1", CreatedAt: now.Add(-2 * time.Hour)})
+	if err != nil {
+		t.Fatalf("CreateComment() = %v", err)
+	}
+	byIP, err := store.GetCommentsByIP("192.0.2.1", now.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("GetCommentsByIP() = %v", err)
+	}
+	if len(byIP) != 1 || byIP[0].Id != int(rootID) || !byIP[0].CreatedAt.Equal(now) {
+		t.Errorf("GetCommentsByIP() = %+v, want only the recent comment from the ip", byIP)
+	}
+
+	if err := store.SetCommentStatus(int(rootID), CommentApproved); err != nil {
+		t.Fatalf("SetCommentStatus() = %v", err)
+	}
+	if err := store.SetCommentStatus(int(replyID)+100, CommentApproved); err != ErrNotFound {
+		t.Errorf("SetCommentStatus() of a missing comment = %v, want ErrNotFound", err)
+	}
+	approved, err := store.GetComments(postID, CommentApproved)
+	if err != nil {
+		t.Fatalf("GetComments() = %v", err)
+	}
+	if len(approved) != 1 || approved[0].Id != int(rootID) || approved[0].Content != "<p>hi</p>" {
+		t.Errorf("GetComments() = %+v", approved)
+	}
+	reply, err := store.GetComment(int(replyID))
+	if err != nil {
+		t.Fatalf("GetComment() = %v", err)
+	}
+	if !reply.ParentId.Valid || reply.ParentId.Int64 != rootID {
+		t.Errorf("GetComment() of a reply = %+v", reply)
+	}
+
+	// replies go with the comment they answer
+	if err := store.DeleteComment(int(rootID)); err != nil {
+		t.Fatalf("DeleteComment() = %v", err)
+	}
+	if _, err := store.GetComment(int(replyID)); err != ErrNotFound {
+		t.Errorf("GetComment() of a deleted thread's reply = %v, want ErrNotFound", err)
+	}
+	if err := store.DeleteComment(int(rootID)); err != ErrNotFound {
+		t.Errorf("DeleteComment() of a missing comment = %v, want ErrNotFound", err)
+	}
+}
+
+// runs against the postgres database in DATABASE_URL, each test in a schema of its
+// own that's dropped afterwards, and is skipped when it isn't set
+func TestPostgresStore(t *testing.T) {
+	databaseURL := os.Getenv("DATABASE_URL")
+	if !strings.HasPrefix(databaseURL, "postgres://") && !strings.HasPrefix(databaseURL, "postgresql://") {
+		t.Skip("DATABASE_URL isn't a postgres url")
+	}
+	admin, err := OpenPostgres(databaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+	var n int
+	testStore(t, func(t *testing.T) Store {
+		n++
+		schema := fmt.Sprintf("store_test_%d_%d", os.Getpid(), n)
+		if _, err := admin.db.Exec("CREATE SCHEMA " + schema); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			if _, err := admin.db.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+				t.Error(err)
+			}
+		})
+		u, err := url.Parse(databaseURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		query := u.Query()
+		query.Set("search_path", schema)
+		u.RawQuery = query.Encode()
+		store, err := OpenPostgres(u.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { store.Close() })
+		if err := store.Setup(); err != nil {
+			t.Fatalf("Setup() = %v", err)
+		}
+		return store
+	})
+}
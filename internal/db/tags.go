@@ -0,0 +1,156 @@
+package db
+
+import (
+	"database/sql"
+	"slices"
+)
+
+type querier interface {
+	execer
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// adds tags to a post, creating the ones that don't exist yet. empty and repeated
+// tags are skipped
+func addTags(q querier, postID int64, tags []string) error {
+	var added []string
+	for _, tag := range tags {
+		if tag == "" || slices.Contains(added, tag) {
+			continue
+		}
+		added = append(added, tag)
+		// lookup in tags table and then insert if not already present
+		var tagID int
+		row := q.QueryRow("SELECT id FROM tag WHERE name = ?", tag)
+		err := row.Scan(&tagID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				err := q.QueryRow("INSERT INTO tag (name) VALUES (?) RETURNING id", tag).Scan(&tagID)
+				if err != nil {
+					return err
+				}
+			} else {
+				return err
+			}
+		}
+		// also add to post_tags junction table
+		_, err = q.Exec("INSERT INTO post_tags (post_id, tag_id) VALUES (?, ?)", postID, tagID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removes every tag from a post, deleting tags no other post uses
+func removeTags(q querier, postID int) error {
+	rows, err := q.Query("SELECT tag_id FROM post_tags WHERE post_id = ?", postID)
+	if err != nil {
+		return err
+	}
+	var tagIDs []int
+	for rows.Next() {
+		var tagID int
+		if err := rows.Scan(&tagID); err != nil {
+			rows.Close()
+			return err
+		}
+		tagIDs = append(tagIDs, tagID)
+	}
+	rows.Close()
+
+	_, err = q.Exec("DELETE FROM post_tags WHERE post_id = ?", postID)
+	if err != nil {
+		return err
+	}
+	// delete orphaned tags
+	for _, tagID := range tagIDs {
+		var count int
+		err := q.QueryRow("SELECT COUNT(*) FROM post_tags WHERE tag_id = ?", tagID).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			_, err := q.Exec("DELETE FROM tag WHERE id = ?", tagID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// replaces a post's tags
+func (s *SQLStore) SetTags(postID int, tags []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	err = removeTags(tx, postID)
+	if err != nil {
+		return err
+	}
+	err = addTags(tx, int64(postID), tags)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// returns every tag with the number of posts it's on, whatever their status
+func (s *SQLStore) GetAllTags() ([]*TagCount, error) {
+	rows, err := s.db.Query(
+		`SELECT tag.name, COUNT(post_tags.post_id)
+		FROM tag
+		LEFT JOIN post_tags ON tag.id = post_tags.tag_id
+		GROUP BY tag.name
+		ORDER BY tag.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := make([]*TagCount, 0)
+	for rows.Next() {
+		var tag TagCount
+		if err := rows.Scan(&tag.Name, &tag.Posts); err != nil {
+			return nil, err
+		}
+		tags = append(tags, &tag)
+	}
+	return tags, rows.Err()
+}
+
+// removes a tag from every post, returns ErrNotFound if there's no such tag
+func (s *SQLStore) DeleteTag(name string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var tagID int
+	err = tx.QueryRow("SELECT id FROM tag WHERE name = ?", name).Scan(&tagID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM post_tags WHERE tag_id = ?", tagID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM tag WHERE id = ?", tagID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,316 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"html/template"
+	"log"
+	"personal-site/internal/config"
+	"personal-site/pkg/utils/password"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrUsernameTaken      = errors.New("username is already taken")
+	ErrUserHasPosts       = errors.New("user still has posts")
+)
+
+const userColumns = "id, username, password, role, email, totp_secret, totp_enabled"
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row scanner) (*User, error) {
+	var user User
+	err := row.Scan(&user.Id, &user.Username, &user.Password, &user.Role, &user.Email, &user.TOTPSecret, &user.TOTPEnabled)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+type TwoFactorData struct {
+	Enabled bool
+	// set while enrolling
+	Secret string
+	URI    string
+	QRCode template.HTML
+	// shown once, right after enrollment is confirmed
+	RecoveryCodes     []string
+	RemainingRecovery int
+	Error             string
+}
+
+type UsersData struct {
+	Users []*User
+	// the logged in user, who can't change their own role or delete themselves
+	Current int
+	Roles   []Role
+	Error   string
+}
+
+// looks up a user and verifies their password, hashes made with outdated
+// params are transparently upgraded on a successful login
+func (s *SQLStore) GetUserByCreds(username string, pass string) (*User, error) {
+	user, err := scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM "user" WHERE username = ?`, username))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			password.VerifyDummy(pass)
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+	match, needsRehash, err := password.Verify(pass, user.Password)
+	if err != nil {
+		return nil, err
+	}
+	if !match {
+		return nil, ErrInvalidCredentials
+	}
+	if needsRehash {
+		// a failed upgrade shouldn't block the login, the next one will retry
+		if err := updatePassword(s.db, user.Id, pass); err != nil {
+			log.Printf("failed to upgrade password hash for user %d: %v", user.Id, err)
+		}
+	}
+	return user, nil
+}
+
+func (s *SQLStore) GetUser(userID int) (*User, error) {
+	return scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM "user" WHERE id = ?`, userID))
+}
+
+func (s *SQLStore) GetUserByUsername(username string) (*User, error) {
+	return scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM "user" WHERE username = ?`, username))
+}
+
+func (s *SQLStore) GetUsers() ([]*User, error) {
+	rows, err := s.db.Query(`SELECT ` + userColumns + ` FROM "user" ORDER BY username`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := make([]*User, 0)
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// adds a user with a hashed password, usernames have to be unique
+func (s *SQLStore) CreateUser(username string, pass string, role Role) (int64, error) {
+	_, err := s.GetUserByUsername(username)
+	if err == nil {
+		return -1, ErrUsernameTaken
+	}
+	if err != sql.ErrNoRows {
+		return -1, err
+	}
+	hash, err := password.Hash(pass)
+	if err != nil {
+		return -1, err
+	}
+	var userID int64
+	err = s.db.QueryRow(`INSERT INTO "user" (username, password, role, is_admin) VALUES (?, ?, ?, ?) RETURNING id;`,
+		username, hash, role, role == Admin).Scan(&userID)
+	// the check above can race another user being created, the unique index settles it
+	if isSQLiteUniqueViolation(err) || isPostgresUniqueViolation(err) {
+		return -1, ErrUsernameTaken
+	}
+	if err != nil {
+		return -1, err
+	}
+	return userID, nil
+}
+
+func (s *SQLStore) SetUserRole(userID int, role Role) error {
+	_, err := s.db.Exec(`UPDATE "user" SET role = ?, is_admin = ? WHERE id = ?;`, role, role == Admin, userID)
+	return err
+}
+
+func (s *SQLStore) SetUserEmail(userID int, email string) error {
+	_, err := s.db.Exec(`UPDATE "user" SET email = ? WHERE id = ?;`, email, userID)
+	return err
+}
+
+// deletes a user along with their sessions, recovery codes and api tokens, users
+// who still have posts can't be deleted so no post is left without an author
+func (s *SQLStore) DeleteUser(userID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var posts int
+	err = tx.QueryRow("SELECT COUNT(*) FROM post WHERE user_id = ?", userID).Scan(&posts)
+	if err != nil {
+		return err
+	}
+	if posts > 0 {
+		err = ErrUserHasPosts
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM session WHERE user_id = ?;", userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM recovery_code WHERE user_id = ?;", userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM api_token WHERE user_id = ?;", userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM "user" WHERE id = ?;`, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// starts totp enrollment with a new secret, two-factor stays off until EnableTOTP
+func (s *SQLStore) SetTOTPSecret(userID int, secret string) error {
+	_, err := s.db.Exec(`UPDATE "user" SET totp_secret = ?, totp_enabled = ?, totp_last_counter = 0 WHERE id = ?;`, secret, false, userID)
+	return err
+}
+
+// turns on two-factor for the pending secret and replaces any recovery codes
+func (s *SQLStore) EnableTOTP(userID int, recoveryCodeHashes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	_, err = tx.Exec(`UPDATE "user" SET totp_enabled = ? WHERE id = ? AND totp_secret != '';`, true, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM recovery_code WHERE user_id = ?;", userID)
+	if err != nil {
+		return err
+	}
+	for _, hash := range recoveryCodeHashes {
+		_, err = tx.Exec("INSERT INTO recovery_code (user_id, code_hash) VALUES (?, ?);", userID, hash)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) DisableTOTP(userID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	_, err = tx.Exec(`UPDATE "user" SET totp_secret = '', totp_enabled = ?, totp_last_counter = 0 WHERE id = ?;`, false, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM recovery_code WHERE user_id = ?;", userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// records that a totp time step was used, reports false if it (or a later one)
+// already was so a code can't be replayed
+func (s *SQLStore) UseTOTPCounter(userID int, counter int64) (bool, error) {
+	res, err := s.db.Exec(`UPDATE "user" SET totp_last_counter = ? WHERE id = ? AND totp_last_counter < ?;`, counter, userID, counter)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// consumes a recovery code, reports false if the user has no such code
+func (s *SQLStore) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	res, err := s.db.Exec("DELETE FROM recovery_code WHERE user_id = ? AND code_hash = ?;", userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *SQLStore) CountRecoveryCodes(userID int) (int, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM recovery_code WHERE user_id = ?", userID).Scan(&count)
+	return count, err
+}
+
+func updatePassword(db *database, userID int, pass string) error {
+	hash, err := password.Hash(pass)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`UPDATE "user" SET password = ? WHERE id = ?;`, hash, userID)
+	return err
+}
+
+// creates the admin user from ADMIN_USER and ADMIN_PASS when there are no users yet
+func createAdminUser(db *database) error {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM "user"`).Scan(&count)
+	if err != nil || count > 0 || config.AdminUser == "" {
+		return err
+	}
+	hash, err := password.Hash(config.AdminPass)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`INSERT INTO "user" (username, password, role, is_admin) VALUES (?, ?, ?, ?);`, config.AdminUser, hash, Admin, true)
+	return err
+}
+
+// one-time migration for databases created before passwords were hashed
+func rehashPlaintextPasswords(db *database) error {
+	rows, err := db.Query(`SELECT id, password FROM "user"`)
+	if err != nil {
+		return err
+	}
+	plaintext := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var stored sql.NullString
+		if err := rows.Scan(&id, &stored); err != nil {
+			rows.Close()
+			return err
+		}
+		if stored.Valid && !password.IsHashed(stored.String) {
+			plaintext[id] = stored.String
+		}
+	}
+	rows.Close()
+	for id, pass := range plaintext {
+		if err := updatePassword(db, id, pass); err != nil {
+			return err
+		}
+		log.Printf("rehashed plaintext password for user %d", id)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package db
+
+import (
+	"time"
+)
+
+const webmentionColumns = "id, post_id, source, target, status, title, created_at, updated_at"
+
+func scanWebmention(row scanner) (*Webmention, error) {
+	var mention Webmention
+	err := row.Scan(&mention.Id, &mention.PostId, &mention.Source, &mention.Target, &mention.Status,
+		&mention.Title, &mention.CreatedAt, &mention.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &mention, nil
+}
+
+// saves a received webmention as pending, a mention that was sent before is reset so
+// its source is checked again
+func (s *SQLStore) SaveWebmention(mention *Webmention) (int64, error) {
+	var mentionID int64
+	err := s.db.QueryRow("SELECT id FROM webmention WHERE source = ? AND target = ?", mention.Source, mention.Target).
+		Scan(&mentionID)
+	if err == nil {
+		_, err = s.db.Exec("UPDATE webmention SET post_id = ?, status = ?, updated_at = ? WHERE id = ?;",
+			mention.PostId, WebmentionPending, mention.UpdatedAt.UTC(), mentionID)
+		if err != nil {
+			return -1, err
+		}
+		return mentionID, nil
+	}
+	if err != ErrNotFound {
+		return -1, err
+	}
+	err = s.db.QueryRow(
+		`INSERT INTO webmention (post_id, source, target, status, title, created_at, updated_at) 
+		VALUES (?, ?, ?, ?, '', ?, ?) RETURNING id;`,
+		mention.PostId, mention.Source, mention.Target, WebmentionPending, mention.CreatedAt.UTC(), mention.UpdatedAt.UTC()).
+		Scan(&mentionID)
+	if err != nil {
+		return -1, err
+	}
+	return mentionID, nil
+}
+
+func (s *SQLStore) GetWebmention(mentionID int) (*Webmention, error) {
+	return scanWebmention(s.db.QueryRow("SELECT "+webmentionColumns+" FROM webmention WHERE id = ?", mentionID))
+}
+
+// returns a post's mentions with the given status, oldest first. a post id of 0
+// returns them for every post
+func (s *SQLStore) GetWebmentions(postID int, status WebmentionStatus) ([]*Webmention, error) {
+	query := "SELECT " + webmentionColumns + " FROM webmention WHERE status = ?"
+	args := []interface{}{status}
+	if postID != 0 {
+		query += " AND post_id = ?"
+		args = append(args, postID)
+	}
+	rows, err := s.db.Query(query+" ORDER BY created_at, id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	mentions := make([]*Webmention, 0)
+	for rows.Next() {
+		mention, err := scanWebmention(rows)
+		if err != nil {
+			return nil, err
+		}
+		mentions = append(mentions, mention)
+	}
+	return mentions, rows.Err()
+}
+
+// records the outcome of checking a mention's source
+func (s *SQLStore) SetWebmentionStatus(mentionID int, status WebmentionStatus, title string, updatedAt time.Time) error {
+	_, err := s.db.Exec("UPDATE webmention SET status = ?, title = ?, updated_at = ? WHERE id = ?;",
+		status, title, updatedAt.UTC(), mentionID)
+	return err
+}
+
+func (s *SQLStore) DeleteWebmention(mentionID int) error {
+	_, err := s.db.Exec("DELETE FROM webmention WHERE id = ?;", mentionID)
+	return err
+}
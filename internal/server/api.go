@@ -0,0 +1,650 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"personal-site/internal/config"
+	"personal-site/internal/db"
+	"personal-site/internal/types"
+	"personal-site/pkg/utils"
+	"personal-site/pkg/utils/markdown"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+)
+
+const (
+	// makes tokens easy to recognize, e.g. by secret scanners
+	apiTokenPrefix = "ps_"
+	// how stale last used can get before a request updates it
+	apiTokenTouchInterval = time.Minute
+	apiMaxPageSize        = 100
+	apiMaxBodySize        = 1 << 20
+)
+
+func newAPIToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return apiTokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// tokens are random enough that a plain hash is as good as a password hash
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	encoder := json.NewEncoder(w)
+	// post content is html, escaping it would only make it harder to read
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(v)
+}
+
+// the api's handleError, the body is the StatusError as json
+func handleAPIError(w http.ResponseWriter, statusCode int) {
+	handleAPIErrorMessage(w, statusCode, http.StatusText(statusCode))
+}
+
+// like handleAPIError, with a message saying what was wrong with the request
+func handleAPIErrorMessage(w http.ResponseWriter, statusCode int, message string) {
+	writeJSON(w, statusCode, types.NewStatusError(errors.New(message), statusCode))
+}
+
+func handleAPINotFound(w http.ResponseWriter, r *http.Request) {
+	handleAPIError(w, http.StatusNotFound)
+}
+
+func handleAPIMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	handleAPIError(w, http.StatusMethodNotAllowed)
+}
+
+var errInvalidAPIToken = errors.New("invalid api token")
+
+// looks up the user an api token belongs to and records that it was used
+func (s *Server) userFromAPIToken(token string) (*db.User, error) {
+	apiToken, err := s.store.GetAPITokenByHash(hashAPIToken(token))
+	if err != nil {
+		if err == db.ErrNotFound {
+			return nil, errInvalidAPIToken
+		}
+		return nil, err
+	}
+	user, err := s.store.GetUser(apiToken.UserId)
+	if err != nil {
+		if err == db.ErrNotFound {
+			return nil, errInvalidAPIToken
+		}
+		return nil, err
+	}
+	if !db.IsValidRole(user.Role) {
+		return nil, errInvalidAPIToken
+	}
+	now := s.now()
+	if !apiToken.LastUsedAt.Valid || now.Sub(apiToken.LastUsedAt.Time) > apiTokenTouchInterval {
+		if err := s.store.TouchAPIToken(apiToken.Id, now); err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+// authenticates api requests with an "Authorization: Bearer <token>" header, there
+// are no cookies involved so the api doesn't need csrf protection
+func (s *Server) APIAuthenticator(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			handleAPIError(w, http.StatusUnauthorized)
+			return
+		}
+		user, err := s.userFromAPIToken(token)
+		if err != nil {
+			if err == errInvalidAPIToken {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				handleAPIError(w, http.StatusUnauthorized)
+			} else {
+				handleAPIError(w, http.StatusInternalServerError)
+			}
+			return
+		}
+		ctx := context.WithValue(r.Context(), userKey, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// the api's PostCtx, authors can read other users' posts only once they're published
+func (s *Server) APIPostCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentUser(r)
+		if !ok {
+			handleAPIError(w, http.StatusUnauthorized)
+			return
+		}
+		postID, err := strconv.Atoi(chi.URLParam(r, "postID"))
+		if err != nil {
+			handleAPIError(w, http.StatusBadRequest)
+			return
+		}
+		post, err := s.store.GetPost(postID)
+		if err != nil {
+			if err == db.ErrNotFound {
+				handleAPIError(w, http.StatusNotFound)
+			} else {
+				handleAPIError(w, http.StatusInternalServerError)
+			}
+			return
+		}
+		if post.Status != db.Published && !user.CanEditPost(post) {
+			handleAPIError(w, http.StatusNotFound)
+			return
+		}
+		ctx := context.WithValue(r.Context(), postKey, post)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// the api's RequirePostAccess, requires APIPostCtx to have run
+func (s *Server) APIRequirePostAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentUser(r)
+		if !ok {
+			handleAPIError(w, http.StatusUnauthorized)
+			return
+		}
+		post, ok := r.Context().Value(postKey).(*db.Post)
+		if !ok {
+			handleAPIError(w, http.StatusUnprocessableEntity)
+			return
+		}
+		if !user.CanEditPost(post) {
+			handleAPIError(w, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// a post as the api returns it
+type apiPost struct {
+	Id           int           `json:"id"`
+	Author       string        `json:"author"`
+	Title        string        `json:"title"`
+	Slug         string        `json:"slug"`
+	URL          string        `json:"url"`
+	Content      template.HTML `json:"content,omitempty"`
+	Description  string        `json:"description"`
+	CoverImage   string        `json:"cover_image"`
+	CanonicalURL string        `json:"canonical_url"`
+	Status       db.PostStatus `json:"status"`
+	PublishAt    *time.Time    `json:"publish_at"`
+	TOC          db.TOCMode    `json:"toc,omitempty"`
+	CreatedAt    time.Time     `json:"created_at"`
+	UpdatedAt    time.Time     `json:"updated_at"`
+	Tags         []string      `json:"tags"`
+}
+
+type apiPostList struct {
+	Posts   []*apiPost `json:"posts"`
+	Page    int        `json:"page"`
+	PerPage int        `json:"per_page"`
+	HasMore bool       `json:"has_more"`
+}
+
+type apiTag struct {
+	Name  string `json:"name"`
+	Posts int    `json:"posts"`
+}
+
+type apiTags struct {
+	Tags []string `json:"tags"`
+}
+
+// the fields a request can set on a post, missing fields are left as they are
+type apiPostInput struct {
+	Title        *string        `json:"title"`
+	Slug         *string        `json:"slug"`
+	Content      *string        `json:"content"`
+	Markdown     *string        `json:"markdown"`
+	Description  *string        `json:"description"`
+	CoverImage   *string        `json:"cover_image"`
+	CanonicalURL *string        `json:"canonical_url"`
+	Status       *db.PostStatus `json:"status"`
+	PublishAt    *time.Time     `json:"publish_at"`
+	TOC          *db.TOCMode    `json:"toc"`
+	Tags         *[]string      `json:"tags"`
+}
+
+// decodes a json request body into v, writing a 400 if it isn't valid json
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiMaxBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		handleAPIErrorMessage(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func validateTags(tags []string) error {
+	for _, tag := range tags {
+		if tag == "" || strings.ContainsAny(tag, " \t") {
+			return fmt.Errorf("tag %q must be a single non-empty word", tag)
+		}
+	}
+	return nil
+}
+
+// applies the input to the post and checks the result, errors are meant for the client
+func (input *apiPostInput) apply(post *db.Post) error {
+	if input.Title != nil {
+		post.Title = strings.TrimSpace(*input.Title)
+	}
+	if input.Slug != nil {
+		post.Slug = *input.Slug
+	}
+	if input.Content != nil && input.Markdown != nil {
+		return errors.New("content and markdown can't both be set")
+	}
+	if input.Content != nil {
+		post.Content = template.HTML(*input.Content)
+	}
+	if input.Markdown != nil {
+		content, err := markdown.ParseMD(*input.Markdown)
+		if err != nil {
+			return errors.New("invalid markdown: " + err.Error())
+		}
+		post.Content = template.HTML(content.HTML)
+	}
+	if input.Description != nil {
+		post.Description = *input.Description
+	}
+	if input.CoverImage != nil {
+		post.CoverImage = *input.CoverImage
+	}
+	if input.CanonicalURL != nil {
+		post.CanonicalURL = *input.CanonicalURL
+	}
+	if input.Status != nil {
+		post.Status = *input.Status
+	}
+	if input.PublishAt != nil {
+		post.PublishAt.Time = input.PublishAt.UTC()
+		post.PublishAt.Valid = true
+	}
+	if input.TOC != nil {
+		post.TOC = *input.TOC
+	}
+	if post.Slug == "" {
+		post.Slug = utils.TitleToSlug(post.Title)
+	}
+
+	if post.Title == "" {
+		return errors.New("title is required")
+	}
+	if !utils.IsValidSlug(post.Slug) {
+		return fmt.Errorf("slug %q may only contain lowercase letters, numbers and hyphens", post.Slug)
+	}
+	if post.CanonicalURL != "" && !utils.IsAbsoluteURL(post.CanonicalURL) {
+		return fmt.Errorf("canonical_url %q must be an absolute http(s) url", post.CanonicalURL)
+	}
+	if !db.IsValidPostStatus(post.Status) {
+		return fmt.Errorf("status %q must be one of draft, scheduled or published", post.Status)
+	}
+	if post.TOC != "" && !db.IsValidTOCMode(post.TOC) {
+		return fmt.Errorf("toc %q must be one of auto, on or off", post.TOC)
+	}
+	if post.Status == db.Scheduled && !post.PublishAt.Valid {
+		return errors.New("scheduled posts need a publish_at time")
+	}
+	// only scheduled posts keep a publish time, like the admin forms
+	if post.Status != db.Scheduled {
+		post.PublishAt.Valid = false
+	}
+	if input.Tags != nil {
+		return validateTags(*input.Tags)
+	}
+	return nil
+}
+
+// builds the api representation of posts, looking up each author once
+func (s *Server) apiPosts(posts []*db.Post) ([]*apiPost, error) {
+	authors := make(map[int]string)
+	result := make([]*apiPost, len(posts))
+	for i, post := range posts {
+		author, ok := authors[post.UserId]
+		if !ok {
+			user, err := s.store.GetUser(post.UserId)
+			if err != nil && err != db.ErrNotFound {
+				return nil, err
+			}
+			if user != nil {
+				author = user.Username
+			}
+			authors[post.UserId] = author
+		}
+		tags, err := s.store.GetTags(post.Id)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = &apiPost{
+			Id:           post.Id,
+			Author:       author,
+			Title:        post.Title,
+			Slug:         post.Slug,
+			URL:          fmt.Sprintf("%s/blog/%s", config.SiteURL, post.Slug),
+			Content:      post.Content,
+			Description:  post.Description,
+			CoverImage:   post.CoverImage,
+			CanonicalURL: post.CanonicalURL,
+			Status:       post.Status,
+			TOC:          post.TOC,
+			CreatedAt:    post.CreatedAt,
+			UpdatedAt:    post.UpdatedAt,
+			Tags:         utils.Map(tags, func(tag *db.Tag) string { return tag.Name }),
+		}
+		if post.PublishAt.Valid {
+			result[i].PublishAt = &post.PublishAt.Time
+		}
+	}
+	return result, nil
+}
+
+// writes a single post, re-reading it so the response shows what was stored
+func (s *Server) writeAPIPost(w http.ResponseWriter, statusCode int, postID int) {
+	post, err := s.store.GetPost(postID)
+	if err != nil {
+		handleAPIError(w, http.StatusInternalServerError)
+		return
+	}
+	posts, err := s.apiPosts([]*db.Post{post})
+	if err != nil {
+		handleAPIError(w, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, statusCode, posts[0])
+}
+
+// lists posts, newest first. filters: status (comma separated, any status by
+// default), tag (repeatable, matches any), author (username), page and per_page
+func (s *Server) APIListPosts(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(r)
+	if !ok {
+		handleAPIError(w, http.StatusUnauthorized)
+		return
+	}
+	params := r.URL.Query()
+	options := []db.Option{db.WithAnyStatus()}
+
+	if status := params.Get("status"); status != "" {
+		var statuses []db.PostStatus
+		for _, value := range strings.Split(status, ",") {
+			status := db.PostStatus(strings.TrimSpace(value))
+			if !db.IsValidPostStatus(status) {
+				handleAPIErrorMessage(w, http.StatusBadRequest, fmt.Sprintf("unknown status %q", value))
+				return
+			}
+			statuses = append(statuses, status)
+		}
+		options = append(options, db.WithStatus(statuses...))
+	}
+
+	authorID := 0
+	if username := params.Get("author"); username != "" {
+		author, err := s.store.GetUserByUsername(username)
+		if err != nil {
+			if err == db.ErrNotFound {
+				handleAPIErrorMessage(w, http.StatusBadRequest, fmt.Sprintf("unknown author %q", username))
+			} else {
+				handleAPIError(w, http.StatusInternalServerError)
+			}
+			return
+		}
+		authorID = author.Id
+	}
+	// authors only get to list their own posts, like the admin page
+	if user.Role == db.Author {
+		if authorID != 0 && authorID != user.Id {
+			handleAPIError(w, http.StatusForbidden)
+			return
+		}
+		authorID = user.Id
+	}
+	if authorID != 0 {
+		options = append(options, db.WithAuthor(authorID))
+	}
+
+	page, perPage := 1, config.PageSize
+	for _, param := range []struct {
+		name  string
+		value *int
+	}{{"page", &page}, {"per_page", &perPage}} {
+		value := params.Get(param.name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			handleAPIErrorMessage(w, http.StatusBadRequest, param.name+" must be a positive number")
+			return
+		}
+		*param.value = n
+	}
+	perPage = min(perPage, apiMaxPageSize)
+	// fetch one extra post to find out whether there's a next page
+	options = append(options, db.WithLimit(perPage+1), db.WithOffset((page-1)*perPage))
+
+	var posts []*db.Post
+	var err error
+	if tags := params["tag"]; len(tags) > 0 {
+		posts, err = s.store.GetFilteredPosts(tags, options...)
+	} else {
+		posts, err = s.store.GetAllPosts(options...)
+	}
+	if err != nil {
+		handleAPIError(w, http.StatusInternalServerError)
+		return
+	}
+	hasMore := len(posts) > perPage
+	if hasMore {
+		posts = posts[:perPage]
+	}
+	result, err := s.apiPosts(posts)
+	if err != nil {
+		handleAPIError(w, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, &apiPostList{Posts: result, Page: page, PerPage: perPage, HasMore: hasMore})
+}
+
+func (s *Server) APIGetPost(w http.ResponseWriter, r *http.Request) {
+	post, ok := r.Context().Value(postKey).(*db.Post)
+	if !ok {
+		handleAPIError(w, http.StatusUnprocessableEntity)
+		return
+	}
+	s.writeAPIPost(w, http.StatusOK, post.Id)
+}
+
+func (s *Server) APICreatePost(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(r)
+	if !ok {
+		handleAPIError(w, http.StatusUnauthorized)
+		return
+	}
+	var input apiPostInput
+	if !decodeJSON(w, r, &input) {
+		return
+	}
+	now := s.now()
+	post := db.Post{
+		UserId:    user.Id,
+		Status:    db.Published,
+		Published: now.Format("Monday, January 2, 2006"),
+	}
+	if err := input.apply(&post); err != nil {
+		handleAPIErrorMessage(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	s.sanitizePost(&post)
+	postID, err := s.store.CreatePost(&post)
+	if err == db.ErrSlugTaken {
+		handleAPIErrorMessage(w, http.StatusConflict, fmt.Sprintf("slug %q is already used by another post", post.Slug))
+		return
+	}
+	if err != nil {
+		handleAPIError(w, http.StatusInternalServerError)
+		return
+	}
+	if input.Tags != nil {
+		err = s.store.CreateTags(postID, *input.Tags)
+		if err != nil {
+			handleAPIError(w, http.StatusInternalServerError)
+			return
+		}
+	}
+	s.sendWebmentions(&post, "")
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/posts/%d", postID))
+	s.writeAPIPost(w, http.StatusCreated, int(postID))
+}
+
+func (s *Server) APIUpdatePost(w http.ResponseWriter, r *http.Request) {
+	post, ok := r.Context().Value(postKey).(*db.Post)
+	if !ok {
+		handleAPIError(w, http.StatusUnprocessableEntity)
+		return
+	}
+	var input apiPostInput
+	if !decodeJSON(w, r, &input) {
+		return
+	}
+	previous := post.Content
+	if err := input.apply(post); err != nil {
+		handleAPIErrorMessage(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	post.UpdatedAt = s.now()
+	s.sanitizePost(post)
+	err := s.store.EditPost(post.Id, post)
+	if err != nil {
+		// deleted since APIPostCtx loaded it
+		if err == db.ErrNotFound {
+			handleAPIError(w, http.StatusNotFound)
+		} else if err == db.ErrSlugTaken {
+			handleAPIErrorMessage(w, http.StatusConflict, fmt.Sprintf("slug %q is already used by another post", post.Slug))
+		} else {
+			handleAPIError(w, http.StatusInternalServerError)
+		}
+		return
+	}
+	if input.Tags != nil {
+		err = s.store.SetTags(post.Id, *input.Tags)
+		if err != nil {
+			handleAPIError(w, http.StatusInternalServerError)
+			return
+		}
+	}
+	s.sendWebmentions(post, previous)
+	s.writeAPIPost(w, http.StatusOK, post.Id)
+}
+
+func (s *Server) APIDeletePost(w http.ResponseWriter, r *http.Request) {
+	post, ok := r.Context().Value(postKey).(*db.Post)
+	if !ok {
+		handleAPIError(w, http.StatusUnprocessableEntity)
+		return
+	}
+	err := s.store.DeletePost(post.Id)
+	if err != nil {
+		handleAPIError(w, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) APIGetPostTags(w http.ResponseWriter, r *http.Request) {
+	post, ok := r.Context().Value(postKey).(*db.Post)
+	if !ok {
+		handleAPIError(w, http.StatusUnprocessableEntity)
+		return
+	}
+	tags, err := s.store.GetTags(post.Id)
+	if err != nil {
+		handleAPIError(w, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, &apiTags{Tags: utils.Map(tags, func(tag *db.Tag) string { return tag.Name })})
+}
+
+// replaces a post's tags with the ones in the body
+func (s *Server) APISetPostTags(w http.ResponseWriter, r *http.Request) {
+	post, ok := r.Context().Value(postKey).(*db.Post)
+	if !ok {
+		handleAPIError(w, http.StatusUnprocessableEntity)
+		return
+	}
+	var input apiTags
+	if !decodeJSON(w, r, &input) {
+		return
+	}
+	if err := validateTags(input.Tags); err != nil {
+		handleAPIErrorMessage(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	err := s.store.SetTags(post.Id, input.Tags)
+	if err != nil {
+		handleAPIError(w, http.StatusInternalServerError)
+		return
+	}
+	s.APIGetPostTags(w, r)
+}
+
+func (s *Server) APIListTags(w http.ResponseWriter, r *http.Request) {
+	tags, err := s.store.GetAllTags()
+	if err != nil {
+		handleAPIError(w, http.StatusInternalServerError)
+		return
+	}
+	result := utils.Map(tags, func(tag *db.TagCount) *apiTag {
+		return &apiTag{Name: tag.Name, Posts: tag.Posts}
+	})
+	writeJSON(w, http.StatusOK, map[string][]*apiTag{"tags": result})
+}
+
+// removes a tag from every post, which is why authors can't do it
+func (s *Server) APIDeleteTag(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(r)
+	if !ok {
+		handleAPIError(w, http.StatusUnauthorized)
+		return
+	}
+	if user.Role != db.Admin && user.Role != db.Editor {
+		handleAPIError(w, http.StatusForbidden)
+		return
+	}
+	err := s.store.DeleteTag(chi.URLParam(r, "tag"))
+	if err != nil {
+		if err == db.ErrNotFound {
+			handleAPIError(w, http.StatusNotFound)
+		} else {
+			handleAPIError(w, http.StatusInternalServerError)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"personal-site/internal/db"
+	"strings"
+	"testing"
+)
+
+// creates an api token for the user and returns it
+func newTestAPIToken(t *testing.T, store *db.MemoryStore, userID int) string {
+	t.Helper()
+	token, err := newAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateAPIToken(&db.APIToken{UserId: userID, Name: "test", TokenHash: hashAPIToken(token)}); err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// sends an api request with the token, decoding the response into v when it isn't nil
+func apiRequest(t *testing.T, handler http.Handler, token string, method string, path string, body string, v interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if v != nil && rec.Code < 300 {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: %v\n%s", method, path, err, rec.Body)
+		}
+	}
+	return rec
+}
+
+func TestAPIAuthentication(t *testing.T) {
+	s, store := newTestServer(t)
+	handler := s.Handler()
+	token := newTestAPIToken(t, store, 1)
+
+	tests := []struct {
+		name   string
+		header string
+		status int
+	}{
+		{"no header", "", http.StatusUnauthorized},
+		{"not a bearer token", "Basic " + token, http.StatusUnauthorized},
+		{"unknown token", "Bearer ps_nope", http.StatusUnauthorized},
+		{"valid token", "Bearer " + token, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/posts", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("GET /api/v1/posts = %d, want %d", rec.Code, tt.status)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without a WWW-Authenticate header")
+			}
+		})
+	}
+
+	// a session cookie isn't enough
+	c := newTestClient(t, handler)
+	c.login("admin", "pass")
+	if rec := c.do(http.MethodGet, "/api/v1/posts", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("GET /api/v1/posts with a session cookie = %d, want 401", rec.Code)
+	}
+}
+
+func TestAPIRoles(t *testing.T) {
+	s, store := newTestServer(t)
+	handler := s.Handler()
+	authorID, err := store.CreateUser("author", "pass", db.Author)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminToken := newTestAPIToken(t, store, 1)
+	authorToken := newTestAPIToken(t, store, int(authorID))
+	own := &db.Post{UserId: int(authorID), Title: "Own", Slug: "own", Content: "<p>own</p>", Status: db.Draft}
+	ownID, err := store.CreatePost(own)
+	if err != nil {
+		t.Fatal(err)
+	}
+	published, err := store.GetPostBySlug("hello-world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	draft, err := store.GetPostBySlug("work-in-progress", db.WithAnyStatus())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var list apiPostList
+	if rec := apiRequest(t, handler, authorToken, http.MethodGet, "/api/v1/posts", "", &list); rec.Code != http.StatusOK {
+		t.Fatalf("author listing posts = %d", rec.Code)
+	}
+	if len(list.Posts) != 1 || list.Posts[0].Id != int(ownID) {
+		t.Errorf("author listing posts got %+v, want only their own", list.Posts)
+	}
+	if rec := apiRequest(t, handler, adminToken, http.MethodGet, "/api/v1/posts", "", &list); rec.Code != http.StatusOK || len(list.Posts) != 3 {
+		t.Errorf("admin listing posts = %d, %d posts, want every post", rec.Code, len(list.Posts))
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"author listing another author's posts", authorToken, http.MethodGet, "/api/v1/posts?author=admin", "", http.StatusForbidden},
+		{"author reading a published post", authorToken, http.MethodGet, fmt.Sprintf("/api/v1/posts/%d", published.Id), "", http.StatusOK},
+		{"author reading another author's draft", authorToken, http.MethodGet, fmt.Sprintf("/api/v1/posts/%d", draft.Id), "", http.StatusNotFound},
+		{"author editing another author's post", authorToken, http.MethodPatch, fmt.Sprintf("/api/v1/posts/%d", published.Id), `{"title":"Mine"}`, http.StatusForbidden},
+		{"author deleting another author's post", authorToken, http.MethodDelete, fmt.Sprintf("/api/v1/posts/%d", published.Id), "", http.StatusForbidden},
+		{"author editing their own post", authorToken, http.MethodPatch, fmt.Sprintf("/api/v1/posts/%d", ownID), `{"title":"Still Mine"}`, http.StatusOK},
+		{"author deleting a tag", authorToken, http.MethodDelete, "/api/v1/tags/go", "", http.StatusForbidden},
+		{"admin editing another author's post", adminToken, http.MethodPatch, fmt.Sprintf("/api/v1/posts/%d", ownID), `{"title":"Edited"}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := apiRequest(t, handler, tt.token, tt.method, tt.path, tt.body, nil); rec.Code != tt.status {
+				t.Errorf("%s %s = %d, want %d: %s", tt.method, tt.path, rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+	if post, err := store.GetPost(published.Id); err != nil || post.Title != "Hello World" {
+		t.Errorf("another author's post was changed: %+v, %v", post, err)
+	}
+}
+
+func TestAPIPagination(t *testing.T) {
+	s, store := newTestServer(t)
+	handler := s.Handler()
+	token := newTestAPIToken(t, store, 1)
+	for i := 0; i < 3; i++ {
+		post := &db.Post{UserId: 1, Title: fmt.Sprintf("Post %d", i), Slug: fmt.Sprintf("post-%d", i), Content: "<p>hi</p>"}
+		if _, err := store.CreatePost(post); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// five posts in all, two per page
+	seen := make(map[int]bool)
+	for page, hasMore := range []bool{true, true, false} {
+		var list apiPostList
+		path := fmt.Sprintf("/api/v1/posts?per_page=2&page=%d", page+1)
+		if rec := apiRequest(t, handler, token, http.MethodGet, path, "", &list); rec.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d", path, rec.Code)
+		}
+		if list.Page != page+1 || list.PerPage != 2 || list.HasMore != hasMore {
+			t.Errorf("GET %s = page %d, per_page %d, has_more %t", path, list.Page, list.PerPage, list.HasMore)
+		}
+		for _, post := range list.Posts {
+			if seen[post.Id] {
+				t.Errorf("post %d is on more than one page", post.Id)
+			}
+			seen[post.Id] = true
+		}
+	}
+	if len(seen) != 5 {
+		t.Errorf("pages had %d posts, want 5", len(seen))
+	}
+
+	var list apiPostList
+	if rec := apiRequest(t, handler, token, http.MethodGet, "/api/v1/posts?per_page=1000", "", &list); rec.Code != http.StatusOK || list.PerPage != apiMaxPageSize {
+		t.Errorf("per_page=1000 = %d, per_page %d, want %d", rec.Code, list.PerPage, apiMaxPageSize)
+	}
+	for _, query := range []string{"page=0", "page=x", "per_page=-1", "status=deleted"} {
+		if rec := apiRequest(t, handler, token, http.MethodGet, "/api/v1/posts?"+query, "", nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("GET /api/v1/posts?%s = %d, want 400", query, rec.Code)
+		}
+	}
+}
+
+func TestAPIPostValidation(t *testing.T) {
+	s, store := newTestServer(t)
+	handler := s.Handler()
+	token := newTestAPIToken(t, store, 1)
+	existing, err := store.GetPostBySlug("hello-world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	existingPath := fmt.Sprintf("/api/v1/posts/%d", existing.Id)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"invalid json", http.MethodPost, "/api/v1/posts", `{"title":`, http.StatusBadRequest},
+		{"unknown field", http.MethodPost, "/api/v1/posts", `{"title":"A","author":"someone"}`, http.StatusBadRequest},
+		{"missing title", http.MethodPost, "/api/v1/posts", `{"content":"<p>hi</p>"}`, http.StatusUnprocessableEntity},
+		{"invalid slug", http.MethodPost, "/api/v1/posts", `{"title":"A","slug":"Not A Slug"}`, http.StatusUnprocessableEntity},
+		{"content and markdown", http.MethodPost, "/api/v1/posts", `{"title":"A","content":"a","markdown":"a"}`, http.StatusUnprocessableEntity},
+		{"unknown status", http.MethodPost, "/api/v1/posts", `{"title":"A","status":"hidden"}`, http.StatusUnprocessableEntity},
+		{"scheduled without publish_at", http.MethodPost, "/api/v1/posts", `{"title":"A","status":"scheduled"}`, http.StatusUnprocessableEntity},
+		{"relative canonical_url", http.MethodPost, "/api/v1/posts", `{"title":"A","canonical_url":"/a"}`, http.StatusUnprocessableEntity},
+		{"tag with a space", http.MethodPost, "/api/v1/posts", `{"title":"A","tags":["two words"]}`, http.StatusUnprocessableEntity},
+		{"slug of another post", http.MethodPost, "/api/v1/posts", `{"title":"A","slug":"hello-world"}`, http.StatusConflict},
+		{"slug from a title of another post", http.MethodPost, "/api/v1/posts", `{"title":"Hello World"}`, http.StatusConflict},
+		{"slug of a draft", http.MethodPost, "/api/v1/posts", `{"title":"A","slug":"work-in-progress"}`, http.StatusConflict},
+		{"update to the slug of another post", http.MethodPatch, existingPath, `{"slug":"work-in-progress"}`, http.StatusConflict},
+		{"update keeping its own slug", http.MethodPatch, existingPath, `{"slug":"hello-world","title":"Hello Again"}`, http.StatusOK},
+		{"new post", http.MethodPost, "/api/v1/posts", `{"title":"A New Post","markdown":"# hi","tags":["go"]}`, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := apiRequest(t, handler, token, tt.method, tt.path, tt.body, nil)
+			if rec.Code != tt.status {
+				t.Errorf("%s %s %s = %d, want %d: %s", tt.method, tt.path, tt.body, rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+
+	var posts apiPostList
+	apiRequest(t, handler, token, http.MethodGet, "/api/v1/posts", "", &posts)
+	slugs := make(map[string]int)
+	for _, post := range posts.Posts {
+		slugs[post.Slug]++
+	}
+	if len(posts.Posts) != 3 || slugs["a-new-post"] != 1 || slugs["hello-world"] != 1 || slugs["work-in-progress"] != 1 {
+		t.Errorf("posts after the requests have slugs %v, want one of each", slugs)
+	}
+}
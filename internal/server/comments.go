@@ -0,0 +1,321 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/mail"
+	"personal-site/internal/config"
+	"personal-site/internal/db"
+	"personal-site/pkg/utils"
+	"personal-site/pkg/utils/markdown"
+	"personal-site/pkg/utils/sanitize"
+	"personal-site/web/static/html"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	commentMaxLength     = 5000
+	commentMaxNameLength = 100
+	// people take longer than this to write a comment, bots don't
+	commentMinDelay = 3 * time.Second
+	// how long a rendered comment form can be submitted for
+	commentFormMaxAge = 24 * time.Hour
+	// comments with more links than this are almost always spam
+	commentMaxLinks = 3
+)
+
+// signs when the comment form for a post was rendered, so the time check can't be
+// skipped by sending a made up time
+func commentToken(postID int, renderedAt time.Time) string {
+	ts := strconv.FormatInt(renderedAt.Unix(), 10)
+	mac := hmac.New(sha256.New, config.SignKey)
+	mac.Write([]byte(ts + ":" + strconv.Itoa(postID)))
+	return ts + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// returns when the form was rendered, false if the token wasn't made for this post
+func checkCommentToken(token string, postID int) (time.Time, bool) {
+	ts, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return time.Time{}, false
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	renderedAt := time.Unix(unix, 0)
+	return renderedAt, hmac.Equal([]byte(token), []byte(commentToken(postID, renderedAt)))
+}
+
+// the spam heuristics, a hidden field only bots fill in, a form sent back faster than
+// anyone could type, and too many links
+func isSpamComment(r *http.Request, renderedAt time.Time, now time.Time, body string) bool {
+	if r.PostForm.Get("website") != "" {
+		return true
+	}
+	if now.Sub(renderedAt) < commentMinDelay {
+		return true
+	}
+	return strings.Count(body, "http://")+strings.Count(body, "https://") > commentMaxLinks
+}
+
+// renders a comment's markdown, keeping only the formatting comments are allowed
+func renderComment(body string) (template.HTML, error) {
+	// comments can't have headings, so they don't get anchors either
+	content, err := markdown.ParseMD(body, markdown.WithoutAnchors(), markdown.WithUserLinks())
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(sanitize.Comments.Sanitize(content.HTML)), nil
+}
+
+// approved comments on a post, nested into threads
+func (s *Server) commentThreads(postID int) ([]*db.Comment, error) {
+	comments, err := s.store.GetComments(postID, db.CommentApproved)
+	if err != nil {
+		return nil, err
+	}
+	return db.CommentTree(comments), nil
+}
+
+// takes a comment from a reader, comments wait for a moderator before they're shown.
+// spam is saved for review but answered the same way, so bots can't tell
+func (s *Server) HandleCreateComment(w http.ResponseWriter, r *http.Request) {
+	post, ok := r.Context().Value(postKey).(*db.Post)
+	if !ok {
+		handleError(w, http.StatusUnprocessableEntity)
+		return
+	}
+	if post.Status != db.Published {
+		handleError(w, http.StatusNotFound)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, apiMaxBodySize)
+	if err := r.ParseForm(); err != nil {
+		handleError(w, http.StatusBadRequest)
+		return
+	}
+	now := s.now()
+	renderedAt, ok := checkCommentToken(r.PostForm.Get("token"), post.Id)
+	if !ok || now.Sub(renderedAt) > commentFormMaxAge {
+		http.Error(w, "This form has expired, reload the page and try again.", http.StatusUnprocessableEntity)
+		return
+	}
+	release, ok := s.allowComment(w, clientIP(r))
+	if !ok {
+		return
+	}
+	// held until the comment is saved, so parallel posts can't all pass the throttle
+	defer release()
+	comment := db.Comment{
+		PostId:      post.Id,
+		AuthorName:  strings.TrimSpace(r.PostForm.Get("name")),
+		AuthorEmail: strings.TrimSpace(r.PostForm.Get("email")),
+		AuthorURL:   strings.TrimSpace(r.PostForm.Get("url")),
+		Body:        strings.TrimSpace(r.PostForm.Get("body")),
+		Status:      db.CommentPending,
+		IP:          clientIP(r),
+		CreatedAt:   now,
+	}
+	if message := s.validateComment(&comment, r.PostForm.Get("parent")); message != "" {
+		http.Error(w, message, http.StatusUnprocessableEntity)
+		return
+	}
+	content, err := renderComment(comment.Body)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError)
+		return
+	}
+	comment.Content = content
+	if isSpamComment(r, renderedAt, now, comment.Body) {
+		comment.Status = db.CommentSpam
+	}
+	_, err = s.store.CreateComment(&comment)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError)
+		return
+	}
+	if comment.Status == db.CommentPending {
+		go s.notifyComment(post, &comment)
+	}
+	if r.Header.Get("HX-Request") == "" {
+		http.Redirect(w, r, "/blog/"+post.Slug+"#comments", http.StatusSeeOther)
+		return
+	}
+	html.CommentReceived(w)
+}
+
+// checks a comment from the form, returning a message for the reader if it's invalid
+func (s *Server) validateComment(comment *db.Comment, parent string) string {
+	if comment.AuthorName == "" || utf8.RuneCountInString(comment.AuthorName) > commentMaxNameLength {
+		return fmt.Sprintf("Your name is required and can be up to %d characters.", commentMaxNameLength)
+	}
+	if comment.Body == "" || utf8.RuneCountInString(comment.Body) > commentMaxLength {
+		return fmt.Sprintf("A comment is required and can be up to %d characters.", commentMaxLength)
+	}
+	if comment.AuthorEmail != "" {
+		address, err := mail.ParseAddress(comment.AuthorEmail)
+		if err != nil {
+			return "That email address doesn't look right."
+		}
+		comment.AuthorEmail = address.Address
+	}
+	if comment.AuthorURL != "" && !utils.IsAbsoluteURL(comment.AuthorURL) {
+		return "Your website has to be a full http(s) address."
+	}
+	if parent != "" {
+		parentID, err := strconv.Atoi(parent)
+		if err != nil {
+			return "The comment you're replying to doesn't exist."
+		}
+		// only shown comments can be replied to, on the same post
+		reply, err := s.store.GetComment(parentID)
+		if err != nil || reply.PostId != comment.PostId || reply.Status != db.CommentApproved {
+			return "The comment you're replying to doesn't exist."
+		}
+		comment.ParentId = sql.NullInt64{Int64: int64(parentID), Valid: true}
+	}
+	return ""
+}
+
+func (s *Server) notifyComment(post *db.Post, comment *db.Comment) {
+	author, err := s.store.GetUser(post.UserId)
+	if err != nil {
+		if err != db.ErrNotFound {
+			log.Printf("failed to load the author of post %d: %v", post.Id, err)
+		}
+		return
+	}
+	subject := fmt.Sprintf("New comment on %q", post.Title)
+	body := fmt.Sprintf("%s commented on %q:\n\n%s\n\nApprove or reject it at %s/admin/comments\n",
+		comment.AuthorName, post.Title, comment.Body, config.SiteURL)
+	if err := s.notifier.Notify(author, subject, body); err != nil {
+		log.Printf("failed to notify %s about a comment: %v", author.Username, err)
+	}
+}
+
+// comments with a status on posts the user can moderate, which are the posts they can edit
+func (s *Server) moderatedComments(user *db.User, status db.CommentStatus) ([]*db.ModeratedComment, error) {
+	comments, err := s.store.GetComments(0, status)
+	if err != nil {
+		return nil, err
+	}
+	posts := make(map[int]*db.Post)
+	result := make([]*db.ModeratedComment, 0, len(comments))
+	for _, comment := range comments {
+		post, ok := posts[comment.PostId]
+		if !ok {
+			post, err = s.store.GetPost(comment.PostId)
+			if err != nil {
+				return nil, err
+			}
+			posts[comment.PostId] = post
+		}
+		if user.CanEditPost(post) {
+			result = append(result, &db.ModeratedComment{Comment: comment, Post: post})
+		}
+	}
+	// newest first, the queue is worked from the top
+	slices.Reverse(result)
+	return result, nil
+}
+
+func (s *Server) GetCommentsPage(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(r)
+	if !ok {
+		handleError(w, http.StatusUnprocessableEntity)
+		return
+	}
+	status := db.CommentPending
+	if value := r.URL.Query().Get("status"); value != "" {
+		status = db.CommentStatus(value)
+	}
+	if !db.IsValidCommentStatus(status) {
+		handleError(w, http.StatusBadRequest)
+		return
+	}
+	comments, err := s.moderatedComments(user, status)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError)
+		return
+	}
+	html.Comments(w, &db.CommentsData{Comments: comments, Status: status, Statuses: db.CommentStatuses})
+}
+
+// looks up the comment in the url, writing an error unless the user can moderate it
+func (s *Server) commentFromURL(w http.ResponseWriter, r *http.Request) (*db.Comment, bool) {
+	user, ok := currentUser(r)
+	if !ok {
+		handleError(w, http.StatusUnprocessableEntity)
+		return nil, false
+	}
+	commentID, err := strconv.Atoi(chi.URLParam(r, "commentID"))
+	if err != nil {
+		handleError(w, http.StatusBadRequest)
+		return nil, false
+	}
+	comment, err := s.store.GetComment(commentID)
+	if err != nil {
+		if err == db.ErrNotFound {
+			handleError(w, http.StatusNotFound)
+		} else {
+			handleError(w, http.StatusInternalServerError)
+		}
+		return nil, false
+	}
+	post, err := s.store.GetPost(comment.PostId)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError)
+		return nil, false
+	}
+	if !user.CanEditPost(post) {
+		handleError(w, http.StatusForbidden)
+		return nil, false
+	}
+	return comment, true
+}
+
+// approves a comment or marks it as spam, the comment leaves the list it was in
+func (s *Server) HandleModerateComment(w http.ResponseWriter, r *http.Request) {
+	comment, ok := s.commentFromURL(w, r)
+	if !ok {
+		return
+	}
+	status := db.CommentStatus(r.FormValue("status"))
+	if !db.IsValidCommentStatus(status) {
+		handleError(w, http.StatusBadRequest)
+		return
+	}
+	err := s.store.SetCommentStatus(comment.Id, status)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// deletes a comment and its replies
+func (s *Server) HandleDeleteComment(w http.ResponseWriter, r *http.Request) {
+	comment, ok := s.commentFromURL(w, r)
+	if !ok {
+		return
+	}
+	err := s.store.DeleteComment(comment.Id)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
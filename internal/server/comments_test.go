@@ -0,0 +1,353 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"personal-site/internal/db"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// a notifier that hands notifications to the test
+type testNotifier chan string
+
+func (n testNotifier) Notify(user *db.User, subject string, body string) error {
+	n <- user.Username + ": " + subject
+	return nil
+}
+
+// a server with a fixed clock and captured notifications, and a reader posting comments
+func newCommentServer(t *testing.T) (*Server, *db.MemoryStore, *db.Post, *time.Time, testNotifier) {
+	t.Helper()
+	s, store := newTestServer(t)
+	now := time.Unix(1700000000, 0)
+	s.now = func() time.Time { return now }
+	notifications := make(testNotifier, 10)
+	s.notifier = notifications
+	post, err := store.GetPostBySlug("hello-world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, store, post, &now, notifications
+}
+
+// a comment form for the post rendered a minute before now
+func commentForm(post *db.Post, now time.Time, body string) url.Values {
+	return url.Values{
+		"token": {commentToken(post.Id, now.Add(-time.Minute))},
+		"name":  {"Reader"},
+		"body":  {body},
+	}
+}
+
+func TestCommentFormToken(t *testing.T) {
+	s, store, post, now, _ := newCommentServer(t)
+	c := newTestClient(t, s.Handler())
+	path := "/blog/hello-world/comments"
+	valid := commentToken(post.Id, now.Add(-time.Minute))
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"no token", "", http.StatusUnprocessableEntity},
+		{"not a token", "nope", http.StatusUnprocessableEntity},
+		{"token for another post", commentToken(post.Id+1, now.Add(-time.Minute)), http.StatusUnprocessableEntity},
+		{"made up time", fmt.Sprintf("%d.%s", now.Add(-time.Hour).Unix(), strings.SplitN(valid, ".", 2)[1]), http.StatusUnprocessableEntity},
+		{"expired form", commentToken(post.Id, now.Add(-commentFormMaxAge-time.Minute)), http.StatusUnprocessableEntity},
+		{"valid token", valid, http.StatusSeeOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := commentForm(post, *now, "hi")
+			form.Set("token", tt.token)
+			if rec := c.do(http.MethodPost, path, form); rec.Code != tt.status {
+				t.Errorf("POST %s = %d, want %d", path, rec.Code, tt.status)
+			}
+		})
+	}
+	comments, err := store.GetComments(post.Id, db.CommentPending)
+	if err != nil || len(comments) != 1 {
+		t.Errorf("GetComments() = %d comments, %v, want only the valid one", len(comments), err)
+	}
+
+	if rec := c.do(http.MethodPost, "/blog/work-in-progress/comments", commentForm(post, *now, "hi")); rec.Code != http.StatusNotFound {
+		t.Errorf("commenting on a draft = %d, want 404", rec.Code)
+	}
+}
+
+// a form sent back over and over is throttled like failed logins, so it can't flood
+// the moderators with notifications
+func TestCommentThrottle(t *testing.T) {
+	s, store, post, now, notifications := newCommentServer(t)
+	c := newTestClient(t, s.Handler())
+	path := "/blog/hello-world/comments"
+	form := commentForm(post, *now, "hi")
+
+	for i := 0; i < commentLimit.free; i++ {
+		if rec := c.do(http.MethodPost, path, form); rec.Code != http.StatusSeeOther {
+			t.Fatalf("POST %s #%d = %d, want 303", path, i+1, rec.Code)
+		}
+	}
+	rec := c.do(http.MethodPost, path, form)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Fatalf("replayed POST %s = %d, Retry-After %q, want 429 after 1s", path, rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if !strings.Contains(rec.Body.String(), "too quickly") {
+		t.Errorf("throttled comment body = %q", rec.Body.String())
+	}
+	comments, err := store.GetComments(post.Id, db.CommentPending)
+	if err != nil || len(comments) != commentLimit.free {
+		t.Errorf("GetComments() = %d comments, %v, want %d", len(comments), err, commentLimit.free)
+	}
+	for i := 0; i < commentLimit.free; i++ {
+		select {
+		case <-notifications:
+		case <-time.After(time.Second):
+			t.Fatal("the author wasn't notified")
+		}
+	}
+	select {
+	case notification := <-notifications:
+		t.Errorf("a throttled comment sent a notification: %q", notification)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the wait doubles with each comment, and the window forgets them
+	*now = now.Add(time.Second)
+	if rec := c.do(http.MethodPost, path, form); rec.Code != http.StatusSeeOther {
+		t.Errorf("POST %s after waiting = %d, want 303", path, rec.Code)
+	}
+	if rec := c.do(http.MethodPost, path, form); rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "2" {
+		t.Errorf("POST %s right after = %d, Retry-After %q, want 429 after 2s", path, rec.Code, rec.Header().Get("Retry-After"))
+	}
+	*now = now.Add(commentWindow)
+	if rec := c.do(http.MethodPost, path, commentForm(post, *now, "later")); rec.Code != http.StatusSeeOther {
+		t.Errorf("POST %s an hour later = %d, want 303", path, rec.Code)
+	}
+}
+
+func TestCommentSpamChecks(t *testing.T) {
+	s, store, post, now, notifications := newCommentServer(t)
+	c := newTestClient(t, s.Handler())
+
+	tests := []struct {
+		name   string
+		form   func(url.Values)
+		status db.CommentStatus
+	}{
+		{"person", func(url.Values) {}, db.CommentPending},
+		{"honeypot filled in", func(form url.Values) { form.Set("website", "https://spam.example") }, db.CommentSpam},
+		{"sent too quickly", func(form url.Values) { form.Set("token", commentToken(post.Id, now.Add(-time.Second))) }, db.CommentSpam},
+		{"too many links", func(form url.Values) {
+			form.Set("body", "see"+strings.Repeat(" https://spam.example", commentMaxLinks+1))
+		}, db.CommentSpam},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// a while apart, so the throttle doesn't step in
+			*now = now.Add(time.Minute)
+			form := commentForm(post, *now, fmt.Sprintf("comment %d", i))
+			tt.form(form)
+			// spam is answered like any other comment
+			if rec := c.do(http.MethodPost, "/blog/hello-world/comments", form); rec.Code != http.StatusSeeOther {
+				t.Fatalf("POST = %d, want 303", rec.Code)
+			}
+			comments, err := store.GetComments(post.Id, tt.status)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(comments) == 0 || comments[len(comments)-1].Body != form.Get("body") {
+				t.Errorf("the comment wasn't saved as %s", tt.status)
+			}
+		})
+	}
+
+	// only the comment that wasn't spam tells the author
+	select {
+	case notification := <-notifications:
+		if notification != `admin: New comment on "Hello World"` {
+			t.Errorf("notification = %q", notification)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the author wasn't notified")
+	}
+	select {
+	case notification := <-notifications:
+		t.Errorf("spam sent a notification: %q", notification)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestCommentThreads(t *testing.T) {
+	s, store, post, now, _ := newCommentServer(t)
+	other, err := store.GetPostBySlug("work-in-progress", db.WithAnyStatus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// each comment replies to the one before it
+	var ids []int
+	for depth := 0; depth < 4; depth++ {
+		comment := &db.Comment{PostId: post.Id, AuthorName: "Reader", Body: fmt.Sprintf("depth %d", depth), Status: db.CommentApproved, CreatedAt: *now}
+		comment.Content = template.HTML(fmt.Sprintf("<p>depth %d</p>", depth))
+		if depth > 0 {
+			comment.ParentId = sql.NullInt64{Int64: int64(ids[depth-1]), Valid: true}
+		}
+		id, err := store.CreateComment(comment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, int(id))
+	}
+	pending, err := store.CreateComment(&db.Comment{PostId: post.Id, AuthorName: "Reader", Body: "pending", Status: db.CommentPending, CreatedAt: *now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	elsewhere, err := store.CreateComment(&db.Comment{PostId: other.Id, AuthorName: "Reader", Body: "elsewhere", Status: db.CommentApproved, CreatedAt: *now})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	threads, err := s.commentThreads(post.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	depth := 0
+	for comments := threads; len(comments) > 0; comments = comments[0].Replies {
+		if len(comments) != 1 || comments[0].Id != ids[depth] {
+			t.Fatalf("comments at depth %d = %+v, want comment %d", depth, comments, ids[depth])
+		}
+		depth++
+	}
+	if depth != 4 {
+		t.Errorf("threads are %d deep, want 4", depth)
+	}
+
+	c := newTestClient(t, s.Handler())
+	body := c.do(http.MethodGet, "/blog/hello-world", nil).Body.String()
+	nested := regexp.MustCompile(`(?s)id="comment-` + fmt.Sprint(ids[0]) + `".*id="comment-` + fmt.Sprint(ids[3]) + `"`)
+	if !nested.MatchString(body) || strings.Contains(body, "pending") {
+		t.Errorf("the post page doesn't show the thread:\n%s", body)
+	}
+
+	replies := []struct {
+		name   string
+		parent string
+		status int
+	}{
+		{"reply to the deepest comment", fmt.Sprint(ids[3]), http.StatusSeeOther},
+		{"reply to a pending comment", fmt.Sprint(pending), http.StatusUnprocessableEntity},
+		{"reply to a comment on another post", fmt.Sprint(elsewhere), http.StatusUnprocessableEntity},
+		{"reply to a missing comment", "999", http.StatusUnprocessableEntity},
+		{"reply to something that isn't a comment", "first", http.StatusUnprocessableEntity},
+	}
+	for _, tt := range replies {
+		t.Run(tt.name, func(t *testing.T) {
+			form := commentForm(post, *now, "a reply")
+			form.Set("parent", tt.parent)
+			if rec := c.do(http.MethodPost, "/blog/hello-world/comments", form); rec.Code != tt.status {
+				t.Errorf("POST = %d, want %d", rec.Code, tt.status)
+			}
+		})
+	}
+
+	// marking a comment in the middle as spam hides the replies under it
+	if err := store.SetCommentStatus(ids[1], db.CommentSpam); err != nil {
+		t.Fatal(err)
+	}
+	threads, err = s.commentThreads(post.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(threads) != 1 || len(threads[0].Replies) != 0 {
+		t.Errorf("threads after hiding a reply = %+v, want only the first comment", threads)
+	}
+}
+
+func TestCommentModeration(t *testing.T) {
+	s, store, post, now, _ := newCommentServer(t)
+	if _, err := store.CreateUser("author", "pass", db.Author); err != nil {
+		t.Fatal(err)
+	}
+	reader := newTestClient(t, s.Handler())
+	if rec := reader.do(http.MethodPost, "/blog/hello-world/comments", commentForm(post, *now, "first!")); rec.Code != http.StatusSeeOther {
+		t.Fatalf("POST comment = %d", rec.Code)
+	}
+	comments, err := store.GetComments(post.Id, db.CommentPending)
+	if err != nil || len(comments) != 1 {
+		t.Fatalf("GetComments() = %d comments, %v", len(comments), err)
+	}
+	path := fmt.Sprintf("/admin/comments/%d", comments[0].Id)
+	if strings.Contains(reader.do(http.MethodGet, "/blog/hello-world", nil).Body.String(), "first!") {
+		t.Error("a pending comment is shown on the post")
+	}
+
+	// authors only moderate comments on their own posts
+	author := newTestClient(t, s.Handler())
+	author.login("author", "pass")
+	if body := author.do(http.MethodGet, "/admin/comments", nil).Body.String(); strings.Contains(body, "first!") {
+		t.Error("an author sees comments on another author's post")
+	}
+	if rec := author.do(http.MethodPatch, path, url.Values{"status": {"approved"}}); rec.Code != http.StatusForbidden {
+		t.Errorf("author approving a comment on another author's post = %d, want 403", rec.Code)
+	}
+
+	admin := newTestClient(t, s.Handler())
+	admin.login("admin", "pass")
+	if body := admin.do(http.MethodGet, "/admin/comments", nil).Body.String(); !strings.Contains(body, "first!") {
+		t.Errorf("the queue doesn't list the pending comment:\n%s", body)
+	}
+	if rec := admin.do(http.MethodGet, "/admin/comments?status=deleted", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("listing an unknown status = %d, want 400", rec.Code)
+	}
+	if rec := admin.do(http.MethodPatch, path, url.Values{"status": {"hidden"}}); rec.Code != http.StatusBadRequest {
+		t.Errorf("setting an unknown status = %d, want 400", rec.Code)
+	}
+	if rec := admin.do(http.MethodPatch, path, url.Values{"status": {"approved"}}); rec.Code != http.StatusOK {
+		t.Fatalf("approving = %d", rec.Code)
+	}
+	if body := admin.do(http.MethodGet, "/admin/comments", nil).Body.String(); strings.Contains(body, "first!") {
+		t.Error("an approved comment is still in the pending queue")
+	}
+	if !strings.Contains(reader.do(http.MethodGet, "/blog/hello-world", nil).Body.String(), "first!") {
+		t.Error("an approved comment isn't shown on the post")
+	}
+
+	reply := commentForm(post, *now, "a reply")
+	reply.Set("parent", fmt.Sprint(comments[0].Id))
+	reader.do(http.MethodPost, "/blog/hello-world/comments", reply)
+	if rec := admin.do(http.MethodDelete, path, url.Values{}); rec.Code != http.StatusOK {
+		t.Fatalf("deleting = %d", rec.Code)
+	}
+	for _, status := range db.CommentStatuses {
+		if comments, err := store.GetComments(post.Id, status); err != nil || len(comments) != 0 {
+			t.Errorf("%d %s comments left after deleting the thread, %v", len(comments), status, err)
+		}
+	}
+	if rec := admin.do(http.MethodDelete, path, url.Values{}); rec.Code != http.StatusNotFound {
+		t.Errorf("deleting a deleted comment = %d, want 404", rec.Code)
+	}
+}
+
+func TestRenderCommentLinks(t *testing.T) {
+	setSiteURL(t, "https://example.com")
+	content, err := renderComment("[mine](https://spam.example), https://auto.example and [a post](https://example.com/blog/hello-world)\n\n# heading\n\n<script>alert(1)</script>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := string(content)
+	if strings.Count(html, `rel="nofollow ugc"`) != 3 {
+		t.Errorf("every link should be rel=\"nofollow ugc\":\n%s", html)
+	}
+	for _, unwanted := range []string{"↗", "external", "noopener", "<h1", "<script", "heading-anchor"} {
+		if strings.Contains(html, unwanted) {
+			t.Errorf("rendered comment contains %q:\n%s", unwanted, html)
+		}
+	}
+}
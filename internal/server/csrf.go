@@ -0,0 +1,78 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"personal-site/internal/config"
+)
+
+// csrf tokens use the double-submit pattern, the token lives in a cookie that page
+// scripts can read and every state-changing request has to echo it back in a
+// header (or a form field), which a cross-site request can't do
+const (
+	csrfCookieName = "csrf_token"
+	csrfHeaderName = "X-CSRF-Token"
+	csrfFormField  = "csrf_token"
+)
+
+func newCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func setCSRFCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    token,
+		Secure:   !config.IsDev,
+		SameSite: http.SameSiteStrictMode,
+		Path:     "/",
+	})
+}
+
+// issues a csrf cookie if the request doesn't have one yet and rejects unsafe
+// requests whose token is missing or doesn't match the cookie
+func CSRFProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(csrfCookieName)
+		if err != nil || cookie.Value == "" {
+			if !isSafeMethod(r.Method) {
+				handleError(w, http.StatusForbidden)
+				return
+			}
+			token, err := newCSRFToken()
+			if err != nil {
+				handleError(w, http.StatusInternalServerError)
+				return
+			}
+			setCSRFCookie(w, token)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !isSafeMethod(r.Method) {
+			token := r.Header.Get(csrfHeaderName)
+			if token == "" {
+				token = r.PostFormValue(csrfFormField)
+			}
+			if subtle.ConstantTimeCompare([]byte(token), []byte(cookie.Value)) != 1 {
+				handleError(w, http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
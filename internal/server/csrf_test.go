@@ -0,0 +1,102 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// sends a request with the client's cookies but the csrf token the test picks, in
+// the header when header is set
+func (c *testClient) doWithToken(method string, path string, form url.Values, header string) *httptest.ResponseRecorder {
+	c.t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = c.remoteIP + ":1234"
+	for _, cookie := range c.cookies {
+		req.AddCookie(cookie)
+	}
+	if header != "" {
+		req.Header.Set(csrfHeaderName, header)
+	}
+	rec := httptest.NewRecorder()
+	c.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCSRFCookieIsIssuedOnSafeRequests(t *testing.T) {
+	s, _ := newTestServer(t)
+	c := newTestClient(t, s.Handler())
+	c.login("admin", "pass")
+	delete(c.cookies, csrfCookieName)
+
+	if rec := c.do(http.MethodGet, "/admin", nil); rec.Code != http.StatusOK {
+		t.Fatalf("GET /admin = %d", rec.Code)
+	}
+	cookie, ok := c.cookies[csrfCookieName]
+	if !ok || cookie.Value == "" {
+		t.Fatal("GET /admin without a csrf cookie didn't issue one")
+	}
+	if cookie.SameSite != http.SameSiteStrictMode || cookie.HttpOnly {
+		t.Errorf("csrf cookie = %+v, want SameSite=Strict and readable by scripts", cookie)
+	}
+
+	// a cookie that's already there is kept
+	rec := c.do(http.MethodGet, "/admin", nil)
+	for _, set := range rec.Result().Cookies() {
+		if set.Name == csrfCookieName {
+			t.Errorf("GET /admin with a csrf cookie replaced it with %q", set.Value)
+		}
+	}
+}
+
+func TestCSRFTokens(t *testing.T) {
+	s, store := newTestServer(t)
+	c := newTestClient(t, s.Handler())
+	c.login("admin", "pass")
+	c.do(http.MethodGet, "/admin", nil)
+	token := c.cookies[csrfCookieName].Value
+	post, err := store.GetPostBySlug("hello-world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	postPath := fmt.Sprintf("/post/%d", post.Id)
+	edit := url.Values{"post-title": {"Changed"}, "post-slug": {"changed"}, "post-content": {"<p>changed</p>"}}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		form   url.Values
+		header string
+		status int
+	}{
+		{"header matching the cookie", http.MethodPost, "/preview", url.Values{"post-content": {"hi"}}, token, http.StatusOK},
+		{"header not matching the cookie", http.MethodPost, "/preview", url.Values{"post-content": {"hi"}}, token + "x", http.StatusForbidden},
+		{"form field matching the cookie", http.MethodPost, "/preview", url.Values{"post-content": {"hi"}, csrfFormField: {token}}, "", http.StatusOK},
+		{"form field not matching the cookie", http.MethodPost, "/preview", url.Values{"post-content": {"hi"}, csrfFormField: {"nope"}}, "", http.StatusForbidden},
+		{"header checked before the form field", http.MethodPost, "/preview", url.Values{"post-content": {"hi"}, csrfFormField: {token}}, "nope", http.StatusForbidden},
+		{"patch without a token", http.MethodPatch, postPath, edit, "", http.StatusForbidden},
+		{"delete without a token", http.MethodDelete, postPath, url.Values{}, "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := c.doWithToken(tt.method, tt.path, tt.form, tt.header); rec.Code != tt.status {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, rec.Code, tt.status)
+			}
+		})
+	}
+	if got, err := store.GetPost(post.Id); err != nil || got.Slug != "hello-world" {
+		t.Errorf("post after requests without a token = %v, %v, want it unchanged", got, err)
+	}
+
+	if rec := c.doWithToken(http.MethodDelete, postPath, url.Values{}, token); rec.Code != http.StatusOK {
+		t.Errorf("DELETE %s with the token = %d, want 200", postPath, rec.Code)
+	}
+	if _, err := store.GetPost(post.Id); err == nil {
+		t.Error("the post wasn't deleted")
+	}
+}
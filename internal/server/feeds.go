@@ -0,0 +1,127 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"personal-site/internal/config"
+	"personal-site/internal/db"
+	"personal-site/pkg/utils/feed"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	feedTitle       = "Rafael Singer's Blog"
+	feedDescription = "Writing about movies, philosophy, technology, or whatever else I find interesting."
+	feedAuthor      = "Rafael Singer"
+	feedLimit       = 20
+)
+
+func (s *Server) GetRSSFeed(w http.ResponseWriter, r *http.Request) {
+	s.serveFeed(w, r, "/blog/feed.xml", nil, "application/rss+xml; charset=utf-8", (*feed.Feed).RSS)
+}
+
+func (s *Server) GetAtomFeed(w http.ResponseWriter, r *http.Request) {
+	s.serveFeed(w, r, "/blog/atom.xml", nil, "application/atom+xml; charset=utf-8", (*feed.Feed).Atom)
+}
+
+func (s *Server) GetJSONFeed(w http.ResponseWriter, r *http.Request) {
+	s.serveFeed(w, r, "/blog/feed.json", nil, "application/feed+json; charset=utf-8", (*feed.Feed).JSON)
+}
+
+func (s *Server) GetTagRSSFeed(w http.ResponseWriter, r *http.Request) {
+	tag := chi.URLParam(r, "tag")
+	if tag == "" {
+		handleError(w, http.StatusNotFound)
+		return
+	}
+	path := fmt.Sprintf("/blog/tags/%s/feed.xml", url.PathEscape(tag))
+	s.serveFeed(w, r, path, []string{tag}, "application/rss+xml; charset=utf-8", (*feed.Feed).RSS)
+}
+
+// builds a feed of the latest published posts (optionally filtered by tags) and serves it
+// through http.ServeContent, which takes care of the conditional request headers. the
+// newest post time doesn't change when a post is deleted or unpublished, so the ETag,
+// a hash of the body, is what tells readers the feed changed; If-None-Match takes
+// precedence over If-Modified-Since when a reader sends both
+func (s *Server) serveFeed(w http.ResponseWriter, r *http.Request, path string, tags []string, contentType string, render func(*feed.Feed) ([]byte, error)) {
+	var posts []*db.Post
+	var err error
+	if len(tags) > 0 {
+		posts, err = s.store.GetFilteredPosts(tags, db.WithLimit(feedLimit), db.WithContent())
+	} else {
+		posts, err = s.store.GetAllPosts(db.WithLimit(feedLimit), db.WithContent())
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError)
+		return
+	}
+	f, err := s.buildFeed(posts, path, tags)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError)
+		return
+	}
+	body, err := render(f)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError)
+		return
+	}
+	sum := sha256.Sum256(body)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	http.ServeContent(w, r, "", f.Updated, bytes.NewReader(body))
+}
+
+func (s *Server) buildFeed(posts []*db.Post, path string, tags []string) (*feed.Feed, error) {
+	f := &feed.Feed{
+		Title:       feedTitle,
+		Link:        config.SiteURL + "/blog",
+		FeedLink:    config.SiteURL + path,
+		Description: feedDescription,
+		Author:      feedAuthor,
+		Items:       make([]*feed.Item, 0, len(posts)),
+	}
+	if len(tags) > 0 {
+		f.Title = fmt.Sprintf("%s: #%s", feedTitle, tags[0])
+		f.Link = fmt.Sprintf("%s/blog?q=%s", config.SiteURL, url.QueryEscape(tags[0]))
+	}
+	for _, post := range posts {
+		postTags, err := s.store.GetTags(post.Id)
+		if err != nil {
+			return nil, err
+		}
+		link := fmt.Sprintf("%s/blog/%s", config.SiteURL, post.Slug)
+		item := &feed.Item{
+			Id:          link,
+			Title:       post.Title,
+			Link:        link,
+			Description: post.Description,
+			Content:     string(post.Content),
+			Published:   post.CreatedAt,
+			Updated:     post.UpdatedAt,
+		}
+		for _, tag := range postTags {
+			item.Tags = append(item.Tags, tag.Name)
+		}
+		f.Items = append(f.Items, item)
+		f.Updated = latest(f.Updated, post.CreatedAt, post.UpdatedAt)
+	}
+	// Last-Modified has second precision, so truncate to keep conditional requests stable
+	f.Updated = f.Updated.Truncate(time.Second)
+	return f, nil
+}
+
+func latest(times ...time.Time) time.Time {
+	var result time.Time
+	for _, t := range times {
+		if t.After(result) {
+			result = t
+		}
+	}
+	return result
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"personal-site/internal/db"
+	"testing"
+)
+
+func TestFeedConditionalRequests(t *testing.T) {
+	s, store := newTestServer(t)
+	newer := &db.Post{UserId: 1, Title: "Newer", Slug: "newer", Content: "<p>newer</p>", Status: db.Published}
+	if _, err := store.CreatePost(newer); err != nil {
+		t.Fatal(err)
+	}
+	handler := s.Handler()
+	get := func(path string, etag string, lastModified string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if lastModified != "" {
+			req.Header.Set("If-Modified-Since", lastModified)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, path := range []string{"/blog/feed.xml", "/blog/atom.xml", "/blog/feed.json"} {
+		t.Run(path, func(t *testing.T) {
+			rec := get(path, "", "")
+			etag, lastModified := rec.Header().Get("ETag"), rec.Header().Get("Last-Modified")
+			if rec.Code != http.StatusOK || etag == "" || lastModified == "" {
+				t.Fatalf("GET %s = %d, ETag %q, Last-Modified %q", path, rec.Code, etag, lastModified)
+			}
+			if rec := get(path, etag, lastModified); rec.Code != http.StatusNotModified {
+				t.Errorf("GET %s with the same validators = %d, want 304", path, rec.Code)
+			}
+			if rec := get(path, "", lastModified); rec.Code != http.StatusNotModified {
+				t.Errorf("GET %s with If-Modified-Since = %d, want 304", path, rec.Code)
+			}
+		})
+	}
+
+	// deleting an older post leaves the newest post time alone but changes the feed
+	rec := get("/blog/feed.xml", "", "")
+	etag, lastModified := rec.Header().Get("ETag"), rec.Header().Get("Last-Modified")
+	old, err := store.GetPostBySlug("hello-world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeletePost(old.Id); err != nil {
+		t.Fatal(err)
+	}
+	rec = get("/blog/feed.xml", etag, lastModified)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /blog/feed.xml after deleting a post = %d, want 200", rec.Code)
+	}
+	if rec.Header().Get("ETag") == etag {
+		t.Errorf("ETag %q didn't change after deleting a post", etag)
+	}
+}
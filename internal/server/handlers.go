@@ -8,10 +8,12 @@ import (
 	"html/template"
 	"io"
 	"net/http"
+	"net/url"
 	"personal-site/internal/config"
 	"personal-site/internal/db"
 	"personal-site/internal/types"
 	"personal-site/pkg/utils"
+	"personal-site/pkg/utils/diff"
 	"personal-site/pkg/utils/markdown"
 	"personal-site/web/static/html"
 	"strconv"
@@ -20,19 +22,22 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/jwtauth/v5"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/pkg/errors"
 )
 
 type key int
 
+const searchLimit = 20
+
 const (
 	postKey key = iota
 	tagsKey
+	sessionKey
+	userKey
 )
 
 // middleware to add post to context, throw 404 if not found
-func PostCtx(next http.Handler) http.Handler {
+func (s *Server) PostCtx(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var post *db.Post
 		var tags []*db.Tag
@@ -44,9 +49,9 @@ func PostCtx(next http.Handler) http.Handler {
 				handleError(w, http.StatusBadRequest)
 				return
 			}
-			post, err = db.GetPost(postIdInt)
+			post, err = s.store.GetPost(postIdInt)
 			if err != nil {
-				if err == sql.ErrNoRows {
+				if err == db.ErrNotFound {
 					handleError(w, http.StatusNotFound)
 				} else {
 					handleError(w, http.StatusInternalServerError)
@@ -54,16 +59,26 @@ func PostCtx(next http.Handler) http.Handler {
 				return
 			}
 		} else if postSlug := chi.URLParam(r, "postSlug"); postSlug != "" {
-			post, err = db.GetPostBySlug(postSlug)
+			// unpublished posts are only visible to the users who can edit them
+			user := s.requestUser(r)
+			var options []db.Option
+			if user != nil {
+				options = append(options, db.WithAnyStatus())
+			}
+			post, err = s.store.GetPostBySlug(postSlug, options...)
+			if err == nil && post.Status != db.Published && (user == nil || !user.CanEditPost(post)) {
+				// someone else's draft, the published post with the slug if there is one
+				post, err = s.store.GetPostBySlug(postSlug)
+			}
 			if err != nil {
-				if err == sql.ErrNoRows {
+				if err == db.ErrNotFound {
 					handleError(w, http.StatusNotFound)
 				} else {
 					handleError(w, http.StatusInternalServerError)
 				}
 				return
 			}
-			tags, err = db.GetTags(post.Id)
+			tags, err = s.store.GetTags(post.Id)
 		} else {
 			handleError(w, http.StatusNotFound)
 			return
@@ -80,8 +95,8 @@ func PostCtx(next http.Handler) http.Handler {
 	})
 }
 
-func GetHomePage(w http.ResponseWriter, r *http.Request) {
-	posts, err := db.GetAllPosts(db.WithLimit(3))
+func (s *Server) GetHomePage(w http.ResponseWriter, r *http.Request) {
+	posts, err := s.store.GetAllPosts(db.WithLimit(3))
 	if err != nil {
 		handleError(w, http.StatusUnprocessableEntity)
 		return
@@ -89,90 +104,266 @@ func GetHomePage(w http.ResponseWriter, r *http.Request) {
 	html.Home(w, posts)
 }
 
-func GetLoginPage(w http.ResponseWriter, r *http.Request) {
+func (s *Server) GetLoginPage(w http.ResponseWriter, r *http.Request) {
 	html.Login(w)
 }
 
-func HandleNotFound(w http.ResponseWriter, r *http.Request) {
+func (s *Server) HandleNotFound(w http.ResponseWriter, r *http.Request) {
 	handleError(w, http.StatusNotFound)
 }
 
-func CustomAuthenticator(ja *jwtauth.JWTAuth) func(http.Handler) http.Handler {
+// the user behind the request's session, nil without one. for routes that don't
+// require a session, requires jwtauth.Verifier to have run
+func (s *Server) requestUser(r *http.Request) *db.User {
+	if user, ok := currentUser(r); ok {
+		return user
+	}
+	session, err := s.sessionFromRequest(r)
+	if err != nil {
+		return nil
+	}
+	user, err := s.store.GetUser(session.UserId)
+	if err != nil {
+		return nil
+	}
+	return user
+}
+
+// rejects requests without an active session and refreshes tokens that are close
+// to expiring, or already have, while their session is still alive
+func (s *Server) CustomAuthenticator(ja *jwtauth.JWTAuth) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		hfn := func(w http.ResponseWriter, r *http.Request) {
-			token, _, err := jwtauth.FromContext(r.Context())
-
+			session, err := s.sessionFromRequest(r)
 			if err != nil {
-				handleError(w, http.StatusUnauthorized)
+				if err == errNoSession {
+					handleError(w, http.StatusUnauthorized)
+				} else {
+					handleError(w, http.StatusInternalServerError)
+				}
 				return
 			}
-
-			if token == nil {
-				handleError(w, http.StatusUnauthorized)
+			// the user is loaded on every request so role changes apply right away
+			user, err := s.store.GetUser(session.UserId)
+			if err != nil {
+				if err == db.ErrNotFound {
+					handleError(w, http.StatusUnauthorized)
+				} else {
+					handleError(w, http.StatusInternalServerError)
+				}
 				return
 			}
 
+			now := time.Now()
+			token, _, _ := jwtauth.FromContext(r.Context())
+			refresh := token.Expiration().Sub(now) < utils.TokenLifetime/2
+			if refresh {
+				if err := setTokenCookie(w, session); err != nil {
+					handleError(w, http.StatusInternalServerError)
+					return
+				}
+			}
+			// every refresh slides the session forward, otherwise last seen is
+			// only written once in a while to spare the database
+			if refresh || now.Sub(session.LastSeenAt) > sessionTouchInterval {
+				err := s.store.TouchSession(session.Id, clientIP(r), now, now.Add(sessionIdleTimeout))
+				if err != nil {
+					handleError(w, http.StatusInternalServerError)
+					return
+				}
+			}
+
 			// Token is authenticated, pass it through
-			next.ServeHTTP(w, r)
+			ctx := context.WithValue(r.Context(), sessionKey, session)
+			ctx = context.WithValue(ctx, userKey, user)
+			next.ServeHTTP(w, r.WithContext(ctx))
 		}
 		return http.HandlerFunc(hfn)
 	}
 }
 
 // TODO: standardize date formatting, this is inefficient
-func GetAdminPage(w http.ResponseWriter, r *http.Request) {
-	posts, err := db.GetAllPosts()
-	for _, post := range posts {
-		post.Published = post.CreatedAt.Format("01/02/06")
+func (s *Server) GetAdminPage(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(r)
+	if !ok {
+		handleError(w, http.StatusUnprocessableEntity)
+		return
+	}
+	// authors only get to see their own posts
+	var options []db.Option
+	if user.Role == db.Author {
+		options = append(options, db.WithAuthor(user.Id))
+	}
+	drafts, err := s.store.GetAllPosts(append(options, db.WithStatus(db.Draft), db.WithOrderBy("updated_at", db.DESC))...)
+	if err != nil {
+		handleError(w, http.StatusUnprocessableEntity)
+		return
+	}
+	scheduled, err := s.store.GetAllPosts(append(options, db.WithStatus(db.Scheduled), db.WithOrderBy("publish_at", db.ASC))...)
+	if err != nil {
+		handleError(w, http.StatusUnprocessableEntity)
+		return
+	}
+	published, err := s.store.GetAllPosts(options...)
+	if err != nil {
+		handleError(w, http.StatusUnprocessableEntity)
+		return
 	}
+	pending, err := s.moderatedComments(user, db.CommentPending)
 	if err != nil {
 		handleError(w, http.StatusUnprocessableEntity)
 		return
 	}
-	html.Admin(w, posts)
+	for _, post := range drafts {
+		post.Published = post.CreatedAt.Format("01/02/06")
+	}
+	for _, post := range scheduled {
+		post.Published = post.PublishAt.Time.Local().Format("01/02/06 15:04")
+	}
+	for _, post := range published {
+		post.Published = post.CreatedAt.Format("01/02/06")
+	}
+	html.Admin(w, &db.AdminData{
+		User:            user,
+		Drafts:          drafts,
+		Scheduled:       scheduled,
+		Published:       published,
+		PendingComments: len(pending),
+	})
+}
+
+// the colors for highlighted code, generated to match CODE_STYLE
+func (s *Server) GetCodeStylesheet(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(markdown.CodeCSS())
 }
 
-func GetNewPost(w http.ResponseWriter, r *http.Request) {
+func (s *Server) GetNewPost(w http.ResponseWriter, r *http.Request) {
 	html.NewPost(w)
 }
 
-func GetProjectsPage(w http.ResponseWriter, r *http.Request) {
+func (s *Server) GetProjectsPage(w http.ResponseWriter, r *http.Request) {
 	html.Projects(w)
 }
-func GetAllPosts(w http.ResponseWriter, r *http.Request) {
-	var tagFilters []string
-	var posts []*db.Post
-	var err error
-	params := r.URL.Query()
-	for key, val := range params {
-		if key == "q" {
-			tagFilters = val
+func (s *Server) GetAllPosts(w http.ResponseWriter, r *http.Request) {
+	tagFilters := r.URL.Query()["q"]
+	s.servePostPage(w, r, "/blog", db.BlogData{Filters: tagFilters}, func(options ...db.Option) ([]*db.Post, error) {
+		if len(tagFilters) > 0 {
+			return s.store.GetFilteredPosts(tagFilters, options...)
+		}
+		return s.store.GetAllPosts(options...)
+	})
+}
+
+// lists an author's published posts, paged like /blog
+func (s *Server) GetAuthorPosts(w http.ResponseWriter, r *http.Request) {
+	author, err := s.store.GetUserByUsername(chi.URLParam(r, "username"))
+	if err != nil {
+		if err == db.ErrNotFound {
+			handleError(w, http.StatusNotFound)
+		} else {
+			handleError(w, http.StatusInternalServerError)
 		}
+		return
 	}
-	if len(tagFilters) > 0 {
-		posts, err = db.GetFilteredPosts(tagFilters)
-	} else {
-		posts, err = db.GetAllPosts()
+	path := "/blog/authors/" + url.PathEscape(author.Username)
+	s.servePostPage(w, r, path, db.BlogData{Author: author}, func(options ...db.Option) ([]*db.Post, error) {
+		return s.store.GetAllPosts(append(options, db.WithAuthor(author.Id))...)
+	})
+}
+
+// renders the page of a post list at path that ?page asks for. fetch loads the posts
+// with the page's limit and offset, and the page links keep the other query params
+func (s *Server) servePostPage(w http.ResponseWriter, r *http.Request, path string, blogData db.BlogData, fetch func(options ...db.Option) ([]*db.Post, error)) {
+	params := r.URL.Query()
+	page := 1
+	if pageParam := params.Get("page"); pageParam != "" {
+		var err error
+		page, err = strconv.Atoi(pageParam)
+		if err != nil || page < 1 {
+			handleError(w, http.StatusBadRequest)
+			return
+		}
 	}
+	// fetch one extra post to find out whether there's a next page
+	posts, err := fetch(db.WithLimit(config.PageSize+1), db.WithOffset((page-1)*config.PageSize))
 	if err != nil {
 		handleError(w, http.StatusUnprocessableEntity)
 		return
 	}
+	if len(posts) == 0 && page > 1 {
+		handleError(w, http.StatusNotFound)
+		return
+	}
+	hasNext := len(posts) > config.PageSize
+	if hasNext {
+		posts = posts[:config.PageSize]
+	}
 	for _, post := range posts {
 		post.Published = post.CreatedAt.Format("Jan 2, 2006")
 	}
+	blogData.Posts = posts
+	blogData.Page = page
+	if page > 1 {
+		blogData.PrevURL = pageURL(path, params, page-1)
+	}
+	if hasNext {
+		blogData.NextURL = pageURL(path, params, page+1)
+	}
+	html.AllPosts(w, &blogData)
+}
+
+// builds a url for the given page of a post list, keeping the other query params (e.g. tag filters)
+func pageURL(path string, params url.Values, page int) string {
+	query := url.Values{}
+	for key, val := range params {
+		query[key] = val
+	}
+	if page > 1 {
+		query.Set("page", strconv.Itoa(page))
+	} else {
+		query.Del("page")
+	}
+	if len(query) == 0 {
+		return path
+	}
+	return path + "?" + query.Encode()
+}
+
+func (s *Server) GetSearchResults(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	var results []*db.SearchResult
+	var err error
+	if query == "" {
+		// an empty search (e.g. a cleared search box) lists every post
+		var posts []*db.Post
+		posts, err = s.store.GetAllPosts()
+		for _, post := range posts {
+			results = append(results, &db.SearchResult{Post: post})
+		}
+	} else {
+		results, err = s.store.SearchPosts(query, db.WithLimit(searchLimit))
+	}
 	if err != nil {
-		handleError(w, http.StatusUnprocessableEntity)
+		handleError(w, http.StatusInternalServerError)
 		return
 	}
-	blogData := db.BlogData{
-		Posts:   posts,
-		Filters: tagFilters,
+	for _, result := range results {
+		result.Post.Published = result.Post.CreatedAt.Format("Jan 2, 2006")
 	}
-	html.AllPosts(w, &blogData)
+	searchData := db.SearchData{
+		Query:   query,
+		Results: results,
+	}
+	if r.Header.Get("HX-Request") == "true" {
+		html.SearchResults(w, &searchData)
+		return
+	}
+	html.Search(w, &searchData)
 }
 
-func GetPost(w http.ResponseWriter, r *http.Request) {
+func (s *Server) GetPost(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	post, ok := ctx.Value(postKey).(*db.Post)
 	if !ok {
@@ -184,24 +375,52 @@ func GetPost(w http.ResponseWriter, r *http.Request) {
 		handleError(w, http.StatusUnprocessableEntity)
 		return
 	}
+	author, err := s.store.GetUser(post.UserId)
+	if err != nil && err != db.ErrNotFound {
+		handleError(w, http.StatusInternalServerError)
+		return
+	}
+	webmentions, err := s.store.GetWebmentions(post.Id, db.WebmentionVerified)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError)
+		return
+	}
+	comments, err := s.commentThreads(post.Id)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError)
+		return
+	}
 	data := db.PostData{
-		Post: post,
-		Tags: tags,
+		Post:        post,
+		Tags:        tags,
+		Author:      author,
+		Webmentions: webmentions,
+		Comments:    comments,
+	}
+	// posts are saved as html, so the contents are read back from it
+	if toc := markdown.ContentTOC(string(post.Content)); post.ShowsTOC(len(toc)) {
+		data.TOC = toc
+	}
+	// only published posts take comments
+	if post.Status == db.Published {
+		data.CommentToken = commentToken(post.Id, s.now())
 	}
 	html.Post(w, &data)
 }
 
-func EditPost(w http.ResponseWriter, r *http.Request) {
+func (s *Server) EditPost(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	post, ok := ctx.Value(postKey).(*db.Post)
 	if !ok {
 		handleError(w, http.StatusUnprocessableEntity)
 		return
 	}
+	// posts saved before sanitizing existed are shown as they'll be saved now
+	s.sanitizePost(post)
 	html.Edit(w, post)
 }
 
-func HandleLogin(w http.ResponseWriter, r *http.Request) {
+func (s *Server) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	// request validation
 	err := r.ParseForm()
 	if err != nil {
@@ -214,29 +433,45 @@ func HandleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var user *db.User
+	ip := clientIP(r)
+	release, allowed := s.allowLogin(w, username, ip)
+	if !allowed {
+		return
+	}
+	defer release()
 
-	user, err = db.GetUserByCreds(username, password)
-	if !user.IsAdmin {
+	user, err := s.store.GetUserByCreds(username, password)
+	if err != nil {
+		if err == db.ErrInvalidCredentials {
+			s.recordLoginAttempt(username, ip, false)
+			handleError(w, http.StatusUnauthorized)
+		} else {
+			handleError(w, http.StatusInternalServerError)
+		}
+		return
+	}
+	if !db.IsValidRole(user.Role) {
+		s.recordLoginAttempt(username, ip, false)
 		handleError(w, http.StatusUnauthorized)
 		return
-	} else if err != nil {
-		handleError(w, http.StatusInternalServerError)
+	}
+	// with two-factor on, the session is only started once the code checks out
+	if user.TOTPEnabled {
+		err = setMFACookie(w, user)
+		if err != nil {
+			handleError(w, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("HX-Redirect", "/login/2fa")
+		w.WriteHeader(http.StatusOK)
 		return
 	}
-	jwt, err := utils.GenerateToken(user.Id)
+	err = s.startSession(w, r, user)
 	if err != nil {
 		handleError(w, http.StatusInternalServerError)
 		return
 	}
-
-	http.SetCookie(w, &http.Cookie{
-		Name:     "jwt",
-		Value:    jwt,
-		HttpOnly: true,
-		Secure:   !config.IsDev,
-		Path:     "/",
-	})
+	s.recordLoginAttempt(username, ip, true)
 
 	w.Header().Set("HX-Redirect", "/admin")
 	w.WriteHeader(http.StatusOK)
@@ -244,7 +479,7 @@ func HandleLogin(w http.ResponseWriter, r *http.Request) {
 
 // TODO: clean up this logic it is really messy and ugly and i hate it
 // TODO: ideally, when you edit the tags, it's reflected in the preview
-func HandleUploadMarkdown(w http.ResponseWriter, r *http.Request) {
+func (s *Server) HandleUploadMarkdown(w http.ResponseWriter, r *http.Request) {
 	r.ParseMultipartForm(32 << 20)
 	var buf bytes.Buffer
 	file, header, err := r.FormFile("markdown")
@@ -254,94 +489,180 @@ func HandleUploadMarkdown(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 	io.Copy(&buf, file)
-	contents := buf.String()
-	title := utils.FormatTitle(header.Filename)
-	slug := utils.TitleToSlug(title)
-	tags := utils.ParseTags(contents)
-	utils.CleanPostContent(&contents)
+	fm, contents, err := utils.ParseFrontMatter(buf.String())
+	if err != nil {
+		// surfaced by the upload form's hx-on::response-error handler
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	title := fm.Title
+	if title == "" {
+		title = utils.FormatTitle(header.Filename)
+	}
+	slug := fm.Slug
+	if slug == "" {
+		slug = utils.TitleToSlug(title)
+	}
+	tags := strings.Join(fm.Tags, " ")
 	mk, err := markdown.ParseMD(contents)
 	if err != nil {
 		handleError(w, http.StatusBadRequest)
 		return
 	}
+	// the textarea gets exactly what the preview shows and what will be saved
+	content := string(s.sanitizePostContent(mk.HTML))
+
+	// drafts win over dates, a date in the future schedules the post
+	var publishAt string
+	status := db.Published
+	if !fm.Date.IsZero() {
+		publishAt = fm.Date.Local().Format("2006-01-02T15:04")
+	}
+	if fm.Draft {
+		status = db.Draft
+	} else if fm.Date.After(time.Now()) {
+		status = db.Scheduled
+	}
+	selected := func(s db.PostStatus) string {
+		if s == status {
+			return "selected"
+		}
+		return ""
+	}
+	toc := db.TOCAuto
+	if fm.TOC != nil {
+		toc = db.TOCOff
+		if *fm.TOC {
+			toc = db.TOCOn
+		}
+	}
+	tocSelected := func(m db.TOCMode) string {
+		if m == toc {
+			return "selected"
+		}
+		return ""
+	}
+
+	esc := template.HTMLEscapeString
 	html := fmt.Sprintf(`
 		<div class="raw-container">
             <h2 id="raw-post-title">Raw</h2>
-            <textarea class="raw-post" oninput={previewPostBody(this.value)} name="post-content" form="create-post-form">%s</textarea>
-            <form class="upload-markdown-container" enctype="multipart/form-data" hx-post="/markdown" hx-target=".raw-post" hx-swap="innerHTML">
+            <textarea class="raw-post" name="post-content" form="create-post-form" hx-post="/preview" hx-trigger="input changed delay:300ms" hx-target=".preview-post">%[12]s</textarea>
+            <form class="upload-markdown-container" enctype="multipart/form-data" hx-post="/markdown" hx-target=".post-text" hx-swap="innerHTML" hx-on::response-error="showUploadError(event)">
                 <input type="file" name="markdown">
                 <input type="submit" value="Upload Markdown"></button>
             </form>
+            <p class="upload-error"></p>
             <label for="post-title">Title</label>
-            <input type="text" name="post-title" value="%s" oninput={previewPostTitle(this.value)} form="create-post-form">
+            <input type="text" name="post-title" value="%[2]s" oninput="previewPostTitle(this.value)" form="create-post-form">
             <label for="post-slug">Slug</label>
-            <input type="text" name="post-slug" value="%s" form="create-post-form">
+            <input type="text" name="post-slug" value="%[3]s" form="create-post-form">
 			<label for="tags">Tags</label>
-            <input type="text" name="tags" value="%s" form="create-post-form">
-            <form class="create-post-container" id="create-post-form" method="post" action="/post">
+            <input type="text" name="tags" value="%[4]s" form="create-post-form">
+            <label for="post-description">Description</label>
+            <input type="text" name="post-description" value="%[5]s" form="create-post-form">
+            <label for="post-cover-image">Cover Image</label>
+            <input type="text" name="post-cover-image" value="%[6]s" form="create-post-form">
+            <label for="post-canonical-url">Canonical URL</label>
+            <input type="url" name="post-canonical-url" value="%[7]s" form="create-post-form">
+            <label for="post-status">Status</label>
+            <select name="post-status" form="create-post-form">
+                <option value="draft" %[8]s>Draft</option>
+                <option value="scheduled" %[9]s>Scheduled</option>
+                <option value="published" %[10]s>Published</option>
+            </select>
+            <label for="publish-at">Publish At</label>
+            <input type="datetime-local" name="publish-at" value="%[11]s" form="create-post-form">
+            <label for="post-toc">Table of Contents</label>
+            <select name="post-toc" form="create-post-form">
+                <option value="auto" %[13]s>Auto</option>
+                <option value="on" %[14]s>On</option>
+                <option value="off" %[15]s>Off</option>
+            </select>
+            <form class="create-post-container" id="create-post-form" hx-post="/post" hx-on::response-error="showPostError(event)">
                 <button type="submit">Create Post</button>
             </form>
+            <p class="post-error"></p>
         </div>
 		<div class="preview-container">
             <h2 id="preview-post-title">Preview</h2>
-            <h3 class="preview-title">%s</h3>
-            <div class="preview-post">%s</div>
+            <h3 class="preview-title">%[2]s</h3>
+            <div class="preview-post">%[1]s</div>
         </div>
-	`, mk, title, slug, tags, title, mk)
+	`, content, esc(title), esc(slug), esc(tags), esc(fm.Description), esc(fm.CoverImage), esc(fm.CanonicalURL),
+		selected(db.Draft), selected(db.Scheduled), selected(db.Published), publishAt, esc(content),
+		tocSelected(db.TOCAuto), tocSelected(db.TOCOn), tocSelected(db.TOCOff))
 	w.Write([]byte(html))
 }
 
-func HandleCreatePost(w http.ResponseWriter, r *http.Request) {
+// shown under the post form when the slug belongs to another post
+const slugTakenMessage = "That slug is already used by another post."
+
+func (s *Server) HandleCreatePost(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
 		handleError(w, http.StatusBadRequest)
 		return
 	}
-	cookie, err := r.Cookie("jwt")
+	user, ok := currentUser(r)
+	if !ok {
+		handleError(w, http.StatusUnauthorized)
+		return
+	}
+	content := r.FormValue("post-content")
+	tags := strings.Split(r.FormValue("tags"), " ")
+	status, publishAt, err := parsePostStatus(r)
 	if err != nil {
-		handleError(w, http.StatusInternalServerError)
+		handleError(w, http.StatusBadRequest)
 		return
 	}
-	tokenString := cookie.Value
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte(config.SignKey), nil
-	})
-	if err != nil || !token.Valid {
+	toc, err := parseTOCMode(r)
+	if err != nil {
 		handleError(w, http.StatusBadRequest)
 		return
 	}
-	content := r.FormValue("post-content")
-	tags := strings.Split(r.FormValue("tags"), " ")
-	claims := token.Claims.(jwt.MapClaims)
 	post := db.Post{
-		UserId:    int(claims["user_id"].(float64)), // user_id is a float64 in the map and not an int for some reason
-		Title:     r.FormValue("post-title"),
-		Slug:      r.FormValue("post-slug"),
-		Content:   template.HTML(content),
-		Published: time.Now().Format("Monday, January 2, 2006"),
+		UserId:       user.Id,
+		Title:        r.FormValue("post-title"),
+		Slug:         r.FormValue("post-slug"),
+		Content:      template.HTML(content),
+		Description:  r.FormValue("post-description"),
+		CoverImage:   r.FormValue("post-cover-image"),
+		CanonicalURL: r.FormValue("post-canonical-url"),
+		Published:    time.Now().Format("Monday, January 2, 2006"),
+		Status:       status,
+		PublishAt:    publishAt,
+		TOC:          toc,
+	}
+	s.sanitizePost(&post)
+	postID, err := s.store.CreatePost(&post)
+	if err == db.ErrSlugTaken {
+		// surfaced by the post form's hx-on::response-error handler
+		http.Error(w, slugTakenMessage, http.StatusUnprocessableEntity)
+		return
 	}
-	postID, err := db.CreatePost(&post)
 	if err != nil {
 		handleError(w, http.StatusInternalServerError)
 		return
 	}
-	err = db.CreateTags(postID, tags)
+	err = s.store.CreateTags(postID, tags)
 	if err != nil {
 		handleError(w, http.StatusInternalServerError)
 		return
 	}
+	s.sendWebmentions(&post, "")
 	w.Header().Set("HX-Redirect", "/admin")
 	w.WriteHeader(http.StatusOK)
 }
 
-func HandleDeletePost(w http.ResponseWriter, r *http.Request) {
+func (s *Server) HandleDeletePost(w http.ResponseWriter, r *http.Request) {
 	if postID := chi.URLParam(r, "postID"); postID != "" {
 		postIdInt, err := strconv.Atoi(postID)
 		if err != nil {
 			handleError(w, http.StatusBadRequest)
 			return
 		}
-		err = db.DeletePost(postIdInt)
+		err = s.store.DeletePost(postIdInt)
 		if err != nil {
 			handleError(w, http.StatusInternalServerError)
 			return
@@ -350,7 +671,7 @@ func HandleDeletePost(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-func HandleEditPost(w http.ResponseWriter, r *http.Request) {
+func (s *Server) HandleEditPost(w http.ResponseWriter, r *http.Request) {
 	if postID := chi.URLParam(r, "postID"); postID != "" {
 		postIdInt, err := strconv.Atoi(postID)
 		if err != nil {
@@ -362,22 +683,178 @@ func HandleEditPost(w http.ResponseWriter, r *http.Request) {
 			handleError(w, http.StatusBadRequest)
 			return
 		}
+		status, publishAt, err := parsePostStatus(r)
+		if err != nil {
+			handleError(w, http.StatusBadRequest)
+			return
+		}
+		toc, err := parseTOCMode(r)
+		if err != nil {
+			handleError(w, http.StatusBadRequest)
+			return
+		}
 		post := db.Post{
-			Title:     r.FormValue("post-title"),
-			Slug:      r.FormValue("post-slug"),
-			Content:   template.HTML(r.FormValue("post-content")),
-			UpdatedAt: time.Now(),
+			Title:        r.FormValue("post-title"),
+			Slug:         r.FormValue("post-slug"),
+			Content:      template.HTML(r.FormValue("post-content")),
+			Description:  r.FormValue("post-description"),
+			CoverImage:   r.FormValue("post-cover-image"),
+			CanonicalURL: r.FormValue("post-canonical-url"),
+			Status:       status,
+			PublishAt:    publishAt,
+			TOC:          toc,
+			UpdatedAt:    time.Now(),
+		}
+		s.sanitizePost(&post)
+		err = s.store.EditPost(postIdInt, &post)
+		if err == db.ErrSlugTaken {
+			http.Error(w, slugTakenMessage, http.StatusUnprocessableEntity)
+			return
 		}
-		err = db.EditPost(postIdInt, &post)
 		if err != nil {
 			handleError(w, http.StatusInternalServerError)
 			return
 		}
+		var previous template.HTML
+		if old, ok := r.Context().Value(postKey).(*db.Post); ok {
+			previous = old.Content
+		}
+		s.sendWebmentions(&post, previous)
 	}
 	w.Header().Set("HX-Redirect", "/admin")
 	http.Redirect(w, r, "/admin", http.StatusOK)
 }
 
+func (s *Server) GetRevisionsPage(w http.ResponseWriter, r *http.Request) {
+	post, ok := r.Context().Value(postKey).(*db.Post)
+	if !ok {
+		handleError(w, http.StatusUnprocessableEntity)
+		return
+	}
+	revisions, err := s.store.GetRevisions(post.Id)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError)
+		return
+	}
+	data := db.RevisionsData{
+		Post:      post,
+		Revisions: revisions,
+	}
+	// default to comparing the two most recent revisions
+	if len(revisions) > 0 {
+		data.To = revisions[0]
+		data.From = revisions[0]
+	}
+	if len(revisions) > 1 {
+		data.From = revisions[1]
+	}
+	for _, param := range []struct {
+		name     string
+		revision **db.Revision
+	}{{"from", &data.From}, {"to", &data.To}} {
+		value := r.URL.Query().Get(param.name)
+		if value == "" {
+			continue
+		}
+		revisionID, err := strconv.Atoi(value)
+		if err != nil {
+			handleError(w, http.StatusBadRequest)
+			return
+		}
+		*param.revision, err = s.store.GetRevision(post.Id, revisionID)
+		if err != nil {
+			if err == db.ErrNotFound {
+				handleError(w, http.StatusNotFound)
+			} else {
+				handleError(w, http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+	if data.From != nil && data.To != nil {
+		data.Diff = diff.Lines(string(data.From.Content), string(data.To.Content))
+	}
+	html.Revisions(w, &data)
+}
+
+func (s *Server) HandleRestoreRevision(w http.ResponseWriter, r *http.Request) {
+	post, ok := r.Context().Value(postKey).(*db.Post)
+	if !ok {
+		handleError(w, http.StatusUnprocessableEntity)
+		return
+	}
+	revisionID, err := strconv.Atoi(chi.URLParam(r, "revisionID"))
+	if err != nil {
+		handleError(w, http.StatusBadRequest)
+		return
+	}
+	revision, err := s.store.GetRevision(post.Id, revisionID)
+	if err == db.ErrNotFound {
+		handleError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError)
+		return
+	}
+	// revisions can predate sanitizing or a stricter HTML_ALLOW
+	revision.Content = s.sanitizePostContent(string(revision.Content))
+	err = s.store.RestoreRevision(post.Id, revision)
+	if err != nil {
+		if err == db.ErrNotFound {
+			handleError(w, http.StatusNotFound)
+		} else if err == db.ErrSlugTaken {
+			// surfaced by the revisions page's hx-on::response-error handler
+			http.Error(w, "The slug of that revision is now used by another post, change it before restoring.", http.StatusConflict)
+		} else {
+			handleError(w, http.StatusInternalServerError)
+		}
+		return
+	}
+	restored, err := s.store.GetPost(post.Id)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError)
+		return
+	}
+	s.sendWebmentions(restored, post.Content)
+	w.Header().Set("HX-Redirect", fmt.Sprintf("/admin/posts/%d/revisions", post.Id))
+	w.WriteHeader(http.StatusOK)
+}
+
+// reads the post-status and publish-at form values, scheduled posts must have a publish time
+func parsePostStatus(r *http.Request) (db.PostStatus, sql.NullTime, error) {
+	var publishAt sql.NullTime
+	status := db.PostStatus(r.FormValue("post-status"))
+	if status == "" {
+		return db.Published, publishAt, nil
+	}
+	if !db.IsValidPostStatus(status) {
+		return "", publishAt, fmt.Errorf("invalid post status %q", status)
+	}
+	if status != db.Scheduled {
+		return status, publishAt, nil
+	}
+	// datetime-local inputs don't include a timezone, so assume server local time
+	t, err := time.ParseInLocation("2006-01-02T15:04", r.FormValue("publish-at"), time.Local)
+	if err != nil {
+		return "", publishAt, err
+	}
+	publishAt = sql.NullTime{Time: t.UTC(), Valid: true}
+	return status, publishAt, nil
+}
+
+// the table of contents setting from the post form, auto when it's left out
+func parseTOCMode(r *http.Request) (db.TOCMode, error) {
+	mode := db.TOCMode(r.FormValue("post-toc"))
+	if mode == "" {
+		return db.TOCAuto, nil
+	}
+	if !db.IsValidTOCMode(mode) {
+		return "", fmt.Errorf("invalid table of contents setting %q", mode)
+	}
+	return mode, nil
+}
+
 func handleError(w http.ResponseWriter, statusCode int) {
 	var statusErr types.StatusError
 	switch statusCode {
@@ -387,10 +864,15 @@ func handleError(w http.ResponseWriter, statusCode int) {
 		statusErr = types.NewStatusError(errors.New(http.StatusText(http.StatusBadRequest)), http.StatusBadRequest)
 	case http.StatusUnauthorized:
 		statusErr = types.NewStatusError(errors.New(http.StatusText(http.StatusUnauthorized)), http.StatusUnauthorized)
+	case http.StatusForbidden:
+		statusErr = types.NewStatusError(errors.New(http.StatusText(http.StatusForbidden)), http.StatusForbidden)
 	case http.StatusInternalServerError:
 		statusErr = types.NewStatusError(errors.New(http.StatusText(http.StatusInternalServerError)), http.StatusInternalServerError)
 	case http.StatusUnprocessableEntity:
 		statusErr = types.NewStatusError(errors.New(http.StatusText(http.StatusUnprocessableEntity)), http.StatusUnprocessableEntity)
+	case http.StatusTooManyRequests:
+		statusErr = types.NewStatusError(errors.New(http.StatusText(http.StatusTooManyRequests)), http.StatusTooManyRequests)
 	}
+	w.WriteHeader(statusCode)
 	html.Error(w, statusErr)
 }
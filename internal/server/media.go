@@ -0,0 +1,123 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"personal-site/internal/config"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+)
+
+const mediaMaxSize = 20 << 20
+
+// the content types the media endpoint accepts and the extension they're saved with,
+// sniffed from the file itself rather than trusting the client
+var mediaExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+	"video/mp4":  ".mp4",
+	"audio/mpeg": ".mp3",
+}
+
+var errUnsupportedMedia = errors.New("unsupported media type")
+
+// saves an upload to MEDIA_DIR under a random name and returns its url
+func saveMedia(file io.Reader) (string, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		if err == io.EOF {
+			return "", errUnsupportedMedia
+		}
+		return "", err
+	}
+	head = head[:n]
+	ext, ok := mediaExtensions[http.DetectContentType(head)]
+	if !ok {
+		return "", errUnsupportedMedia
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	name := hex.EncodeToString(b) + ext
+	if err := os.MkdirAll(config.MediaDir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(config.MediaDir, name)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, io.MultiReader(bytes.NewReader(head), file))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return config.SiteURL + "/media/" + name, nil
+}
+
+// serves uploaded media, names are random so files never change and there are
+// no directory listings
+func (s *Server) ServeMedia(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "*")
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		handleError(w, http.StatusNotFound)
+		return
+	}
+	path := filepath.Join(config.MediaDir, name)
+	if _, err := os.Stat(path); err != nil {
+		handleError(w, http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeFile(w, r, path)
+}
+
+// the micropub media endpoint, takes a multipart upload in the file field and
+// answers with the url of the saved file in the Location header
+func (s *Server) HandleMediaUpload(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, mediaMaxSize)
+	err := r.ParseMultipartForm(mediaMaxSize)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			micropubError(w, http.StatusRequestEntityTooLarge, "invalid_request", "the upload is too large")
+		} else {
+			micropubError(w, http.StatusBadRequest, "invalid_request", "expected a multipart upload")
+		}
+		return
+	}
+	if _, ok := s.micropubUser(w, r); !ok {
+		return
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		micropubError(w, http.StatusBadRequest, "invalid_request", "expected a file in the file field")
+		return
+	}
+	defer file.Close()
+	location, err := saveMedia(file)
+	if err != nil {
+		if err == errUnsupportedMedia {
+			micropubError(w, http.StatusBadRequest, "invalid_request", "only jpeg, png, gif and webp images, mp4 video and mp3 audio are accepted")
+		} else {
+			micropubError(w, http.StatusInternalServerError, "server_error", "the upload couldn't be saved")
+		}
+		return
+	}
+	w.Header().Set("Location", location)
+	w.WriteHeader(http.StatusCreated)
+}
@@ -0,0 +1,602 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html"
+	"html/template"
+	"mime"
+	"net/http"
+	"net/url"
+	"personal-site/internal/config"
+	"personal-site/internal/db"
+	"personal-site/pkg/utils"
+	"personal-site/pkg/utils/markdown"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// the longest title derived from the content of a note without a name
+	micropubTitleLength = 60
+	// the most a non-upload micropub request can send, files go through the media endpoint
+	micropubMaxFormSize = 32 << 20
+)
+
+// writes a micropub error, which uses oauth's error format rather than the StatusError
+func micropubError(w http.ResponseWriter, statusCode int, code string, description string) {
+	writeJSON(w, statusCode, map[string]string{"error": code, "error_description": description})
+}
+
+// authenticates a micropub request with an api token, sent either as a bearer token
+// or in the access_token field of a form
+func (s *Server) micropubUser(w http.ResponseWriter, r *http.Request) (*db.User, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		token = r.FormValue("access_token")
+	}
+	if token == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		micropubError(w, http.StatusUnauthorized, "unauthorized", "an access token is required")
+		return nil, false
+	}
+	user, err := s.userFromAPIToken(token)
+	if err != nil {
+		if err == errInvalidAPIToken {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			micropubError(w, http.StatusUnauthorized, "unauthorized", "the access token is invalid")
+		} else {
+			micropubError(w, http.StatusInternalServerError, "server_error", "the access token couldn't be checked")
+		}
+		return nil, false
+	}
+	return user, true
+}
+
+// h-entry properties, each value is a string or, in json requests, an object such
+// as {"html": "..."} for content or {"value": "...", "alt": "..."} for photos
+type mf2Properties map[string][]interface{}
+
+// the first value of a property as text, objects give their value
+func (p mf2Properties) text(name string) (string, bool) {
+	if len(p[name]) == 0 {
+		return "", false
+	}
+	switch v := p[name][0].(type) {
+	case string:
+		return v, true
+	case map[string]interface{}:
+		value, ok := v["value"].(string)
+		return value, ok
+	}
+	return "", false
+}
+
+// every value of a property that is text, objects give their value
+func (p mf2Properties) texts(name string) []string {
+	var result []string
+	for i := range p[name] {
+		if value, ok := (mf2Properties{name: p[name][i:]}).text(name); ok {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+type micropubRequest struct {
+	Type       []string        `json:"type"`
+	Properties mf2Properties   `json:"properties"`
+	Action     string          `json:"action"`
+	URL        string          `json:"url"`
+	Replace    mf2Properties   `json:"replace"`
+	Add        mf2Properties   `json:"add"`
+	Delete     json.RawMessage `json:"delete"`
+}
+
+// reads a json, form-encoded or multipart micropub request, files in a multipart
+// request are left for saveMicropubMedia
+func parseMicropubRequest(w http.ResponseWriter, r *http.Request) (*micropubRequest, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		var req micropubRequest
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiMaxBodySize))
+		if err := decoder.Decode(&req); err != nil {
+			return nil, errors.New("invalid json: " + err.Error())
+		}
+		return &req, nil
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, micropubMaxFormSize)
+	var err error
+	if mediaType == "multipart/form-data" {
+		err = r.ParseMultipartForm(micropubMaxFormSize)
+	} else {
+		err = r.ParseForm()
+	}
+	if err != nil {
+		return nil, errors.New("invalid form: " + err.Error())
+	}
+	req := micropubRequest{
+		Action:     r.PostForm.Get("action"),
+		URL:        r.PostForm.Get("url"),
+		Properties: mf2Properties{},
+	}
+	if h := r.PostForm.Get("h"); h != "" {
+		req.Type = []string{"h-" + h}
+	}
+	for key, values := range r.PostForm {
+		name := strings.TrimSuffix(key, "[]")
+		if name == "h" || name == "action" || name == "url" || name == "access_token" {
+			continue
+		}
+		for _, value := range values {
+			req.Properties[name] = append(req.Properties[name], value)
+		}
+	}
+	return &req, nil
+}
+
+// saves the photos, videos and audio sent as files in a multipart micropub request
+// like media endpoint uploads and adds their urls to the properties. only called
+// once the request is authenticated, so anonymous requests can't fill MEDIA_DIR
+func saveMicropubMedia(r *http.Request, req *micropubRequest) error {
+	if r.MultipartForm == nil {
+		return nil
+	}
+	for key, files := range r.MultipartForm.File {
+		name := strings.TrimSuffix(key, "[]")
+		if name != "photo" && name != "video" && name != "audio" {
+			continue
+		}
+		for _, header := range files {
+			file, err := header.Open()
+			if err != nil {
+				return err
+			}
+			location, err := saveMedia(file)
+			file.Close()
+			if err != nil {
+				return err
+			}
+			req.Properties[name] = append(req.Properties[name], location)
+		}
+	}
+	return nil
+}
+
+var slugInvalidChars = regexp.MustCompile("[^a-z0-9]+")
+
+// cleans up a slug from a client or a title, so it matches utils.IsValidSlug
+func micropubSlug(s string) string {
+	return strings.Trim(slugInvalidChars.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
+
+// tags can't contain whitespace, so multi-word categories are hyphenated
+func micropubTags(categories []string) []string {
+	var tags []string
+	for _, category := range categories {
+		tag := strings.Join(strings.Fields(category), "-")
+		if tag != "" && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// notes don't have a name, so their title is the start of their text
+func noteTitle(text string) string {
+	words := strings.Fields(text)
+	title := ""
+	for _, word := range words {
+		if utf8.RuneCountInString(title)+utf8.RuneCountInString(word) >= micropubTitleLength {
+			if title == "" {
+				return string([]rune(word)[:micropubTitleLength]) + "…"
+			}
+			return title + "…"
+		}
+		title = strings.TrimSpace(title + " " + word)
+	}
+	return title
+}
+
+// applies the h-entry properties that are set to the post, tags are left to the caller,
+// errors are meant for the client
+func (s *Server) applyProperties(post *db.Post, props mf2Properties) error {
+	var text string
+	if len(props["content"]) > 0 {
+		switch v := props["content"][0].(type) {
+		case string:
+			content, err := markdown.ParseMD(v)
+			if err != nil {
+				return errors.New("invalid content: " + err.Error())
+			}
+			post.Content = template.HTML(content.HTML)
+			text = v
+		case map[string]interface{}:
+			if value, ok := v["html"].(string); ok {
+				post.Content = template.HTML(value)
+			} else if value, ok := v["value"].(string); ok {
+				post.Content = template.HTML("<p>" + html.EscapeString(value) + "</p>")
+				text = value
+			} else {
+				return errors.New("content must be a string or have an html or value")
+			}
+		default:
+			return errors.New("content must be a string or an object")
+		}
+	}
+	if name, ok := props.text("name"); ok {
+		post.Title = strings.TrimSpace(name)
+	}
+	if post.Title == "" {
+		post.Title = noteTitle(text)
+	}
+	if post.Title == "" {
+		post.Title = "Note from " + s.now().Format("January 2, 2006")
+	}
+	if summary, ok := props.text("summary"); ok {
+		post.Description = summary
+	}
+	// the first photo is the cover, any others are added to the end of the content
+	for i := range props["photo"] {
+		photo, _ := (mf2Properties{"photo": props["photo"][i:]}).text("photo")
+		if photo == "" {
+			continue
+		}
+		if i == 0 {
+			post.CoverImage = photo
+			continue
+		}
+		alt := ""
+		if v, ok := props["photo"][i].(map[string]interface{}); ok {
+			alt, _ = v["alt"].(string)
+		}
+		post.Content += template.HTML(fmt.Sprintf(`<p><img src="%s" alt="%s"></p>`,
+			html.EscapeString(photo), html.EscapeString(alt)))
+	}
+	if slug, ok := props.text("mp-slug"); ok {
+		post.Slug = micropubSlug(slug)
+	}
+	if status, ok := props.text("post-status"); ok {
+		switch status {
+		case "draft":
+			post.Status = db.Draft
+		case "published":
+			post.Status = db.Published
+		default:
+			return fmt.Errorf("post-status %q must be draft or published", status)
+		}
+	}
+	// a published date in the future schedules the post
+	if published, ok := props.text("published"); ok {
+		t, err := time.Parse(time.RFC3339, published)
+		if err != nil {
+			return fmt.Errorf("published %q must be an RFC 3339 date", published)
+		}
+		post.Published = t.Format("Monday, January 2, 2006")
+		if post.Status == db.Published && t.After(s.now()) {
+			post.Status = db.Scheduled
+			post.PublishAt = sql.NullTime{Time: t.UTC(), Valid: true}
+		}
+	}
+	if post.Status != db.Scheduled {
+		post.PublishAt.Valid = false
+	}
+	return nil
+}
+
+// finds a slug for the post that no other post has, adding -2, -3, ... if needed.
+// another post can still take it before this one is saved, the store then
+// returns db.ErrSlugTaken
+func (s *Server) uniqueSlug(slug string, postID int) (string, error) {
+	candidate := slug
+	for i := 2; ; i++ {
+		post, err := s.store.GetPostBySlug(candidate, db.WithAnyStatus())
+		if err == db.ErrNotFound {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if post.Id == postID {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s-%d", slug, i)
+	}
+}
+
+func postURL(post *db.Post) string {
+	return config.SiteURL + "/blog/" + post.Slug
+}
+
+// the slug of the post a url points at, the inverse of postURL
+func postSlug(u *url.URL) (string, bool) {
+	slug, ok := strings.CutPrefix(strings.TrimSuffix(u.Path, "/"), "/blog/")
+	return slug, ok && utils.IsValidSlug(slug)
+}
+
+// looks up the post a micropub url points at, writing an error if there's no such post
+// or the user can't edit it
+func (s *Server) micropubPost(w http.ResponseWriter, user *db.User, rawURL string) (*db.Post, bool) {
+	u, err := url.Parse(rawURL)
+	slug, ok := "", false
+	if err == nil {
+		slug, ok = postSlug(u)
+	}
+	if !ok {
+		micropubError(w, http.StatusBadRequest, "invalid_request", "url must be the url of a post")
+		return nil, false
+	}
+	post, err := s.store.GetPostBySlug(slug, db.WithAnyStatus())
+	if err != nil {
+		if err == db.ErrNotFound {
+			micropubError(w, http.StatusBadRequest, "invalid_request", "there's no post at "+rawURL)
+		} else {
+			micropubError(w, http.StatusInternalServerError, "server_error", "the post couldn't be loaded")
+		}
+		return nil, false
+	}
+	if !user.CanEditPost(post) {
+		micropubError(w, http.StatusForbidden, "forbidden", "you can't edit this post")
+		return nil, false
+	}
+	return post, true
+}
+
+// answers micropub queries, q=config tells clients where to upload media and
+// q=source returns a post's properties so it can be edited
+func (s *Server) GetMicropub(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.micropubUser(w, r)
+	if !ok {
+		return
+	}
+	switch r.URL.Query().Get("q") {
+	case "config":
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"media-endpoint": config.SiteURL + "/micropub/media",
+			"syndicate-to":   []string{},
+		})
+	case "syndicate-to":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"syndicate-to": []string{}})
+	case "source":
+		post, ok := s.micropubPost(w, user, r.URL.Query().Get("url"))
+		if !ok {
+			return
+		}
+		tags, err := s.store.GetTags(post.Id)
+		if err != nil {
+			micropubError(w, http.StatusInternalServerError, "server_error", "the post's tags couldn't be loaded")
+			return
+		}
+		status := "published"
+		if post.Status == db.Draft {
+			status = "draft"
+		}
+		published := post.CreatedAt
+		if post.PublishAt.Valid {
+			published = post.PublishAt.Time
+		}
+		props := mf2Properties{
+			"name":        {post.Title},
+			"category":    utils.Map(tags, func(tag *db.Tag) interface{} { return tag.Name }),
+			"post-status": {status},
+			"published":   {published.Format(time.RFC3339)},
+			"url":         {postURL(post)},
+			"mp-slug":     {post.Slug},
+		}
+		if post.Content != "" {
+			props["content"] = []interface{}{map[string]interface{}{"html": string(post.Content)}}
+		}
+		if post.Description != "" {
+			props["summary"] = []interface{}{post.Description}
+		}
+		if post.CoverImage != "" {
+			props["photo"] = []interface{}{post.CoverImage}
+		}
+		if wanted := r.URL.Query()["properties[]"]; len(wanted) > 0 {
+			for name := range props {
+				if !slices.Contains(wanted, name) {
+					delete(props, name)
+				}
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{"properties": props})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"type": []string{"h-entry"}, "properties": props})
+	default:
+		micropubError(w, http.StatusBadRequest, "invalid_request", "q must be config, source or syndicate-to")
+	}
+}
+
+func (s *Server) HandleMicropub(w http.ResponseWriter, r *http.Request) {
+	req, err := parseMicropubRequest(w, r)
+	if err != nil {
+		micropubError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	user, ok := s.micropubUser(w, r)
+	if !ok {
+		return
+	}
+	if err := saveMicropubMedia(r, req); err != nil {
+		if err == errUnsupportedMedia {
+			micropubError(w, http.StatusBadRequest, "invalid_request", "only jpeg, png, gif and webp images, mp4 video and mp3 audio are accepted")
+		} else {
+			micropubError(w, http.StatusInternalServerError, "server_error", "the upload couldn't be saved")
+		}
+		return
+	}
+	switch req.Action {
+	case "":
+		s.micropubCreate(w, user, req)
+	case "update":
+		s.micropubUpdate(w, user, req)
+	case "delete":
+		post, ok := s.micropubPost(w, user, req.URL)
+		if !ok {
+			return
+		}
+		if err := s.store.DeletePost(post.Id); err != nil {
+			micropubError(w, http.StatusInternalServerError, "server_error", "the post couldn't be deleted")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		micropubError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("action %q isn't supported", req.Action))
+	}
+}
+
+func (s *Server) micropubCreate(w http.ResponseWriter, user *db.User, req *micropubRequest) {
+	if len(req.Type) > 0 && req.Type[0] != "h-entry" {
+		micropubError(w, http.StatusBadRequest, "invalid_request", "only h-entry is supported")
+		return
+	}
+	post := db.Post{
+		UserId:    user.Id,
+		Status:    db.Published,
+		Published: s.now().Format("Monday, January 2, 2006"),
+	}
+	if err := s.applyProperties(&post, req.Properties); err != nil {
+		micropubError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if post.Slug == "" {
+		post.Slug = micropubSlug(post.Title)
+	}
+	if post.Slug == "" {
+		post.Slug = fmt.Sprintf("note-%d", s.now().Unix())
+	}
+	slug, err := s.uniqueSlug(post.Slug, 0)
+	if err != nil {
+		micropubError(w, http.StatusInternalServerError, "server_error", "the post couldn't be created")
+		return
+	}
+	post.Slug = slug
+	s.sanitizePost(&post)
+	postID, err := s.store.CreatePost(&post)
+	if err == db.ErrSlugTaken {
+		micropubError(w, http.StatusConflict, "invalid_request", "the slug was taken while the post was saved, try again")
+		return
+	}
+	if err != nil {
+		micropubError(w, http.StatusInternalServerError, "server_error", "the post couldn't be created")
+		return
+	}
+	if tags := micropubTags(req.Properties.texts("category")); len(tags) > 0 {
+		err = s.store.CreateTags(postID, tags)
+		if err != nil {
+			micropubError(w, http.StatusInternalServerError, "server_error", "the post's tags couldn't be saved")
+			return
+		}
+	}
+	s.sendWebmentions(&post, "")
+	w.Header().Set("Location", postURL(&post))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// updates are json only, replace sets properties, add appends categories and delete
+// takes either property names or the categories to remove
+func (s *Server) micropubUpdate(w http.ResponseWriter, user *db.User, req *micropubRequest) {
+	// form-encoded requests can't carry any of them, so they'd otherwise succeed
+	// without changing anything
+	if req.Replace == nil && req.Add == nil && len(req.Delete) == 0 {
+		micropubError(w, http.StatusBadRequest, "invalid_request", "updates must be json with replace, add or delete")
+		return
+	}
+	post, ok := s.micropubPost(w, user, req.URL)
+	if !ok {
+		return
+	}
+	oldSlug, previous := post.Slug, post.Content
+	current, err := s.store.GetTags(post.Id)
+	if err != nil {
+		micropubError(w, http.StatusInternalServerError, "server_error", "the post's tags couldn't be loaded")
+		return
+	}
+	tags := utils.Map(current, func(tag *db.Tag) string { return tag.Name })
+
+	if req.Replace != nil {
+		if err := s.applyProperties(post, req.Replace); err != nil {
+			micropubError(w, http.StatusBadRequest, "invalid_request", err.Error())
+			return
+		}
+		if _, ok := req.Replace["category"]; ok {
+			tags = micropubTags(req.Replace.texts("category"))
+		}
+	}
+	if req.Add != nil {
+		for name := range req.Add {
+			if name != "category" {
+				micropubError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("%s can't be added to, use replace", name))
+				return
+			}
+		}
+		tags = micropubTags(append(tags, req.Add.texts("category")...))
+	}
+	if len(req.Delete) > 0 {
+		var names []string
+		var values mf2Properties
+		if json.Unmarshal(req.Delete, &names) == nil {
+			for _, name := range names {
+				switch name {
+				case "category":
+					tags = nil
+				case "summary":
+					post.Description = ""
+				case "photo":
+					post.CoverImage = ""
+				default:
+					micropubError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("%s can't be deleted", name))
+					return
+				}
+			}
+		} else if json.Unmarshal(req.Delete, &values) == nil {
+			removed := micropubTags(values.texts("category"))
+			tags = slices.DeleteFunc(tags, func(tag string) bool { return slices.Contains(removed, tag) })
+		} else {
+			micropubError(w, http.StatusBadRequest, "invalid_request", "delete must be a list of properties or an object of values")
+			return
+		}
+	}
+
+	if post.Slug != oldSlug {
+		if !utils.IsValidSlug(post.Slug) {
+			micropubError(w, http.StatusBadRequest, "invalid_request", "mp-slug must contain a letter or number")
+			return
+		}
+		post.Slug, err = s.uniqueSlug(post.Slug, post.Id)
+		if err != nil {
+			micropubError(w, http.StatusInternalServerError, "server_error", "the post couldn't be updated")
+			return
+		}
+	}
+	post.UpdatedAt = s.now()
+	s.sanitizePost(post)
+	err = s.store.EditPost(post.Id, post)
+	if err == db.ErrSlugTaken {
+		micropubError(w, http.StatusConflict, "invalid_request", "the slug was taken while the post was saved, try again")
+		return
+	}
+	if err != nil {
+		micropubError(w, http.StatusInternalServerError, "server_error", "the post couldn't be updated")
+		return
+	}
+	if err := s.store.SetTags(post.Id, tags); err != nil {
+		micropubError(w, http.StatusInternalServerError, "server_error", "the post's tags couldn't be saved")
+		return
+	}
+	s.sendWebmentions(post, previous)
+	// the url changes with the slug, so clients are told where the post is now
+	if post.Slug != oldSlug {
+		w.Header().Set("Location", postURL(post))
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
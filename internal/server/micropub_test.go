@@ -0,0 +1,136 @@
+package server
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"personal-site/internal/config"
+	"personal-site/internal/db"
+	"strings"
+	"testing"
+)
+
+// enough of a png for the content type to be sniffed
+var testPNG = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+// a multipart request with the fields and a file in fileField
+func multipartRequest(t *testing.T, path string, fields map[string]string, fileField string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for name, value := range fields {
+		if err := mw.WriteField(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fw, err := mw.CreateFormFile(fileField, "photo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(testPNG)
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, path, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestUploadsRequireAToken(t *testing.T) {
+	s, store := newTestServer(t)
+	token, err := newAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateAPIToken(&db.APIToken{UserId: 1, Name: "test", TokenHash: hashAPIToken(token)}); err != nil {
+		t.Fatal(err)
+	}
+	handler := s.Handler()
+	mediaDir := config.MediaDir
+	t.Cleanup(func() { config.MediaDir = mediaDir })
+
+	tests := []struct {
+		name      string
+		path      string
+		fields    map[string]string
+		fileField string
+		header    string
+		status    int
+		saved     int
+	}{
+		{"micropub without a token", "/micropub", map[string]string{"h": "entry", "content": "hi"}, "photo", "", http.StatusUnauthorized, 0},
+		{"micropub with an invalid token", "/micropub", map[string]string{"h": "entry", "content": "hi", "access_token": "nope"}, "photo", "", http.StatusUnauthorized, 0},
+		{"micropub with a token field", "/micropub", map[string]string{"h": "entry", "content": "hi", "access_token": token}, "photo", "", http.StatusCreated, 1},
+		{"micropub with a bearer token", "/micropub", map[string]string{"h": "entry", "content": "hi"}, "photo", "Bearer " + token, http.StatusCreated, 1},
+		{"media without a token", "/micropub/media", nil, "file", "", http.StatusUnauthorized, 0},
+		{"media with a token", "/micropub/media", nil, "file", "Bearer " + token, http.StatusCreated, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.MediaDir = t.TempDir()
+			req := multipartRequest(t, tt.path, tt.fields, tt.fileField)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("POST %s = %d, want %d: %s", tt.path, rec.Code, tt.status, rec.Body)
+			}
+			entries, err := os.ReadDir(config.MediaDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != tt.saved {
+				t.Errorf("%d files in MEDIA_DIR, want %d", len(entries), tt.saved)
+			}
+		})
+	}
+}
+
+func TestMicropubUpdate(t *testing.T) {
+	s, store := newTestServer(t)
+	token, err := newAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateAPIToken(&db.APIToken{UserId: 1, Name: "test", TokenHash: hashAPIToken(token)}); err != nil {
+		t.Fatal(err)
+	}
+	handler := s.Handler()
+	postURL := config.SiteURL + "/blog/hello-world"
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+		title       string
+	}{
+		{"form-encoded", "application/x-www-form-urlencoded", url.Values{"action": {"update"}, "url": {postURL}, "replace[name]": {"Form Title"}}.Encode(), http.StatusBadRequest, "Hello World"},
+		{"json without changes", "application/json", `{"action":"update","url":"` + postURL + `"}`, http.StatusBadRequest, "Hello World"},
+		{"json replace", "application/json", `{"action":"update","url":"` + postURL + `","replace":{"name":["Json Title"]}}`, http.StatusNoContent, "Json Title"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/micropub", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("update = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			post, err := store.GetPostBySlug("hello-world")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if post.Title != tt.title {
+				t.Errorf("title after the update = %q, want %q", post.Title, tt.title)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"personal-site/internal/config"
+	"personal-site/internal/db"
+	"strings"
+)
+
+// tells users about things that happen while they're away, like new comments
+type notifier interface {
+	Notify(user *db.User, subject string, body string) error
+}
+
+func newNotifier() notifier {
+	if config.SMTPAddr == "" {
+		return logNotifier{}
+	}
+	var auth smtp.Auth
+	if config.SMTPUser != "" {
+		host, _, _ := net.SplitHostPort(config.SMTPAddr)
+		auth = smtp.PlainAuth("", config.SMTPUser, config.SMTPPass, host)
+	}
+	return &smtpNotifier{addr: config.SMTPAddr, from: config.SMTPFrom, auth: auth}
+}
+
+// used when there's no smtp server to send through
+type logNotifier struct{}
+
+func (logNotifier) Notify(user *db.User, subject string, body string) error {
+	log.Printf("notification for %s: %s", user.Username, subject)
+	return nil
+}
+
+type smtpNotifier struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// header values can't contain line breaks, or they'd start headers of their own
+var headerReplacer = strings.NewReplacer("\r", " ", "\n", " ")
+
+// emails the user, users without an email address are skipped
+func (n *smtpNotifier) Notify(user *db.User, subject string, body string) error {
+	if user.Email == "" {
+		return nil
+	}
+	msg := "From: " + headerReplacer.Replace(n.from) + "\r\n" +
+		"To: " + headerReplacer.Replace(user.Email) + "\r\n" +
+		"Subject: " + mime.QEncoding.Encode("utf-8", headerReplacer.Replace(subject)) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n\r\n" +
+		strings.ReplaceAll(body, "\n", "\r\n")
+	return smtp.SendMail(n.addr, n.auth, n.from, []string{user.Email}, []byte(msg))
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"personal-site/internal/db"
+)
+
+// strips everything the post policy doesn't allow from a post's content, posts are
+// rendered unescaped so this runs before every save
+func (s *Server) sanitizePost(post *db.Post) {
+	post.Content = s.sanitizePostContent(string(post.Content))
+}
+
+func (s *Server) sanitizePostContent(content string) template.HTML {
+	return template.HTML(s.postPolicy.Sanitize(content))
+}
+
+// brings content saved before it was sanitized, or under a looser HTML_ALLOW, in line
+// with the policy posts are saved with now
+func (s *Server) sanitizeStoredContent() {
+	count, err := s.store.SanitizeContent(s.postPolicy.Sanitize)
+	if err != nil {
+		log.Printf("failed to sanitize stored posts: %v", err)
+		return
+	}
+	if count > 0 {
+		log.Printf("sanitized %d stored post(s) and revision(s)", count)
+	}
+}
+
+// renders the content from the editor the way it'll be published, for the live preview
+func (s *Server) HandlePreview(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		handleError(w, http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(s.sanitizePostContent(r.PostForm.Get("post-content"))))
+}
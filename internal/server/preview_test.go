@@ -0,0 +1,29 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestPreviewIsSanitized(t *testing.T) {
+	s, _ := newTestServer(t)
+	c := newTestClient(t, s.Handler())
+	content := `<h2 id="setup">Setup</h2><p onclick="alert(1)">hi <a href="javascript:alert(1)">there</a></p>` +
+		`<script>alert(1)</script><form class="comment-form"><p id="comments" class="callout comment-error">x</p></form>`
+	form := url.Values{"post-content": {content}}
+
+	if rec := c.do(http.MethodPost, "/preview", form); rec.Code != http.StatusUnauthorized {
+		t.Errorf("POST /preview without a session = %d, want 401", rec.Code)
+	}
+
+	c.login("admin", "pass")
+	rec := c.do(http.MethodPost, "/preview", form)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /preview = %d", rec.Code)
+	}
+	want := `<h2 id="setup">Setup</h2><p>hi <a>there</a></p><p class="callout">x</p>`
+	if got := rec.Body.String(); got != want {
+		t.Errorf("POST /preview\n got %q\nwant %q", got, want)
+	}
+}
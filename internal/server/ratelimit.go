@@ -0,0 +1,200 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"personal-site/internal/db"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// failed logins are throttled per username and per ip. the first few failures
+// are free, after that each one doubles the wait before the next attempt until
+// the key is locked out. usernames are limited more tightly than ips, since
+// several people can share an ip
+type loginLimit struct {
+	free    int
+	lockout int
+}
+
+var (
+	usernameLimit = loginLimit{free: 3, lockout: 10}
+	ipLimit       = loginLimit{free: 10, lockout: 30}
+	// comments are throttled per ip the same way, counting every comment sent
+	// rather than failures, so a form can't be replayed to flood the moderators
+	commentLimit = loginLimit{free: 3, lockout: 10}
+)
+
+const (
+	// failures older than this are forgotten
+	loginWindow    = time.Hour
+	loginBaseDelay = time.Second
+	loginLockout   = 15 * time.Minute
+	// how long failed attempts are kept for auditing
+	loginAuditRetention = 90 * 24 * time.Hour
+	// comments older than this don't count toward the throttle
+	commentWindow = time.Hour
+)
+
+// how long a key with this many failures has to wait after its last one
+func (l loginLimit) delay(failures int) time.Duration {
+	if failures < l.free {
+		return 0
+	}
+	if failures >= l.lockout {
+		return loginLockout
+	}
+	return min(loginBaseDelay<<(failures-l.free), loginLockout)
+}
+
+// a run of failures for a username or ip
+type loginFailures struct {
+	count int
+	last  time.Time
+}
+
+func (f *loginFailures) add(at time.Time) {
+	f.count++
+	f.last = at
+}
+
+func (f loginFailures) retryAfter(l loginLimit, now time.Time) time.Duration {
+	return max(f.last.Add(l.delay(f.count)).Sub(now), 0)
+}
+
+// returns how long the username and ip have to wait before trying to log in
+// again, zero if they can try now
+func (s *Server) loginRetryAfter(username string, ip string) (time.Duration, error) {
+	now := s.now()
+	attempts, err := s.store.GetLoginAttempts(username, ip, now.Add(-loginWindow))
+	if err != nil {
+		return 0, err
+	}
+	var byUsername, byIP loginFailures
+	for _, attempt := range attempts {
+		// logging in clears the username's failures but not the ip's, otherwise
+		// logging into an account of your own between guesses would reset the ip
+		if attempt.Username == username {
+			if attempt.Succeeded {
+				byUsername = loginFailures{}
+			} else {
+				byUsername.add(attempt.CreatedAt)
+			}
+		}
+		if attempt.IP == ip && !attempt.Succeeded {
+			byIP.add(attempt.CreatedAt)
+		}
+	}
+	return max(byUsername.retryAfter(usernameLimit, now), byIP.retryAfter(ipLimit, now)), nil
+}
+
+// usernames and ips with a login or comment being checked. the throttle only sees attempts
+// once they're recorded, so parallel attempts on the same username or ip would all
+// pass it before the first failure was, they're turned away instead
+type inFlightLogins struct {
+	mu   sync.Mutex
+	keys map[string]bool
+}
+
+func newInFlightLogins() *inFlightLogins {
+	return &inFlightLogins{keys: make(map[string]bool)}
+}
+
+// claims the keys, reports false if any of them is already claimed
+func (l *inFlightLogins) acquire(keys ...string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		if l.keys[key] {
+			return false
+		}
+	}
+	for _, key := range keys {
+		l.keys[key] = true
+	}
+	return true
+}
+
+func (l *inFlightLogins) release(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		delete(l.keys, key)
+	}
+}
+
+// checks the login throttle, writing a 429 and returning false if the request has
+// to wait. otherwise no other login for the username or ip runs until release is
+// called, which has to be after the attempt is recorded
+func (s *Server) allowLogin(w http.ResponseWriter, username string, ip string) (release func(), ok bool) {
+	keys := []string{"username:" + username, "ip:" + ip}
+	if !s.logins.acquire(keys...) {
+		w.Header().Set("Retry-After", "1")
+		handleError(w, http.StatusTooManyRequests)
+		return nil, false
+	}
+	release = func() { s.logins.release(keys...) }
+	retryAfter, err := s.loginRetryAfter(username, ip)
+	if err != nil {
+		release()
+		handleError(w, http.StatusInternalServerError)
+		return nil, false
+	}
+	if retryAfter > 0 {
+		release()
+		w.Header().Set("Retry-After", retryAfterSeconds(retryAfter))
+		handleError(w, http.StatusTooManyRequests)
+		return nil, false
+	}
+	return release, true
+}
+
+// rounded up so clients don't retry a moment too early
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(int((d + time.Second - 1) / time.Second))
+}
+
+// checks the comment throttle for the ip like allowLogin, writing a 429 with a
+// message for the comment form. release has to be called after the comment is saved
+func (s *Server) allowComment(w http.ResponseWriter, ip string) (release func(), ok bool) {
+	key := "comment:" + ip
+	if !s.logins.acquire(key) {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "You're commenting too quickly, try again in a moment.", http.StatusTooManyRequests)
+		return nil, false
+	}
+	release = func() { s.logins.release(key) }
+	now := s.now()
+	comments, err := s.store.GetCommentsByIP(ip, now.Add(-commentWindow))
+	if err != nil {
+		release()
+		handleError(w, http.StatusInternalServerError)
+		return nil, false
+	}
+	var sent loginFailures
+	for _, comment := range comments {
+		sent.add(comment.CreatedAt)
+	}
+	if retryAfter := sent.retryAfter(commentLimit, now); retryAfter > 0 {
+		release()
+		seconds := retryAfterSeconds(retryAfter)
+		w.Header().Set("Retry-After", seconds)
+		http.Error(w, "You're commenting too quickly, try again in "+seconds+" seconds.", http.StatusTooManyRequests)
+		return nil, false
+	}
+	return release, true
+}
+
+// a failure to record an attempt is logged rather than failing the login
+func (s *Server) recordLoginAttempt(username string, ip string, succeeded bool) {
+	err := s.store.RecordLoginAttempt(&db.LoginAttempt{
+		Username:  username,
+		IP:        ip,
+		Succeeded: succeeded,
+		CreatedAt: s.now(),
+	})
+	if err != nil {
+		log.Printf("failed to record login attempt for %q from %s: %v", username, ip, err)
+	}
+}
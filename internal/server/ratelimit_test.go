@@ -0,0 +1,94 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoginThrottle(t *testing.T) {
+	s, _ := newTestServer(t)
+	now := time.Unix(1700000000, 0)
+	s.now = func() time.Time { return now }
+	c := newTestClient(t, s.Handler())
+
+	for i := 0; i < usernameLimit.free; i++ {
+		if rec := c.login("admin", "wrong"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("failure %d = %d, want 401", i+1, rec.Code)
+		}
+	}
+	rec := c.login("admin", "pass")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("login after %d failures = %d, Retry-After %q, want 429 and 1", usernameLimit.free, rec.Code, rec.Header().Get("Retry-After"))
+	}
+	now = now.Add(time.Second)
+	if rec := c.login("admin", "pass"); rec.Code != http.StatusOK {
+		t.Errorf("login once the delay passed = %d, want 200", rec.Code)
+	}
+}
+
+// a successful login clears the failures on the username, but not on the ip
+func TestLoginSuccessKeepsIPFailures(t *testing.T) {
+	s, _ := newTestServer(t)
+	now := time.Unix(1700000000, 0)
+	s.now = func() time.Time { return now }
+	c := newTestClient(t, s.Handler())
+
+	// different usernames each time, so only the ip's limit applies
+	for i := 0; i < ipLimit.free-1; i++ {
+		if rec := c.login(fmt.Sprintf("guess%d", i), "wrong"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("failure %d = %d, want 401", i+1, rec.Code)
+		}
+	}
+	if rec := c.login("admin", "pass"); rec.Code != http.StatusOK {
+		t.Fatalf("login = %d, want 200", rec.Code)
+	}
+	if rec := c.login("admin", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("failure %d = %d, want 401", ipLimit.free, rec.Code)
+	}
+	if rec := c.login("another", "wrong"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("login from the ip after %d failures = %d, want 429", ipLimit.free, rec.Code)
+	}
+}
+
+// parallel guesses can't all get past the throttle before the first one is recorded
+func TestParallelLoginsAreThrottled(t *testing.T) {
+	s, store := newTestServer(t)
+	handler := s.Handler()
+
+	const guesses = 20
+	var wg sync.WaitGroup
+	codes := make(chan int, guesses)
+	for i := 0; i < guesses; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- newTestClient(t, handler).login("admin", "wrong").Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	checked := 0
+	for code := range codes {
+		switch code {
+		case http.StatusUnauthorized:
+			checked++
+		case http.StatusTooManyRequests:
+		default:
+			t.Errorf("parallel login = %d", code)
+		}
+	}
+	if checked > usernameLimit.free {
+		t.Errorf("%d parallel guesses were checked, the throttle allows %d", checked, usernameLimit.free)
+	}
+	attempts, err := store.GetLoginAttempts("admin", "", time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != checked {
+		t.Errorf("%d attempts were recorded, want %d", len(attempts), checked)
+	}
+}
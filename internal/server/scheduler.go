@@ -0,0 +1,43 @@
+package server
+
+import (
+	"log"
+	"personal-site/internal/db"
+	"time"
+)
+
+// periodically promotes scheduled posts once their publish time has passed, sending
+// their webmentions, and cleans up expired sessions and old login attempts
+func (s *Server) startScheduler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		for now := range ticker.C {
+			if _, err := s.store.DeleteExpiredSessions(now); err != nil {
+				log.Printf("failed to delete expired sessions: %v", err)
+			}
+			if _, err := s.store.DeleteLoginAttempts(now.Add(-loginAuditRetention)); err != nil {
+				log.Printf("failed to delete old login attempts: %v", err)
+			}
+			// looked up first so the posts that go live can send webmentions
+			scheduled, err := s.store.GetAllPosts(db.WithStatus(db.Scheduled), db.WithContent())
+			if err != nil {
+				log.Printf("failed to load scheduled posts: %v", err)
+				continue
+			}
+			count, err := s.store.PublishScheduledPosts(now)
+			if err != nil {
+				log.Printf("failed to publish scheduled posts: %v", err)
+				continue
+			}
+			if count > 0 {
+				log.Printf("published %d scheduled post(s)", count)
+			}
+			for _, post := range scheduled {
+				if post.PublishAt.Valid && !post.PublishAt.Time.After(now) {
+					post.Status = db.Published
+					s.sendWebmentions(post, "")
+				}
+			}
+		}
+	}()
+}
@@ -2,9 +2,13 @@ package server
 
 import (
 	"fmt"
+	"log"
 	"net/http"
 	"personal-site/internal/config"
+	"personal-site/internal/db"
+	"personal-site/pkg/utils/sanitize"
 	"strings"
+	"time"
 
 	"github.com/aarol/reload"
 	"github.com/go-chi/chi/v5"
@@ -12,7 +16,40 @@ import (
 	"github.com/go-chi/jwtauth/v5"
 )
 
-func Start() {
+type Server struct {
+	store db.Store
+	// the clock totp codes are checked against, replaceable so tests can fix it
+	now func() time.Time
+	// fetches other sites for webmentions, replaceable so tests can use local servers
+	client *http.Client
+	// ids of received webmentions waiting to be verified
+	webmentions chan int
+	// replaceable so tests can capture notifications
+	notifier notifier
+	// what post content may contain, the defaults plus HTML_ALLOW
+	postPolicy *sanitize.Policy
+	// logins being checked, so attempts on the same username or ip don't overlap
+	logins *inFlightLogins
+}
+
+func New(store db.Store) *Server {
+	postPolicy, err := sanitize.Posts.Extend(config.HTMLAllow)
+	if err != nil {
+		log.Fatalf("invalid HTML_ALLOW: %v", err)
+	}
+	return &Server{
+		store:       store,
+		now:         time.Now,
+		client:      newWebClient(),
+		webmentions: make(chan int, webmentionQueueSize),
+		notifier:    newNotifier(),
+		postPolicy:  postPolicy,
+		logins:      newInFlightLogins(),
+	}
+}
+
+// Handler routes every page and endpoint of the site
+func (s *Server) Handler() http.Handler {
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)    // log start and end of each request
 	r.Use(middleware.RequestID) // add unique id to each request context
@@ -44,35 +81,110 @@ func Start() {
 	// protected routes
 	r.Group(func(r chi.Router) {
 		r.Use(jwtauth.Verifier(config.TokenAuth))
-		r.Use(CustomAuthenticator(config.TokenAuth))
+		r.Use(s.CustomAuthenticator(config.TokenAuth))
+		r.Use(CSRFProtect)
 
 		// TODO: rework API to use the same name, differentiate through HTTP verb
-		r.Get("/admin", GetAdminPage)
-		r.Get("/post", GetNewPost)
-		r.Post("/post", HandleCreatePost)
-		r.Delete("/post/{postID}", HandleDeletePost)
-		r.Patch("/post/{postID}", HandleEditPost)
+		r.Get("/admin", s.GetAdminPage)
+		r.Get("/post", s.GetNewPost)
+		r.Post("/post", s.HandleCreatePost)
+		r.With(s.PostCtx, s.RequirePostAccess).Delete("/post/{postID}", s.HandleDeletePost)
+		r.With(s.PostCtx, s.RequirePostAccess).Patch("/post/{postID}", s.HandleEditPost)
+		r.With(s.PostCtx, s.RequirePostAccess).Get("/admin/posts/{postID}/revisions", s.GetRevisionsPage)
+		r.With(s.PostCtx, s.RequirePostAccess).Post("/admin/posts/{postID}/revisions/{revisionID}/restore", s.HandleRestoreRevision)
+
+		r.Post("/markdown", s.HandleUploadMarkdown)
+		r.Post("/preview", s.HandlePreview)
+
+		r.Get("/admin/sessions", s.GetSessionsPage)
+		r.Delete("/admin/sessions/{sessionID}", s.HandleRevokeSession)
+		r.Post("/logout", s.HandleLogout)
+		r.Get("/admin/2fa", s.GetTwoFactorPage)
+		r.Post("/admin/2fa", s.HandleEnableTwoFactor)
+		r.Post("/admin/2fa/disable", s.HandleDisableTwoFactor)
+		r.Get("/admin/tokens", s.GetAPITokensPage)
+		r.Post("/admin/tokens", s.HandleCreateAPIToken)
+		r.Delete("/admin/tokens/{tokenID}", s.HandleRevokeAPIToken)
+		r.Get("/admin/comments", s.GetCommentsPage)
+		r.Patch("/admin/comments/{commentID}", s.HandleModerateComment)
+		r.Delete("/admin/comments/{commentID}", s.HandleDeleteComment)
+
+		r.Route("/admin/users", func(r chi.Router) {
+			r.Use(s.RequireRole(db.Admin))
+			r.Get("/", s.GetUsersPage)
+			r.Post("/", s.HandleCreateUser)
+			r.Patch("/{userID}", s.HandleUpdateUserRole)
+			r.Patch("/{userID}/email", s.HandleUpdateUserEmail)
+			r.Delete("/{userID}", s.HandleDeleteUser)
+		})
+	})
+
+	// json api, authenticated with api tokens instead of the jwt cookie
+	r.Route("/api/v1", func(r chi.Router) {
+		r.Use(s.APIAuthenticator)
+		r.NotFound(handleAPINotFound)
+		r.MethodNotAllowed(handleAPIMethodNotAllowed)
 
-		r.Post("/markdown", HandleUploadMarkdown)
+		r.Get("/posts", s.APIListPosts)
+		r.Post("/posts", s.APICreatePost)
+		r.Route("/posts/{postID}", func(r chi.Router) {
+			r.Use(s.APIPostCtx)
+			r.Get("/", s.APIGetPost)
+			r.Get("/tags", s.APIGetPostTags)
+			r.With(s.APIRequirePostAccess).Patch("/", s.APIUpdatePost)
+			r.With(s.APIRequirePostAccess).Delete("/", s.APIDeletePost)
+			r.With(s.APIRequirePostAccess).Put("/tags", s.APISetPostTags)
+		})
+		r.Get("/tags", s.APIListTags)
+		r.Delete("/tags/{tag}", s.APIDeleteTag)
 	})
 
+	// micropub, authenticated with api tokens like the json api, and webmentions
+	r.Get("/micropub", s.GetMicropub)
+	r.Post("/micropub", s.HandleMicropub)
+	r.Post("/micropub/media", s.HandleMediaUpload)
+	r.Get("/media/*", s.ServeMedia)
+	r.Post("/webmention", s.HandleWebmention)
+
 	// public routes
 	r.Group(func(r chi.Router) {
-		r.Get("/", GetHomePage)
-		r.Get("/login", GetLoginPage)
-		r.Get("/projects", GetProjectsPage)
+		// verify but don't require a token, so admins can preview unpublished posts
+		r.Use(jwtauth.Verifier(config.TokenAuth))
+
+		r.Get("/", s.GetHomePage)
+		r.Get("/login", s.GetLoginPage)
+		r.Get("/projects", s.GetProjectsPage)
+		r.Get("/code.css", s.GetCodeStylesheet)
 		r.Route("/blog", func(r chi.Router) {
-			// TODO: add pagination (eventually)
-			r.Get("/", GetAllPosts)
-			r.With(PostCtx).Get("/{postSlug:[a-z-]+}", GetPost)
-			r.With(PostCtx).Get("/{postSlug:[a-z-]+}/edit", EditPost)
+			r.Get("/", s.GetAllPosts)
+			r.Get("/search", s.GetSearchResults)
+			r.Get("/feed.xml", s.GetRSSFeed)
+			r.Get("/atom.xml", s.GetAtomFeed)
+			r.Get("/feed.json", s.GetJSONFeed)
+			r.Get("/tags/{tag}/feed.xml", s.GetTagRSSFeed)
+			r.Get("/authors/{username}", s.GetAuthorPosts)
+			r.With(s.PostCtx).Get("/{postSlug:[a-z0-9-]+}", s.GetPost)
+			r.With(s.PostCtx).Post("/{postSlug:[a-z0-9-]+}/comments", s.HandleCreateComment)
+			// the edit form is only for users who can edit the post
+			r.With(s.CustomAuthenticator(config.TokenAuth), CSRFProtect, s.PostCtx, s.RequirePostAccess).
+				Get("/{postSlug:[a-z0-9-]+}/edit", s.EditPost)
 		})
-		r.Post("/login", HandleLogin)
+		r.Post("/login", s.HandleLogin)
+		r.Get("/login/2fa", s.GetTwoFactorLoginPage)
+		r.Post("/login/2fa", s.HandleTwoFactorLogin)
 	})
 
-	r.NotFound(HandleNotFound)
+	r.NotFound(s.HandleNotFound)
+
+	return handler
+}
+
+func (s *Server) Start() {
+	s.sanitizeStoredContent()
+	s.startScheduler(time.Minute)
+	s.startWebmentionWorker()
 
-	err := http.ListenAndServe(config.Port, handler)
+	err := http.ListenAndServe(config.Port, s.Handler())
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,577 @@
+package server
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"personal-site/internal/config"
+	"personal-site/internal/db"
+	"personal-site/pkg/utils/markdown"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/jwtauth/v5"
+)
+
+func TestMain(m *testing.M) {
+	config.SignKey = []byte("test sign key")
+	config.TokenAuth = jwtauth.New("HS256", config.SignKey, nil)
+	os.Exit(m.Run())
+}
+
+// a browser for the site's handler, keeping cookies between requests and echoing
+// the csrf cookie back the way the pages' scripts do
+type testClient struct {
+	t        *testing.T
+	handler  http.Handler
+	remoteIP string
+	cookies  map[string]*http.Cookie
+}
+
+func newTestClient(t *testing.T, handler http.Handler) *testClient {
+	return &testClient{t: t, handler: handler, remoteIP: "192.0.2.1", cookies: make(map[string]*http.Cookie)}
+}
+
+func (c *testClient) do(method string, path string, form url.Values) *httptest.ResponseRecorder {
+	c.t.Helper()
+	var req *http.Request
+	if form != nil {
+		req = httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		req = httptest.NewRequest(method, path, nil)
+	}
+	req.RemoteAddr = c.remoteIP + ":1234"
+	for _, cookie := range c.cookies {
+		req.AddCookie(cookie)
+	}
+	if cookie, ok := c.cookies[csrfCookieName]; ok {
+		req.Header.Set(csrfHeaderName, cookie.Value)
+	}
+	rec := httptest.NewRecorder()
+	c.handler.ServeHTTP(rec, req)
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.MaxAge < 0 || cookie.Value == "" {
+			delete(c.cookies, cookie.Name)
+		} else {
+			c.cookies[cookie.Name] = cookie
+		}
+	}
+	return rec
+}
+
+func (c *testClient) login(username string, password string) *httptest.ResponseRecorder {
+	c.t.Helper()
+	return c.do(http.MethodPost, "/login", url.Values{"username": {username}, "password": {password}})
+}
+
+// a server on a memory store with an admin, admin/pass, a published post and a draft
+func newTestServer(t *testing.T) (*Server, *db.MemoryStore) {
+	t.Helper()
+	store := db.NewMemoryStore()
+	userID, err := store.CreateUser("admin", "pass", db.Admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, post := range []*db.Post{
+		{Title: "Hello World", Slug: "hello-world", Status: db.Published},
+		{Title: "Work in Progress", Slug: "work-in-progress", Status: db.Draft},
+	} {
+		post.UserId = int(userID)
+		post.Content = template.HTML("<p>" + post.Title + "</p>")
+		if _, err := store.CreatePost(post); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return New(store), store
+}
+
+func TestPostRoutes(t *testing.T) {
+	s, store := newTestServer(t)
+	post := &db.Post{UserId: 1, Title: "2024 in Review", Slug: "2024-in-review", Content: "<p>hi</p>"}
+	if _, err := store.CreatePost(post); err != nil {
+		t.Fatal(err)
+	}
+	handler := s.Handler()
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/blog/hello-world", http.StatusOK},
+		{"/blog/2024-in-review", http.StatusOK},
+		{"/blog/missing", http.StatusNotFound},
+		{"/blog/Hello-World", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.status {
+				t.Errorf("GET %s = %d, want %d", tt.path, rec.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestCodeStylesheet(t *testing.T) {
+	s, store := newTestServer(t)
+	post := &db.Post{UserId: 1, Title: "Code", Slug: "code", Status: db.Published}
+	content, err := markdown.ParseMD("```go\nfunc main() {}\n```\n\n```nosuchlang\nx\n```\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	post.Content = s.sanitizePostContent(content.HTML)
+	if _, err := store.CreatePost(post); err != nil {
+		t.Fatal(err)
+	}
+	c := newTestClient(t, s.Handler())
+
+	rec := c.do(http.MethodGet, "/code.css", nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/css; charset=utf-8" {
+		t.Fatalf("GET /code.css = %d, %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if rec.Body.String() != string(markdown.CodeCSS()) || !strings.Contains(rec.Body.String(), ".chroma .kd {") {
+		t.Errorf("GET /code.css doesn't serve the generated stylesheet:\n%s", rec.Body)
+	}
+
+	// highlighted posts link the stylesheet, and their classes make it through the sanitizer
+	body := c.do(http.MethodGet, "/blog/code", nil).Body.String()
+	for _, want := range []string{`href="/code.css"`, `<pre class="chroma">`, `<span class="kd">func</span>`, `<code class="language-nosuchlang">x`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("GET /blog/code has no %s:\n%s", want, body)
+		}
+	}
+}
+
+func TestBlogListsPublishedPosts(t *testing.T) {
+	s, _ := newTestServer(t)
+	c := newTestClient(t, s.Handler())
+
+	rec := c.do(http.MethodGet, "/blog/", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /blog/ = %d", rec.Code)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "Hello World") || strings.Contains(body, "Work in Progress") {
+		t.Errorf("GET /blog/ should list only published posts:\n%s", body)
+	}
+	if rec := c.do(http.MethodGet, "/blog/work-in-progress", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("GET of a draft without logging in = %d, want 404", rec.Code)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	s, _ := newTestServer(t)
+	c := newTestClient(t, s.Handler())
+
+	if rec := c.do(http.MethodGet, "/admin", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("GET /admin without logging in = %d, want 401", rec.Code)
+	}
+	if rec := c.login("admin", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("login with the wrong password = %d, want 401", rec.Code)
+	}
+	rec := c.login("admin", "pass")
+	if rec.Code != http.StatusOK || rec.Header().Get("HX-Redirect") != "/admin" {
+		t.Fatalf("login = %d, redirect %q", rec.Code, rec.Header().Get("HX-Redirect"))
+	}
+	if rec := c.do(http.MethodGet, "/admin", nil); rec.Code != http.StatusOK {
+		t.Errorf("GET /admin after logging in = %d, want 200", rec.Code)
+	}
+	if rec := c.do(http.MethodGet, "/blog/work-in-progress", nil); rec.Code != http.StatusOK {
+		t.Errorf("GET of a draft after logging in = %d, want 200", rec.Code)
+	}
+
+	if rec := c.do(http.MethodPost, "/logout", url.Values{}); rec.Code != http.StatusOK {
+		t.Fatalf("logout = %d", rec.Code)
+	}
+	if rec := c.do(http.MethodGet, "/admin", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("GET /admin after logging out = %d, want 401", rec.Code)
+	}
+}
+
+// the rel=prev and rel=next urls a post list links to, from both the <link>s in the
+// head and the pagination links
+var pageLinkPattern = regexp.MustCompile(`rel="(prev|next)" href="([^"]*)"`)
+
+func pageLinks(t *testing.T, body string) map[string]string {
+	t.Helper()
+	links := make(map[string]string)
+	for _, match := range pageLinkPattern.FindAllStringSubmatch(body, -1) {
+		href := html.UnescapeString(match[2])
+		if previous, ok := links[match[1]]; ok && previous != href {
+			t.Errorf("rel=%s links to both %q and %q", match[1], previous, href)
+		}
+		links[match[1]] = href
+	}
+	return links
+}
+
+func TestBlogPages(t *testing.T) {
+	pageSize := config.PageSize
+	config.PageSize = 2
+	t.Cleanup(func() { config.PageSize = pageSize })
+	s, store := newTestServer(t)
+	hello, err := store.GetPostBySlug("hello-world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateTags(int64(hello.Id), []string{"go"}); err != nil {
+		t.Fatal(err)
+	}
+	// newest first, so the list is post-4, post-3, post-2, post-1, hello-world
+	for i := 1; i <= 4; i++ {
+		postID, err := store.CreatePost(&db.Post{UserId: 1, Title: fmt.Sprintf("Post %d", i), Slug: fmt.Sprintf("post-%d", i), Content: "<p>hi</p>"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i%2 == 1 {
+			if err := store.CreateTags(postID, []string{"go"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	handler := s.Handler()
+
+	tests := []struct {
+		path  string
+		posts []string
+		prev  string
+		next  string
+	}{
+		{"/blog", []string{"post-4", "post-3"}, "", "/blog?page=2"},
+		{"/blog?page=1", []string{"post-4", "post-3"}, "", "/blog?page=2"},
+		{"/blog?page=2", []string{"post-2", "post-1"}, "/blog", "/blog?page=3"},
+		{"/blog?page=3", []string{"hello-world"}, "/blog?page=2", ""},
+		// tag filters are kept from page to page
+		{"/blog?q=go", []string{"post-3", "post-1"}, "", "/blog?page=2&q=go"},
+		{"/blog?q=go&page=2", []string{"hello-world"}, "/blog?q=go", ""},
+		// an author's posts are paged the same way
+		{"/blog/authors/admin", []string{"post-4", "post-3"}, "", "/blog/authors/admin?page=2"},
+		{"/blog/authors/admin?page=2", []string{"post-2", "post-1"}, "/blog/authors/admin", "/blog/authors/admin?page=3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("GET %s = %d", tt.path, rec.Code)
+			}
+			body := rec.Body.String()
+			var got []string
+			for _, match := range regexp.MustCompile(`<a href="/blog/([a-z0-9-]+)">`).FindAllStringSubmatch(body, -1) {
+				got = append(got, match[1])
+			}
+			if strings.Join(got, " ") != strings.Join(tt.posts, " ") {
+				t.Errorf("GET %s lists %q, want %q", tt.path, got, tt.posts)
+			}
+			links := pageLinks(t, body)
+			if links["prev"] != tt.prev || links["next"] != tt.next {
+				t.Errorf("GET %s links to prev %q, next %q, want %q, %q", tt.path, links["prev"], links["next"], tt.prev, tt.next)
+			}
+		})
+	}
+
+	for path, status := range map[string]int{
+		"/blog?page=4":               http.StatusNotFound,
+		"/blog?q=go&page=3":          http.StatusNotFound,
+		"/blog?page=0":               http.StatusBadRequest,
+		"/blog?page=-1":              http.StatusBadRequest,
+		"/blog?page=x":               http.StatusBadRequest,
+		"/blog?q=nosuchtag":          http.StatusOK,
+		"/blog/authors/admin?page=4": http.StatusNotFound,
+		"/blog/authors/admin?page=x": http.StatusBadRequest,
+		"/blog/authors/nobody":       http.StatusNotFound,
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != status {
+			t.Errorf("GET %s = %d, want %d", path, rec.Code, status)
+		}
+	}
+}
+
+func TestCreatePost(t *testing.T) {
+	s, store := newTestServer(t)
+	c := newTestClient(t, s.Handler())
+	c.login("admin", "pass")
+	form := url.Values{
+		"post-title":   {"New Post"},
+		"post-slug":    {"new-post"},
+		"post-content": {`<p>new <script>alert(1)</script></p>`},
+		"post-status":  {"published"},
+		"tags":         {"go web"},
+	}
+
+	// without the csrf token, e.g. from another site
+	delete(c.cookies, csrfCookieName)
+	if rec := c.do(http.MethodPost, "/post", form); rec.Code != http.StatusForbidden {
+		t.Errorf("POST /post without a csrf token = %d, want 403", rec.Code)
+	}
+
+	c.do(http.MethodGet, "/admin", nil)
+	if rec := c.do(http.MethodPost, "/post", form); rec.Code != http.StatusOK {
+		t.Fatalf("POST /post = %d", rec.Code)
+	}
+	post, err := store.GetPostBySlug("new-post")
+	if err != nil {
+		t.Fatalf("GetPostBySlug() = %v", err)
+	}
+	if post.Title != "New Post" || strings.Contains(string(post.Content), "script") {
+		t.Errorf("created post = %+v", post)
+	}
+	tags, err := store.GetTags(post.Id)
+	if err != nil || len(tags) != 2 {
+		t.Errorf("GetTags() = %v, %v, want 2 tags", tags, err)
+	}
+	if rec := c.do(http.MethodGet, "/blog/new-post", nil); rec.Code != http.StatusOK {
+		t.Errorf("GET /blog/new-post = %d, want 200", rec.Code)
+	}
+
+	// a slug another post has is shown as a form error
+	rec := c.do(http.MethodPost, "/post", form)
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), slugTakenMessage) {
+		t.Errorf("POST /post with a taken slug = %d %q, want 422", rec.Code, rec.Body.String())
+	}
+	existing, err := store.GetPostBySlug("hello-world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	form.Set("post-title", existing.Title)
+	rec = c.do(http.MethodPatch, fmt.Sprintf("/post/%d", existing.Id), form)
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), slugTakenMessage) {
+		t.Errorf("PATCH /post/%d to a taken slug = %d %q, want 422", existing.Id, rec.Code, rec.Body.String())
+	}
+	if post, err := store.GetPost(existing.Id); err != nil || post.Slug != "hello-world" {
+		t.Errorf("post after a rejected edit = %v, %v", post, err)
+	}
+}
+
+func TestDraftsAreVisibleToTheirEditors(t *testing.T) {
+	s, store := newTestServer(t)
+	for _, user := range []struct {
+		name string
+		role db.Role
+	}{{"author", db.Author}, {"editor", db.Editor}} {
+		if _, err := store.CreateUser(user.name, "pass", user.role); err != nil {
+			t.Fatal(err)
+		}
+	}
+	author, err := store.GetUserByUsername("author")
+	if err != nil {
+		t.Fatal(err)
+	}
+	draft := &db.Post{UserId: author.Id, Title: "Own Draft", Slug: "own-draft", Content: "<p>mine</p>", Status: db.Draft}
+	if _, err := store.CreatePost(draft); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		username string
+		path     string
+		status   int
+	}{
+		{"author", "/blog/own-draft", http.StatusOK},
+		{"author", "/blog/work-in-progress", http.StatusNotFound},
+		{"author", "/blog/hello-world", http.StatusOK},
+		{"editor", "/blog/own-draft", http.StatusOK},
+		{"editor", "/blog/work-in-progress", http.StatusOK},
+		{"admin", "/blog/own-draft", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.username+tt.path, func(t *testing.T) {
+			c := newTestClient(t, s.Handler())
+			if rec := c.login(tt.username, "pass"); rec.Code != http.StatusOK {
+				t.Fatalf("login = %d", rec.Code)
+			}
+			if rec := c.do(http.MethodGet, tt.path, nil); rec.Code != tt.status {
+				t.Errorf("GET %s = %d, want %d", tt.path, rec.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestRestoreRevisionAddsARevision(t *testing.T) {
+	s, store := newTestServer(t)
+	post, err := store.GetPostBySlug("hello-world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{"<p>second</p>", "<p>third</p>"} {
+		edited := *post
+		edited.Content = template.HTML(content)
+		if err := store.EditPost(post.Id, &edited); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before, err := store.GetRevisions(post.Id)
+	if err != nil || len(before) != 3 {
+		t.Fatalf("GetRevisions() = %d revisions, %v, want 3", len(before), err)
+	}
+	original := before[len(before)-1]
+
+	c := newTestClient(t, s.Handler())
+	c.login("admin", "pass")
+	path := fmt.Sprintf("/admin/posts/%d/revisions/%d/restore", post.Id, original.Id)
+	if rec := c.do(http.MethodPost, path, url.Values{}); rec.Code != http.StatusOK {
+		t.Fatalf("POST %s = %d", path, rec.Code)
+	}
+
+	restored, err := store.GetPost(post.Id)
+	if err != nil || restored.Content != original.Content {
+		t.Errorf("restored post = %+v, %v, want content %q", restored, err, original.Content)
+	}
+	after, err := store.GetRevisions(post.Id)
+	if err != nil || len(after) != 4 {
+		t.Fatalf("GetRevisions() after restoring = %d revisions, %v, want 4", len(after), err)
+	}
+	if after[0].Content != original.Content || after[0].Id == original.Id {
+		t.Errorf("newest revision = %+v, want a new revision with the original content", after[0])
+	}
+	for i, revision := range before {
+		if *after[i+1] != *revision {
+			t.Errorf("revision %d changed from %+v to %+v", revision.Id, revision, after[i+1])
+		}
+	}
+}
+
+// a revision saved before content was sanitized is sanitized when it's restored
+func TestRestoreRevisionIsSanitized(t *testing.T) {
+	s, store := newTestServer(t)
+	post, err := store.GetPostBySlug("hello-world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsafe := *post
+	unsafe.Content = `<p onclick="alert(1)">old</p><script>alert(1)</script>`
+	if err := store.EditPost(post.Id, &unsafe); err != nil {
+		t.Fatal(err)
+	}
+	safe := *post
+	safe.Content = "<p>new</p>"
+	if err := store.EditPost(post.Id, &safe); err != nil {
+		t.Fatal(err)
+	}
+	revisions, err := store.GetRevisions(post.Id)
+	if err != nil || len(revisions) != 3 || revisions[1].Content != unsafe.Content {
+		t.Fatalf("GetRevisions() = %+v, %v", revisions, err)
+	}
+
+	c := newTestClient(t, s.Handler())
+	c.login("admin", "pass")
+	path := fmt.Sprintf("/admin/posts/%d/revisions/%d/restore", post.Id, revisions[1].Id)
+	if rec := c.do(http.MethodPost, path, url.Values{}); rec.Code != http.StatusOK {
+		t.Fatalf("POST %s = %d", path, rec.Code)
+	}
+	if restored, err := store.GetPost(post.Id); err != nil || restored.Content != "<p>old</p>" {
+		t.Errorf("restored post = %+v, %v, want content %q", restored, err, "<p>old</p>")
+	}
+	if after, err := store.GetRevisions(post.Id); err != nil || after[0].Content != "<p>old</p>" {
+		t.Errorf("newest revision = %+v, %v, want the sanitized content", after[0], err)
+	}
+}
+
+// content saved before it was sanitized is rewritten when the server starts
+func TestSanitizeStoredContent(t *testing.T) {
+	s, store := newTestServer(t)
+	post, err := store.GetPostBySlug("hello-world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsafe := *post
+	unsafe.Content = `<p>hi</p><script>alert(1)</script>`
+	if err := store.EditPost(post.Id, &unsafe); err != nil {
+		t.Fatal(err)
+	}
+
+	s.sanitizeStoredContent()
+	if post, err := store.GetPost(post.Id); err != nil || post.Content != "<p>hi</p>" {
+		t.Errorf("post after sanitizing = %+v, %v", post, err)
+	}
+	revisions, err := store.GetRevisions(post.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, revision := range revisions {
+		if strings.Contains(string(revision.Content), "script") {
+			t.Errorf("revision %d wasn't sanitized: %q", revision.Id, revision.Content)
+		}
+	}
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/blog/hello-world", nil))
+	if strings.Contains(rec.Body.String(), "alert(1)") {
+		t.Errorf("GET /blog/hello-world still has the script: %s", rec.Body.String())
+	}
+}
+
+func TestRestoreRevisionWithATakenSlug(t *testing.T) {
+	s, store := newTestServer(t)
+	post, err := store.GetPostBySlug("hello-world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	renamed := *post
+	renamed.Slug = "hello-again"
+	if err := store.EditPost(post.Id, &renamed); err != nil {
+		t.Fatal(err)
+	}
+	revisions, err := store.GetRevisions(post.Id)
+	if err != nil || len(revisions) != 2 {
+		t.Fatalf("GetRevisions() = %d revisions, %v, want 2", len(revisions), err)
+	}
+	// another post takes the slug the original had
+	if _, err := store.CreatePost(&db.Post{UserId: 1, Title: "Other", Slug: "hello-world"}); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestClient(t, s.Handler())
+	c.login("admin", "pass")
+	path := fmt.Sprintf("/admin/posts/%d/revisions/%d/restore", post.Id, revisions[1].Id)
+	if rec := c.do(http.MethodPost, path, url.Values{}); rec.Code != http.StatusConflict {
+		t.Errorf("POST %s = %d, want 409", path, rec.Code)
+	}
+	if current, err := store.GetPost(post.Id); err != nil || current.Slug != "hello-again" {
+		t.Errorf("post after a rejected restore = %v, %v", current, err)
+	}
+}
+
+// an editor's session token expires while they write, the post should still be saved
+// and the cookie refreshed
+func TestCreatePostWithExpiredToken(t *testing.T) {
+	s, store := newTestServer(t)
+	c := newTestClient(t, s.Handler())
+	c.login("admin", "pass")
+	token, err := config.TokenAuth.Decode(c.cookies["jwt"].Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, expired, err := config.TokenAuth.Encode(map[string]interface{}{
+		"user_id": 1,
+		"jti":     token.JwtID(),
+		"exp":     time.Now().Add(-time.Minute).Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.cookies["jwt"].Value = expired
+
+	form := url.Values{"post-title": {"Slow Post"}, "post-slug": {"slow-post"}, "post-content": {"<p>slow</p>"}}
+	if rec := c.do(http.MethodPost, "/post", form); rec.Code != http.StatusOK {
+		t.Fatalf("POST /post with an expired token = %d, want 200", rec.Code)
+	}
+	if c.cookies["jwt"].Value == expired {
+		t.Error("the expired token wasn't refreshed")
+	}
+	post, err := store.GetPostBySlug("slow-post")
+	if err != nil {
+		t.Fatalf("GetPostBySlug() = %v", err)
+	}
+	if post.UserId != 1 {
+		t.Errorf("post author = %d, want 1", post.UserId)
+	}
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"personal-site/internal/config"
+	"personal-site/internal/db"
+	"personal-site/pkg/utils"
+	"personal-site/web/static/html"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/pkg/errors"
+)
+
+const (
+	// a session ends after this long without any requests
+	sessionIdleTimeout = 24 * time.Hour
+	// how stale last seen can get before a request updates it
+	sessionTouchInterval = time.Minute
+)
+
+var errNoSession = errors.New("no active session")
+
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// RealIP has already replaced RemoteAddr with the forwarded address if there was one
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// records a new session for the user and hands the browser a token for it
+func (s *Server) startSession(w http.ResponseWriter, r *http.Request, user *db.User) error {
+	id, err := newSessionID()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	session := &db.Session{
+		Id:         id,
+		UserId:     user.Id,
+		IP:         clientIP(r),
+		UserAgent:  r.UserAgent(),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(sessionIdleTimeout),
+	}
+	if err := s.store.CreateSession(session); err != nil {
+		return err
+	}
+	// a fresh csrf token per login, so a token planted before login is useless
+	csrfToken, err := newCSRFToken()
+	if err != nil {
+		return err
+	}
+	setCSRFCookie(w, csrfToken)
+	return setTokenCookie(w, session)
+}
+
+func setTokenCookie(w http.ResponseWriter, session *db.Session) error {
+	jwt, err := utils.GenerateToken(session.UserId, session.Id)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     "jwt",
+		Value:    jwt,
+		HttpOnly: true,
+		Secure:   !config.IsDev,
+		SameSite: http.SameSiteLaxMode,
+		Path:     "/",
+	})
+	return nil
+}
+
+func clearTokenCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "jwt",
+		Value:    "",
+		HttpOnly: true,
+		Secure:   !config.IsDev,
+		SameSite: http.SameSiteLaxMode,
+		Path:     "/",
+		MaxAge:   -1,
+	})
+}
+
+// looks up the session named by the jti claim of the request's jwt. expired tokens
+// are still accepted here since they're refreshed as long as the session is active
+func (s *Server) sessionFromRequest(r *http.Request) (*db.Session, error) {
+	token, claims, err := jwtauth.FromContext(r.Context())
+	if token == nil || (err != nil && err != jwtauth.ErrExpired) {
+		return nil, errNoSession
+	}
+	sessionID, _ := claims["jti"].(string)
+	if sessionID == "" {
+		return nil, errNoSession
+	}
+	session, err := s.store.GetSession(sessionID)
+	if err != nil {
+		if err == db.ErrNotFound {
+			return nil, errNoSession
+		}
+		return nil, err
+	}
+	if !session.ExpiresAt.After(time.Now()) {
+		return nil, errNoSession
+	}
+	return session, nil
+}
+
+func (s *Server) HandleLogout(w http.ResponseWriter, r *http.Request) {
+	session, ok := r.Context().Value(sessionKey).(*db.Session)
+	if !ok {
+		handleError(w, http.StatusUnprocessableEntity)
+		return
+	}
+	err := s.store.DeleteSession(session.Id)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError)
+		return
+	}
+	clearTokenCookie(w)
+	w.Header().Set("HX-Redirect", "/")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) GetSessionsPage(w http.ResponseWriter, r *http.Request) {
+	session, ok := r.Context().Value(sessionKey).(*db.Session)
+	if !ok {
+		handleError(w, http.StatusUnprocessableEntity)
+		return
+	}
+	sessions, err := s.store.GetSessions(session.UserId, time.Now())
+	if err != nil {
+		handleError(w, http.StatusInternalServerError)
+		return
+	}
+	html.Sessions(w, &db.SessionsData{Sessions: sessions, Current: session.Id})
+}
+
+// revokes one of the current user's sessions, revoking the current one logs out
+func (s *Server) HandleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	current, ok := r.Context().Value(sessionKey).(*db.Session)
+	if !ok {
+		handleError(w, http.StatusUnprocessableEntity)
+		return
+	}
+	session, err := s.store.GetSession(chi.URLParam(r, "sessionID"))
+	if err != nil {
+		if err == db.ErrNotFound {
+			handleError(w, http.StatusNotFound)
+		} else {
+			handleError(w, http.StatusInternalServerError)
+		}
+		return
+	}
+	// other users' sessions are treated as if they don't exist
+	if session.UserId != current.UserId {
+		handleError(w, http.StatusNotFound)
+		return
+	}
+	err = s.store.DeleteSession(session.Id)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError)
+		return
+	}
+	if session.Id == current.Id {
+		clearTokenCookie(w)
+		w.Header().Set("HX-Redirect", "/login")
+	}
+	w.WriteHeader(http.StatusOK)
+}
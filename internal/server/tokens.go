@@ -0,0 +1,84 @@
+package server
+
+import (
+	"net/http"
+	"personal-site/internal/db"
+	"personal-site/web/static/html"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func (s *Server) GetAPITokensPage(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(r)
+	if !ok {
+		handleError(w, http.StatusUnprocessableEntity)
+		return
+	}
+	tokens, err := s.store.GetAPITokens(user.Id)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError)
+		return
+	}
+	html.APITokens(w, &db.APITokensData{Tokens: tokens})
+}
+
+// creates a token for the current user, the plain token is only shown in this response
+func (s *Server) HandleCreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(r)
+	if !ok {
+		handleError(w, http.StatusUnprocessableEntity)
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		handleError(w, http.StatusBadRequest)
+		return
+	}
+	token, err := newAPIToken()
+	if err != nil {
+		handleError(w, http.StatusInternalServerError)
+		return
+	}
+	_, err = s.store.CreateAPIToken(&db.APIToken{
+		UserId:    user.Id,
+		Name:      name,
+		TokenHash: hashAPIToken(token),
+		CreatedAt: s.now(),
+	})
+	if err != nil {
+		handleError(w, http.StatusInternalServerError)
+		return
+	}
+	tokens, err := s.store.GetAPITokens(user.Id)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError)
+		return
+	}
+	html.APITokensSection(w, &db.APITokensData{Tokens: tokens, NewToken: token})
+}
+
+func (s *Server) HandleRevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(r)
+	if !ok {
+		handleError(w, http.StatusUnprocessableEntity)
+		return
+	}
+	tokenID, err := strconv.Atoi(chi.URLParam(r, "tokenID"))
+	if err != nil {
+		handleError(w, http.StatusBadRequest)
+		return
+	}
+	// other users' tokens are treated as if they don't exist
+	err = s.store.DeleteAPIToken(user.Id, tokenID)
+	if err != nil {
+		if err == db.ErrNotFound {
+			handleError(w, http.StatusNotFound)
+		} else {
+			handleError(w, http.StatusInternalServerError)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,287 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"personal-site/internal/config"
+	"personal-site/internal/db"
+	"personal-site/pkg/utils"
+	"personal-site/pkg/utils/totp"
+	"personal-site/web/static/html"
+	"strings"
+	"time"
+
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/pkg/errors"
+)
+
+const (
+	mfaCookieName = "mfa_token"
+	// how long the code can take to enter after the password was accepted
+	mfaTokenLifetime  = 5 * time.Minute
+	recoveryCodeCount = 10
+)
+
+var errNoMFAToken = errors.New("no valid mfa token")
+
+func setMFACookie(w http.ResponseWriter, user *db.User) error {
+	token, err := utils.GenerateMFAToken(user.Id, mfaTokenLifetime)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     mfaCookieName,
+		Value:    token,
+		HttpOnly: true,
+		Secure:   !config.IsDev,
+		SameSite: http.SameSiteStrictMode,
+		Path:     "/login",
+		MaxAge:   int(mfaTokenLifetime / time.Second),
+	})
+	return nil
+}
+
+func clearMFACookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     mfaCookieName,
+		Value:    "",
+		HttpOnly: true,
+		Secure:   !config.IsDev,
+		SameSite: http.SameSiteStrictMode,
+		Path:     "/login",
+		MaxAge:   -1,
+	})
+}
+
+// returns the user whose password was accepted by the first login step
+func (s *Server) userFromMFACookie(r *http.Request) (*db.User, error) {
+	cookie, err := r.Cookie(mfaCookieName)
+	if err != nil {
+		return nil, errNoMFAToken
+	}
+	token, err := jwtauth.VerifyToken(config.TokenAuth, cookie.Value)
+	if err != nil {
+		return nil, errNoMFAToken
+	}
+	claims, err := token.AsMap(context.Background())
+	if err != nil {
+		return nil, errNoMFAToken
+	}
+	mfa, _ := claims["mfa"].(bool)
+	userID, ok := claims["user_id"].(float64)
+	if !mfa || !ok {
+		return nil, errNoMFAToken
+	}
+	user, err := s.store.GetUser(int(userID))
+	if err != nil {
+		if err == db.ErrNotFound {
+			return nil, errNoMFAToken
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// accepts either a totp code or an unused recovery code, each only once
+func (s *Server) checkSecondFactor(user *db.User, code string) (bool, error) {
+	if counter, ok := totp.Validate(user.TOTPSecret, code, s.now()); ok {
+		return s.store.UseTOTPCounter(user.Id, counter)
+	}
+	return s.store.UseRecoveryCode(user.Id, totp.HashRecoveryCode(code))
+}
+
+func (s *Server) GetTwoFactorLoginPage(w http.ResponseWriter, r *http.Request) {
+	if _, err := s.userFromMFACookie(r); err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	html.TwoFactorLogin(w)
+}
+
+// the second login step, the session is only started here once the code checks out
+func (s *Server) HandleTwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	user, err := s.userFromMFACookie(r)
+	if err != nil {
+		if err == errNoMFAToken {
+			handleError(w, http.StatusUnauthorized)
+		} else {
+			handleError(w, http.StatusInternalServerError)
+		}
+		return
+	}
+	code := r.FormValue("code")
+	if code == "" {
+		handleError(w, http.StatusBadRequest)
+		return
+	}
+	// codes are short enough to guess, so they share the password throttle
+	ip := clientIP(r)
+	release, allowed := s.allowLogin(w, user.Username, ip)
+	if !allowed {
+		return
+	}
+	defer release()
+	ok, err := s.checkSecondFactor(user, code)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		s.recordLoginAttempt(user.Username, ip, false)
+		handleError(w, http.StatusUnauthorized)
+		return
+	}
+	err = s.startSession(w, r, user)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError)
+		return
+	}
+	s.recordLoginAttempt(user.Username, ip, true)
+	clearMFACookie(w)
+	w.Header().Set("HX-Redirect", "/admin")
+	w.WriteHeader(http.StatusOK)
+}
+
+// the user behind the current session, for the two-factor settings handlers
+func (s *Server) sessionUser(w http.ResponseWriter, r *http.Request) (*db.User, bool) {
+	user, ok := currentUser(r)
+	if !ok {
+		handleError(w, http.StatusUnprocessableEntity)
+		return nil, false
+	}
+	return user, true
+}
+
+// shows the current two-factor status, or starts enrollment if it's off
+func (s *Server) GetTwoFactorPage(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	if !user.TOTPEnabled && user.TOTPSecret == "" {
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			handleError(w, http.StatusInternalServerError)
+			return
+		}
+		err = s.store.SetTOTPSecret(user.Id, secret)
+		if err != nil {
+			handleError(w, http.StatusInternalServerError)
+			return
+		}
+		user.TOTPSecret = secret
+	}
+	data, err := s.twoFactorData(user)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError)
+		return
+	}
+	html.TwoFactor(w, data)
+}
+
+func (s *Server) twoFactorData(user *db.User) (*db.TwoFactorData, error) {
+	if user.TOTPEnabled {
+		remaining, err := s.store.CountRecoveryCodes(user.Id)
+		if err != nil {
+			return nil, err
+		}
+		return &db.TwoFactorData{Enabled: true, RemainingRecovery: remaining}, nil
+	}
+	uri := totp.ProvisioningURI(user.TOTPSecret, totpIssuer(), user.Username)
+	qrCode, err := totp.QRCodeSVG(uri)
+	if err != nil {
+		return nil, err
+	}
+	return &db.TwoFactorData{Secret: user.TOTPSecret, URI: uri, QRCode: qrCode}, nil
+}
+
+// authenticator apps group accounts by issuer, the site's host is used for it
+func totpIssuer() string {
+	siteURL, err := url.Parse(config.SiteURL)
+	if err != nil || siteURL.Host == "" {
+		return "personal-site"
+	}
+	return siteURL.Host
+}
+
+// confirms enrollment with a code from the authenticator app, then shows the recovery codes once
+func (s *Server) HandleEnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabled || user.TOTPSecret == "" {
+		handleError(w, http.StatusBadRequest)
+		return
+	}
+	counter, valid := totp.Validate(user.TOTPSecret, r.FormValue("code"), s.now())
+	if !valid {
+		data, err := s.twoFactorData(user)
+		if err != nil {
+			handleError(w, http.StatusInternalServerError)
+			return
+		}
+		data.Error = "That code didn't match, check the time on your device and try again."
+		html.TwoFactorSection(w, data)
+		return
+	}
+	codes, err := totp.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError)
+		return
+	}
+	err = s.store.EnableTOTP(user.Id, utils.Map(codes, totp.HashRecoveryCode))
+	if err != nil {
+		handleError(w, http.StatusInternalServerError)
+		return
+	}
+	// the code used to confirm can't be used again to log in
+	if _, err := s.store.UseTOTPCounter(user.Id, counter); err != nil {
+		handleError(w, http.StatusInternalServerError)
+		return
+	}
+	html.TwoFactorSection(w, &db.TwoFactorData{Enabled: true, RecoveryCodes: codes, RemainingRecovery: len(codes)})
+}
+
+// turning two-factor off needs a current code, so a hijacked session alone can't do it
+func (s *Server) HandleDisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	if !user.TOTPEnabled {
+		handleError(w, http.StatusBadRequest)
+		return
+	}
+	// guessing the code from a hijacked session is throttled like logging in with it
+	ip := clientIP(r)
+	release, allowed := s.allowLogin(w, user.Username, ip)
+	if !allowed {
+		return
+	}
+	defer release()
+	valid, err := s.checkSecondFactor(user, strings.TrimSpace(r.FormValue("code")))
+	if err != nil {
+		handleError(w, http.StatusInternalServerError)
+		return
+	}
+	s.recordLoginAttempt(user.Username, ip, valid)
+	if !valid {
+		data, err := s.twoFactorData(user)
+		if err != nil {
+			handleError(w, http.StatusInternalServerError)
+			return
+		}
+		data.Error = "That code didn't match."
+		html.TwoFactorSection(w, data)
+		return
+	}
+	err = s.store.DisableTOTP(user.Id)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("HX-Redirect", "/admin/2fa")
+	w.WriteHeader(http.StatusOK)
+}
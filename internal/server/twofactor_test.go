@@ -0,0 +1,144 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"personal-site/pkg/utils/totp"
+	"testing"
+	"time"
+)
+
+func TestTwoFactorLogin(t *testing.T) {
+	s, store := newTestServer(t)
+	now := time.Unix(1700000000, 0)
+	s.now = func() time.Time { return now }
+	handler := s.Handler()
+
+	user, err := store.GetUserByUsername("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetTOTPSecret(user.Id, secret); err != nil {
+		t.Fatal(err)
+	}
+	const recoveryCode = "abcde-fghij"
+	if err := store.EnableTOTP(user.Id, []string{totp.HashRecoveryCode(recoveryCode)}); err != nil {
+		t.Fatal(err)
+	}
+	codeAt := func(offset time.Duration) string {
+		code, err := totp.Code(secret, now.Add(offset))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	// logs in with the password and then the code, returning the second step's status
+	login := func(code string) int {
+		t.Helper()
+		c := newTestClient(t, handler)
+		rec := c.login("admin", "pass")
+		if rec.Code != http.StatusOK || rec.Header().Get("HX-Redirect") != "/login/2fa" {
+			t.Fatalf("password step = %d, redirect %q", rec.Code, rec.Header().Get("HX-Redirect"))
+		}
+		if _, ok := c.cookies["jwt"]; ok {
+			t.Fatal("a session was started before the second factor")
+		}
+		rec = c.do(http.MethodPost, "/login/2fa", url.Values{"code": {code}})
+		if rec.Code == http.StatusOK {
+			if _, ok := c.cookies["jwt"]; !ok {
+				t.Error("no session was started after the second factor")
+			}
+		}
+		return rec.Code
+	}
+
+	steps := []struct {
+		name string
+		code string
+		want int
+	}{
+		{"current code", codeAt(0), http.StatusOK},
+		{"replayed code", codeAt(0), http.StatusUnauthorized},
+		// a step older than one already used can't be used either
+		{"previous code", codeAt(-totp.Period), http.StatusUnauthorized},
+		{"next code within the skew", codeAt(totp.Period), http.StatusOK},
+		{"code past the skew", codeAt(3 * totp.Period), http.StatusUnauthorized},
+		{"recovery code", recoveryCode, http.StatusOK},
+		{"used recovery code", recoveryCode, http.StatusUnauthorized},
+	}
+	for _, step := range steps {
+		if got := login(step.code); got != step.want {
+			t.Errorf("%s = %d, want %d", step.name, got, step.want)
+		}
+	}
+	if remaining, err := store.CountRecoveryCodes(user.Id); err != nil || remaining != 0 {
+		t.Errorf("CountRecoveryCodes() = %d, %v, want 0", remaining, err)
+	}
+
+	// the second step can't be skipped
+	c := newTestClient(t, handler)
+	if rec := c.do(http.MethodPost, "/login/2fa", url.Values{"code": {codeAt(2 * totp.Period)}}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("second step without the first = %d, want 401", rec.Code)
+	}
+}
+
+// the code needed to turn two-factor off can't be brute-forced from a session
+func TestDisableTwoFactorIsThrottled(t *testing.T) {
+	s, store := newTestServer(t)
+	now := time.Unix(1700000000, 0)
+	s.now = func() time.Time { return now }
+	c := newTestClient(t, s.Handler())
+	if rec := c.login("admin", "pass"); rec.Code != http.StatusOK {
+		t.Fatalf("login = %d", rec.Code)
+	}
+
+	user, err := store.GetUserByUsername("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetTOTPSecret(user.Id, secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.EnableTOTP(user.Id, nil); err != nil {
+		t.Fatal(err)
+	}
+	code, err := totp.Code(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < usernameLimit.free; i++ {
+		if rec := c.do(http.MethodPost, "/admin/2fa/disable", url.Values{"code": {"000000"}}); rec.Code != http.StatusOK {
+			t.Fatalf("wrong code %d = %d, want the form again", i+1, rec.Code)
+		}
+	}
+	rec := c.do(http.MethodPost, "/admin/2fa/disable", url.Values{"code": {code}})
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("disabling after %d wrong codes = %d, want 429", usernameLimit.free, rec.Code)
+	}
+	if user, err := store.GetUser(user.Id); err != nil || !user.TOTPEnabled {
+		t.Fatalf("two-factor was turned off while throttled: %v", err)
+	}
+	attempts, err := store.GetLoginAttempts("admin", "", now.Add(-time.Hour))
+	if err != nil || len(attempts) < usernameLimit.free {
+		t.Errorf("GetLoginAttempts() = %d attempts, %v, want the wrong codes recorded", len(attempts), err)
+	}
+
+	now = now.Add(time.Second)
+	rec = c.do(http.MethodPost, "/admin/2fa/disable", url.Values{"code": {code}})
+	if rec.Code != http.StatusOK || rec.Header().Get("HX-Redirect") != "/admin/2fa" {
+		t.Errorf("disabling once the delay passed = %d, redirect %q", rec.Code, rec.Header().Get("HX-Redirect"))
+	}
+	if user, err := store.GetUser(user.Id); err != nil || user.TOTPEnabled {
+		t.Errorf("two-factor is still on: %v", err)
+	}
+}
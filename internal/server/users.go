@@ -0,0 +1,211 @@
+package server
+
+import (
+	"net/http"
+	"net/mail"
+	"personal-site/internal/db"
+	"personal-site/web/static/html"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// the user behind the current session, set by CustomAuthenticator
+func currentUser(r *http.Request) (*db.User, bool) {
+	user, ok := r.Context().Value(userKey).(*db.User)
+	return user, ok
+}
+
+// rejects requests from users without one of the given roles, requires CustomAuthenticator to have run
+func (s *Server) RequireRole(roles ...db.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := currentUser(r)
+			if !ok {
+				handleError(w, http.StatusUnauthorized)
+				return
+			}
+			if !slices.Contains(roles, user.Role) {
+				handleError(w, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rejects requests for posts the user isn't allowed to edit, requires PostCtx to have run
+func (s *Server) RequirePostAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentUser(r)
+		if !ok {
+			handleError(w, http.StatusUnauthorized)
+			return
+		}
+		post, ok := r.Context().Value(postKey).(*db.Post)
+		if !ok {
+			handleError(w, http.StatusUnprocessableEntity)
+			return
+		}
+		if !user.CanEditPost(post) {
+			handleError(w, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) GetUsersPage(w http.ResponseWriter, r *http.Request) {
+	data, ok := s.usersData(w, r)
+	if !ok {
+		return
+	}
+	html.Users(w, data)
+}
+
+func (s *Server) usersData(w http.ResponseWriter, r *http.Request) (*db.UsersData, bool) {
+	user, ok := currentUser(r)
+	if !ok {
+		handleError(w, http.StatusUnprocessableEntity)
+		return nil, false
+	}
+	users, err := s.store.GetUsers()
+	if err != nil {
+		handleError(w, http.StatusInternalServerError)
+		return nil, false
+	}
+	return &db.UsersData{Users: users, Current: user.Id, Roles: db.Roles}, true
+}
+
+// re-renders the user list after a change, with an error message if it was refused
+func (s *Server) renderUsersSection(w http.ResponseWriter, r *http.Request, message string) {
+	data, ok := s.usersData(w, r)
+	if !ok {
+		return
+	}
+	data.Error = message
+	html.UsersSection(w, data)
+}
+
+func (s *Server) HandleCreateUser(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		handleError(w, http.StatusBadRequest)
+		return
+	}
+	username := strings.TrimSpace(r.FormValue("username"))
+	password := r.FormValue("password")
+	role := db.Role(r.FormValue("role"))
+	if username == "" || password == "" || !db.IsValidRole(role) {
+		handleError(w, http.StatusBadRequest)
+		return
+	}
+	_, err = s.store.CreateUser(username, password, role)
+	if err != nil {
+		if err == db.ErrUsernameTaken {
+			s.renderUsersSection(w, r, "That username is already taken.")
+		} else {
+			handleError(w, http.StatusInternalServerError)
+		}
+		return
+	}
+	s.renderUsersSection(w, r, "")
+}
+
+// looks up the user named in the url, writing an error if there's no such user
+func (s *Server) userFromURL(w http.ResponseWriter, r *http.Request) (*db.User, bool) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		handleError(w, http.StatusBadRequest)
+		return nil, false
+	}
+	user, err := s.store.GetUser(userID)
+	if err != nil {
+		if err == db.ErrNotFound {
+			handleError(w, http.StatusNotFound)
+		} else {
+			handleError(w, http.StatusInternalServerError)
+		}
+		return nil, false
+	}
+	return user, true
+}
+
+// admins can't change their own role, so there's always at least one admin left
+func (s *Server) HandleUpdateUserRole(w http.ResponseWriter, r *http.Request) {
+	current, ok := currentUser(r)
+	if !ok {
+		handleError(w, http.StatusUnprocessableEntity)
+		return
+	}
+	user, ok := s.userFromURL(w, r)
+	if !ok {
+		return
+	}
+	role := db.Role(r.FormValue("role"))
+	if !db.IsValidRole(role) {
+		handleError(w, http.StatusBadRequest)
+		return
+	}
+	if user.Id == current.Id {
+		s.renderUsersSection(w, r, "You can't change your own role.")
+		return
+	}
+	err := s.store.SetUserRole(user.Id, role)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError)
+		return
+	}
+	s.renderUsersSection(w, r, "")
+}
+
+// sets the address comment notifications are sent to, empty turns them off
+func (s *Server) HandleUpdateUserEmail(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.userFromURL(w, r)
+	if !ok {
+		return
+	}
+	email := strings.TrimSpace(r.FormValue("email"))
+	if email != "" {
+		address, err := mail.ParseAddress(email)
+		if err != nil {
+			s.renderUsersSection(w, r, "That email address doesn't look right.")
+			return
+		}
+		email = address.Address
+	}
+	err := s.store.SetUserEmail(user.Id, email)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError)
+		return
+	}
+	s.renderUsersSection(w, r, "")
+}
+
+func (s *Server) HandleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	current, ok := currentUser(r)
+	if !ok {
+		handleError(w, http.StatusUnprocessableEntity)
+		return
+	}
+	user, ok := s.userFromURL(w, r)
+	if !ok {
+		return
+	}
+	if user.Id == current.Id {
+		s.renderUsersSection(w, r, "You can't delete yourself.")
+		return
+	}
+	err := s.store.DeleteUser(user.Id)
+	if err != nil {
+		if err == db.ErrUserHasPosts {
+			s.renderUsersSection(w, r, user.Username+" still has posts, delete them first.")
+		} else {
+			handleError(w, http.StatusInternalServerError)
+		}
+		return
+	}
+	s.renderUsersSection(w, r, "")
+}
@@ -0,0 +1,388 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"personal-site/internal/config"
+	"personal-site/internal/db"
+	"personal-site/pkg/utils"
+	"regexp"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+)
+
+const (
+	webmentionTimeout = 10 * time.Second
+	// how much of a fetched page is read, links past this aren't found
+	webmentionMaxBody   = 1 << 20
+	webmentionQueueSize = 100
+	webmentionUserAgent = "personal-site webmention"
+)
+
+var (
+	errPrivateAddress        = errors.New("refusing to connect to a private address")
+	errNoWebmentionEndpoint  = errors.New("no webmention endpoint")
+	linkHeaderPattern        = regexp.MustCompile(`^\s*<([^>]*)>(.*)$`)
+	linkHeaderRelPattern     = regexp.MustCompile(`(?i);\s*rel\s*=\s*("[^"]*"|[^;\s]*)`)
+	webmentionSourceElements = map[string]string{"a": "href", "img": "src", "video": "src", "audio": "src", "source": "src"}
+)
+
+// the client used to fetch other sites, it won't connect to loopback or private
+// addresses so the webmention endpoint can't be used to probe the local network
+func newWebClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: webmentionTimeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+				ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+				return errPrivateAddress
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would be the only address checked
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: webmentionTimeout, Transport: transport}
+}
+
+func (s *Server) fetch(method string, target string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", webmentionUserAgent)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		req.Header.Set("Accept", "text/html, */*;q=0.5")
+	}
+	return s.client.Do(req)
+}
+
+func isHTML(resp *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// resolves a link against the page it's on and drops the fragment, so links to the
+// same page compare equal
+func resolveLink(base *url.URL, href string) (string, bool) {
+	u, err := base.Parse(strings.TrimSpace(href))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", false
+	}
+	u.Fragment = ""
+	return u.String(), true
+}
+
+func hasRel(rel string, value string) bool {
+	return slices.Contains(strings.Fields(strings.ToLower(rel)), value)
+}
+
+// calls fn for each element in the tree, stopping early if it returns false
+func walkHTML(n *html.Node, fn func(*html.Node) bool) bool {
+	if n.Type == html.ElementNode && !fn(n) {
+		return false
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if !walkHTML(c, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+func attr(n *html.Node, name string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == name {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+// the links in a post's content to other sites, these are the ones that get mentions
+func outboundLinks(content template.HTML) []string {
+	doc, err := html.Parse(strings.NewReader(string(content)))
+	if err != nil {
+		return nil
+	}
+	site, _ := url.Parse(config.SiteURL)
+	var links []string
+	walkHTML(doc, func(n *html.Node) bool {
+		href, ok := attr(n, "href")
+		if n.Data != "a" || !ok || !utils.IsAbsoluteURL(href) {
+			return true
+		}
+		link, ok := resolveLink(&url.URL{}, href)
+		if !ok || slices.Contains(links, link) {
+			return true
+		}
+		if u, err := url.Parse(link); err == nil && (site == nil || !strings.EqualFold(u.Host, site.Host)) {
+			links = append(links, link)
+		}
+		return true
+	})
+	return links
+}
+
+// finds where a page takes webmentions, from its Link header or else a link or a
+// element with rel="webmention"
+func (s *Server) discoverWebmentionEndpoint(target string) (string, error) {
+	resp, err := s.fetch(http.MethodGet, target, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("%s answered %s", target, resp.Status)
+	}
+	base := resp.Request.URL
+	for _, header := range resp.Header.Values("Link") {
+		for _, link := range strings.Split(header, ",") {
+			matches := linkHeaderPattern.FindStringSubmatch(link)
+			if matches == nil {
+				continue
+			}
+			for _, rel := range linkHeaderRelPattern.FindAllStringSubmatch(matches[2], -1) {
+				if hasRel(strings.Trim(rel[1], `"`), "webmention") {
+					if endpoint, ok := resolveLink(base, matches[1]); ok {
+						return endpoint, nil
+					}
+				}
+			}
+		}
+	}
+	if !isHTML(resp) {
+		return "", errNoWebmentionEndpoint
+	}
+	doc, err := html.Parse(io.LimitReader(resp.Body, webmentionMaxBody))
+	if err != nil {
+		return "", err
+	}
+	endpoint := ""
+	walkHTML(doc, func(n *html.Node) bool {
+		if n.Data != "link" && n.Data != "a" {
+			return true
+		}
+		rel, _ := attr(n, "rel")
+		href, ok := attr(n, "href")
+		if !ok || !hasRel(rel, "webmention") {
+			return true
+		}
+		// an empty href is the page itself
+		endpoint, ok = resolveLink(base, href)
+		return !ok
+	})
+	if endpoint == "" {
+		return "", errNoWebmentionEndpoint
+	}
+	return endpoint, nil
+}
+
+func (s *Server) sendWebmention(source string, target string) error {
+	endpoint, err := s.discoverWebmentionEndpoint(target)
+	if err != nil {
+		return err
+	}
+	form := url.Values{"source": {source}, "target": {target}}
+	resp, err := s.fetch(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s answered %s", endpoint, resp.Status)
+	}
+	return nil
+}
+
+// tells the sites a published post links to about it in the background. previous is
+// the content before an edit, so sites whose links were removed hear about it too
+func (s *Server) sendWebmentions(post *db.Post, previous template.HTML) {
+	if post.Status != db.Published {
+		return
+	}
+	links := outboundLinks(post.Content)
+	for _, link := range outboundLinks(previous) {
+		if !slices.Contains(links, link) {
+			links = append(links, link)
+		}
+	}
+	if len(links) == 0 {
+		return
+	}
+	source := postURL(post)
+	go func() {
+		for _, target := range links {
+			err := s.sendWebmention(source, target)
+			if err != nil && err != errNoWebmentionEndpoint {
+				log.Printf("failed to send webmention to %s: %v", target, err)
+			}
+		}
+	}()
+}
+
+// checks received mentions one at a time, starting with any left pending by the
+// last run
+func (s *Server) startWebmentionWorker() {
+	go func() {
+		pending, err := s.store.GetWebmentions(0, db.WebmentionPending)
+		if err != nil {
+			log.Printf("failed to load pending webmentions: %v", err)
+		}
+		for _, mention := range pending {
+			s.queueWebmention(mention.Id)
+		}
+	}()
+	go func() {
+		for mentionID := range s.webmentions {
+			if err := s.verifyWebmention(mentionID); err != nil {
+				log.Printf("failed to verify webmention %d: %v", mentionID, err)
+			}
+		}
+	}()
+}
+
+// a full queue leaves the mention pending until the next restart
+func (s *Server) queueWebmention(mentionID int) {
+	select {
+	case s.webmentions <- mentionID:
+	default:
+		log.Printf("webmention queue is full, leaving %d pending", mentionID)
+	}
+}
+
+// fetches a mention's source and keeps the mention only if it links to the target,
+// a source that's gone deletes the mention
+func (s *Server) verifyWebmention(mentionID int) error {
+	mention, err := s.store.GetWebmention(mentionID)
+	if err != nil {
+		if err == db.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	resp, err := s.fetch(http.MethodGet, mention.Source, nil)
+	if err != nil {
+		s.store.SetWebmentionStatus(mention.Id, db.WebmentionRejected, "", s.now())
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusGone {
+		return s.store.DeleteWebmention(mention.Id)
+	}
+	if resp.StatusCode/100 != 2 {
+		return s.store.SetWebmentionStatus(mention.Id, db.WebmentionRejected, "", s.now())
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, webmentionMaxBody))
+	if err != nil {
+		return err
+	}
+	found, title := false, ""
+	if isHTML(resp) {
+		found, title = linksTo(body, resp.Request.URL, mention.Target)
+	} else {
+		found = bytes.Contains(body, []byte(mention.Target))
+	}
+	status := db.WebmentionRejected
+	if found {
+		status = db.WebmentionVerified
+	}
+	return s.store.SetWebmentionStatus(mention.Id, status, title, s.now())
+}
+
+// reports whether an html page links to the target, along with the page's title
+func linksTo(body []byte, base *url.URL, target string) (bool, string) {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return false, ""
+	}
+	target, _ = resolveLink(&url.URL{}, target)
+	found, title := false, ""
+	walkHTML(doc, func(n *html.Node) bool {
+		if n.Data == "title" && title == "" && n.FirstChild != nil {
+			title = strings.Join(strings.Fields(n.FirstChild.Data), " ")
+		}
+		if name, ok := webmentionSourceElements[n.Data]; ok && !found {
+			value, _ := attr(n, name)
+			link, ok := resolveLink(base, value)
+			found = ok && link == target
+		}
+		return true
+	})
+	return found, title
+}
+
+// writes a webmention error as plain text, senders are other servers
+func webmentionError(w http.ResponseWriter, statusCode int, message string) {
+	http.Error(w, message, statusCode)
+}
+
+// receives webmentions, the source is checked in the background so this only
+// validates the request and answers 202
+func (s *Server) HandleWebmention(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, apiMaxBodySize)
+	if err := r.ParseForm(); err != nil {
+		webmentionError(w, http.StatusBadRequest, "expected a form with source and target")
+		return
+	}
+	source, target := r.PostForm.Get("source"), r.PostForm.Get("target")
+	if !utils.IsAbsoluteURL(source) || !utils.IsAbsoluteURL(target) {
+		webmentionError(w, http.StatusBadRequest, "source and target must be absolute http(s) urls")
+		return
+	}
+	if source == target {
+		webmentionError(w, http.StatusBadRequest, "source and target must be different")
+		return
+	}
+	u, _ := url.Parse(target)
+	site, _ := url.Parse(config.SiteURL)
+	slug, ok := postSlug(u)
+	if !ok || site == nil || !strings.EqualFold(u.Host, site.Host) {
+		webmentionError(w, http.StatusBadRequest, "target isn't a post on this site")
+		return
+	}
+	post, err := s.store.GetPostBySlug(slug)
+	if err != nil {
+		if err == db.ErrNotFound {
+			webmentionError(w, http.StatusBadRequest, "target isn't a post on this site")
+		} else {
+			webmentionError(w, http.StatusInternalServerError, "the webmention couldn't be saved")
+		}
+		return
+	}
+	now := s.now()
+	mentionID, err := s.store.SaveWebmention(&db.Webmention{
+		PostId:    post.Id,
+		Source:    source,
+		Target:    target,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	if err != nil {
+		webmentionError(w, http.StatusInternalServerError, "the webmention couldn't be saved")
+		return
+	}
+	s.queueWebmention(int(mentionID))
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,266 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"personal-site/internal/config"
+	"personal-site/internal/db"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// points SITE_URL at a fixed host for the test
+func setSiteURL(t *testing.T, siteURL string) {
+	t.Helper()
+	previous := config.SiteURL
+	config.SiteURL = siteURL
+	t.Cleanup(func() { config.SiteURL = previous })
+}
+
+// a webmention endpoint that records what it's sent
+type testEndpoint struct {
+	mu       sync.Mutex
+	received []url.Values
+	done     chan struct{}
+}
+
+func (e *testEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	e.mu.Lock()
+	e.received = append(e.received, r.PostForm)
+	e.mu.Unlock()
+	w.WriteHeader(http.StatusAccepted)
+	if e.done != nil {
+		e.done <- struct{}{}
+	}
+}
+
+func TestSendWebmention(t *testing.T) {
+	setSiteURL(t, "https://example.com")
+	s, _ := newTestServer(t)
+	endpoint := &testEndpoint{}
+	mux := http.NewServeMux()
+	mux.Handle("/endpoint", endpoint)
+	mux.HandleFunc("/link-header", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Link", `<https://other.example/feed>; rel="alternate", </endpoint>; rel="webmention"`)
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><head><link rel="webmention" href="/wrong"></head></html>`)
+	})
+	mux.HandleFunc("/link-element", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, `<html><head><link rel="stylesheet" href="/style.css"><link rel="webmention" href="endpoint"></head></html>`)
+	})
+	mux.HandleFunc("/a-element", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<p>send mentions <a rel="nofollow webmention" href="/endpoint?via=a">here</a></p>`)
+	})
+	mux.HandleFunc("/none", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<p>no endpoint</p>`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	s.client = server.Client()
+
+	tests := []struct {
+		path     string
+		endpoint string
+		err      error
+	}{
+		{"/link-header", server.URL + "/endpoint", nil},
+		{"/link-element", server.URL + "/endpoint", nil},
+		{"/a-element", server.URL + "/endpoint?via=a", nil},
+		{"/none", "", errNoWebmentionEndpoint},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := s.discoverWebmentionEndpoint(server.URL + tt.path)
+			if got != tt.endpoint || err != tt.err {
+				t.Errorf("discoverWebmentionEndpoint() = %q, %v, want %q, %v", got, err, tt.endpoint, tt.err)
+			}
+		})
+	}
+
+	// publishing a post mentions every site it links to, in the background
+	endpoint.received = nil
+	endpoint.done = make(chan struct{}, 2)
+	post := &db.Post{
+		Slug:    "linking",
+		Status:  db.Published,
+		Content: template.HTML(`<p><a href="` + server.URL + `/link-header">one</a> <a href="https://example.com/blog/hello-world">own site</a></p>`),
+	}
+	previous := template.HTML(`<p><a href="` + server.URL + `/link-element">removed</a></p>`)
+	s.sendWebmentions(post, previous)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-endpoint.done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of 2 webmentions were sent", i)
+		}
+	}
+	targets := map[string]bool{}
+	for _, form := range endpoint.received {
+		if form.Get("source") != "https://example.com/blog/linking" {
+			t.Errorf("webmention source = %q", form.Get("source"))
+		}
+		targets[form.Get("target")] = true
+	}
+	if !targets[server.URL+"/link-header"] || !targets[server.URL+"/link-element"] {
+		t.Errorf("webmentions were sent for %v, want the current and the removed link", targets)
+	}
+
+	// drafts don't send any
+	endpoint.received = nil
+	s.sendWebmentions(&db.Post{Slug: "draft", Status: db.Draft, Content: post.Content}, "")
+	select {
+	case <-endpoint.done:
+		t.Error("a draft sent a webmention")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestReceiveWebmention(t *testing.T) {
+	setSiteURL(t, "https://example.com")
+	s, store := newTestServer(t)
+	target := "https://example.com/blog/hello-world"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/links", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<html><head><title>A
+			Reply</title></head><body><a href="%s#comments">a post</a></body></html>`, target)
+	})
+	mux.HandleFunc("/no-link", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><body><a href="https://example.com/blog/other">another post</a> mentions hello-world</body></html>`)
+	})
+	mux.HandleFunc("/plain", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "see %s\n", target)
+	})
+	mux.HandleFunc("/gone", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	s.client = server.Client()
+	handler := s.Handler()
+
+	receive := func(source string, target string) *httptest.ResponseRecorder {
+		form := url.Values{"source": {source}, "target": {target}}
+		req := httptest.NewRequest(http.MethodPost, "/webmention", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	invalid := []struct {
+		name   string
+		source string
+		target string
+	}{
+		{"relative source", "/links", target},
+		{"same source and target", target, target},
+		{"target on another site", server.URL + "/links", "https://other.example/blog/hello-world"},
+		{"target that isn't a post", server.URL + "/links", "https://example.com/projects"},
+		{"missing post", server.URL + "/links", "https://example.com/blog/missing"},
+		{"draft", server.URL + "/links", "https://example.com/blog/work-in-progress"},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := receive(tt.source, tt.target); rec.Code != http.StatusBadRequest {
+				t.Errorf("POST /webmention = %d, want 400", rec.Code)
+			}
+		})
+	}
+
+	tests := []struct {
+		path   string
+		status db.WebmentionStatus
+		title  string
+	}{
+		{"/links", db.WebmentionVerified, "A Reply"},
+		{"/no-link", db.WebmentionRejected, ""},
+		{"/plain", db.WebmentionVerified, ""},
+		{"/missing", db.WebmentionRejected, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if rec := receive(server.URL+tt.path, target); rec.Code != http.StatusAccepted {
+				t.Fatalf("POST /webmention = %d, want 202", rec.Code)
+			}
+			mentionID := <-s.webmentions
+			if mention, err := store.GetWebmention(mentionID); err != nil || mention.Status != db.WebmentionPending {
+				t.Fatalf("received webmention = %+v, %v, want it pending", mention, err)
+			}
+			s.verifyWebmention(mentionID)
+			mention, err := store.GetWebmention(mentionID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mention.Status != tt.status || mention.Title != tt.title {
+				t.Errorf("verified webmention = %q %q, want %q %q", mention.Status, mention.Title, tt.status, tt.title)
+			}
+		})
+	}
+
+	// a source that's gone takes its mention with it
+	now := time.Now()
+	mentionID, err := store.SaveWebmention(&db.Webmention{PostId: 1, Source: server.URL + "/gone", Target: target, CreatedAt: now, UpdatedAt: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetWebmentionStatus(int(mentionID), db.WebmentionVerified, "Was Here", now); err != nil {
+		t.Fatal(err)
+	}
+	if rec := receive(server.URL+"/gone", target); rec.Code != http.StatusAccepted {
+		t.Fatalf("POST /webmention for a deleted source = %d, want 202", rec.Code)
+	}
+	if err := s.verifyWebmention(<-s.webmentions); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetWebmention(int(mentionID)); err != db.ErrNotFound {
+		t.Errorf("GetWebmention() after the source answered 410 = %v, want ErrNotFound", err)
+	}
+}
+
+func TestWebClientRefusesPrivateAddresses(t *testing.T) {
+	setSiteURL(t, "https://example.com")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("the guarded client reached %s", r.URL)
+	}))
+	defer server.Close()
+
+	client := newWebClient()
+	for _, target := range []string{server.URL, "http://10.0.0.1:1/", "http://[::1]:1/", "http://169.254.169.254/latest/meta-data"} {
+		resp, err := client.Get(target)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if !errors.Is(err, errPrivateAddress) {
+			t.Errorf("GET %s = %v, want errPrivateAddress", target, err)
+		}
+	}
+
+	// a mention whose source is on the local network is rejected without fetching it
+	s, store := newTestServer(t)
+	now := time.Now()
+	mentionID, err := store.SaveWebmention(&db.Webmention{
+		PostId: 1, Source: server.URL + "/admin", Target: "https://example.com/blog/hello-world", CreatedAt: now, UpdatedAt: now,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.verifyWebmention(int(mentionID)); !errors.Is(err, errPrivateAddress) {
+		t.Errorf("verifyWebmention() = %v, want errPrivateAddress", err)
+	}
+	if mention, err := store.GetWebmention(int(mentionID)); err != nil || mention.Status != db.WebmentionRejected {
+		t.Errorf("webmention from a private address = %+v, %v, want it rejected", mention, err)
+	}
+}
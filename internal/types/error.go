@@ -1,5 +1,7 @@
 package types
 
+import "encoding/json"
+
 type StatusError struct {
 	Error  error
 	Status int
@@ -13,9 +15,20 @@ func (e StatusError) HTTPStatus() int {
 	return e.Status
 }
 
+// the body of api error responses, {"error": {"status": 404, "message": "Not Found"}}
+func (e StatusError) MarshalJSON() ([]byte, error) {
+	type body struct {
+		Status  int    `json:"status"`
+		Message string `json:"message"`
+	}
+	return json.Marshal(struct {
+		Error body `json:"error"`
+	}{body{Status: e.Status, Message: e.Error.Error()}})
+}
+
 func NewStatusError(err error, status int) StatusError {
 	return StatusError{
 		Error:  err,
 		Status: status,
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,105 @@
+package diff
+
+import "strings"
+
+type Op int
+
+const (
+	Equal Op = iota
+	Insert
+	Delete
+)
+
+type Line struct {
+	Op   Op
+	Text string
+	// 1-based line numbers in the old and new text, 0 when the line isn't present
+	OldNum int
+	NewNum int
+}
+
+func (l Line) IsInsert() bool { return l.Op == Insert }
+func (l Line) IsDelete() bool { return l.Op == Delete }
+
+// the most cells the lcs table may have, about 32MB. the table grows with the product
+// of both sides, so past this the changed region is shown as removed and re-added
+// whole instead of being matched line by line
+const maxTableCells = 1 << 22
+
+// computes a line-level diff between a and b using the longest common subsequence
+func Lines(a, b string) []Line {
+	oldLines := splitLines(a)
+	newLines := splitLines(b)
+
+	// trim the common prefix and suffix so the lcs table only covers the changed region
+	prefix := 0
+	for prefix < len(oldLines) && prefix < len(newLines) && oldLines[prefix] == newLines[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(oldLines)-prefix && suffix < len(newLines)-prefix &&
+		oldLines[len(oldLines)-1-suffix] == newLines[len(newLines)-1-suffix] {
+		suffix++
+	}
+	oldMid := oldLines[prefix : len(oldLines)-suffix]
+	newMid := newLines[prefix : len(newLines)-suffix]
+
+	// lcs[i][j] is the length of the lcs of oldMid[i:] and newMid[j:], nil when the
+	// table would be too big
+	var lcs [][]int
+	if len(oldMid) == 0 || len(newMid) <= maxTableCells/len(oldMid) {
+		lcs = make([][]int, len(oldMid)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(newMid)+1)
+		}
+		for i := len(oldMid) - 1; i >= 0; i-- {
+			for j := len(newMid) - 1; j >= 0; j-- {
+				if oldMid[i] == newMid[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+	}
+
+	result := make([]Line, 0, len(oldLines)+len(newLines))
+	oldNum, newNum := 0, 0
+	equal := func(text string) {
+		oldNum++
+		newNum++
+		result = append(result, Line{Op: Equal, Text: text, OldNum: oldNum, NewNum: newNum})
+	}
+	for _, line := range oldLines[:prefix] {
+		equal(line)
+	}
+	i, j := 0, 0
+	for i < len(oldMid) || j < len(newMid) {
+		switch {
+		case lcs != nil && i < len(oldMid) && j < len(newMid) && oldMid[i] == newMid[j]:
+			equal(oldMid[i])
+			i++
+			j++
+		case i < len(oldMid) && (lcs == nil || j == len(newMid) || lcs[i+1][j] >= lcs[i][j+1]):
+			oldNum++
+			result = append(result, Line{Op: Delete, Text: oldMid[i], OldNum: oldNum})
+			i++
+		default:
+			newNum++
+			result = append(result, Line{Op: Insert, Text: newMid[j], NewNum: newNum})
+			j++
+		}
+	}
+	for _, line := range oldLines[len(oldLines)-suffix:] {
+		equal(line)
+	}
+	return result
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
@@ -0,0 +1,144 @@
+package diff
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want []Line
+	}{
+		{
+			name: "identical",
+			a:    "one\ntwo\n",
+			b:    "one\ntwo\n",
+			want: []Line{
+				{Op: Equal, Text: "one", OldNum: 1, NewNum: 1},
+				{Op: Equal, Text: "two", OldNum: 2, NewNum: 2},
+			},
+		},
+		{
+			name: "both empty",
+			a:    "",
+			b:    "",
+			want: []Line{},
+		},
+		{
+			name: "insertion in the middle",
+			a:    "one\nthree",
+			b:    "one\ntwo\nthree",
+			want: []Line{
+				{Op: Equal, Text: "one", OldNum: 1, NewNum: 1},
+				{Op: Insert, Text: "two", NewNum: 2},
+				{Op: Equal, Text: "three", OldNum: 2, NewNum: 3},
+			},
+		},
+		{
+			name: "deletion in the middle",
+			a:    "one\ntwo\nthree",
+			b:    "one\nthree",
+			want: []Line{
+				{Op: Equal, Text: "one", OldNum: 1, NewNum: 1},
+				{Op: Delete, Text: "two", OldNum: 2},
+				{Op: Equal, Text: "three", OldNum: 3, NewNum: 2},
+			},
+		},
+		{
+			name: "change at the start",
+			a:    "one\ntwo\nthree",
+			b:    "uno\ntwo\nthree",
+			want: []Line{
+				{Op: Delete, Text: "one", OldNum: 1},
+				{Op: Insert, Text: "uno", NewNum: 1},
+				{Op: Equal, Text: "two", OldNum: 2, NewNum: 2},
+				{Op: Equal, Text: "three", OldNum: 3, NewNum: 3},
+			},
+		},
+		{
+			name: "change at the end",
+			a:    "one\ntwo\nthree",
+			b:    "one\ntwo\ntres",
+			want: []Line{
+				{Op: Equal, Text: "one", OldNum: 1, NewNum: 1},
+				{Op: Equal, Text: "two", OldNum: 2, NewNum: 2},
+				{Op: Delete, Text: "three", OldNum: 3},
+				{Op: Insert, Text: "tres", NewNum: 3},
+			},
+		},
+		{
+			name: "everything added",
+			a:    "",
+			b:    "one\ntwo",
+			want: []Line{
+				{Op: Insert, Text: "one", NewNum: 1},
+				{Op: Insert, Text: "two", NewNum: 2},
+			},
+		},
+		{
+			name: "everything removed",
+			a:    "one\ntwo",
+			b:    "",
+			want: []Line{
+				{Op: Delete, Text: "one", OldNum: 1},
+				{Op: Delete, Text: "two", OldNum: 2},
+			},
+		},
+		{
+			name: "windows line endings match unix ones",
+			a:    "one\r\ntwo\r\n",
+			b:    "one\ntwo",
+			want: []Line{
+				{Op: Equal, Text: "one", OldNum: 1, NewNum: 1},
+				{Op: Equal, Text: "two", OldNum: 2, NewNum: 2},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Lines(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Lines(%q, %q) =\n%+v\nwant\n%+v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+// a changed region too big for the lcs table is removed and re-added whole
+func TestLinesTooBigToMatch(t *testing.T) {
+	var oldLines, newLines []string
+	for i := 0; i < 2100; i++ {
+		oldLines = append(oldLines, fmt.Sprintf("old %d", i))
+		newLines = append(newLines, fmt.Sprintf("new %d", i))
+	}
+	// a line both sides share is no longer matched up
+	oldLines[1000] = "shared"
+	newLines[1000] = "shared"
+	a := "first\n" + strings.Join(oldLines, "\n") + "\nlast"
+	b := "first\n" + strings.Join(newLines, "\n") + "\nlast"
+
+	got := Lines(a, b)
+	if len(got) != 2+len(oldLines)+len(newLines) {
+		t.Fatalf("Lines() = %d lines, want %d", len(got), 2+len(oldLines)+len(newLines))
+	}
+	if got[0] != (Line{Op: Equal, Text: "first", OldNum: 1, NewNum: 1}) {
+		t.Errorf("first line = %+v", got[0])
+	}
+	for i, text := range oldLines {
+		if want := (Line{Op: Delete, Text: text, OldNum: i + 2}); got[1+i] != want {
+			t.Fatalf("line %d = %+v, want %+v", 1+i, got[1+i], want)
+		}
+	}
+	for i, text := range newLines {
+		if want := (Line{Op: Insert, Text: text, NewNum: i + 2}); got[1+len(oldLines)+i] != want {
+			t.Fatalf("line %d = %+v, want %+v", 1+len(oldLines)+i, got[1+len(oldLines)+i], want)
+		}
+	}
+	if last := got[len(got)-1]; last != (Line{Op: Equal, Text: "last", OldNum: 2102, NewNum: 2102}) {
+		t.Errorf("last line = %+v", last)
+	}
+}
@@ -0,0 +1,195 @@
+package feed
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testFeed() *Feed {
+	published := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	return &Feed{
+		Title:       "Blog",
+		Link:        "https://example.com/blog",
+		FeedLink:    "https://example.com/blog/feed.xml",
+		Description: "a blog",
+		Author:      "Someone",
+		Updated:     published.Add(time.Hour),
+		Items: []*Item{{
+			Id:          "https://example.com/blog/hello",
+			Title:       "Hello & Welcome",
+			Link:        "https://example.com/blog/hello",
+			Description: "a first post",
+			Content:     `<p>hi <a href="/x">there</a></p>`,
+			Tags:        []string{"go", "web"},
+			Published:   published,
+			Updated:     published.Add(time.Hour),
+		}},
+	}
+}
+
+func TestRSS(t *testing.T) {
+	out, err := testFeed().RSS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		XMLName xml.Name
+		Version string `xml:"version,attr"`
+		Channel struct {
+			Title         string `xml:"title"`
+			LastBuildDate string `xml:"lastBuildDate"`
+			// the channel's link and the atom:link to the feed itself
+			Links []struct {
+				XMLName xml.Name
+				Href    string `xml:"href,attr"`
+				Rel     string `xml:"rel,attr"`
+				Value   string `xml:",chardata"`
+			} `xml:"link"`
+			Items []struct {
+				Title   string   `xml:"title"`
+				Link    string   `xml:"link"`
+				Guid    string   `xml:"guid"`
+				Content string   `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+				PubDate string   `xml:"pubDate"`
+				Tags    []string `xml:"category"`
+			} `xml:"item"`
+		} `xml:"channel"`
+	}
+	if err := xml.Unmarshal(out, &doc); err != nil {
+		t.Fatalf("RSS() isn't valid xml: %v\n%s", err, out)
+	}
+	if doc.XMLName.Local != "rss" || doc.Version != "2.0" {
+		t.Errorf("root = <%s version=%q>, want <rss version=\"2.0\">", doc.XMLName.Local, doc.Version)
+	}
+	channel := doc.Channel
+	if channel.Title != "Blog" || channel.LastBuildDate != "Tue, 02 Jan 2024 04:04:05 +0000" {
+		t.Errorf("channel = %+v", channel)
+	}
+	if len(channel.Links) != 2 || channel.Links[0].Value != "https://example.com/blog" {
+		t.Fatalf("channel links = %+v", channel.Links)
+	}
+	if atom := channel.Links[1]; atom.XMLName.Space != "http://www.w3.org/2005/Atom" || atom.Href != "https://example.com/blog/feed.xml" || atom.Rel != "self" {
+		t.Errorf("atom:link = %+v, want the feed's own url", atom)
+	}
+	if len(channel.Items) != 1 {
+		t.Fatalf("%d items, want 1", len(channel.Items))
+	}
+	item := channel.Items[0]
+	if item.Title != "Hello & Welcome" || item.Guid != item.Link || item.PubDate != "Tue, 02 Jan 2024 03:04:05 +0000" {
+		t.Errorf("item = %+v", item)
+	}
+	if item.Content != `<p>hi <a href="/x">there</a></p>` || !reflect.DeepEqual(item.Tags, []string{"go", "web"}) {
+		t.Errorf("item content = %q, tags = %v", item.Content, item.Tags)
+	}
+	if !strings.Contains(string(out), "<![CDATA[<p>hi") {
+		t.Errorf("content:encoded should be cdata:\n%s", out)
+	}
+}
+
+func TestAtom(t *testing.T) {
+	out, err := testFeed().Atom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		XMLName xml.Name
+		Id      string `xml:"id"`
+		Updated string `xml:"updated"`
+		Author  string `xml:"author>name"`
+		Links   []struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+		} `xml:"link"`
+		Entries []struct {
+			Id        string `xml:"id"`
+			Published string `xml:"published"`
+			Updated   string `xml:"updated"`
+			Content   struct {
+				Type  string `xml:"type,attr"`
+				Value string `xml:",chardata"`
+			} `xml:"content"`
+			Categories []struct {
+				Term string `xml:"term,attr"`
+			} `xml:"category"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(out, &doc); err != nil {
+		t.Fatalf("Atom() isn't valid xml: %v\n%s", err, out)
+	}
+	if doc.XMLName.Space != "http://www.w3.org/2005/Atom" || doc.XMLName.Local != "feed" {
+		t.Errorf("root = %v, want an atom feed", doc.XMLName)
+	}
+	if doc.Id != "https://example.com/blog/feed.xml" || doc.Updated != "2024-01-02T04:04:05Z" || doc.Author != "Someone" {
+		t.Errorf("feed = %+v", doc)
+	}
+	if len(doc.Links) != 2 || doc.Links[0].Rel != "alternate" || doc.Links[1].Rel != "self" {
+		t.Errorf("links = %+v, want alternate and self", doc.Links)
+	}
+	if len(doc.Entries) != 1 {
+		t.Fatalf("%d entries, want 1", len(doc.Entries))
+	}
+	entry := doc.Entries[0]
+	if entry.Published != "2024-01-02T03:04:05Z" || entry.Updated != "2024-01-02T04:04:05Z" {
+		t.Errorf("entry dates = %q, %q", entry.Published, entry.Updated)
+	}
+	if entry.Content.Type != "html" || entry.Content.Value != `<p>hi <a href="/x">there</a></p>` || len(entry.Categories) != 2 {
+		t.Errorf("entry = %+v", entry)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	out, err := testFeed().JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(out, &doc); err != nil {
+		t.Fatalf("JSON() isn't valid json: %v\n%s", err, out)
+	}
+	want := map[string]any{
+		"version":       "https://jsonfeed.org/version/1.1",
+		"title":         "Blog",
+		"home_page_url": "https://example.com/blog",
+		"feed_url":      "https://example.com/blog/feed.xml",
+		"description":   "a blog",
+		"authors":       []any{map[string]any{"name": "Someone"}},
+		"items": []any{map[string]any{
+			"id":             "https://example.com/blog/hello",
+			"url":            "https://example.com/blog/hello",
+			"title":          "Hello & Welcome",
+			"content_html":   `<p>hi <a href="/x">there</a></p>`,
+			"summary":        "a first post",
+			"date_published": "2024-01-02T03:04:05Z",
+			"date_modified":  "2024-01-02T04:04:05Z",
+			"tags":           []any{"go", "web"},
+		}},
+	}
+	if !reflect.DeepEqual(doc, want) {
+		t.Errorf("JSON() =\n%s\nwant\n%v", out, want)
+	}
+	if strings.Contains(string(out), `\u003c`) {
+		t.Errorf("content_html shouldn't have its html escaped:\n%s", out)
+	}
+}
+
+func TestEmptyFeeds(t *testing.T) {
+	f := &Feed{Title: "Blog", Link: "https://example.com/blog", FeedLink: "https://example.com/blog/feed.json"}
+	out, err := f.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), `"items": []`) {
+		t.Errorf("an empty json feed should have an empty items array:\n%s", out)
+	}
+	out, err = f.RSS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), "lastBuildDate") {
+		t.Errorf("an empty rss feed shouldn't have a lastBuildDate:\n%s", out)
+	}
+}
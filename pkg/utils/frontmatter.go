@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const frontMatterDelimiter = "---"
+
+var slugPattern = regexp.MustCompile("^[a-z0-9]+(-[a-z0-9]+)*$")
+
+type FrontMatter struct {
+	Title        string    `yaml:"title"`
+	Slug         string    `yaml:"slug"`
+	Date         time.Time `yaml:"date"`
+	Description  string    `yaml:"description"`
+	Tags         []string  `yaml:"tags"`
+	Draft        bool      `yaml:"draft"`
+	CoverImage   string    `yaml:"cover_image"`
+	CanonicalURL string    `yaml:"canonical_url"`
+	// nil leaves it to the length of the post
+	TOC *bool `yaml:"toc"`
+}
+
+// FrontMatterError is returned when the front matter of a markdown file is malformed
+type FrontMatterError struct {
+	Reason string
+}
+
+func (e *FrontMatterError) Error() string {
+	return "invalid front matter: " + e.Reason
+}
+
+// splits a markdown file into its yaml front matter and body, files without
+// front matter return an empty FrontMatter and the contents unchanged
+func ParseFrontMatter(contents string) (*FrontMatter, string, error) {
+	fm := new(FrontMatter)
+	contents = strings.TrimPrefix(contents, "\ufeff")
+	contents = strings.ReplaceAll(contents, "\r\n", "\n")
+
+	firstLine, rest, _ := strings.Cut(contents, "\n")
+	if strings.TrimSpace(firstLine) != frontMatterDelimiter {
+		return fm, contents, nil
+	}
+
+	// find the closing delimiter, which has to be on a line of its own
+	var raw, body string
+	found := false
+	lines := strings.SplitAfter(rest, "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) == frontMatterDelimiter {
+			raw = strings.Join(lines[:i], "")
+			body = strings.Join(lines[i+1:], "")
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, "", &FrontMatterError{Reason: "missing closing " + frontMatterDelimiter}
+	}
+
+	decoder := yaml.NewDecoder(strings.NewReader(raw))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(fm); err != nil && !errors.Is(err, io.EOF) {
+		return nil, "", &FrontMatterError{Reason: err.Error()}
+	}
+	if err := fm.validate(); err != nil {
+		return nil, "", err
+	}
+	return fm, strings.TrimLeft(body, "\n"), nil
+}
+
+// reports whether s is made of lowercase letters, numbers and single hyphens
+func IsValidSlug(s string) bool {
+	return slugPattern.MatchString(s)
+}
+
+// reports whether s is an absolute http(s) url
+func IsAbsoluteURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func (fm *FrontMatter) validate() error {
+	if fm.Slug != "" && !IsValidSlug(fm.Slug) {
+		return &FrontMatterError{Reason: fmt.Sprintf("slug %q may only contain lowercase letters, numbers and hyphens", fm.Slug)}
+	}
+	for _, tag := range fm.Tags {
+		if tag == "" || strings.ContainsAny(tag, " \t") {
+			return &FrontMatterError{Reason: fmt.Sprintf("tag %q must be a single non-empty word", tag)}
+		}
+	}
+	if fm.CanonicalURL != "" && !IsAbsoluteURL(fm.CanonicalURL) {
+		return &FrontMatterError{Reason: fmt.Sprintf("canonical_url %q must be an absolute http(s) url", fm.CanonicalURL)}
+	}
+	return nil
+}
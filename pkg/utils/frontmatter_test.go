@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseFrontMatter(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		want     FrontMatter
+		body     string
+	}{
+		{
+			name:     "no front matter",
+			contents: "# Hello\n\nworld\n",
+			body:     "# Hello\n\nworld\n",
+		},
+		{
+			name: "all fields",
+			contents: "---\ntitle: Hello, World\nslug: hello-world\ndate: 2024-01-02T03:04:05Z\n" +
+				"description: a first post\ntags: [go, web]\ndraft: true\ncover_image: /media/cover.png\n" +
+				"canonical_url: https://example.com/hello\n---\n\n# Hello\n",
+			want: FrontMatter{
+				Title:        "Hello, World",
+				Slug:         "hello-world",
+				Date:         time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+				Description:  "a first post",
+				Tags:         []string{"go", "web"},
+				Draft:        true,
+				CoverImage:   "/media/cover.png",
+				CanonicalURL: "https://example.com/hello",
+			},
+			body: "# Hello\n",
+		},
+		{
+			name:     "slug with numbers",
+			contents: "---\nslug: 2024-in-review\n---\nbody",
+			want:     FrontMatter{Slug: "2024-in-review"},
+			body:     "body",
+		},
+		{
+			name:     "windows line endings and a byte order mark",
+			contents: "\ufeff---\r\ntitle: Hello\r\n---\r\nbody\r\n",
+			want:     FrontMatter{Title: "Hello"},
+			body:     "body\n",
+		},
+		{
+			name:     "empty front matter",
+			contents: "---\n---\nbody",
+			body:     "body",
+		},
+		{
+			// only the first line opens front matter
+			name:     "rule later in the file",
+			contents: "body\n\n---\n\nmore",
+			body:     "body\n\n---\n\nmore",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fm, body, err := ParseFrontMatter(tt.contents)
+			if err != nil {
+				t.Fatalf("ParseFrontMatter() error = %v", err)
+			}
+			if fm.Title != tt.want.Title || fm.Slug != tt.want.Slug || !fm.Date.Equal(tt.want.Date) ||
+				fm.Description != tt.want.Description || fm.Draft != tt.want.Draft ||
+				fm.CoverImage != tt.want.CoverImage || fm.CanonicalURL != tt.want.CanonicalURL {
+				t.Errorf("ParseFrontMatter() = %+v, want %+v", *fm, tt.want)
+			}
+			if len(fm.Tags) != len(tt.want.Tags) {
+				t.Errorf("tags = %q, want %q", fm.Tags, tt.want.Tags)
+			}
+			for i := range fm.Tags {
+				if fm.Tags[i] != tt.want.Tags[i] {
+					t.Errorf("tags = %q, want %q", fm.Tags, tt.want.Tags)
+					break
+				}
+			}
+			if body != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestParseFrontMatterErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+	}{
+		{"unclosed", "---\ntitle: Hello\n\nbody"},
+		{"invalid yaml", "---\ntitle: [Hello\n---\nbody"},
+		{"unknown field", "---\nauthor: me\n---\nbody"},
+		{"uppercase slug", "---\nslug: Hello\n---\nbody"},
+		{"slug with spaces", "---\nslug: hello world\n---\nbody"},
+		{"slug with double hyphen", "---\nslug: hello--world\n---\nbody"},
+		{"tag with spaces", "---\ntags: [hello world]\n---\nbody"},
+		{"empty tag", "---\ntags: ['']\n---\nbody"},
+		{"relative canonical url", "---\ncanonical_url: /hello\n---\nbody"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := ParseFrontMatter(tt.contents)
+			var fmErr *FrontMatterError
+			if !errors.As(err, &fmErr) {
+				t.Errorf("ParseFrontMatter() error = %v, want a *FrontMatterError", err)
+			}
+		})
+	}
+}
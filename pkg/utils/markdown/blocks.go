@@ -0,0 +1,260 @@
+package markdown
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Block renders a custom block around its markdown content. a block registered as
+// "note" is written either as a container
+//
+//	:::note Optional title
+//	content
+//	:::
+//
+// or as a callout, a blockquote starting with its name
+//
+//	> [!NOTE] Optional title
+//	> content
+type Block interface {
+	// writes the html before the content, title is already escaped and may be empty
+	Open(w io.Writer, title string)
+	// writes the html after the content
+	Close(w io.Writer)
+}
+
+var blocks = make(map[string]Block)
+
+// RegisterBlock adds a block type, names are case insensitive. it's meant to be called
+// from an init function, blocks can't be registered while markdown is being parsed
+func RegisterBlock(name string, block Block) {
+	blocks[strings.ToLower(name)] = block
+}
+
+func lookupBlock(name string) (Block, bool) {
+	block, ok := blocks[strings.ToLower(name)]
+	return block, ok
+}
+
+// a callout is an aside with a title, which defaults to its label
+type callout struct {
+	kind  string
+	label string
+}
+
+func (c *callout) Open(w io.Writer, title string) {
+	if title == "" {
+		title = c.label
+	}
+	fmt.Fprintf(w, "<aside class=\"callout callout-%s\">\n<p class=\"callout-title\">%s</p>\n", c.kind, title)
+}
+
+func (c *callout) Close(w io.Writer) {
+	io.WriteString(w, "</aside>\n")
+}
+
+// a collapsed section, the title is what's shown while it's closed
+type details struct{}
+
+func (d *details) Open(w io.Writer, title string) {
+	if title == "" {
+		title = "Details"
+	}
+	fmt.Fprintf(w, "<details>\n<summary>%s</summary>\n", title)
+}
+
+func (d *details) Close(w io.Writer) {
+	io.WriteString(w, "</details>\n")
+}
+
+func init() {
+	// the same kinds github and obsidian have
+	RegisterBlock("note", &callout{kind: "note", label: "Note"})
+	RegisterBlock("tip", &callout{kind: "tip", label: "Tip"})
+	RegisterBlock("important", &callout{kind: "important", label: "Important"})
+	RegisterBlock("warning", &callout{kind: "warning", label: "Warning"})
+	RegisterBlock("caution", &callout{kind: "caution", label: "Caution"})
+	RegisterBlock("details", &details{})
+}
+
+// blockExtension parses the registered blocks, both as ::: containers and as callouts
+type blockExtension struct{}
+
+func (e *blockExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithBlockParsers(util.Prioritized(&containerParser{}, 150)),
+		parser.WithASTTransformers(util.Prioritized(&calloutTransformer{}, 100)),
+	)
+	m.Renderer().AddOptions(
+		renderer.WithNodeRenderers(util.Prioritized(&blockRenderer{}, 150)),
+	)
+}
+
+var kindCustomBlock = ast.NewNodeKind("CustomBlock")
+
+type customBlock struct {
+	ast.BaseBlock
+	block Block
+	title string
+	// how many colons opened a container, the closing line needs the same number
+	fence int
+}
+
+func (n *customBlock) Kind() ast.NodeKind {
+	return kindCustomBlock
+}
+
+func (n *customBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"title": n.title}, nil)
+}
+
+// counts the colons a line starts with
+func colonFence(line []byte) int {
+	n := 0
+	for n < len(line) && line[n] == ':' {
+		n++
+	}
+	return n
+}
+
+type containerParser struct{}
+
+func (p *containerParser) Trigger() []byte {
+	return []byte{':'}
+}
+
+func (p *containerParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, segment := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 {
+		return nil, parser.NoChildren
+	}
+	fence := colonFence(line[pos:])
+	if fence < 3 {
+		return nil, parser.NoChildren
+	}
+	name, title, _ := strings.Cut(strings.TrimSpace(string(line[pos+fence:])), " ")
+	block, ok := lookupBlock(name)
+	if !ok {
+		return nil, parser.NoChildren
+	}
+	advanceLine(reader, line, segment)
+	return &customBlock{block: block, title: blockTitle(title), fence: fence}, parser.HasChildren
+}
+
+// a container closes on a line of just as many colons as opened it, so containers
+// nest by giving the outer one more colons
+func (p *containerParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	line, segment := reader.PeekLine()
+	w, pos := util.IndentWidth(line, reader.LineOffset())
+	if w < 4 {
+		fence := colonFence(line[pos:])
+		if fence == node.(*customBlock).fence && util.IsBlank(line[pos+fence:]) {
+			advanceLine(reader, line, segment)
+			return parser.Close
+		}
+	}
+	return parser.Continue | parser.HasChildren
+}
+
+func (p *containerParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+
+func (p *containerParser) CanInterruptParagraph() bool {
+	return true
+}
+
+func (p *containerParser) CanAcceptIndentedLine() bool {
+	return false
+}
+
+// matches the first line of a callout, [!NAME] and an optional title
+var calloutMarker = regexp.MustCompile(`^\[!([A-Za-z][A-Za-z0-9-]*)\][ \t]*(.*?)\s*$`)
+
+// calloutTransformer turns blockquotes starting with [!NAME] into the block registered
+// under that name, other blockquotes are left alone
+type calloutTransformer struct{}
+
+func (t *calloutTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	quotes := make([]*ast.Blockquote, 0)
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if quote, ok := n.(*ast.Blockquote); ok && entering {
+			quotes = append(quotes, quote)
+		}
+		return ast.WalkContinue, nil
+	})
+	for _, quote := range quotes {
+		paragraph, ok := quote.FirstChild().(*ast.Paragraph)
+		if !ok || paragraph.Lines().Len() == 0 {
+			continue
+		}
+		first := paragraph.Lines().At(0)
+		match := calloutMarker.FindSubmatch(first.Value(source))
+		if match == nil {
+			continue
+		}
+		block, ok := lookupBlock(string(match[1]))
+		if !ok {
+			continue
+		}
+		// the marker line becomes the title, the rest of the paragraph stays content
+		for child := paragraph.FirstChild(); child != nil; {
+			if start := inlineStart(child); start < 0 || start >= first.Stop {
+				break
+			}
+			next := child.NextSibling()
+			paragraph.RemoveChild(paragraph, child)
+			child = next
+		}
+		if !paragraph.HasChildren() {
+			quote.RemoveChild(quote, paragraph)
+		}
+		node := &customBlock{block: block, title: blockTitle(string(match[2]))}
+		for child := quote.FirstChild(); child != nil; {
+			next := child.NextSibling()
+			node.AppendChild(node, child)
+			child = next
+		}
+		quote.Parent().ReplaceChild(quote.Parent(), quote, node)
+	}
+}
+
+// where an inline node starts in the source, found through its first text
+func inlineStart(n ast.Node) int {
+	for ; n != nil; n = n.FirstChild() {
+		if t, ok := n.(*ast.Text); ok {
+			return t.Segment.Start
+		}
+	}
+	return -1
+}
+
+// titles are plain text
+func blockTitle(title string) string {
+	return string(util.EscapeHTML([]byte(strings.TrimSpace(title))))
+}
+
+type blockRenderer struct{}
+
+func (r *blockRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindCustomBlock, r.renderCustomBlock)
+}
+
+func (r *blockRenderer) renderCustomBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	n := node.(*customBlock)
+	if entering {
+		n.block.Open(w, n.title)
+	} else {
+		n.block.Close(w)
+	}
+	return ast.WalkContinue, nil
+}
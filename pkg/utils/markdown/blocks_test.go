@@ -0,0 +1,120 @@
+package markdown
+
+import (
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestContainers(t *testing.T) {
+	runGolden(t, []goldenTest{
+		{
+			name:  "container",
+			input: ":::note\nhi\n:::\n",
+			want:  "<aside class=\"callout callout-note\">\n<p class=\"callout-title\">Note</p>\n<p>hi</p>\n</aside>\n",
+		},
+		{
+			name:  "names are case insensitive",
+			input: ":::NOTE\nhi\n:::\n",
+			want:  "<aside class=\"callout callout-note\">\n<p class=\"callout-title\">Note</p>\n<p>hi</p>\n</aside>\n",
+		},
+		{
+			name:  "title is escaped",
+			input: ":::tip Use <b>this</b>\nhi\n:::\n",
+			want:  "<aside class=\"callout callout-tip\">\n<p class=\"callout-title\">Use &lt;b&gt;this&lt;/b&gt;</p>\n<p>hi</p>\n</aside>\n",
+		},
+		{
+			name:  "nested with more colons outside",
+			input: "::::details More\n:::warning\ninner\n:::\nouter\n::::\n",
+			want:  "<details>\n<summary>More</summary>\n<aside class=\"callout callout-warning\">\n<p class=\"callout-title\">Warning</p>\n<p>inner</p>\n</aside>\n<p>outer</p>\n</details>\n",
+		},
+		{
+			// the first closing line matches the outer container, which closes both
+			name:  "nested with as many colons",
+			input: ":::note\n:::tip\nx\n:::\n:::\n",
+			want:  "<aside class=\"callout callout-note\">\n<p class=\"callout-title\">Note</p>\n<aside class=\"callout callout-tip\">\n<p class=\"callout-title\">Tip</p>\n<p>x</p>\n</aside>\n</aside>\n<p>:::</p>\n",
+		},
+		{
+			name:  "interrupts a paragraph",
+			input: "para\n:::note\nx\n:::\n",
+			want:  "<p>para</p>\n<aside class=\"callout callout-note\">\n<p class=\"callout-title\">Note</p>\n<p>x</p>\n</aside>\n",
+		},
+		{
+			name:  "unclosed",
+			input: ":::note\nunclosed\n",
+			want:  "<aside class=\"callout callout-note\">\n<p class=\"callout-title\">Note</p>\n<p>unclosed</p>\n</aside>\n",
+		},
+		{
+			name:  "unknown name",
+			input: ":::nosuch Title\nhi\n:::\n",
+			want:  "<p>:::nosuch Title<br>\nhi<br>\n:::</p>\n",
+		},
+		{
+			name:  "too few colons",
+			input: "::note\nx\n::\n",
+			want:  "<p>::note<br>\nx<br>\n::</p>\n",
+		},
+	})
+}
+
+func TestCallouts(t *testing.T) {
+	runGolden(t, []goldenTest{
+		{
+			name:  "callout",
+			input: "> [!NOTE]\n> hi\n",
+			want:  "<aside class=\"callout callout-note\">\n<p class=\"callout-title\">Note</p>\n<p>hi</p>\n</aside>\n",
+		},
+		{
+			name:  "title and paragraphs",
+			input: "> [!warning] Careful & quick\n> first\n>\n> second\n",
+			want:  "<aside class=\"callout callout-warning\">\n<p class=\"callout-title\">Careful &amp; quick</p>\n<p>first</p>\n<p>second</p>\n</aside>\n",
+		},
+		{
+			name:  "nested callouts",
+			input: "> [!NOTE]\n> > [!TIP]\n> > inner\n",
+			want:  "<aside class=\"callout callout-note\">\n<p class=\"callout-title\">Note</p>\n<aside class=\"callout callout-tip\">\n<p class=\"callout-title\">Tip</p>\n<p>inner</p>\n</aside>\n</aside>\n",
+		},
+		{
+			name:  "callout in a container",
+			input: ":::note\n> [!TIP]\n> inner\n:::\n",
+			want:  "<aside class=\"callout callout-note\">\n<p class=\"callout-title\">Note</p>\n<aside class=\"callout callout-tip\">\n<p class=\"callout-title\">Tip</p>\n<p>inner</p>\n</aside>\n</aside>\n",
+		},
+		{
+			name:  "unknown name",
+			input: "> [!NOSUCH]\n> hi\n",
+			want:  "<blockquote>\n<p>[!NOSUCH]<br>\nhi</p>\n</blockquote>\n",
+		},
+		{
+			name:  "plain blockquote",
+			input: "> plain quote\n",
+			want:  "<blockquote>\n<p>plain quote</p>\n</blockquote>\n",
+		},
+	})
+}
+
+type testBlock struct{}
+
+func (b *testBlock) Open(w io.Writer, title string) {
+	fmt.Fprintf(w, "<section data-title=\"%s\">\n", title)
+}
+
+func (b *testBlock) Close(w io.Writer) {
+	io.WriteString(w, "</section>\n")
+}
+
+func TestRegisterBlock(t *testing.T) {
+	RegisterBlock("Test-Block", &testBlock{})
+	t.Cleanup(func() { delete(blocks, "test-block") })
+	runGolden(t, []goldenTest{
+		{
+			name:  "container",
+			input: ":::test-block a \"title\"\nhi\n:::\n",
+			want:  "<section data-title=\"a &quot;title&quot;\">\n<p>hi</p>\n</section>\n",
+		},
+		{
+			name:  "callout",
+			input: "> [!TEST-BLOCK]\n> hi\n",
+			want:  "<section data-title=\"\">\n<p>hi</p>\n</section>\n",
+		},
+	})
+}
@@ -0,0 +1,140 @@
+package markdown
+
+import (
+	"image"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"personal-site/internal/config"
+	"strconv"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+
+	// decoders for the image types the media endpoint takes
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/webp"
+)
+
+// imageExtension loads images lazily, sizes the ones served by this site so the page
+// doesn't jump around while they load, and turns images with a title into figures
+type imageExtension struct{}
+
+func (e *imageExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithASTTransformers(util.Prioritized(&imageTransformer{}, 100)),
+	)
+	m.Renderer().AddOptions(
+		renderer.WithNodeRenderers(util.Prioritized(&figureRenderer{}, 150)),
+	)
+}
+
+var kindFigure = ast.NewNodeKind("Figure")
+
+type figure struct {
+	ast.BaseBlock
+	caption []byte
+}
+
+func (n *figure) Kind() ast.NodeKind {
+	return kindFigure
+}
+
+func (n *figure) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"caption": string(n.caption)}, nil)
+}
+
+// where a local image is on disk, for urls under /media and /static on this site
+func localImagePath(dest string) (string, bool) {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return "", false
+	}
+	if u.Host != "" && !strings.EqualFold(u.Host, siteHost()) {
+		return "", false
+	}
+	if name, ok := strings.CutPrefix(u.Path, "/media/"); ok {
+		// the same names ServeMedia serves
+		if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+			return "", false
+		}
+		return filepath.Join(config.MediaDir, name), true
+	}
+	if rest, ok := strings.CutPrefix(u.Path, "/static/"); ok {
+		return filepath.Join("web/static", filepath.FromSlash(path.Clean("/"+rest))), true
+	}
+	return "", false
+}
+
+// reads the size from the image's header, without decoding the rest of it
+func imageSize(path string) (int, int, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, false
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0, false
+	}
+	return cfg.Width, cfg.Height, true
+}
+
+type imageTransformer struct{}
+
+func (t *imageTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	images := make([]*ast.Image, 0)
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if img, ok := n.(*ast.Image); ok && entering {
+			images = append(images, img)
+		}
+		return ast.WalkContinue, nil
+	})
+	for _, img := range images {
+		img.SetAttributeString("loading", []byte("lazy"))
+		if file, ok := localImagePath(string(img.Destination)); ok {
+			if width, height, ok := imageSize(file); ok {
+				img.SetAttributeString("width", []byte(strconv.Itoa(width)))
+				img.SetAttributeString("height", []byte(strconv.Itoa(height)))
+			}
+		}
+		// an image on its own line with a title is a figure, the title its caption.
+		// images inside text stay inline, a figure can't go in a paragraph
+		paragraph, ok := img.Parent().(*ast.Paragraph)
+		if !ok || len(img.Title) == 0 || paragraph.ChildCount() != 1 {
+			continue
+		}
+		node := &figure{caption: img.Title}
+		img.Title = nil
+		node.AppendChild(node, img)
+		paragraph.Parent().ReplaceChild(paragraph.Parent(), paragraph, node)
+	}
+}
+
+type figureRenderer struct{}
+
+func (r *figureRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindFigure, r.renderFigure)
+}
+
+func (r *figureRenderer) renderFigure(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		w.WriteString("<figure>\n")
+		return ast.WalkContinue, nil
+	}
+	// captions are written the way titles are, escapes and entities work the same
+	w.WriteString("\n<figcaption>")
+	html.DefaultWriter.Write(w, node.(*figure).caption)
+	w.WriteString("</figcaption>\n</figure>\n")
+	return ast.WalkContinue, nil
+}
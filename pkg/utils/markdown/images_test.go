@@ -0,0 +1,87 @@
+package markdown
+
+import (
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"personal-site/internal/config"
+	"personal-site/pkg/utils/sanitize"
+	"testing"
+)
+
+func TestImages(t *testing.T) {
+	setSiteURL(t, "https://example.com")
+	mediaDir := t.TempDir()
+	previous := config.MediaDir
+	config.MediaDir = mediaDir
+	t.Cleanup(func() { config.MediaDir = previous })
+
+	f, err := os.Create(filepath.Join(mediaDir, "cat.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, image.NewGray(image.Rect(0, 0, 40, 30))); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if err := os.WriteFile(filepath.Join(mediaDir, "notes.png"), []byte("not an image"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	runGolden(t, []goldenTest{
+		{
+			name:  "local image",
+			input: "![A cat](/media/cat.png)",
+			want:  `<p><img src="/media/cat.png" alt="A cat" loading="lazy" width="40" height="30"></p>` + "\n",
+		},
+		{
+			name:  "local image by its full url",
+			input: "![A cat](https://example.com/media/cat.png)",
+			want:  `<p><img src="https://example.com/media/cat.png" alt="A cat" loading="lazy" width="40" height="30"></p>` + "\n",
+		},
+		{
+			name:  "missing file",
+			input: "![gone](/media/gone.png)",
+			want:  `<p><img src="/media/gone.png" alt="gone" loading="lazy"></p>` + "\n",
+		},
+		{
+			name:  "file that isn't an image",
+			input: "![notes](/media/notes.png)",
+			want:  `<p><img src="/media/notes.png" alt="notes" loading="lazy"></p>` + "\n",
+		},
+		{
+			name:  "outside the media directory",
+			input: "![up](/media/../cat.png)",
+			want:  `<p><img src="/media/../cat.png" alt="up" loading="lazy"></p>` + "\n",
+		},
+		{
+			name:  "another site",
+			input: "![remote](https://cdn.example/media/cat.png)",
+			want:  `<p><img src="https://cdn.example/media/cat.png" alt="remote" loading="lazy"></p>` + "\n",
+		},
+		{
+			name:  "figure",
+			input: `![A cat](/media/cat.png "A *sleepy* cat & friend")`,
+			want:  "<figure>\n" + `<img src="/media/cat.png" alt="A cat" loading="lazy" width="40" height="30">` + "\n<figcaption>A *sleepy* cat &amp; friend</figcaption>\n</figure>\n",
+		},
+		{
+			name:  "title inside text",
+			input: `text ![inline](/media/cat.png "a title") text`,
+			want:  `<p>text <img src="/media/cat.png" alt="inline" title="a title" loading="lazy" width="40" height="30"> text</p>` + "\n",
+		},
+		{
+			name:  "two images with titles",
+			input: `![a](/a.png "one") ![b](/b.png "two")`,
+			want:  `<p><img src="/a.png" alt="a" title="one" loading="lazy"> <img src="/b.png" alt="b" title="two" loading="lazy"></p>` + "\n",
+		},
+	})
+	// the sizes and captions are kept when the post is saved
+	result, err := ParseMD(`![A cat](/media/cat.png "A cat")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sanitized := sanitize.Posts.Sanitize(result.HTML); sanitized != result.HTML {
+		t.Errorf("sanitize.Posts changed the figure\n got %q\nwant %q", sanitized, result.HTML)
+	}
+}
@@ -0,0 +1,119 @@
+package markdown
+
+import (
+	"net/url"
+	"personal-site/internal/config"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// linkExtension marks links to other sites, they open without telling the other
+// site where the reader came from and get a marker so readers know they're leaving.
+// links written by readers are only marked as theirs, see WithUserLinks
+type linkExtension struct{}
+
+func (e *linkExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithASTTransformers(util.Prioritized(&linkTransformer{}, 100)),
+	)
+	m.Renderer().AddOptions(
+		renderer.WithNodeRenderers(util.Prioritized(&linkRenderer{}, 150)),
+	)
+}
+
+var kindExternalMarker = ast.NewNodeKind("ExternalMarker")
+
+var userLinksKey = parser.NewContextKey()
+
+type externalMarker struct {
+	ast.BaseInline
+}
+
+func (n *externalMarker) Kind() ast.NodeKind {
+	return kindExternalMarker
+}
+
+func (n *externalMarker) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// the host of SITE_URL, links to it aren't external
+func siteHost() string {
+	u, err := url.Parse(config.SiteURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// reports whether the url points to another site. relative urls, fragments and
+// schemes like mailto stay on the page or leave the browser, so they aren't
+func isExternal(dest string) bool {
+	u, err := url.Parse(dest)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+	return !strings.EqualFold(u.Host, siteHost())
+}
+
+type linkTransformer struct{}
+
+func (t *linkTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	userLinks := pc.Get(userLinksKey) != nil
+	links := make([]ast.Node, 0)
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		switch n.(type) {
+		case *ast.Link, *ast.AutoLink:
+			if entering {
+				links = append(links, n)
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	for _, n := range links {
+		if userLinks {
+			n.SetAttributeString("rel", []byte("nofollow ugc"))
+			continue
+		}
+		link, ok := n.(*ast.Link)
+		if autoLink, isAuto := n.(*ast.AutoLink); isAuto {
+			if autoLink.AutoLinkType != ast.AutoLinkURL || !isExternal(string(autoLink.URL(source))) {
+				continue
+			}
+			// autolinks render their own label, so they're swapped for a plain link the
+			// marker can go inside
+			link = ast.NewLink()
+			link.Destination = autoLink.URL(source)
+			link.AppendChild(link, ast.NewString(autoLink.Label(source)))
+			autoLink.Parent().ReplaceChild(autoLink.Parent(), autoLink, link)
+		} else if !ok || !isExternal(string(link.Destination)) {
+			continue
+		}
+		link.SetAttributeString("rel", []byte("noopener noreferrer"))
+		link.SetAttributeString("class", []byte("external-link"))
+		link.AppendChild(link, &externalMarker{})
+	}
+}
+
+type linkRenderer struct{}
+
+func (r *linkRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindExternalMarker, r.renderExternalMarker)
+}
+
+func (r *linkRenderer) renderExternalMarker(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		w.WriteString(`<span class="external-marker" aria-hidden="true">↗</span>`)
+	}
+	return ast.WalkContinue, nil
+}
@@ -0,0 +1,50 @@
+package markdown
+
+import (
+	"personal-site/internal/config"
+	"testing"
+)
+
+// points SITE_URL at a fixed host for the test
+func setSiteURL(t *testing.T, siteURL string) {
+	t.Helper()
+	previous := config.SiteURL
+	config.SiteURL = siteURL
+	t.Cleanup(func() { config.SiteURL = previous })
+}
+
+func TestLinks(t *testing.T) {
+	setSiteURL(t, "https://example.com")
+	external := func(href string, text string) string {
+		return `<p><a href="` + href + `" rel="noopener noreferrer" class="external-link">` + text +
+			`<span class="external-marker" aria-hidden="true">↗</span></a></p>` + "\n"
+	}
+	runGolden(t, []goldenTest{
+		{"another site", "[a](https://other.example/a)", external("https://other.example/a", "a")},
+		{"protocol relative", "[a](//other.example/a)", external("//other.example/a", "a")},
+		{"subdomain", "[a](https://www.example.com/)", external("https://www.example.com/", "a")},
+		{"autolink", "https://other.example/auto", external("https://other.example/auto", "https://other.example/auto")},
+		{"this site", "[a](https://example.com/blog/x)", `<p><a href="https://example.com/blog/x">a</a></p>` + "\n"},
+		{"this site in capitals", "[a](https://EXAMPLE.com/x)", `<p><a href="https://EXAMPLE.com/x">a</a></p>` + "\n"},
+		{"relative", "[a](/blog/x)", `<p><a href="/blog/x">a</a></p>` + "\n"},
+		{"fragment", "[a](#top)", `<p><a href="#top">a</a></p>` + "\n"},
+		{"mailto", "[a](mailto:me@example.com)", `<p><a href="mailto:me@example.com">a</a></p>` + "\n"},
+		{"autolinked email", "me@other.example", `<p><a href="mailto:me@other.example">me@other.example</a></p>` + "\n"},
+	})
+
+	// the port is part of the host, so a local SITE_URL only matches itself
+	setSiteURL(t, "http://localhost:8080")
+	runGolden(t, []goldenTest{
+		{"same port", "[a](http://localhost:8080/blog)", `<p><a href="http://localhost:8080/blog">a</a></p>` + "\n"},
+		{"other port", "[a](http://localhost:9090/)", external("http://localhost:9090/", "a")},
+	})
+}
+
+func TestUserLinks(t *testing.T) {
+	setSiteURL(t, "https://example.com")
+	runGolden(t, []goldenTest{
+		{"another site", "[a](https://other.example/a)", `<p><a href="https://other.example/a" rel="nofollow ugc">a</a></p>` + "\n"},
+		{"this site", "[a](https://example.com/x)", `<p><a href="https://example.com/x" rel="nofollow ugc">a</a></p>` + "\n"},
+		{"autolink", "https://other.example/auto", `<p><a href="https://other.example/auto" rel="nofollow ugc">https://other.example/auto</a></p>` + "\n"},
+	}, WithUserLinks())
+}
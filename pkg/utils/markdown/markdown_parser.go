@@ -2,36 +2,117 @@ package markdown
 
 import (
 	"bytes"
+	"log"
+	"personal-site/internal/config"
 
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/styles"
 	"github.com/yuin/goldmark"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
 	"github.com/yuin/goldmark/extension"
 	"github.com/yuin/goldmark/parser"
 	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/util"
 )
 
 var mdParser goldmark.Markdown
 
+// the stylesheet for highlighted code, generated from the configured style
+var codeCSS []byte
+
 func init() {
+	// code is marked up with classes instead of inline styles, so the theme lives in
+	// one stylesheet and the sanitizer never has to allow style attributes
+	formatOptions := []chromahtml.Option{
+		chromahtml.WithClasses(true),
+		chromahtml.WithLineNumbers(config.CodeLineNumbers),
+	}
 	mdParser = goldmark.New(
 		goldmark.WithExtensions(
 			extension.GFM,
 			extension.Typographer,
+			// footnotes link back to where they're referenced
+			extension.Footnote,
+			// fences take options like ```go {hl_lines=[2,4] linenos=true linenostart=10}
+			highlighting.NewHighlighting(
+				highlighting.WithStyle(config.CodeStyle),
+				highlighting.WithFormatOptions(formatOptions...),
+			),
+			&mathExtension{},
+			&blockExtension{},
+			&linkExtension{},
+			&imageExtension{},
 		),
 		goldmark.WithParserOptions(
 			parser.WithAutoHeadingID(),
+			parser.WithASTTransformers(util.Prioritized(&headingTransformer{}, 100)),
 		),
 		goldmark.WithRendererOptions(
 			html.WithHardWraps(),
 		),
 	)
+
+	if _, ok := styles.Registry[config.CodeStyle]; !ok {
+		log.Printf("unknown code style %q, using the fallback", config.CodeStyle)
+	}
+	var buf bytes.Buffer
+	if err := chromahtml.New(formatOptions...).WriteCSS(&buf, styles.Get(config.CodeStyle)); err != nil {
+		log.Printf("failed to generate the code stylesheet: %v", err)
+	}
+	codeCSS = buf.Bytes()
 }
 
-func ParseMD(source string) (string, error) {
+// Result is rendered markdown along with what was found while rendering it
+type Result struct {
+	HTML string
+	// the document's headings, nested by level
+	TOC []*Heading
+}
+
+type options struct {
+	noAnchors bool
+	userLinks bool
+}
+
+type Option func(*options)
+
+// WithoutAnchors leaves out the self links on headings, for html that's shown
+// somewhere the headings can't be linked to
+func WithoutAnchors() Option {
+	return func(o *options) {
+		o.noAnchors = true
+	}
+}
+
+// WithUserLinks marks every link rel="nofollow ugc" and leaves external links
+// undecorated, for html written by readers rather than authors
+func WithUserLinks() Option {
+	return func(o *options) {
+		o.userLinks = true
+	}
+}
+
+func ParseMD(source string, opts ...Option) (*Result, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	pc := parser.NewContext()
+	if o.noAnchors {
+		pc.Set(noAnchorsKey, true)
+	}
+	if o.userLinks {
+		pc.Set(userLinksKey, true)
+	}
 	var buf bytes.Buffer
-	if err := mdParser.Convert([]byte(source), &buf); err != nil {
-		return "", err
+	if err := mdParser.Convert([]byte(source), &buf, parser.WithContext(pc)); err != nil {
+		return nil, err
 	}
-	result := buf.String()
+	toc, _ := pc.Get(headingsKey).([]*Heading)
+	return &Result{HTML: buf.String(), TOC: toc}, nil
+}
 
-	return result, nil
+// CodeCSS returns the stylesheet that colors highlighted code blocks
+func CodeCSS() []byte {
+	return codeCSS
 }
@@ -0,0 +1,104 @@
+package markdown
+
+import (
+	"personal-site/pkg/utils/sanitize"
+	"strings"
+	"testing"
+)
+
+// a golden case, markdown and the exact html it renders to
+type goldenTest struct {
+	name  string
+	input string
+	want  string
+}
+
+func runGolden(t *testing.T, tests []goldenTest, opts ...Option) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ParseMD(tt.input, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.HTML != tt.want {
+				t.Errorf("ParseMD(%q)\n got %q\nwant %q", tt.input, result.HTML, tt.want)
+			}
+		})
+	}
+}
+
+func TestHighlighting(t *testing.T) {
+	runGolden(t, []goldenTest{
+		{
+			name:  "known language",
+			input: "```go\nfunc main() {}\n```\n",
+			want:  `<pre class="chroma"><code><span class="line"><span class="cl"><span class="kd">func</span> <span class="nf">main</span><span class="p">()</span> <span class="p">{}</span>` + "\n" + `</span></span></code></pre>`,
+		},
+		{
+			name:  "unknown language",
+			input: "```nosuchlang\nx := <b>\n```\n",
+			want:  `<pre><code class="language-nosuchlang">x := &lt;b&gt;` + "\n" + `</code></pre>` + "\n",
+		},
+		{
+			name:  "no language",
+			input: "```\nplain <b>\n```\n",
+			want:  `<pre><code>plain &lt;b&gt;` + "\n" + `</code></pre>` + "\n",
+		},
+		{
+			name:  "highlighted lines and line numbers",
+			input: "```go {hl_lines=[2] linenos=true}\na\nb\n```\n",
+			want: `<pre class="chroma"><code>` +
+				`<span class="line"><span class="ln">1</span><span class="cl"><span class="nx">a</span>` + "\n" + `</span></span>` +
+				`<span class="line hl"><span class="ln">2</span><span class="cl"><span class="nx">b</span>` + "\n" + `</span></span>` +
+				`</code></pre>`,
+		},
+	})
+}
+
+func TestCodeCSS(t *testing.T) {
+	css := string(CodeCSS())
+	// every class the golden cases use is colored by the stylesheet, and nothing is inline
+	for _, selector := range []string{".chroma {", ".chroma .kd {", ".chroma .nf {", ".chroma .hl {", ".chroma .ln {"} {
+		if !strings.Contains(css, selector) {
+			t.Errorf("CodeCSS() has no %q rule", selector)
+		}
+	}
+	result, err := ParseMD("```go\nfunc main() {}\n```\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(result.HTML, "style=") {
+		t.Errorf("highlighted code has inline styles: %s", result.HTML)
+	}
+}
+
+func TestFootnotes(t *testing.T) {
+	runGolden(t, []goldenTest{
+		{
+			// every reference gets its own id, and the note links back to each of them
+			name:  "referenced more than once",
+			input: "One[^a] and again[^a], then[^b].\n\n[^a]: First.\n[^b]: Second.\n",
+			want: `<p>One<sup id="fnref:1"><a href="#fn:1" class="footnote-ref" role="doc-noteref">1</a></sup>` +
+				` and again<sup id="fnref1:1"><a href="#fn:1" class="footnote-ref" role="doc-noteref">1</a></sup>` +
+				`, then<sup id="fnref:2"><a href="#fn:2" class="footnote-ref" role="doc-noteref">2</a></sup>.</p>` + "\n" +
+				`<div class="footnotes" role="doc-endnotes">` + "\n<hr>\n<ol>\n" +
+				`<li id="fn:1">` + "\n" + `<p>First.&#160;<a href="#fnref:1" class="footnote-backref" role="doc-backlink">&#x21a9;&#xfe0e;</a>` +
+				`&#160;<a href="#fnref1:1" class="footnote-backref" role="doc-backlink">&#x21a9;&#xfe0e;</a></p>` + "\n</li>\n" +
+				`<li id="fn:2">` + "\n" + `<p>Second.&#160;<a href="#fnref:2" class="footnote-backref" role="doc-backlink">&#x21a9;&#xfe0e;</a></p>` + "\n</li>\n" +
+				"</ol>\n</div>\n",
+		},
+	})
+
+	// the ids and links survive being saved
+	result, err := ParseMD("One[^a] and again[^a].\n\n[^a]: First.\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sanitized := sanitize.Posts.Sanitize(result.HTML)
+	for _, want := range []string{`id="fnref:1"`, `id="fnref1:1"`, `id="fn:1"`, `href="#fn:1"`, `href="#fnref1:1"`} {
+		if !strings.Contains(sanitized, want) {
+			t.Errorf("sanitized footnotes have no %s: %s", want, sanitized)
+		}
+	}
+}
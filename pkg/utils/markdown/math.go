@@ -0,0 +1,216 @@
+package markdown
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// math adds $...$ for inline and $$...$$ for display math, rendered to MathML on the
+// server so pages don't need any scripts or fonts for it
+type mathExtension struct{}
+
+func (e *mathExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithBlockParsers(util.Prioritized(&mathBlockParser{}, 150)),
+		parser.WithInlineParsers(util.Prioritized(&mathInlineParser{}, 150)),
+	)
+	m.Renderer().AddOptions(
+		renderer.WithNodeRenderers(util.Prioritized(&mathRenderer{}, 150)),
+	)
+}
+
+var kindMathInline = ast.NewNodeKind("MathInline")
+var kindMathBlock = ast.NewNodeKind("MathBlock")
+
+type mathInline struct {
+	ast.BaseInline
+	tex []byte
+	// $$...$$ inside a paragraph
+	display bool
+}
+
+func (n *mathInline) Kind() ast.NodeKind {
+	return kindMathInline
+}
+
+func (n *mathInline) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"tex": string(n.tex)}, nil)
+}
+
+type mathBlock struct {
+	ast.BaseBlock
+	// the closing $$ was on the opening line
+	closed bool
+}
+
+func (n *mathBlock) Kind() ast.NodeKind {
+	return kindMathBlock
+}
+
+func (n *mathBlock) IsRaw() bool {
+	return true
+}
+
+func (n *mathBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+type mathInlineParser struct{}
+
+func (p *mathInlineParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+// follows pandoc's rules so prices aren't taken for math: the opening $ can't be
+// followed by a space, the closing one can't come after a space or before a digit.
+// escaped dollars never open math, and don't close it either
+func (p *mathInlineParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+	delim := 1
+	if len(line) > 1 && line[1] == '$' {
+		delim = 2
+	}
+	if delim >= len(line) || (delim == 1 && util.IsSpace(line[1])) {
+		return nil
+	}
+	for i := delim; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '$':
+			if delim == 2 {
+				if i+1 < len(line) && line[i+1] == '$' && i > delim {
+					block.Advance(i + 2)
+					return &mathInline{tex: bytes.Clone(line[delim:i]), display: true}
+				}
+				continue
+			}
+			if util.IsSpace(line[i-1]) || (i+1 < len(line) && util.IsNumeric(line[i+1])) {
+				continue
+			}
+			block.Advance(i + 1)
+			return &mathInline{tex: bytes.Clone(line[1:i])}
+		}
+	}
+	return nil
+}
+
+type mathBlockParser struct{}
+
+func (b *mathBlockParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+func (b *mathBlockParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, segment := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 || !bytes.HasPrefix(line[pos:], []byte("$$")) {
+		return nil, parser.NoChildren
+	}
+	node := &mathBlock{}
+	start := pos + 2
+	rest := util.TrimRightSpace(line[start:])
+	if end := bytes.Index(rest, []byte("$$")); end >= 0 {
+		// $$...$$ on one line is only a block when nothing follows it, otherwise
+		// it's display math inside a paragraph
+		if !util.IsBlank(rest[end+2:]) {
+			return nil, parser.NoChildren
+		}
+		node.Lines().Append(text.NewSegment(segment.Start+start, segment.Start+start+end))
+		node.closed = true
+	} else if !util.IsBlank(rest) {
+		node.Lines().Append(text.NewSegment(segment.Start+start, segment.Stop))
+	}
+	advanceLine(reader, line, segment)
+	return node, parser.NoChildren
+}
+
+func (b *mathBlockParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	if node.(*mathBlock).closed {
+		return parser.Close
+	}
+	line, segment := reader.PeekLine()
+	trimmed := util.TrimRightSpace(line)
+	if bytes.HasSuffix(trimmed, []byte("$$")) {
+		if content := trimmed[:len(trimmed)-2]; !util.IsBlank(content) {
+			node.Lines().Append(text.NewSegment(segment.Start, segment.Start+len(content)))
+		}
+		advanceLine(reader, line, segment)
+		return parser.Close
+	}
+	node.Lines().Append(segment)
+	advanceLine(reader, line, segment)
+	return parser.Continue | parser.NoChildren
+}
+
+// moves to the end of the line, leaving the newline for the block parser. the last
+// line of a document may not have one
+func advanceLine(reader text.Reader, line []byte, segment text.Segment) {
+	newline := 0
+	if len(line) > 0 && line[len(line)-1] == '\n' {
+		newline = 1
+	}
+	reader.Advance(segment.Len() - newline)
+}
+
+func (b *mathBlockParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+
+// an equation can directly follow the paragraph introducing it
+func (b *mathBlockParser) CanInterruptParagraph() bool {
+	return true
+}
+
+func (b *mathBlockParser) CanAcceptIndentedLine() bool {
+	return false
+}
+
+type mathRenderer struct{}
+
+func (r *mathRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindMathInline, r.renderMathInline)
+	reg.Register(kindMathBlock, r.renderMathBlock)
+}
+
+func (r *mathRenderer) renderMathInline(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		n := node.(*mathInline)
+		writeMath(w, string(n.tex), n.display)
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *mathRenderer) renderMathBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		var tex bytes.Buffer
+		lines := node.Lines()
+		for i := 0; i < lines.Len(); i++ {
+			segment := lines.At(i)
+			tex.Write(segment.Value(source))
+		}
+		writeMath(w, tex.String(), true)
+		w.WriteByte('\n')
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+// math that doesn't parse is shown as its source, so a typo doesn't eat the equation
+func writeMath(w util.BufWriter, tex string, display bool) {
+	mathML, err := texToMathML(tex, display)
+	if err != nil {
+		delim := "$"
+		if display {
+			delim = "$$"
+		}
+		w.WriteString(`<code class="math-error">`)
+		w.Write(util.EscapeHTML([]byte(delim + tex + delim)))
+		w.WriteString("</code>")
+		return
+	}
+	w.WriteString(mathML)
+}
@@ -0,0 +1,101 @@
+package markdown
+
+import (
+	"personal-site/pkg/utils/sanitize"
+	"strings"
+	"testing"
+)
+
+func TestMath(t *testing.T) {
+	runGolden(t, []goldenTest{
+		{
+			name:  "inline",
+			input: `$x^2$`,
+			want:  `<p><math><semantics><mrow><msup><mi>x</mi><mn>2</mn></msup></mrow><annotation encoding="application/x-tex">x^2</annotation></semantics></math></p>` + "\n",
+		},
+		{
+			name:  "prices",
+			input: `It costs $5 and $10.`,
+			want:  `<p>It costs $5 and $10.</p>` + "\n",
+		},
+		{
+			name:  "space before the closing dollar",
+			input: `trailing $x $`,
+			want:  `<p>trailing $x $</p>` + "\n",
+		},
+		{
+			name:  "escaped dollar",
+			input: `an escaped \$x$ here`,
+			want:  `<p>an escaped $x$ here</p>` + "\n",
+		},
+		{
+			name:  "inline code",
+			input: "`$x$` in code",
+			want:  `<p><code>$x$</code> in code</p>` + "\n",
+		},
+		{
+			name:  "fenced code",
+			input: "```\n$x$\n```\n",
+			want:  `<pre><code>$x$` + "\n" + `</code></pre>` + "\n",
+		},
+		{
+			name:  "display math over several lines",
+			input: "$$\na^2\n+ b\n$$\n",
+			want:  `<math display="block"><semantics><mrow><msup><mi>a</mi><mn>2</mn></msup><mo>+</mo><mi>b</mi></mrow><annotation encoding="application/x-tex">a^2` + "\n" + `+ b</annotation></semantics></math>` + "\n",
+		},
+		{
+			name:  "display math inside a paragraph",
+			input: `inline $$x$$ display`,
+			want:  `<p>inline <math display="block"><semantics><mrow><mi>x</mi></mrow><annotation encoding="application/x-tex">x</annotation></semantics></math> display</p>` + "\n",
+		},
+		{
+			name:  "text is escaped",
+			input: `$\text{a < b & c}$`,
+			want:  `<p><math><semantics><mrow><mtext>a &lt; b &amp; c</mtext></mrow><annotation encoding="application/x-tex">\text{a &lt; b &amp; c}</annotation></semantics></math></p>` + "\n",
+		},
+		{
+			name:  "unbalanced dollar",
+			input: `unbalanced $x and more`,
+			want:  `<p>unbalanced $x and more</p>` + "\n",
+		},
+		{
+			name:  "unbalanced double dollar",
+			input: `unbalanced $$x`,
+			want:  `<p>unbalanced $$x</p>` + "\n",
+		},
+		{
+			name:  "unbalanced group",
+			input: `$\sqrt{x`,
+			want:  `<p>$\sqrt{x</p>` + "\n",
+		},
+		{
+			name:  "invalid tex",
+			input: `$\frac{1}$`,
+			want:  `<p><code class="math-error">$\frac{1}$</code></p>` + "\n",
+		},
+	})
+}
+
+// the math markdown renders is kept by both policies, so it's shown the same
+// in posts, previews and comments
+func TestMathSurvivesSanitizing(t *testing.T) {
+	for _, input := range []string{
+		`$\text{a < b & c}$`,
+		"$$\n\\frac{1}{2} \\leq \\sqrt[3]{x_1^2}\n$$\n",
+		`$\left( \sum_{i=0}^n \overline{x}_i \right)$`,
+		"$$\n\\begin{matrix} a & b \\\\ c & d \\end{matrix}\n$$\n",
+	} {
+		result, err := ParseMD(input, WithoutAnchors())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(result.HTML, "<math") || strings.Contains(result.HTML, "math-error") {
+			t.Fatalf("ParseMD(%q) didn't render math: %s", input, result.HTML)
+		}
+		for name, policy := range map[string]*sanitize.Policy{"Posts": sanitize.Posts, "Comments": sanitize.Comments} {
+			if got := policy.Sanitize(result.HTML); got != result.HTML {
+				t.Errorf("sanitize.%s changed the math for %q\n got %q\nwant %q", name, input, got, result.HTML)
+			}
+		}
+	}
+}
@@ -0,0 +1,801 @@
+package markdown
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+)
+
+// how deeply groups, fractions and the like may nest, math comes from readers too
+const mathMaxDepth = 64
+
+// identifiers, rendered as <mi>
+var mathIdentifiers = map[string]string{
+	"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ϵ", "varepsilon": "ε",
+	"zeta": "ζ", "eta": "η", "theta": "θ", "vartheta": "ϑ", "iota": "ι", "kappa": "κ",
+	"lambda": "λ", "mu": "μ", "nu": "ν", "xi": "ξ", "omicron": "ο", "pi": "π", "varpi": "ϖ",
+	"rho": "ρ", "varrho": "ϱ", "sigma": "σ", "varsigma": "ς", "tau": "τ", "upsilon": "υ",
+	"phi": "ϕ", "varphi": "φ", "chi": "χ", "psi": "ψ", "omega": "ω",
+	"infty": "∞", "partial": "∂", "nabla": "∇", "hbar": "ℏ", "ell": "ℓ", "aleph": "ℵ",
+	"Re": "ℜ", "Im": "ℑ", "wp": "℘", "emptyset": "∅", "varnothing": "∅", "imath": "ı", "jmath": "ȷ",
+}
+
+// capital greek letters are upright in TeX
+var mathUprightIdentifiers = map[string]string{
+	"Gamma": "Γ", "Delta": "Δ", "Theta": "Θ", "Lambda": "Λ", "Xi": "Ξ", "Pi": "Π",
+	"Sigma": "Σ", "Upsilon": "Υ", "Phi": "Φ", "Psi": "Ψ", "Omega": "Ω",
+}
+
+// operators, relations and punctuation, rendered as <mo>
+var mathOperators = map[string]string{
+	"times": "×", "cdot": "⋅", "pm": "±", "mp": "∓", "div": "÷", "ast": "∗", "star": "⋆",
+	"circ": "∘", "bullet": "∙", "oplus": "⊕", "ominus": "⊖", "otimes": "⊗", "odot": "⊙",
+	"cup": "∪", "cap": "∩", "setminus": "∖", "wedge": "∧", "land": "∧", "vee": "∨", "lor": "∨",
+	"neg": "¬", "lnot": "¬", "forall": "∀", "exists": "∃", "nexists": "∄",
+	"leq": "≤", "le": "≤", "geq": "≥", "ge": "≥", "neq": "≠", "ne": "≠", "ll": "≪", "gg": "≫",
+	"approx": "≈", "equiv": "≡", "sim": "∼", "simeq": "≃", "cong": "≅", "propto": "∝",
+	"in": "∈", "notin": "∉", "ni": "∋", "subset": "⊂", "subseteq": "⊆", "supset": "⊃",
+	"supseteq": "⊇", "mid": "∣", "parallel": "∥", "perp": "⊥", "angle": "∠",
+	"to": "→", "rightarrow": "→", "leftarrow": "←", "gets": "←", "leftrightarrow": "↔",
+	"Rightarrow": "⇒", "Leftarrow": "⇐", "Leftrightarrow": "⇔", "implies": "⟹", "impliedby": "⟸",
+	"iff": "⟺", "mapsto": "↦", "uparrow": "↑", "downarrow": "↓", "longrightarrow": "⟶",
+	"longleftarrow": "⟵", "hookrightarrow": "↪",
+	"ldots": "…", "dots": "…", "cdots": "⋯", "vdots": "⋮", "ddots": "⋱",
+	"langle": "⟨", "rangle": "⟩", "lfloor": "⌊", "rfloor": "⌋", "lceil": "⌈", "rceil": "⌉",
+	"vert": "|", "Vert": "‖", "lvert": "|", "rvert": "|", "lVert": "‖", "rVert": "‖",
+	"colon": ":", "prime": "′", "triangle": "△", "square": "□",
+	"{": "{", "}": "}", "|": "‖", "#": "#", "%": "%", "&": "&", "_": "_", "$": "$",
+}
+
+// big operators, the ones marked true take their scripts above and below
+var mathLargeOperators = map[string]struct {
+	symbol string
+	limits bool
+}{
+	"sum": {"∑", true}, "prod": {"∏", true}, "coprod": {"∐", true},
+	"bigcup": {"⋃", true}, "bigcap": {"⋂", true}, "bigoplus": {"⨁", true},
+	"bigotimes": {"⨂", true}, "bigvee": {"⋁", true}, "bigwedge": {"⋀", true},
+	"int": {"∫", false}, "iint": {"∬", false}, "iiint": {"∭", false}, "oint": {"∮", false},
+}
+
+// named functions, the ones marked true take their scripts below like \lim_{x \to 0}
+var mathFunctions = map[string]bool{
+	"sin": false, "cos": false, "tan": false, "cot": false, "sec": false, "csc": false,
+	"arcsin": false, "arccos": false, "arctan": false, "sinh": false, "cosh": false, "tanh": false,
+	"log": false, "ln": false, "lg": false, "exp": false, "arg": false, "deg": false,
+	"dim": false, "ker": false, "hom": false,
+	"lim": true, "liminf": true, "limsup": true, "max": true, "min": true, "sup": true,
+	"inf": true, "det": true, "gcd": true, "Pr": true, "argmax": true, "argmin": true,
+}
+
+// accents over their argument, the ones marked true stretch across it
+var mathAccents = map[string]struct {
+	symbol  string
+	stretch bool
+}{
+	"hat": {"^", false}, "check": {"ˇ", false}, "tilde": {"~", false}, "acute": {"´", false},
+	"grave": {"`", false}, "dot": {"˙", false}, "ddot": {"¨", false}, "breve": {"˘", false},
+	"bar": {"¯", false}, "vec": {"→", false}, "widehat": {"^", true}, "widetilde": {"~", true},
+	"overline": {"‾", true}, "overrightarrow": {"→", true}, "overleftarrow": {"←", true},
+}
+
+var mathSpaces = map[string]string{
+	",": "0.1667em", ":": "0.2222em", ">": "0.2222em", ";": "0.2778em", "!": "-0.1667em",
+	"quad": "1em", "qquad": "2em", "thinspace": "0.1667em", "enspace": "0.5em",
+}
+
+// delimiter sizes of \big and friends
+var mathDelimiterSizes = map[string]string{
+	"big": "1.2em", "bigl": "1.2em", "bigr": "1.2em", "bigm": "1.2em",
+	"Big": "1.623em", "Bigl": "1.623em", "Bigr": "1.623em", "Bigm": "1.623em",
+	"bigg": "2.047em", "biggl": "2.047em", "biggr": "2.047em", "biggm": "2.047em",
+	"Bigg": "2.470em", "Biggl": "2.470em", "Biggr": "2.470em", "Biggm": "2.470em",
+}
+
+var mathFonts = map[string]string{
+	"mathbf": "bold", "mathit": "italic", "boldsymbol": "bold-italic", "bm": "bold-italic",
+	"mathrm": "normal", "mathbb": "double-struck", "mathcal": "script", "mathscr": "script",
+	"mathfrak": "fraktur", "mathsf": "sans-serif", "mathtt": "monospace",
+}
+
+// the delimiters matrix environments are wrapped in
+var mathMatrixFences = map[string][2]string{
+	"matrix": {"", ""}, "smallmatrix": {"", ""}, "pmatrix": {"(", ")"}, "bmatrix": {"[", "]"},
+	"Bmatrix": {"{", "}"}, "vmatrix": {"|", "|"}, "Vmatrix": {"‖", "‖"},
+}
+
+// texToMathML converts a TeX math expression to MathML. it covers the commands posts
+// actually use, anything it doesn't know is shown as an error inside the formula
+func texToMathML(tex string, display bool) (string, error) {
+	p := &mathParser{src: []rune(tex)}
+	// lines split by \\ outside an environment are stacked like in gather
+	var rows []string
+	for {
+		row, err := p.parseRow()
+		if err != nil {
+			return "", err
+		}
+		rows = append(rows, row)
+		if p.peekCommand() != "\\" {
+			break
+		}
+		p.readCommand()
+	}
+	if !p.eof() {
+		return "", p.errorf("unexpected %q", string(p.peek()))
+	}
+	row := rows[0]
+	if len(rows) > 1 {
+		row = "<mtable><mtr><mtd>" + strings.Join(rows, "</mtd></mtr><mtr><mtd>") + "</mtd></mtr></mtable>"
+	}
+	var b strings.Builder
+	b.WriteString("<math")
+	if display {
+		b.WriteString(` display="block"`)
+	}
+	// the annotation keeps the source around for copying, only the first child renders
+	b.WriteString("><semantics><mrow>")
+	b.WriteString(row)
+	b.WriteString(`</mrow><annotation encoding="application/x-tex">`)
+	b.WriteString(html.EscapeString(strings.TrimSpace(tex)))
+	b.WriteString("</annotation></semantics></math>")
+	return b.String(), nil
+}
+
+type mathParser struct {
+	src   []rune
+	pos   int
+	depth int
+	// font set by \mathbb and friends for the letters and digits in their argument
+	variant string
+	// above zero while parsing a \sqrt[...] index, which ends at ]
+	brackets int
+}
+
+func (p *mathParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("math: "+format+" at %d", append(args, p.pos)...)
+}
+
+func (p *mathParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *mathParser) peek() rune {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *mathParser) skipSpace() {
+	for !p.eof() && unicode.IsSpace(p.peek()) {
+		p.pos++
+	}
+}
+
+// reads the name of the command at the current \, letters or a single symbol
+func (p *mathParser) readCommand() string {
+	p.pos++ // the backslash
+	start := p.pos
+	for !p.eof() && isMathLetter(p.peek()) {
+		p.pos++
+	}
+	if p.pos == start && !p.eof() {
+		p.pos++
+	}
+	return string(p.src[start:p.pos])
+}
+
+// the command at the current position without consuming it
+func (p *mathParser) peekCommand() string {
+	if p.peek() != '\\' {
+		return ""
+	}
+	pos := p.pos
+	name := p.readCommand()
+	p.pos = pos
+	return name
+}
+
+// reads a {...} argument as plain text, for \text and environment names
+func (p *mathParser) readRawGroup() (string, error) {
+	p.skipSpace()
+	if p.peek() != '{' {
+		return "", p.errorf("expected {")
+	}
+	p.pos++
+	start, depth := p.pos, 1
+	for ; !p.eof(); p.pos++ {
+		switch p.peek() {
+		case '\\':
+			p.pos++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				raw := string(p.src[start:p.pos])
+				p.pos++
+				return raw, nil
+			}
+		}
+	}
+	return "", p.errorf("missing }")
+}
+
+func (p *mathParser) atRowEnd() bool {
+	if p.eof() {
+		return true
+	}
+	switch p.peek() {
+	case '}', '&':
+		return true
+	case ']':
+		return p.brackets > 0
+	case '\\':
+		switch p.peekCommand() {
+		case "\\", "right", "middle", "end", "cr":
+			return true
+		}
+	}
+	return false
+}
+
+// parses atoms with their scripts until the end of the group, row or cell
+func (p *mathParser) parseRow() (string, error) {
+	var b strings.Builder
+	for {
+		p.skipSpace()
+		if p.atRowEnd() {
+			return b.String(), nil
+		}
+		node, err := p.parseScripted()
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(node)
+	}
+}
+
+// parses an atom and any sub and superscripts attached to it
+func (p *mathParser) parseScripted() (string, error) {
+	base, limits := "<mrow></mrow>", false
+	if c := p.peek(); c != '^' && c != '_' {
+		var err error
+		base, limits, err = p.parseAtom(false)
+		if err != nil {
+			return "", err
+		}
+	}
+	var sub, sup, primes string
+	hasSub, hasSup := false, false
+	for {
+		p.skipSpace()
+		switch c := p.peek(); {
+		case c == '^' || c == '_':
+			p.pos++
+			if (c == '^' && hasSup) || (c == '_' && hasSub) {
+				return "", p.errorf("double %s", map[rune]string{'^': "superscript", '_': "subscript"}[c])
+			}
+			script, _, err := p.parseAtom(true)
+			if err != nil {
+				return "", err
+			}
+			if c == '^' {
+				sup, hasSup = script, true
+			} else {
+				sub, hasSub = script, true
+			}
+			continue
+		case c == '\'':
+			p.pos++
+			primes += "′"
+			continue
+		case c == '\\':
+			// \limits and \nolimits override where an operator's scripts go
+			switch p.peekCommand() {
+			case "limits":
+				p.readCommand()
+				limits = true
+				continue
+			case "nolimits":
+				p.readCommand()
+				limits = false
+				continue
+			}
+		}
+		break
+	}
+	if primes != "" {
+		if hasSup {
+			sup = "<mrow><mo>" + primes + "</mo>" + sup + "</mrow>"
+		} else {
+			sup, hasSup = "<mo>"+primes+"</mo>", true
+		}
+	}
+	over, under, both := "msup", "msub", "msubsup"
+	if limits {
+		over, under, both = "mover", "munder", "munderover"
+	}
+	switch {
+	case hasSub && hasSup:
+		return "<" + both + ">" + base + sub + sup + "</" + both + ">", nil
+	case hasSup:
+		return "<" + over + ">" + base + sup + "</" + over + ">", nil
+	case hasSub:
+		return "<" + under + ">" + base + sub + "</" + under + ">", nil
+	}
+	return base, nil
+}
+
+// parses a single atom, returning whether scripts attached to it go above and below.
+// a script is a single character or group, so x^12 only raises the 1
+func (p *mathParser) parseAtom(script bool) (string, bool, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > mathMaxDepth {
+		return "", false, p.errorf("nested too deeply")
+	}
+	p.skipSpace()
+	c := p.peek()
+	switch {
+	case p.eof():
+		return "", false, p.errorf("missing argument")
+	case c == '{':
+		p.pos++
+		row, err := p.parseRow()
+		if err != nil {
+			return "", false, err
+		}
+		if p.peek() != '}' {
+			return "", false, p.errorf("missing }")
+		}
+		p.pos++
+		return "<mrow>" + row + "</mrow>", false, nil
+	case c == '}' || c == '&' || c == '^' || c == '_':
+		return "", false, p.errorf("unexpected %q", string(c))
+	case c == '\\':
+		return p.parseCommand()
+	case unicode.IsDigit(c):
+		start := p.pos
+		p.pos++
+		if !script {
+			for !p.eof() && (unicode.IsDigit(p.peek()) ||
+				(p.peek() == '.' && p.pos+1 < len(p.src) && unicode.IsDigit(p.src[p.pos+1]))) {
+				p.pos++
+			}
+		}
+		return "<mn>" + p.styled(string(p.src[start:p.pos])) + "</mn>", false, nil
+	case isMathLetter(c):
+		p.pos++
+		return p.identifier(c), false, nil
+	case c == '~':
+		p.pos++
+		return "<mtext>\u00a0</mtext>", false, nil
+	}
+	p.pos++
+	return mathOperator(c), false, nil
+}
+
+// a letter in the current font, letters are italic by default
+func (p *mathParser) identifier(c rune) string {
+	switch p.variant {
+	case "":
+		return "<mi>" + html.EscapeString(string(c)) + "</mi>"
+	case "normal":
+		return `<mi mathvariant="normal">` + html.EscapeString(string(c)) + "</mi>"
+	}
+	return "<mi>" + p.styled(string(c)) + "</mi>"
+}
+
+// maps letters and digits to the current font's unicode math characters
+func (p *mathParser) styled(s string) string {
+	if p.variant == "" || p.variant == "normal" {
+		return html.EscapeString(s)
+	}
+	var b strings.Builder
+	for _, r := range s {
+		b.WriteRune(mathVariantRune(p.variant, r))
+	}
+	return html.EscapeString(b.String())
+}
+
+func mathOperator(c rune) string {
+	switch c {
+	case '-':
+		return "<mo>−</mo>"
+	case '*':
+		return "<mo>∗</mo>"
+	case '(', ')', '[', ']', '|':
+		// plain brackets keep their size, \left and \right stretch them
+		return `<mo stretchy="false">` + string(c) + "</mo>"
+	}
+	return "<mo>" + html.EscapeString(string(c)) + "</mo>"
+}
+
+func (p *mathParser) parseCommand() (string, bool, error) {
+	start := p.pos
+	name := p.readCommand()
+	if symbol, ok := mathIdentifiers[name]; ok {
+		return "<mi>" + symbol + "</mi>", false, nil
+	}
+	if symbol, ok := mathUprightIdentifiers[name]; ok {
+		return `<mi mathvariant="normal">` + symbol + "</mi>", false, nil
+	}
+	if symbol, ok := mathOperators[name]; ok {
+		return "<mo>" + html.EscapeString(symbol) + "</mo>", false, nil
+	}
+	if op, ok := mathLargeOperators[name]; ok {
+		return "<mo>" + op.symbol + "</mo>", op.limits, nil
+	}
+	if limits, ok := mathFunctions[name]; ok {
+		return mathFunction(name, limits), limits, nil
+	}
+	if width, ok := mathSpaces[name]; ok {
+		return `<mspace width="` + width + `"></mspace>`, false, nil
+	}
+	if variant, ok := mathFonts[name]; ok {
+		previous := p.variant
+		p.variant = variant
+		arg, _, err := p.parseAtom(true)
+		p.variant = previous
+		return arg, false, err
+	}
+	if accent, ok := mathAccents[name]; ok {
+		arg, _, err := p.parseAtom(true)
+		if err != nil {
+			return "", false, err
+		}
+		return fmt.Sprintf(`<mover accent="true">%s<mo stretchy="%t">%s</mo></mover>`,
+			arg, accent.stretch, html.EscapeString(accent.symbol)), false, nil
+	}
+	if size, ok := mathDelimiterSizes[name]; ok {
+		delim, err := p.readDelimiter()
+		if err != nil {
+			return "", false, err
+		}
+		return fmt.Sprintf(`<mo minsize="%s" maxsize="%s">%s</mo>`, size, size, delim), false, nil
+	}
+	switch name {
+	case " ":
+		return "<mtext>\u00a0</mtext>", false, nil
+	case "frac", "dfrac", "tfrac", "cfrac":
+		num, _, err := p.parseAtom(true)
+		if err != nil {
+			return "", false, err
+		}
+		den, _, err := p.parseAtom(true)
+		if err != nil {
+			return "", false, err
+		}
+		frac := "<mfrac>" + num + den + "</mfrac>"
+		switch name {
+		case "dfrac", "cfrac":
+			frac = `<mstyle displaystyle="true">` + frac + "</mstyle>"
+		case "tfrac":
+			frac = `<mstyle displaystyle="false">` + frac + "</mstyle>"
+		}
+		return frac, false, nil
+	case "binom", "dbinom", "tbinom":
+		top, _, err := p.parseAtom(true)
+		if err != nil {
+			return "", false, err
+		}
+		bottom, _, err := p.parseAtom(true)
+		if err != nil {
+			return "", false, err
+		}
+		return `<mrow><mo>(</mo><mfrac linethickness="0">` + top + bottom + "</mfrac><mo>)</mo></mrow>", false, nil
+	case "sqrt":
+		p.skipSpace()
+		var index string
+		if p.peek() == '[' {
+			p.pos++
+			p.brackets++
+			row, err := p.parseRow()
+			p.brackets--
+			if err != nil {
+				return "", false, err
+			}
+			if p.peek() != ']' {
+				return "", false, p.errorf("missing ]")
+			}
+			p.pos++
+			index = "<mrow>" + row + "</mrow>"
+		}
+		arg, _, err := p.parseAtom(true)
+		if err != nil {
+			return "", false, err
+		}
+		if index != "" {
+			return "<mroot>" + arg + index + "</mroot>", false, nil
+		}
+		return "<msqrt>" + arg + "</msqrt>", false, nil
+	case "text", "textrm", "textnormal", "textit", "textbf", "mbox", "hbox":
+		raw, err := p.readRawGroup()
+		if err != nil {
+			return "", false, err
+		}
+		return "<mtext>" + html.EscapeString(unescapeText(raw)) + "</mtext>", false, nil
+	case "operatorname":
+		raw, err := p.readRawGroup()
+		if err != nil {
+			return "", false, err
+		}
+		return mathFunction(strings.TrimSpace(raw), false), false, nil
+	case "underline":
+		arg, _, err := p.parseAtom(true)
+		if err != nil {
+			return "", false, err
+		}
+		return `<munder accentunder="true">` + arg + `<mo stretchy="true">_</mo></munder>`, false, nil
+	case "overbrace", "underbrace":
+		arg, _, err := p.parseAtom(true)
+		if err != nil {
+			return "", false, err
+		}
+		if name == "overbrace" {
+			return `<mover>` + arg + `<mo stretchy="true">⏞</mo></mover>`, true, nil
+		}
+		return `<munder>` + arg + `<mo stretchy="true">⏟</mo></munder>`, true, nil
+	case "not":
+		p.skipSpace()
+		next, _, err := p.parseAtom(true)
+		if err != nil {
+			return "", false, err
+		}
+		// a slash through the following symbol, \not= is ≠
+		if strings.HasPrefix(next, "<mo") && strings.HasSuffix(next, "</mo>") {
+			return strings.TrimSuffix(next, "</mo>") + "̸</mo>", false, nil
+		}
+		return next, false, nil
+	case "left":
+		return p.parseFenced()
+	case "displaystyle", "textstyle":
+		// applies to the rest of the group
+		row, err := p.parseRow()
+		if err != nil {
+			return "", false, err
+		}
+		return fmt.Sprintf(`<mstyle displaystyle="%t"><mrow>%s</mrow></mstyle>`, name == "displaystyle", row), false, nil
+	case "begin":
+		return p.parseEnvironment()
+	case "right", "end", "middle":
+		return "", false, p.errorf(`\%s without a matching opening`, name)
+	}
+	// unknown commands are shown in the formula instead of failing it
+	return "<merror><mtext>" + html.EscapeString(string(p.src[start:p.pos])) + "</mtext></merror>", false, nil
+}
+
+// named functions are upright with a thin space after them
+func mathFunction(name string, limits bool) string {
+	attrs := `form="prefix" lspace="0" rspace="0.1667em"`
+	if limits {
+		attrs += ` movablelimits="true"`
+	}
+	return "<mo " + attrs + ">" + html.EscapeString(name) + "</mo>"
+}
+
+// reads the delimiter after \left, \right or \big, "." stands for none
+func (p *mathParser) readDelimiter() (string, error) {
+	p.skipSpace()
+	if p.eof() {
+		return "", p.errorf("missing delimiter")
+	}
+	if p.peek() == '\\' {
+		name := p.readCommand()
+		if symbol, ok := mathOperators[name]; ok {
+			return html.EscapeString(symbol), nil
+		}
+		return "", p.errorf(`unknown delimiter \%s`, name)
+	}
+	c := p.peek()
+	p.pos++
+	switch c {
+	case '.':
+		return "", nil
+	case '(', ')', '[', ']', '|', '/', '<', '>':
+		if c == '<' {
+			return "⟨", nil
+		}
+		if c == '>' {
+			return "⟩", nil
+		}
+		return string(c), nil
+	}
+	return "", p.errorf("unknown delimiter %q", string(c))
+}
+
+// parses \left( ... \middle| ... \right), the delimiters stretch to the contents
+func (p *mathParser) parseFenced() (string, bool, error) {
+	open, err := p.readDelimiter()
+	if err != nil {
+		return "", false, err
+	}
+	var b strings.Builder
+	b.WriteString("<mrow>" + fence(open, "prefix"))
+	for {
+		row, err := p.parseRow()
+		if err != nil {
+			return "", false, err
+		}
+		b.WriteString(row)
+		switch p.peekCommand() {
+		case "middle":
+			p.readCommand()
+			delim, err := p.readDelimiter()
+			if err != nil {
+				return "", false, err
+			}
+			if delim != "" {
+				b.WriteString(`<mo fence="true" stretchy="true">` + delim + "</mo>")
+			}
+			continue
+		case "right":
+			p.readCommand()
+			closing, err := p.readDelimiter()
+			if err != nil {
+				return "", false, err
+			}
+			b.WriteString(fence(closing, "postfix") + "</mrow>")
+			return b.String(), false, nil
+		}
+		return "", false, p.errorf(`\left without \right`)
+	}
+}
+
+// parses \begin{name} ... \end{name} into a table, cells split by & and rows by \\
+func (p *mathParser) parseEnvironment() (string, bool, error) {
+	name, err := p.readRawGroup()
+	if err != nil {
+		return "", false, err
+	}
+	var align []string
+	var fences [2]string
+	switch name {
+	case "matrix", "smallmatrix", "pmatrix", "bmatrix", "Bmatrix", "vmatrix", "Vmatrix":
+		fences = mathMatrixFences[name]
+	case "cases":
+		fences = [2]string{"{", ""}
+		align = []string{"left", "left"}
+	case "aligned", "align", "align*", "split", "alignat", "alignat*":
+		align = []string{"right", "left"}
+	case "gathered", "gather", "gather*", "equation", "equation*":
+	case "array":
+		// the column spec isn't needed to lay the table out
+		if _, err := p.readRawGroup(); err != nil {
+			return "", false, err
+		}
+	default:
+		return "", false, p.errorf("unknown environment %q", name)
+	}
+
+	var b strings.Builder
+	b.WriteString("<mtable>")
+	for row := 0; ; row++ {
+		var cells []string
+		for {
+			cell, err := p.parseRow()
+			if err != nil {
+				return "", false, err
+			}
+			cells = append(cells, cell)
+			if p.peek() != '&' {
+				break
+			}
+			p.pos++
+		}
+		end := false
+		switch command := p.peekCommand(); command {
+		case "\\", "cr":
+			p.readCommand()
+			// skips the optional spacing of \\[2pt]
+			p.skipSpace()
+			if p.peek() == '[' {
+				for !p.eof() && p.peek() != ']' {
+					p.pos++
+				}
+				p.pos++
+			}
+		case "end":
+			p.readCommand()
+			closing, err := p.readRawGroup()
+			if err != nil {
+				return "", false, err
+			}
+			if closing != name {
+				return "", false, p.errorf(`\begin{%s} ended by \end{%s}`, name, closing)
+			}
+			end = true
+		default:
+			return "", false, p.errorf(`missing \end{%s}`, name)
+		}
+		// a trailing \\ before \end doesn't start another row
+		if !(end && len(cells) == 1 && cells[0] == "" && row > 0) {
+			b.WriteString("<mtr>")
+			for i, cell := range cells {
+				if len(align) > 0 {
+					fmt.Fprintf(&b, `<mtd columnalign="%s">`, align[i%len(align)])
+				} else {
+					b.WriteString("<mtd>")
+				}
+				b.WriteString(cell)
+				b.WriteString("</mtd>")
+			}
+			b.WriteString("</mtr>")
+		}
+		if end {
+			break
+		}
+	}
+	b.WriteString("</mtable>")
+	if fences[0] == "" && fences[1] == "" {
+		return b.String(), false, nil
+	}
+	return "<mrow>" + fence(fences[0], "prefix") + b.String() + fence(fences[1], "postfix") + "</mrow>", false, nil
+}
+
+// a delimiter stretching to the height of what it encloses, none when it's empty
+func fence(delim string, form string) string {
+	if delim == "" {
+		return ""
+	}
+	return `<mo fence="true" form="` + form + `" stretchy="true">` + delim + "</mo>"
+}
+
+// \text keeps its contents, apart from escaped characters like \$ and \{
+func unescapeText(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(`{}$%&#_\ `, s[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func isMathLetter(r rune) bool {
+	return r < unicode.MaxASCII && unicode.IsLetter(r)
+}
+
+// the first capital, small letter and digit of each font in the mathematical
+// alphanumeric symbols block, the fonts without digits have a 0 there
+var mathVariantBases = map[string][3]rune{
+	"bold":          {0x1D400, 0x1D41A, 0x1D7CE},
+	"italic":        {0x1D434, 0x1D44E, 0},
+	"bold-italic":   {0x1D468, 0x1D482, 0x1D7CE},
+	"script":        {0x1D49C, 0x1D4B6, 0},
+	"fraktur":       {0x1D504, 0x1D51E, 0},
+	"double-struck": {0x1D538, 0x1D552, 0x1D7D8},
+	"sans-serif":    {0x1D5A0, 0x1D5BA, 0x1D7E2},
+	"monospace":     {0x1D670, 0x1D68A, 0x1D7F6},
+}
+
+// letters that were in unicode before the block, whose spots in it are left empty
+var mathVariantExceptions = map[string]map[rune]rune{
+	"italic":        {'h': 'ℎ'},
+	"script":        {'B': 'ℬ', 'E': 'ℰ', 'F': 'ℱ', 'H': 'ℋ', 'I': 'ℐ', 'L': 'ℒ', 'M': 'ℳ', 'R': 'ℛ', 'e': 'ℯ', 'g': 'ℊ', 'o': 'ℴ'},
+	"fraktur":       {'C': 'ℭ', 'H': 'ℌ', 'I': 'ℑ', 'R': 'ℜ', 'Z': 'ℨ'},
+	"double-struck": {'C': 'ℂ', 'H': 'ℍ', 'N': 'ℕ', 'P': 'ℙ', 'Q': 'ℚ', 'R': 'ℝ', 'Z': 'ℤ'},
+}
+
+func mathVariantRune(variant string, r rune) rune {
+	if exception, ok := mathVariantExceptions[variant][r]; ok {
+		return exception
+	}
+	bases, ok := mathVariantBases[variant]
+	if !ok {
+		return r
+	}
+	switch {
+	case r >= 'A' && r <= 'Z':
+		return bases[0] + r - 'A'
+	case r >= 'a' && r <= 'z':
+		return bases[1] + r - 'a'
+	case r >= '0' && r <= '9' && bases[2] != 0:
+		return bases[2] + r - '0'
+	}
+	return r
+}
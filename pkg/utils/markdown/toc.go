@@ -0,0 +1,188 @@
+package markdown
+
+import (
+	"personal-site/pkg/utils/sanitize"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"golang.org/x/net/html"
+)
+
+// Heading is an entry in a table of contents, linking to the heading's id
+type Heading struct {
+	Level int
+	ID    string
+	Text  string
+	// the headings under this one, one level down or more
+	Children []*Heading
+}
+
+var headingsKey = parser.NewContextKey()
+var noAnchorsKey = parser.NewContextKey()
+
+// headingTransformer collects the headings of a document and gives each one a link
+// to itself, so a section can be linked to without digging the id out of the page
+type headingTransformer struct{}
+
+func (t *headingTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	anchors := pc.Get(noAnchorsKey) == nil
+	headings := make([]*Heading, 0)
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		heading, ok := n.(*ast.Heading)
+		if !ok || !entering {
+			return ast.WalkContinue, nil
+		}
+		value, ok := heading.AttributeString("id")
+		id, _ := value.([]byte)
+		if !ok || len(id) == 0 {
+			return ast.WalkSkipChildren, nil
+		}
+		// a heading named like part of the page, "Comments" say, would lose its id
+		if sanitize.Posts.Reserved(string(id)) {
+			id = pc.IDs().Generate(append([]byte("section-"), id...), ast.KindHeading)
+			heading.SetAttributeString("id", id)
+		}
+		headings = append(headings, &Heading{Level: heading.Level, ID: string(id), Text: headingText(heading, source)})
+		if anchors {
+			link := ast.NewLink()
+			link.Destination = append([]byte("#"), id...)
+			link.SetAttributeString("class", []byte("heading-anchor"))
+			link.AppendChild(link, ast.NewString([]byte("#")))
+			heading.AppendChild(heading, link)
+		}
+		return ast.WalkSkipChildren, nil
+	})
+	pc.Set(headingsKey, nestHeadings(headings))
+}
+
+// the plain text of a heading, without its formatting
+func headingText(heading *ast.Heading, source []byte) string {
+	var b strings.Builder
+	ast.Walk(heading, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Text:
+			b.Write(n.Segment.Value(source))
+			if n.SoftLineBreak() {
+				b.WriteByte(' ')
+			}
+		case *ast.String:
+			b.Write(n.Value)
+		case *mathInline:
+			b.Write(n.tex)
+		}
+		return ast.WalkContinue, nil
+	})
+	return strings.TrimSpace(b.String())
+}
+
+// nests headings under the closest heading before them with a lower level
+func nestHeadings(headings []*Heading) []*Heading {
+	roots := make([]*Heading, 0)
+	parents := make([]*Heading, 0)
+	for _, heading := range headings {
+		for len(parents) > 0 && parents[len(parents)-1].Level >= heading.Level {
+			parents = parents[:len(parents)-1]
+		}
+		if len(parents) == 0 {
+			roots = append(roots, heading)
+		} else {
+			parent := parents[len(parents)-1]
+			parent.Children = append(parent.Children, heading)
+		}
+		parents = append(parents, heading)
+	}
+	return roots
+}
+
+// ContentTOC reads the table of contents back out of rendered html, for content whose
+// markdown wasn't kept. only headings with an id are listed, since there'd be nothing
+// to link to otherwise
+func ContentTOC(content string) []*Heading {
+	headings := make([]*Heading, 0)
+	tokenizer := html.NewTokenizer(strings.NewReader(content))
+	var current *Heading
+	var b strings.Builder
+	// text inside a heading's own anchor isn't part of its title, and math is
+	// written as its tex source, the way ParseMD lists it
+	anchorDepth := 0
+	inMath, inAnnotation := false, false
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return nestHeadings(headings)
+		case html.StartTagToken:
+			token := tokenizer.Token()
+			if current != nil {
+				switch {
+				case token.Data == "a" && (anchorDepth > 0 || hasClass(token, "heading-anchor")):
+					anchorDepth++
+				case token.Data == "math":
+					inMath = true
+				case token.Data == "annotation":
+					inAnnotation = true
+				}
+				continue
+			}
+			if level := headingLevel(token.Data); level > 0 {
+				if id := attribute(token, "id"); id != "" {
+					current = &Heading{Level: level, ID: id}
+					b.Reset()
+				}
+			}
+		case html.EndTagToken:
+			token := tokenizer.Token()
+			if current == nil {
+				continue
+			}
+			switch {
+			case token.Data == "a" && anchorDepth > 0:
+				anchorDepth--
+			case token.Data == "math":
+				inMath = false
+			case token.Data == "annotation":
+				inAnnotation = false
+			case headingLevel(token.Data) == current.Level:
+				current.Text = strings.Join(strings.Fields(b.String()), " ")
+				headings = append(headings, current)
+				current = nil
+				anchorDepth = 0
+				inMath, inAnnotation = false, false
+			}
+		case html.TextToken:
+			if current != nil && anchorDepth == 0 && (!inMath || inAnnotation) {
+				b.Write(tokenizer.Text())
+			}
+		}
+	}
+}
+
+func headingLevel(tag string) int {
+	if len(tag) == 2 && tag[0] == 'h' && tag[1] >= '1' && tag[1] <= '6' {
+		return int(tag[1] - '0')
+	}
+	return 0
+}
+
+func attribute(token html.Token, name string) string {
+	for _, attr := range token.Attr {
+		if attr.Key == name {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+func hasClass(token html.Token, class string) bool {
+	for _, c := range strings.Fields(attribute(token, "class")) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
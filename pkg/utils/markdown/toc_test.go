@@ -0,0 +1,97 @@
+package markdown
+
+import (
+	"fmt"
+	"personal-site/pkg/utils/sanitize"
+	"reflect"
+	"testing"
+)
+
+func TestHeadingAnchors(t *testing.T) {
+	runGolden(t, []goldenTest{
+		{
+			name:  "repeated headings",
+			input: "# Intro\n\n## Intro\n\n# Intro\n",
+			want: `<h1 id="intro">Intro<a href="#intro" class="heading-anchor">#</a></h1>` + "\n" +
+				`<h2 id="intro-1">Intro<a href="#intro-1" class="heading-anchor">#</a></h2>` + "\n" +
+				`<h1 id="intro-2">Intro<a href="#intro-2" class="heading-anchor">#</a></h1>` + "\n",
+		},
+		{
+			name:  "punctuation",
+			input: "# Hello, World! & 2024\n",
+			want:  `<h1 id="hello-world--2024">Hello, World! &amp; 2024<a href="#hello-world--2024" class="heading-anchor">#</a></h1>` + "\n",
+		},
+		{
+			name:  "formatting",
+			input: "## Setup *fast*\n",
+			want:  `<h2 id="setup-fast">Setup <em>fast</em><a href="#setup-fast" class="heading-anchor">#</a></h2>` + "\n",
+		},
+		{
+			name:  "no letters to slug",
+			input: "# 日本語\n",
+			want:  `<h1 id="heading">日本語<a href="#heading" class="heading-anchor">#</a></h1>` + "\n",
+		},
+		{
+			// the page already has a #comments, and the sanitizer strips ids like it
+			name:  "names the page uses",
+			input: "# Comments\n\n## Comments\n",
+			want: `<h1 id="section-comments">Comments<a href="#section-comments" class="heading-anchor">#</a></h1>` + "\n" +
+				`<h2 id="section-comments-1">Comments<a href="#section-comments-1" class="heading-anchor">#</a></h2>` + "\n",
+		},
+	})
+
+	runGolden(t, []goldenTest{
+		{
+			name:  "without anchors",
+			input: "# Intro\n\n# Intro\n",
+			want:  `<h1 id="intro">Intro</h1>` + "\n" + `<h1 id="intro-1">Intro</h1>` + "\n",
+		},
+	}, WithoutAnchors())
+}
+
+func TestTOC(t *testing.T) {
+	input := "## Before\n\n# A\n\n### Deep $x^2$\n\n## B\n\n## B\n\n# C *em*\n"
+	want := []*Heading{
+		{Level: 2, ID: "before", Text: "Before"},
+		{Level: 1, ID: "a", Text: "A", Children: []*Heading{
+			// a skipped level nests under the closest heading above it
+			{Level: 3, ID: "deep-x2", Text: "Deep x^2"},
+			{Level: 2, ID: "b", Text: "B"},
+			{Level: 2, ID: "b-1", Text: "B"},
+		}},
+		{Level: 1, ID: "c-em", Text: "C em"},
+	}
+	result, err := ParseMD(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.TOC, want) {
+		t.Errorf("ParseMD(%q).TOC =\n%s\nwant\n%s", input, dumpTOC(result.TOC), dumpTOC(want))
+	}
+
+	// the contents read back out of the saved html are the same
+	if got := ContentTOC(sanitize.Posts.Sanitize(result.HTML)); !reflect.DeepEqual(got, want) {
+		t.Errorf("ContentTOC() =\n%s\nwant\n%s", dumpTOC(got), dumpTOC(want))
+	}
+
+	result, err = ParseMD("no headings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.TOC == nil || len(result.TOC) != 0 {
+		t.Errorf("ParseMD().TOC without headings = %#v, want empty", result.TOC)
+	}
+}
+
+func dumpTOC(headings []*Heading) string {
+	var dump func(headings []*Heading, indent string) string
+	dump = func(headings []*Heading, indent string) string {
+		s := ""
+		for _, h := range headings {
+			s += fmt.Sprintf("%sh%d #%s %s\n", indent, h.Level, h.ID, h.Text)
+			s += dump(h.Children, indent+"  ")
+		}
+		return s
+	}
+	return dump(headings, "")
+}
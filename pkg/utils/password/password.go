@@ -0,0 +1,122 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Params are the argon2id cost parameters, they're encoded into every hash so
+// older hashes keep verifying after the defaults change
+type Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultParams follow the second recommended option of RFC 9106
+var DefaultParams = Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+const prefix = "$argon2id$"
+
+var ErrInvalidHash = errors.New("password: hash is not in the expected format")
+var ErrIncompatibleVersion = errors.New("password: incompatible argon2 version")
+
+// dummyHash is compared against when a user doesn't exist, so a missing user
+// takes as long to reject as a wrong password. it's hashed on first use rather than
+// at startup, hashing takes a while and only logins need it
+var dummyHash = sync.OnceValues(func() (string, error) {
+	return Hash("dummy password")
+})
+
+// hashes a password with argon2id and the default params, returned in PHC string format
+func Hash(password string) (string, error) {
+	return HashWithParams(password, DefaultParams)
+}
+
+func HashWithParams(password string, p Params) (string, error) {
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	return fmt.Sprintf(
+		"%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		prefix, argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// reports whether a stored password is an argon2id hash rather than plaintext
+func IsHashed(stored string) bool {
+	return strings.HasPrefix(stored, prefix)
+}
+
+// checks a password against an encoded hash in constant time, needsRehash is set
+// when the hash was created with params other than DefaultParams
+func Verify(password string, encoded string) (match bool, needsRehash bool, err error) {
+	p, salt, key, err := decode(encoded)
+	if err != nil {
+		return false, false, err
+	}
+	other := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return false, false, nil
+	}
+	return true, p != DefaultParams, nil
+}
+
+// burns the same amount of time as a real Verify, for unknown users
+func VerifyDummy(password string) {
+	hash, err := dummyHash()
+	if err != nil {
+		// without a salt to hash with, derive a key the way Verify would anyway
+		p := DefaultParams
+		argon2.IDKey([]byte(password), make([]byte, p.SaltLength), p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+		return
+	}
+	Verify(password, hash)
+}
+
+func decode(encoded string) (p Params, salt []byte, key []byte, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, nil, nil, ErrInvalidHash
+	}
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return p, nil, nil, ErrInvalidHash
+	}
+	if version != argon2.Version {
+		return p, nil, nil, ErrIncompatibleVersion
+	}
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return p, nil, nil, ErrInvalidHash
+	}
+	salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, ErrInvalidHash
+	}
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, ErrInvalidHash
+	}
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(key))
+	return p, salt, key, nil
+}
@@ -0,0 +1,52 @@
+package password
+
+import "testing"
+
+func TestHashAndVerify(t *testing.T) {
+	hash, err := Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsHashed(hash) {
+		t.Errorf("IsHashed(%q) = false", hash)
+	}
+	if match, needsRehash, err := Verify("correct horse", hash); !match || needsRehash || err != nil {
+		t.Errorf("Verify() = %v, %v, %v, want true, false, nil", match, needsRehash, err)
+	}
+	if match, _, err := Verify("battery staple", hash); match || err != nil {
+		t.Errorf("Verify() with the wrong password = %v, %v, want false, nil", match, err)
+	}
+}
+
+func TestVerifyNeedsRehash(t *testing.T) {
+	p := DefaultParams
+	p.Iterations = 1
+	hash, err := HashWithParams("correct horse", p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if match, needsRehash, err := Verify("correct horse", hash); !match || !needsRehash || err != nil {
+		t.Errorf("Verify() = %v, %v, %v, want true, true, nil", match, needsRehash, err)
+	}
+}
+
+func TestVerifyInvalidHash(t *testing.T) {
+	for _, encoded := range []string{"", "plaintext", "$argon2i$v=19$m=1,t=1,p=1$c2FsdA$a2V5", "$argon2id$v=16$m=1,t=1,p=1$c2FsdA$a2V5"} {
+		if _, _, err := Verify("password", encoded); err == nil {
+			t.Errorf("Verify(%q) error = nil", encoded)
+		}
+	}
+}
+
+func TestDummyHash(t *testing.T) {
+	hash, err := dummyHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := dummyHash(); again != hash {
+		t.Error("dummyHash() hashed twice")
+	}
+	if _, _, err := Verify("dummy password", hash); err != nil {
+		t.Errorf("Verify() = %v", err)
+	}
+}
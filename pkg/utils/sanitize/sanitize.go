@@ -0,0 +1,230 @@
+package sanitize
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Policy is an allowlist of elements and their attributes, anything not on it is
+// dropped. the text inside a dropped element is kept, except for elements like
+// script whose contents aren't text
+type Policy struct {
+	// allowed elements and the attributes each may keep
+	Elements map[string][]string
+	// attributes any allowed element may keep
+	GlobalAttributes []string
+	// schemes allowed in href and src, relative urls are always allowed
+	URLSchemes []string
+	// set as the rel of every link, e.g. "nofollow ugc" for user content
+	LinkRel string
+	// prefixes of the ids and classes the site's own pages and scripts use, content
+	// can't take them over
+	ReservedNames []string
+}
+
+// elements whose contents are dropped along with them, unless the policy allows them
+var dropContents = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Iframe:   true,
+	atom.Object:   true,
+	atom.Embed:    true,
+	atom.Noscript: true,
+	atom.Template: true,
+	atom.Textarea: true,
+	atom.Select:   true,
+	atom.Svg:      true,
+	atom.Math:     true,
+}
+
+var urlAttributes = map[string]bool{"href": true, "src": true, "cite": true}
+
+// the MathML markdown renders math to
+var mathML = map[string][]string{
+	"math": {"display"}, "semantics": nil, "annotation": {"encoding"},
+	"mrow": nil, "mi": {"mathvariant"}, "mn": nil, "mtext": nil, "mspace": {"width"},
+	"mo":   {"stretchy", "fence", "form", "lspace", "rspace", "movablelimits", "minsize", "maxsize"},
+	"msup": nil, "msub": nil, "msubsup": nil, "mover": {"accent"}, "munder": {"accentunder"},
+	"munderover": nil, "mfrac": {"linethickness"}, "msqrt": nil, "mroot": nil,
+	"mstyle": {"displaystyle"}, "mtable": nil, "mtr": nil, "mtd": {"columnalign"}, "merror": nil,
+}
+
+func withElements(elements map[string][]string, extra map[string][]string) map[string][]string {
+	merged := maps.Clone(elements)
+	maps.Copy(merged, extra)
+	return merged
+}
+
+// Comments allows the formatting markdown produces for reader comments, without
+// headings or images
+var Comments = &Policy{
+	Elements: withElements(map[string][]string{
+		"p": nil, "br": nil, "hr": nil, "em": nil, "strong": nil, "del": nil,
+		"code": nil, "pre": nil, "blockquote": nil, "ul": nil, "ol": nil, "li": nil,
+		"a": {"href", "title"},
+	}, mathML),
+	URLSchemes: []string{"http", "https", "mailto"},
+	LinkRel:    "nofollow ugc",
+}
+
+// Posts allows what markdown produces for posts, plus the markup authors commonly
+// write by hand around it
+var Posts = &Policy{
+	Elements: withElements(map[string][]string{
+		"p": nil, "br": nil, "hr": nil, "div": nil, "span": {"aria-hidden"},
+		"h1": nil, "h2": nil, "h3": nil, "h4": nil, "h5": nil, "h6": nil,
+		"em": nil, "strong": nil, "b": nil, "i": nil, "u": nil, "s": nil, "del": nil, "ins": nil,
+		"sub": nil, "sup": nil, "mark": nil, "small": nil, "kbd": nil, "abbr": {"title"},
+		"code": nil, "pre": nil, "blockquote": {"cite"}, "q": {"cite"},
+		"ul": nil, "ol": {"start"}, "li": nil, "dl": nil, "dt": nil, "dd": nil,
+		"a":      {"href", "title", "rel"},
+		"img":    {"src", "alt", "title", "width", "height", "loading"},
+		"figure": nil, "figcaption": nil, "details": {"open"}, "summary": nil, "aside": nil,
+		"table": nil, "thead": nil, "tbody": nil, "tfoot": nil, "tr": nil,
+		"th": {"align", "colspan", "rowspan"}, "td": {"align", "colspan", "rowspan"},
+		// gfm task lists render checkboxes
+		"input": {"type", "checked", "disabled"},
+	}, mathML),
+	// footnotes mark up their references and back links with roles
+	GlobalAttributes: []string{"id", "class", "role"},
+	URLSchemes:       []string{"http", "https", "mailto"},
+	// the comment form, the editor and the login forms are found by these
+	ReservedNames: []string{"comment", "preview-", "raw-post", "create-post", "login-", "upload-"},
+}
+
+// Extend returns a copy of the policy that also allows the elements in spec, a space
+// separated list like "iframe[src,width,height] video[src,controls]"
+func (p *Policy) Extend(spec string) (*Policy, error) {
+	extended := *p
+	extended.Elements = maps.Clone(p.Elements)
+	for _, field := range strings.Fields(spec) {
+		element, attrs, hasAttrs := strings.Cut(field, "[")
+		element = strings.ToLower(element)
+		if element == "" {
+			return nil, fmt.Errorf("sanitize: missing element in %q", field)
+		}
+		allowed := slices.Clone(extended.Elements[element])
+		if hasAttrs {
+			attrs, ok := strings.CutSuffix(attrs, "]")
+			if !ok {
+				return nil, fmt.Errorf("sanitize: unclosed attribute list in %q", field)
+			}
+			for _, attr := range strings.Split(attrs, ",") {
+				if attr = strings.ToLower(strings.TrimSpace(attr)); attr != "" && !slices.Contains(allowed, attr) {
+					allowed = append(allowed, attr)
+				}
+			}
+		}
+		extended.Elements[element] = allowed
+	}
+	return &extended, nil
+}
+
+// Reserved reports whether an id or class belongs to the site's own pages, content
+// using it has it removed
+func (p *Policy) Reserved(name string) bool {
+	for _, prefix := range p.ReservedNames {
+		if strings.HasPrefix(strings.ToLower(name), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// the classes in value that aren't reserved, or "" if none are left
+func (p *Policy) allowedClasses(value string) string {
+	classes := strings.Fields(value)
+	return strings.Join(slices.DeleteFunc(classes, p.Reserved), " ")
+}
+
+func (p *Policy) allowedURL(value string) bool {
+	u, err := url.Parse(strings.TrimSpace(value))
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "" || slices.Contains(p.URLSchemes, strings.ToLower(u.Scheme))
+}
+
+// Sanitize returns the html with everything the policy doesn't allow removed
+func (p *Policy) Sanitize(s string) string {
+	var b strings.Builder
+	z := html.NewTokenizer(strings.NewReader(s))
+	// while above zero, tokens are inside an element whose contents are dropped
+	skip := 0
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return b.String()
+		}
+		token := z.Token()
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			attrs, ok := p.Elements[token.Data]
+			if !ok && dropContents[token.DataAtom] {
+				if tt == html.StartTagToken {
+					skip++
+				}
+				continue
+			}
+			if skip > 0 || !ok {
+				continue
+			}
+			token.Attr = p.filterAttributes(token, attrs)
+			b.WriteString(token.String())
+		case html.EndTagToken:
+			_, ok := p.Elements[token.Data]
+			if !ok && dropContents[token.DataAtom] {
+				if skip > 0 {
+					skip--
+				}
+				continue
+			}
+			if ok && skip == 0 {
+				b.WriteString(token.String())
+			}
+		case html.TextToken:
+			if skip == 0 {
+				b.WriteString(token.String())
+			}
+		}
+	}
+}
+
+func (p *Policy) filterAttributes(token html.Token, allowed []string) []html.Attribute {
+	var attrs []html.Attribute
+	for _, attr := range token.Attr {
+		if attr.Namespace != "" || !(slices.Contains(allowed, attr.Key) || slices.Contains(p.GlobalAttributes, attr.Key)) {
+			continue
+		}
+		// event handlers and inline styles never make it through, even if a policy lists them
+		if strings.HasPrefix(attr.Key, "on") || attr.Key == "style" {
+			continue
+		}
+		if urlAttributes[attr.Key] && !p.allowedURL(attr.Val) {
+			continue
+		}
+		if attr.Key == "rel" && token.Data == "a" && p.LinkRel != "" {
+			continue
+		}
+		if attr.Key == "id" && (p.Reserved(attr.Val) || strings.ContainsFunc(attr.Val, unicode.IsSpace)) {
+			continue
+		}
+		if attr.Key == "class" {
+			if attr.Val = p.allowedClasses(attr.Val); attr.Val == "" {
+				continue
+			}
+		}
+		attrs = append(attrs, attr)
+	}
+	if token.Data == "a" && p.LinkRel != "" {
+		attrs = append(attrs, html.Attribute{Key: "rel", Val: p.LinkRel})
+	}
+	return attrs
+}
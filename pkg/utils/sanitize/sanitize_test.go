@@ -0,0 +1,161 @@
+package sanitize
+
+import "testing"
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy *Policy
+		input  string
+		want   string
+	}{
+		{
+			name:   "script and its contents",
+			policy: Posts,
+			input:  `<p>hi</p><script>alert(1)</script><SCRIPT src="x.js"></SCRIPT>`,
+			want:   `<p>hi</p>`,
+		},
+		{
+			name:   "event handlers",
+			policy: Posts,
+			input:  `<img src="a.png" onerror="alert(1)"><p onclick="alert(1)" ONMOUSEOVER="alert(1)">hi</p>`,
+			want:   `<img src="a.png"><p>hi</p>`,
+		},
+		{
+			name:   "javascript urls",
+			policy: Posts,
+			input:  `<a href="javascript:alert(1)">a</a><a href=" JavaScript:alert(1)">b</a><img src="javascript:alert(1)">`,
+			want:   `<a>a</a><a>b</a><img>`,
+		},
+		{
+			name:   "data urls",
+			policy: Posts,
+			input:  `<a href="data:text/html,<script>alert(1)</script>">a</a><img src="data:image/svg+xml;base64,PHN2Zz4=">`,
+			want:   `<a>a</a><img>`,
+		},
+		{
+			name:   "allowed urls",
+			policy: Posts,
+			input:  `<a href="https://example.com">a</a><a href="/blog/hello">b</a><a href="mailto:me@example.com">c</a><a href="#fn:1">d</a>`,
+			want:   `<a href="https://example.com">a</a><a href="/blog/hello">b</a><a href="mailto:me@example.com">c</a><a href="#fn:1">d</a>`,
+		},
+		{
+			name:   "inline styles",
+			policy: Posts,
+			input:  `<p style="position:fixed;top:0">hi</p><style>body { display: none }</style>`,
+			want:   `<p>hi</p>`,
+		},
+		{
+			name:   "styles even when a policy allows them",
+			policy: mustExtend(t, Posts, "p[style,onclick]"),
+			input:  `<p style="color:red" onclick="alert(1)">hi</p>`,
+			want:   `<p>hi</p>`,
+		},
+		{
+			name:   "svg with links",
+			policy: Posts,
+			input:  `<svg><a xlink:href="javascript:alert(1)"><text>x</text></a><use href="data:image/svg+xml,x"/></svg><p>after</p>`,
+			want:   `<p>after</p>`,
+		},
+		{
+			name:   "math with links",
+			policy: Posts,
+			input:  `<math href="javascript:alert(1)" xlink:href="javascript:alert(1)"><mi href="javascript:alert(1)">x</mi></math>`,
+			want:   `<math><mi>x</mi></math>`,
+		},
+		{
+			name:   "math in comments",
+			policy: Comments,
+			input:  `<math display="block" onclick="alert(1)"><mrow><mi>x</mi><mo>=</mo><mn>1</mn></mrow></math>`,
+			want:   `<math display="block"><mrow><mi>x</mi><mo>=</mo><mn>1</mn></mrow></math>`,
+		},
+		{
+			name:   "ids and classes markdown renders",
+			policy: Posts,
+			input:  `<h2 id="setup">Setup</h2><sup id="fnref:1"><a href="#fn:1" class="footnote-ref" role="doc-noteref">1</a></sup><pre class="chroma"><code>x</code></pre>`,
+			want:   `<h2 id="setup">Setup</h2><sup id="fnref:1"><a href="#fn:1" class="footnote-ref" role="doc-noteref">1</a></sup><pre class="chroma"><code>x</code></pre>`,
+		},
+		{
+			name:   "ids the page uses",
+			policy: Posts,
+			input:  `<p id="comments">a</p><p id="comment-1">b</p><p id="Preview-Post-Title">c</p><p id="two words">d</p>`,
+			want:   `<p>a</p><p>b</p><p>c</p><p>d</p>`,
+		},
+		{
+			name:   "classes the page uses",
+			policy: Posts,
+			input:  `<form class="comment-form"><div class="callout comment-error">a</div><div class="login-error">b</div></form>`,
+			want:   `<div class="callout">a</div><div>b</div>`,
+		},
+		{
+			name:   "ids and classes in comments",
+			policy: Comments,
+			input:  `<p id="comments" class="comment-form">hi</p>`,
+			want:   `<p>hi</p>`,
+		},
+		{
+			name:   "comment links",
+			policy: Comments,
+			input:  `<a href="https://example.com" rel="follow" target="_blank">a</a>`,
+			want:   `<a href="https://example.com" rel="nofollow ugc">a</a>`,
+		},
+		{
+			name:   "post links keep their rel",
+			policy: Posts,
+			input:  `<a href="https://example.com" rel="noopener noreferrer">a</a>`,
+			want:   `<a href="https://example.com" rel="noopener noreferrer">a</a>`,
+		},
+		{
+			name:   "comments without headings or images",
+			policy: Comments,
+			input:  `<h1>big</h1><img src="a.png" alt="a"><p>text</p>`,
+			want:   `big<p>text</p>`,
+		},
+		{
+			name:   "dropped elements keep their text",
+			policy: Posts,
+			input:  `<font color="red">red</font> <iframe src="https://example.com">inside</iframe>`,
+			want:   `red `,
+		},
+		{
+			name:   "text is escaped",
+			policy: Posts,
+			input:  `<p>1 &lt; 2 &amp;&amp; "quoted"</p>`,
+			want:   `<p>1 &lt; 2 &amp;&amp; &#34;quoted&#34;</p>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Sanitize(tt.input); got != tt.want {
+				t.Errorf("Sanitize(%q)\n got %q\nwant %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtend(t *testing.T) {
+	policy := mustExtend(t, Posts, "iframe[src,width] video[src,controls]")
+	input := `<iframe src="https://example.com/embed" width="560" height="315"></iframe><iframe src="javascript:alert(1)"></iframe><video src="a.mp4" controls autoplay></video>`
+	want := `<iframe src="https://example.com/embed" width="560"></iframe><iframe></iframe><video src="a.mp4" controls=""></video>`
+	if got := policy.Sanitize(input); got != want {
+		t.Errorf("Sanitize(%q)\n got %q\nwant %q", input, got, want)
+	}
+	if _, ok := Posts.Elements["iframe"]; ok {
+		t.Error("Extend changed the policy it extended")
+	}
+
+	for _, spec := range []string{"[src]", "iframe[src"} {
+		if _, err := Posts.Extend(spec); err == nil {
+			t.Errorf("Extend(%q) = nil error, want an error", spec)
+		}
+	}
+}
+
+func mustExtend(t *testing.T, p *Policy, spec string) *Policy {
+	t.Helper()
+	extended, err := p.Extend(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return extended
+}
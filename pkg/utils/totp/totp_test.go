@@ -0,0 +1,104 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// the ascii secret "12345678901234567890" from RFC 6238 appendix B
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeAt(t *testing.T) {
+	// the SHA1 vectors from RFC 6238 appendix B, cut down to the last 6 of their 8 digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		code, err := CodeAt(rfcSecret, Counter(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("CodeAt() = %v", err)
+		}
+		if code != tt.code {
+			t.Errorf("CodeAt(%d) = %s, want %s", tt.unix, code, tt.code)
+		}
+	}
+	if _, err := CodeAt("not base32!", 1); err != ErrInvalidSecret {
+		t.Errorf("CodeAt() with an invalid secret = %v, want ErrInvalidSecret", err)
+	}
+	// secrets are often typed in lowercase
+	if code, err := CodeAt(strings.ToLower(rfcSecret), 1); err != nil || code != "287082" {
+		t.Errorf("CodeAt() with a lowercase secret = %s, %v", code, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	counter := Counter(now)
+	codeAt := func(c int64) string {
+		code, err := CodeAt(rfcSecret, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	// a step either side of now is accepted, and reports the step that matched
+	for _, step := range []int64{-Skew, 0, Skew} {
+		matched, ok := Validate(rfcSecret, codeAt(counter+step), now)
+		if !ok || matched != counter+step {
+			t.Errorf("Validate() of step %+d = %d, %v, want %d, true", step, matched, ok, counter+step)
+		}
+	}
+	for _, step := range []int64{-Skew - 1, Skew + 1} {
+		if _, ok := Validate(rfcSecret, codeAt(counter+step), now); ok {
+			t.Errorf("Validate() of step %+d = true, want false", step)
+		}
+	}
+
+	code := codeAt(counter)
+	if _, ok := Validate(rfcSecret, " "+code[:3]+" "+code[3:]+" ", now); !ok {
+		t.Error("Validate() should ignore spaces")
+	}
+	for _, bad := range []string{"", code[:5], code + "0", "abcdef"} {
+		if _, ok := Validate(rfcSecret, bad, now); ok {
+			t.Errorf("Validate(%q) = true", bad)
+		}
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' || code != strings.ToLower(code) {
+			t.Errorf("recovery code %q isn't formatted as xxxxx-xxxxx", code)
+		}
+		if seen[code] {
+			t.Errorf("recovery code %q was generated twice", code)
+		}
+		seen[code] = true
+	}
+
+	// the hash doesn't depend on how the code was typed
+	hash := HashRecoveryCode(codes[0])
+	for _, typed := range []string{strings.ToUpper(codes[0]), strings.ReplaceAll(codes[0], "-", ""), " " + codes[0] + " "} {
+		if HashRecoveryCode(typed) != hash {
+			t.Errorf("HashRecoveryCode(%q) doesn't match HashRecoveryCode(%q)", typed, codes[0])
+		}
+	}
+	if HashRecoveryCode(codes[1]) == hash {
+		t.Error("different recovery codes hash the same")
+	}
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"path/filepath"
 	"personal-site/internal/config"
 	"regexp"
 	"strings"
@@ -17,11 +18,18 @@ func Map[T any, U any](input []T, fn func(T) U) []U {
 	return result
 }
 
-func GenerateToken(user_id int) (string, error) {
+// tokens are short-lived and get refreshed for as long as their session is active
+const TokenLifetime = 15 * time.Minute
+
+// the session id goes in the jti claim so the token can be revoked server-side
+func GenerateToken(user_id int, sessionID string) (string, error) {
+	now := time.Now()
 	t := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"admin":   true,
 		"user_id": user_id,
-		"exp":     time.Now().Add(time.Hour * 24).Unix(),
+		"jti":     sessionID,
+		"iat":     now.Unix(),
+		"exp":     now.Add(TokenLifetime).Unix(),
 	})
 	s, err := t.SignedString(config.SignKey)
 	if err != nil {
@@ -30,8 +38,19 @@ func GenerateToken(user_id int) (string, error) {
 	return s, nil
 }
 
+// a short-lived token proving the password step of a two-factor login passed, it
+// has no jti so it's never accepted in place of a session token
+func GenerateMFAToken(user_id int, lifetime time.Duration) (string, error) {
+	t := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"mfa":     true,
+		"user_id": user_id,
+		"exp":     time.Now().Add(lifetime).Unix(),
+	})
+	return t.SignedString(config.SignKey)
+}
+
 func FormatTitle(filename string) string {
-	return filename[:len(filename)-3]
+	return strings.TrimSuffix(filename, filepath.Ext(filename))
 }
 
 func TitleToSlug(title string) string {
@@ -43,20 +62,3 @@ func TitleToSlug(title string) string {
 	slug := strings.Join(slugArray, "-")
 	return slug
 }
-
-func ParseTags(contents string) string {
-	tagsSection := strings.Split(contents, "---")[1]
-	tagsSection = strings.ReplaceAll(tagsSection, "\n", "")
-	tagsSection = strings.TrimPrefix(tagsSection, "tags:")
-	tagsParts := strings.Split(tagsSection, "- ")[1:]
-	tagsParts = Map(tagsParts, func(part string) string {
-		return strings.Trim(part, " ")
-	})
-
-	return strings.Join(tagsParts, " ")
-}
-func CleanPostContent(content *string) {
-	contentParts := strings.Split(*content, "---")
-	contentParts = contentParts[2:]
-	*content = strings.Join(contentParts, "")
-}
@@ -36,8 +36,8 @@ func Login(w io.Writer) error {
 	return parse("login.html").Execute(w, "")
 }
 
-func Admin(w io.Writer, posts []*db.Post) error {
-	return parse("admin.html").Execute(w, posts)
+func Admin(w io.Writer, adminData *db.AdminData) error {
+	return parse("admin.html").Execute(w, adminData)
 }
 
 func NewPost(w io.Writer) error {
@@ -56,6 +56,63 @@ func Edit(w io.Writer, post *db.Post) error {
 	return parse("edit.html").Execute(w, post)
 }
 
+func Revisions(w io.Writer, revisionsData *db.RevisionsData) error {
+	return parse("revisions.html").Execute(w, revisionsData)
+}
+
+func Sessions(w io.Writer, sessionsData *db.SessionsData) error {
+	return parse("sessions.html").Execute(w, sessionsData)
+}
+
+func TwoFactor(w io.Writer, twoFactorData *db.TwoFactorData) error {
+	return parse("two-factor.html").Execute(w, twoFactorData)
+}
+
+// renders only the settings section, for the htmx enroll and disable forms
+func TwoFactorSection(w io.Writer, twoFactorData *db.TwoFactorData) error {
+	return parse("two-factor.html").ExecuteTemplate(w, "two-factor-section", twoFactorData)
+}
+
+func Users(w io.Writer, usersData *db.UsersData) error {
+	return parse("users.html").Execute(w, usersData)
+}
+
+// renders only the user list, for the htmx create, role and delete requests
+func UsersSection(w io.Writer, usersData *db.UsersData) error {
+	return parse("users.html").ExecuteTemplate(w, "users-section", usersData)
+}
+
+func APITokens(w io.Writer, apiTokensData *db.APITokensData) error {
+	return parse("api-tokens.html").Execute(w, apiTokensData)
+}
+
+// renders only the token list, for the htmx create form
+func APITokensSection(w io.Writer, apiTokensData *db.APITokensData) error {
+	return parse("api-tokens.html").ExecuteTemplate(w, "api-tokens-section", apiTokensData)
+}
+
+func TwoFactorLogin(w io.Writer) error {
+	return parse("two-factor-login.html").Execute(w, "")
+}
+
+func Search(w io.Writer, searchData *db.SearchData) error {
+	return parse("search.html").Execute(w, searchData)
+}
+
+// renders only the results, for htmx search-as-you-type requests
+func SearchResults(w io.Writer, searchData *db.SearchData) error {
+	return parse("search.html").ExecuteTemplate(w, "search-results", searchData)
+}
+
 func AllPosts(w io.Writer, blogData *db.BlogData) error {
 	return parse("blog.html").Execute(w, blogData)
 }
+
+func Comments(w io.Writer, commentsData *db.CommentsData) error {
+	return parse("comments.html").Execute(w, commentsData)
+}
+
+// renders only the thank you note that replaces the comment form
+func CommentReceived(w io.Writer) error {
+	return parse("post.html").ExecuteTemplate(w, "comment-received", nil)
+}